@@ -7,18 +7,23 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"io/fs"
 	"log"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/awilliams/wifi-presence/internal/debugfs"
+	"github.com/awilliams/wifi-presence/internal/dhcp"
 	"github.com/awilliams/wifi-presence/internal/hass"
 	"github.com/awilliams/wifi-presence/internal/hostapd"
+	"github.com/awilliams/wifi-presence/internal/neigh"
 	"github.com/awilliams/wifi-presence/internal/presence"
+	"github.com/awilliams/wifi-presence/internal/radius"
+	"github.com/awilliams/wifi-presence/internal/remote"
+	"github.com/awilliams/wifi-presence/internal/syslog"
+	"github.com/awilliams/wifi-presence/internal/ubus"
 
 	"golang.org/x/sync/errgroup"
 )
@@ -26,6 +31,8 @@ import (
 const (
 	appName               = "wifi-presence"
 	defaultHostapdSockDir = "/var/run/hostapd/"
+	defaultDnsmasqLeases  = "/tmp/dhcp.leases"
+	defaultOdhcpdLeases   = "/tmp/hosts/odhcpd"
 )
 
 const helpTxt = `
@@ -40,7 +47,29 @@ https://w1.fi/cgit/hostap/plain/hostapd/hostapd.conf
 
 The wifi-presence -hostapd.socks option should correspond to the socket
 locations defined by 'ctrl_interface'. Multiple sockets can be monitored
-(one socket per radio is created by hostapd).
+(one socket per radio is created by hostapd). Alternatively, the
+-hostapd.global option can be used to monitor all interfaces using hostapd's
+global control interface, or the -hostapd.dir option to monitor sockets as
+they are created and removed.
+
+Connect and disconnect events that were missed, e.g. because hostapd's event
+queue overflowed, are corrected by comparing tracked stations against those
+listed by hostapd every -reconcile interval.
+
+hostapd's UDP control interface ('ctrl_interface=udp:<port>') can be used to
+monitor a remote hostapd by giving an address of the form udp://host:port to
+-hostapd.socks or -hostapd.global.
+
+On OpenWrt, the -ubus option can be used instead to read stations and their
+events from the ubus objects hostapd registers for each interface. This works
+with the default wpad-basic builds, which lack the STA-FIRST/STA-NEXT commands.
+Otherwise, with such builds, connected stations are read from the kernel's
+mac80211 debugfs directory (see -debugfs) when it is available.
+
+When the control interface cannot be reached, the -syslog option can be used to
+read the events that hostapd logs instead, either as a syslog server (e.g.
+udp://:514 or tcp://:514, RFC 5424 or RFC 3164), or from a FIFO or stdin ("-"),
+e.g. 'logread -f | wifi-presence -syslog - ...'.
 
 MQTT:
 wifi-presence publishes and subscribes to an MQTT broker.
@@ -63,11 +92,54 @@ The following topics are used:
   to these topics (based on their MAC address). Home Assistant subscribes to these
   topics and registers/unregisters entities accordingly based on messages received.
 
+  * <HASS_PREFIX>/binary_sensor/<AP_NAME>/<MAC>/config
+  Devices configured as mesh nodes ("mesh": true) are published as connectivity
+  binary sensors instead of device trackers. Mesh peers are tracked using
+  MESH-PEER-CONNECTED and MESH-PEER-DISCONNECTED events.
+
   * <PREFIX>/station/<AP_NAME>/<MAC>/state
   The state of a device (home / not_home) is published to these topics.
 
   * <PREFIX>/station/<AP_NAME>/<MAC>/attrs
   A JSON object with device attributes (SSID, BSSID, etc) is published to these topics.
+
+  * <PREFIX>/station/<AP_NAME>/<MAC>/nearby
+  If -nearby is set, a JSON object with whether a device that is not connected
+  is nearby (nearby / not_nearby) and the signal of its probe requests is
+  published to these topics.
+
+Wired devices:
+Devices that do not use WiFi can be tracked using the kernel's neighbour tables
+(/proc/net/arp and the IPv6 neighbour table) of the interface(s) given by -neigh.
+A device is considered connected while its entry is REACHABLE (or STALE), and
+disconnected once it FAILED or was removed. The same -debounce applies.
+
+RADIUS accounting:
+Access points that send RADIUS accounting requests (RFC 2866) can be monitored
+by listening with -radius.addr. A station is connected from its Accounting-Start
+request until its Accounting-Stop request, identified by Calling-Station-Id.
+Requests are validated using the shared secret given by -radius.secret.
+
+Nearby devices:
+Devices probe for known networks before they associate. With -nearby, hostapd's
+control interface is asked to report probe requests, and a device that is not
+connected is considered nearby until no probe request has been received from it
+for the given duration. Devices that randomize their MAC address while scanning
+are not seen.
+
+Agent and server:
+Several APs can be tracked by a single daemon, which publishes to MQTT. On each
+AP, run wifi-presence with -agent.server to forward its stations and events to
+the daemon, which accepts agents using -server.addr. Both sides authenticate
+using a shared secret (-agent.secret and -server.secret); connections are not
+encrypted. An agent that reconnects within -server.grace resumes where it left
+off; otherwise its stations are considered disconnected.
+
+DHCP:
+The hostname and IP addresses of devices are read from the DHCP lease files of
+dnsmasq and odhcpd (see -dhcp.dnsmasq and -dhcp.odhcpd), and included in their
+attributes. Devices may also be configured by hostname instead of MAC address,
+using a pattern such as "*-iphone".
 `
 
 func main() {
@@ -82,7 +154,7 @@ func main() {
 		switch {
 		case errors.Is(err, hostapd.ErrTerminating):
 			// Special exit code to indicate that hostapd indicated that
-			// the process should exit.
+			// the process should exit. Only happens when -hostapd.reattach=false.
 			os.Exit(125)
 		case errors.As(err, &unknownCmd):
 			fmt.Fprintln(os.Stderr, "This error typically happens when the full version of 'hostapd' is not installed. See https://github.com/awilliams/wifi-presence/#hostapd-full-version for more information.")
@@ -102,6 +174,26 @@ func run(ctx context.Context, appName string) error {
 		apName            string
 		sockDir           string
 		hostapdSocks      string
+		hostapdGlobal     string
+		hostapdDir        string
+		ubusSock          string
+		syslogAddr        string
+		debugfsRoot       string
+		dnsmasqLeases     string
+		odhcpdLeases      string
+		leaseRefresh      time.Duration
+		neighDevs         string
+		neighInterval     time.Duration
+		radiusAddr        string
+		radiusSecret      string
+		radiusTimeout     time.Duration
+		agentServer       string
+		agentSecret       string
+		agentSnapshot     time.Duration
+		serverAddr        string
+		serverSecret      string
+		serverGrace       time.Duration
+		refreshInterval   time.Duration
 		mqttAddr          string
 		mqttID            string
 		mqttPrefix        string
@@ -110,16 +202,30 @@ func run(ctx context.Context, appName string) error {
 		hassAutodiscovery bool
 		hassPrefix        string
 		debounce          time.Duration
+		confirmDeparture  time.Duration
+		nearby            time.Duration
+		reconcile         time.Duration
+		queue             int
+		stats             time.Duration
+		reattach          bool
+		pingInterval      time.Duration
 		verbose           bool
 
 		version  bool
 		moreHelp bool
 	}{
-		apName:  hostName,
-		sockDir: os.TempDir(),
+		apName:        hostName,
+		sockDir:       os.TempDir(),
+		debugfsRoot:   debugfs.DefaultRoot,
+		dnsmasqLeases: defaultDnsmasqLeases,
+		odhcpdLeases:  defaultOdhcpdLeases,
+		leaseRefresh:  5 * time.Second,
+		neighInterval: neigh.DefaultInterval,
+		agentSnapshot: remote.DefaultSnapshotInterval,
+		serverGrace:   remote.DefaultGracePeriod,
 		hostapdSocks: func() string {
 			return strings.Join(
-				findUnixSockets(defaultHostapdSockDir),
+				hostapd.ControlSockets(defaultHostapdSockDir),
 				string(os.PathListSeparator),
 			)
 		}(),
@@ -133,6 +239,11 @@ func run(ctx context.Context, appName string) error {
 		hassAutodiscovery: true,
 		hassPrefix:        "homeassistant",
 		debounce:          10 * time.Second,
+		queue:             1024,
+		stats:             time.Hour,
+		refreshInterval:   30 * time.Second,
+		reattach:          true,
+		pingInterval:      30 * time.Second,
 		verbose:           false,
 		version:           false,
 		moreHelp:          false,
@@ -140,7 +251,27 @@ func run(ctx context.Context, appName string) error {
 
 	flag.StringVar(&args.apName, "apName", args.apName, "Access point name")
 	flag.StringVar(&args.sockDir, "sockDir", args.sockDir, "Directory for local socket(s)")
-	flag.StringVar(&args.hostapdSocks, "hostapd.socks", args.hostapdSocks, fmt.Sprintf("Hostapd control interface socket(s). Separate multiple paths by %q", os.PathListSeparator))
+	flag.StringVar(&args.hostapdSocks, "hostapd.socks", args.hostapdSocks, fmt.Sprintf("Hostapd control interface socket(s), or UDP address(es) of the form udp://host:port. Separate multiple paths by %q", os.PathListSeparator))
+	flag.StringVar(&args.hostapdGlobal, "hostapd.global", args.hostapdGlobal, "Hostapd global control interface socket or UDP address, e.g. \"/var/run/hostapd/global\". When set, all interfaces are monitored and -hostapd.socks is ignored")
+	flag.StringVar(&args.hostapdDir, "hostapd.dir", args.hostapdDir, fmt.Sprintf("Directory of hostapd control interface sockets, e.g. %q. When set, sockets are monitored as they are created and removed and -hostapd.socks is ignored", defaultHostapdSockDir))
+	flag.StringVar(&args.ubusSock, "ubus", args.ubusSock, fmt.Sprintf("ubusd socket, e.g. %q. When set, stations are read from hostapd's ubus objects and -hostapd.socks is ignored", ubus.DefaultSocket))
+	flag.StringVar(&args.syslogAddr, "syslog", args.syslogAddr, "Read hostapd's log messages from a syslog address (udp://host:port or tcp://host:port), a FIFO, or stdin (\"-\"). When set, -hostapd.socks is ignored")
+	flag.StringVar(&args.debugfsRoot, "debugfs", args.debugfsRoot, "mac80211 debugfs directory, used to list connected stations when hostapd is unable to. Empty disables")
+	flag.DurationVar(&args.refreshInterval, "hostapd.refresh", args.refreshInterval, "Interval to refresh the list of interfaces when using -hostapd.global or -hostapd.dir")
+	flag.StringVar(&args.dnsmasqLeases, "dhcp.dnsmasq", args.dnsmasqLeases, "dnsmasq DHCP lease file, used to publish the hostname and IP addresses of stations. Empty disables")
+	flag.StringVar(&args.odhcpdLeases, "dhcp.odhcpd", args.odhcpdLeases, "odhcpd lease file (odhcpd's 'leasefile' option), used to publish the hostname and IP addresses of stations. Empty disables")
+	flag.DurationVar(&args.leaseRefresh, "dhcp.refresh", args.leaseRefresh, "Interval to check the DHCP lease files for changes")
+	flag.StringVar(&args.neighDevs, "neigh", args.neighDevs, fmt.Sprintf("Network interface(s), e.g. \"br-lan\", whose neighbours (ARP & IPv6 neighbour tables) are tracked as wired devices. Separate multiple interfaces by %q", os.PathListSeparator))
+	flag.DurationVar(&args.neighInterval, "neigh.interval", args.neighInterval, "Interval to read the neighbour tables when using -neigh")
+	flag.StringVar(&args.radiusAddr, "radius.addr", args.radiusAddr, fmt.Sprintf("UDP address to receive RADIUS accounting requests on, e.g. %q. Stations with an accounting session are considered connected", radius.DefaultAddr))
+	flag.StringVar(&args.radiusSecret, "radius.secret", args.radiusSecret, "RADIUS shared secret, required when using -radius.addr")
+	flag.DurationVar(&args.radiusTimeout, "radius.timeout", args.radiusTimeout, "Time after which a RADIUS accounting session without updates is considered stopped. 0 disables")
+	flag.StringVar(&args.agentServer, "agent.server", args.agentServer, fmt.Sprintf("Address of a wifi-presence server, e.g. \"central.lan%s\". When set, stations and events are forwarded to the server instead of being published to MQTT", remote.DefaultAddr))
+	flag.StringVar(&args.agentSecret, "agent.secret", args.agentSecret, "Shared secret used to authenticate with the server, required when using -agent.server")
+	flag.DurationVar(&args.agentSnapshot, "agent.snapshot", args.agentSnapshot, "Interval to send the server a snapshot of connected stations when using -agent.server")
+	flag.StringVar(&args.serverAddr, "server.addr", args.serverAddr, fmt.Sprintf("TCP address to accept agents (see -agent.server) on, e.g. %q. The stations of each agent are tracked along with any local ones", remote.DefaultAddr))
+	flag.StringVar(&args.serverSecret, "server.secret", args.serverSecret, "Shared secret used to authenticate agents, required when using -server.addr")
+	flag.DurationVar(&args.serverGrace, "server.grace", args.serverGrace, "Time to wait for an agent to reconnect before considering its stations disconnected")
 	flag.StringVar(&args.mqttAddr, "mqtt.addr", args.mqttAddr, "MQTT broker address, e.g \"tcp://mqtt.broker:1883\"")
 	flag.StringVar(&args.mqttID, "mqtt.id", args.mqttID, "MQTT client ID")
 	flag.StringVar(&args.mqttPrefix, "mqtt.prefix", args.mqttPrefix, "MQTT topic prefix")
@@ -149,6 +280,13 @@ func run(ctx context.Context, appName string) error {
 	flag.BoolVar(&args.hassAutodiscovery, "hass.autodiscovery", args.hassAutodiscovery, "Enable Home Assistant MQTT autodiscovery")
 	flag.StringVar(&args.hassPrefix, "hass.prefix", args.hassPrefix, "Home Assistant MQTT topic prefix")
 	flag.DurationVar(&args.debounce, "debounce", args.debounce, "Time to wait until considering a station disconnected. Examples: 5s, 1m")
+	flag.DurationVar(&args.confirmDeparture, "confirmDeparture", args.confirmDeparture, "Time to wait for a station to respond to a poll before considering it disconnected. 0 disables polling")
+	flag.DurationVar(&args.nearby, "nearby", args.nearby, "Time a station that is not connected remains nearby after its last probe request. Requires hostapd's control interface. 0 disables")
+	flag.DurationVar(&args.reconcile, "reconcile", args.reconcile, "Interval to compare tracked stations against those listed by hostapd, correcting any missed events. 0 disables")
+	flag.IntVar(&args.queue, "queue", args.queue, "Maximum number of station events waiting to be handled. When full, reading further events waits for room")
+	flag.DurationVar(&args.stats, "stats", args.stats, "Interval to log the number of events handled and queued. 0 disables, logging them only on exit")
+	flag.BoolVar(&args.reattach, "hostapd.reattach", args.reattach, "Reconnect to hostapd when it restarts, instead of exiting")
+	flag.DurationVar(&args.pingInterval, "hostapd.ping", args.pingInterval, "Interval to check that hostapd is responsive. 0 disables the check")
 	flag.BoolVar(&args.verbose, "verbose", args.verbose, "Verbose logging")
 	flag.BoolVar(&args.verbose, "v", args.verbose, "Verbose logging (alias)")
 	flag.BoolVar(&args.version, "version", args.version, "Print version and exit")
@@ -175,21 +313,31 @@ func run(ctx context.Context, appName string) error {
 	if args.apName == "" {
 		return errors.New("apName cannot be blank")
 	}
-	if args.hostapdSocks == "" {
+	if args.hostapdSocks == "" && args.hostapdGlobal == "" && args.hostapdDir == "" && args.ubusSock == "" && args.syslogAddr == "" && args.neighDevs == "" && args.radiusAddr == "" && args.serverAddr == "" {
 		return errors.New("hostapd.socks cannot be blank")
 	}
-	if args.mqttAddr == "" {
-		return errors.New("mqtt.addr cannot be blank")
-	}
-	if args.mqttID == "" {
-		return errors.New("mqtt.id cannot be blank")
+	if args.queue < 1 {
+		return errors.New("queue must be at least 1")
 	}
-	if args.mqttPrefix == "" {
-		return errors.New("mqtt.prefix cannot be blank")
-	}
-
-	if args.hassAutodiscovery {
-		if args.hassPrefix == "" {
+	if args.agentServer != "" {
+		// Agents do not use MQTT.
+		if args.agentSecret == "" {
+			return errors.New("agent.secret cannot be blank")
+		}
+		if args.serverAddr != "" {
+			return errors.New("agent.server and server.addr cannot both be set")
+		}
+	} else {
+		if args.mqttAddr == "" {
+			return errors.New("mqtt.addr cannot be blank")
+		}
+		if args.mqttID == "" {
+			return errors.New("mqtt.id cannot be blank")
+		}
+		if args.mqttPrefix == "" {
+			return errors.New("mqtt.prefix cannot be blank")
+		}
+		if args.hassAutodiscovery && args.hassPrefix == "" {
 			return errors.New("hass.prefix cannot be blank when autodiscovery is enabled")
 		}
 	}
@@ -199,6 +347,132 @@ func run(ctx context.Context, appName string) error {
 		log.SetOutput(io.Discard)
 	}
 
+	// Connect to the sources of stations.
+
+	var (
+		sources []presence.Source
+		opts    []presence.Opt
+	)
+
+	clientOpts := []hostapd.ClientOpt{hostapd.WithPingInterval(args.pingInterval), hostapd.WithLogger(log.Default())}
+	if args.nearby > 0 {
+		// Nearby stations are found using their probe requests.
+		clientOpts = append(clientOpts, hostapd.WithProbeRequests())
+	}
+
+	var sockets []string
+	switch {
+	case args.hostapdGlobal != "":
+		// Connect to the global control interface, which is used
+		// to find each interface.
+		global, err := hostapd.NewGlobal(args.sockDir, args.hostapdGlobal, clientOpts...)
+		if err != nil {
+			return fmt.Errorf("unable to connect to hostapd global control socket %q: %w", args.hostapdGlobal, err)
+		}
+		defer global.Close()
+
+		opts = append(opts, presence.WithHostAPDGlobal(global))
+		opts = append(opts, presence.WithInterfaceRefresh(args.refreshInterval))
+	case args.hostapdDir != "":
+		// Sockets in the directory are connected to by the daemon.
+		opts = append(opts, presence.WithHostAPDDir(args.hostapdDir, args.sockDir, clientOpts...))
+		opts = append(opts, presence.WithInterfaceRefresh(args.refreshInterval))
+	case args.ubusSock != "":
+		// Use the ubus object of each of hostapd's interfaces.
+		ubusClient, err := ubus.Dial(args.ubusSock)
+		if err != nil {
+			return fmt.Errorf("unable to connect to ubus socket %q: %w", args.ubusSock, err)
+		}
+		defer ubusClient.Close()
+
+		ifnames, err := ubus.HostapdInterfaces(ctx, ubusClient)
+		if err != nil {
+			return err
+		}
+		if len(ifnames) == 0 {
+			return fmt.Errorf("no hostapd objects found using ubus socket %q", args.ubusSock)
+		}
+		for _, ifname := range ifnames {
+			sources = append(sources, ubus.NewHostapd(ubusClient, ifname))
+		}
+	case args.syslogAddr != "":
+		// Use the events that hostapd logs.
+		logSource, err := syslog.Open(args.syslogAddr)
+		if err != nil {
+			return fmt.Errorf("unable to read hostapd's log messages from %q: %w", args.syslogAddr, err)
+		}
+		defer logSource.Close()
+
+		sources = append(sources, logSource)
+	case args.hostapdSocks != "":
+		sockets = splitSockets(args.hostapdSocks)
+	}
+
+	// Connect to each hostapd control interface socket.
+	for _, ctrlSock := range sockets {
+		hostapdClient, err := hostapd.NewClient(args.sockDir, ctrlSock, clientOpts...)
+		if err != nil {
+			return fmt.Errorf("unable to connect to hostapd control socket %q: %w", ctrlSock, err)
+		}
+		defer hostapdClient.Close()
+
+		sources = append(sources, hostapdClient)
+	}
+
+	if args.neighDevs != "" {
+		for _, dev := range strings.Split(args.neighDevs, string(os.PathListSeparator)) {
+			sources = append(sources, neigh.NewSource(dev, neigh.ReadSystem, args.neighInterval))
+		}
+	}
+
+	if args.radiusAddr != "" {
+		acct, err := radius.Listen(args.radiusAddr, []byte(args.radiusSecret), radius.WithSessionTimeout(args.radiusTimeout), radius.WithLogger(log.Default()))
+		if err != nil {
+			return fmt.Errorf("unable to listen for RADIUS accounting requests on %q: %w", args.radiusAddr, err)
+		}
+		defer acct.Close()
+
+		sources = append(sources, acct)
+	}
+
+	var stationFallback presence.StationsFunc
+	if args.debugfsRoot != "" {
+		stationFallback = func(_ context.Context, ifname string) ([]hostapd.Station, error) {
+			return debugfs.Stations(args.debugfsRoot, ifname)
+		}
+	}
+
+	if args.agentServer != "" {
+		// Forward the stations and events of the sources
+		// to the server, instead of publishing them.
+		if len(opts) > 0 {
+			return errors.New("hostapd.global and hostapd.dir cannot be used with agent.server")
+		}
+		agentOpts := []remote.AgentOpt{
+			remote.WithSnapshotInterval(args.agentSnapshot),
+			remote.WithAgentLogger(log.Default()),
+		}
+		if stationFallback != nil {
+			agentOpts = append(agentOpts, remote.WithStationFallback(stationFallback))
+		}
+		agent, err := remote.NewAgent(args.apName, args.agentServer, []byte(args.agentSecret), sources, agentOpts...)
+		if err != nil {
+			return err
+		}
+		return agent.Run(ctx)
+	}
+
+	if args.serverAddr != "" {
+		// Accept agents, whose interfaces are added as they connect.
+		server, err := remote.Listen(args.serverAddr, []byte(args.serverSecret), remote.WithGracePeriod(args.serverGrace), remote.WithServerLogger(log.Default()))
+		if err != nil {
+			return fmt.Errorf("unable to listen for agents on %q: %w", args.serverAddr, err)
+		}
+		defer server.Close()
+
+		opts = append(opts, presence.WithSourceWatcher(server))
+	}
+
 	// Create MQTT client.
 
 	mqttOpts := hass.MQTTOpts{
@@ -229,28 +503,32 @@ func run(ctx context.Context, appName string) error {
 		mqtt.Close()
 	}()
 
-	sockets := strings.Split(args.hostapdSocks, string(os.PathListSeparator))
-	hostapds := make([]*hostapd.Client, 0, len(sockets))
-
-	// Connect to each hostapd control interface socket.
-	for _, ctrlSock := range sockets {
-		hostapdClient, err := hostapd.NewClient(args.sockDir, ctrlSock)
-		if err != nil {
-			return fmt.Errorf("unable to connect to hostapd control socket %q: %w", ctrlSock, err)
-		}
-		defer hostapdClient.Close()
-
-		hostapds = append(hostapds, hostapdClient)
-	}
-
-	var opts []presence.Opt
 	opts = append(opts, presence.WithAPName(args.apName))
 	opts = append(opts, presence.WithHassOpt(mqtt))
 	opts = append(opts, presence.WithLogger(log.Default()))
 	opts = append(opts, presence.WithDebounce(args.debounce))
+	opts = append(opts, presence.WithConfirmDeparture(args.confirmDeparture))
+	opts = append(opts, presence.WithNearby(args.nearby))
+	opts = append(opts, presence.WithReconcileInterval(args.reconcile))
+	opts = append(opts, presence.WithEventQueue(args.queue))
 	opts = append(opts, presence.WithHASSAutodiscovery(args.hassAutodiscovery))
-	for _, hap := range hostapds {
-		opts = append(opts, presence.WithHostAPD(hap))
+	opts = append(opts, presence.WithReattach(args.reattach))
+	if stationFallback != nil {
+		opts = append(opts, presence.WithStationFallback(stationFallback))
+	}
+	for _, src := range sources {
+		opts = append(opts, presence.WithSource(src))
+	}
+
+	var leaseFiles []dhcp.File
+	if args.dnsmasqLeases != "" {
+		leaseFiles = append(leaseFiles, dhcp.File{Path: args.dnsmasqLeases, Format: dhcp.FormatDnsmasq})
+	}
+	if args.odhcpdLeases != "" {
+		leaseFiles = append(leaseFiles, dhcp.File{Path: args.odhcpdLeases, Format: dhcp.FormatOdhcpd})
+	}
+	if len(leaseFiles) > 0 {
+		opts = append(opts, presence.WithLeases(dhcp.NewLeases(leaseFiles, dhcp.WithLogger(log.Default())), args.leaseRefresh))
 	}
 
 	d, err := presence.NewDaemon(opts...)
@@ -262,30 +540,57 @@ func run(ctx context.Context, appName string) error {
 
 	eg.Go(func() error { return mqtt.OnConnectionLost(egCtx) })
 	eg.Go(func() error { return d.Run(egCtx) })
+	if args.stats > 0 {
+		eg.Go(func() error {
+			t := time.NewTicker(args.stats)
+			defer t.Stop()
+			for {
+				select {
+				case <-egCtx.Done():
+					return nil
+				case <-t.C:
+					logStats(d.Stats())
+				}
+			}
+		})
+	}
 
-	return eg.Wait()
+	err = eg.Wait()
+	logStats(d.Stats())
+	return err
 }
 
-// findUnixSockets returns paths to all Unix domain sockets
-// in the given directory.
-func findUnixSockets(dir string) []string {
-	var sockets []string
-	_ = filepath.WalkDir(dir, func(path string, de fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
+// logStats logs the daemon's counters.
+func logStats(s presence.Stats) {
+	log.Printf("Handled %d events (%d queued, at most %d of %d, waited for room %d times for %s); reconciled %d stations",
+		s.Handled, s.Queued, s.MaxQueued, s.QueueSize, s.Blocked, s.BlockedTime, s.Reconciled)
+}
 
-		// The "global" control interface cannot be used for wifi-presence
-		// purposes, e.g. getting station information.
-		if filepath.Base(path) == "global" {
-			return nil
-		}
+// splitSockets splits the list of control interfaces given by -hostapd.socks.
+// The port of a UDP address, e.g. udp://[::1]:8877, is separated from its host
+// by the same character as list elements, so it is rejoined with its address.
+func splitSockets(list string) []string {
+	sep := string(os.PathListSeparator)
+	parts := strings.Split(list, sep)
 
-		// Test if file is a Unix domain socket.
-		if de.Type()&os.ModeSocket != 0 {
-			sockets = append(sockets, path)
+	var sockets []string
+	for i := 0; i < len(parts); i++ {
+		sock := parts[i]
+		if sock == "udp" && i+1 < len(parts) && strings.HasPrefix(parts[i+1], "//") {
+			// Join the host, including any colons within an IPv6
+			// address, and then the port.
+			for i++; ; i++ {
+				sock += sep + parts[i]
+				if strings.Count(sock, "[") == strings.Count(sock, "]") || i+1 == len(parts) {
+					break
+				}
+			}
+			if i+1 < len(parts) {
+				i++
+				sock += sep + parts[i]
+			}
 		}
-		return nil
-	})
+		sockets = append(sockets, sock)
+	}
 	return sockets
 }
@@ -0,0 +1,5 @@
+// Package debugfs lists the stations of a WiFi interface using the
+// debugfs files of the Linux kernel's mac80211 subsystem. It is used as a
+// fallback when hostapd is unable to list stations, e.g. OpenWrt's
+// stripped down hostapd which lacks the STA-FIRST/STA-NEXT commands.
+package debugfs
@@ -0,0 +1,162 @@
+package debugfs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/awilliams/wifi-presence/internal/hostapd"
+)
+
+// DefaultRoot is the location of mac80211's debugfs directory, when debugfs
+// is mounted at the usual location.
+const DefaultRoot = "/sys/kernel/debug/ieee80211"
+
+// ErrInterfaceNotFound is returned by Stations when the interface has no
+// debugfs directory, e.g. because debugfs is not mounted, or because the
+// interface's driver does not use mac80211.
+var ErrInterfaceNotFound = errors.New("interface not found in debugfs")
+
+// flagNames maps the names used by mac80211 for station flags
+// to their hostapd equivalent. Other flags are ignored.
+var flagNames = map[string]hostapd.StationFlags{
+	"AUTH":           hostapd.FlagAuth,
+	"ASSOC":          hostapd.FlagAssoc,
+	"AUTHORIZED":     hostapd.FlagAuthorized,
+	"SHORT_PREAMBLE": hostapd.FlagShortPreamble,
+	"WME":            hostapd.FlagWMM,
+	"MFP":            hostapd.FlagMFP,
+	"PS_STA":         hostapd.FlagPS,
+}
+
+// Stations returns the stations of the network interface ifname, as listed
+// in the debugfs directory root, typically DefaultRoot. Each of the root's
+// phy directories is searched for the interface, e.g.
+// "phy0/netdev:wlan0/stations/<MAC>/".
+func Stations(root, ifname string) ([]hostapd.Station, error) {
+	dirs, err := filepath.Glob(filepath.Join(root, "phy*", "netdev:"+ifname, "stations"))
+	if err != nil {
+		return nil, err
+	}
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("%s: %w", ifname, ErrInterfaceNotFound)
+	}
+
+	var stations []hostapd.Station
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if !e.IsDir() {
+				continue
+			}
+			sta, err := readStation(filepath.Join(dir, e.Name()))
+			if err != nil {
+				return nil, fmt.Errorf("station %s: %w", e.Name(), err)
+			}
+			stations = append(stations, sta)
+		}
+	}
+	sort.Slice(stations, func(i, j int) bool { return stations[i].MAC < stations[j].MAC })
+	return stations, nil
+}
+
+// readStation reads the station's directory. Files that are missing,
+// since they vary between kernel versions, are ignored.
+func readStation(dir string) (hostapd.Station, error) {
+	sta := hostapd.Station{MAC: filepath.Base(dir)}
+
+	flags, err := readFile(dir, "flags")
+	if err != nil {
+		return sta, err
+	}
+	for _, name := range strings.Fields(flags) {
+		sta.Flags |= flagNames[name]
+	}
+	sta.Associated = sta.Flags.Has(hostapd.FlagAssoc)
+
+	aid, err := readFile(dir, "aid")
+	if err != nil {
+		return sta, err
+	}
+	if aid = strings.TrimSpace(aid); aid != "" {
+		if sta.AID, err = strconv.Atoi(aid); err != nil {
+			return sta, fmt.Errorf("invalid aid: %w", err)
+		}
+	}
+
+	connected, err := readFile(dir, "connected_time")
+	if err != nil {
+		return sta, err
+	}
+	if connected != "" {
+		if sta.Connected, err = parseConnectedTime(connected); err != nil {
+			return sta, err
+		}
+	}
+
+	return sta, nil
+}
+
+// readFile returns the contents of the file in dir, or an empty
+// string if it does not exist.
+func readFile(dir, name string) (string, error) {
+	p, err := os.ReadFile(filepath.Join(dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	return string(p), err
+}
+
+// parseConnectedTime parses the connected_time file, in the form:
+//
+//	years  - 0
+//	months - 0
+//	days   - 1
+//	clock  - 2:03:04
+//
+// Years and months are converted using 365 and 30 days.
+func parseConnectedTime(v string) (time.Duration, error) {
+	const day = 24 * time.Hour
+
+	var d time.Duration
+	scanner := bufio.NewScanner(strings.NewReader(v))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "-", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, val := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+		if key == "clock" {
+			var h, m, s int
+			if _, err := fmt.Sscanf(val, "%d:%d:%d", &h, &m, &s); err != nil {
+				return 0, fmt.Errorf("invalid connected_time clock %q: %w", val, err)
+			}
+			d += time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+			continue
+		}
+
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return 0, fmt.Errorf("invalid connected_time %s %q: %w", key, val, err)
+		}
+		switch key {
+		case "years":
+			d += time.Duration(n) * 365 * day
+		case "months":
+			d += time.Duration(n) * 30 * day
+		case "days":
+			d += time.Duration(n) * day
+		}
+	}
+	return d, scanner.Err()
+}
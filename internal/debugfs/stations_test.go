@@ -0,0 +1,91 @@
+package debugfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/awilliams/wifi-presence/internal/hostapd"
+)
+
+// writeTree creates the files, given by their path relative to root.
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStations(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"phy0/netdev:wlan0/stations/ff:ff:ff:00:00:02/flags": "AUTH\n",
+		"phy0/netdev:wlan0/stations/ff:ff:ff:00:00:02/aid":   "0\n",
+
+		"phy0/netdev:wlan0/stations/ff:ff:ff:00:00:01/flags":          "AUTH\nASSOC\nAUTHORIZED\nWME\nMFP\nINSERTED\nRATE_CONTROL\n",
+		"phy0/netdev:wlan0/stations/ff:ff:ff:00:00:01/aid":            "3\n",
+		"phy0/netdev:wlan0/stations/ff:ff:ff:00:00:01/connected_time": "years  - 0\nmonths - 0\ndays   - 1\nclock  - 2:03:04\n\n",
+
+		// Stations of other interfaces.
+		"phy0/netdev:wlan0-1/stations/ff:ff:ff:00:00:03/flags": "AUTH\nASSOC\n",
+		"phy1/netdev:wlan1/stations/ff:ff:ff:00:00:04/flags":   "AUTH\nASSOC\n",
+	})
+	// The interface of a radio without stations.
+	if err := os.MkdirAll(filepath.Join(root, "phy1/netdev:wlan2/stations"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Stations(root, "wlan0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []hostapd.Station{
+		{
+			MAC:        "ff:ff:ff:00:00:01",
+			Associated: true,
+			Flags:      hostapd.FlagAuth | hostapd.FlagAssoc | hostapd.FlagAuthorized | hostapd.FlagWMM | hostapd.FlagMFP,
+			AID:        3,
+			Connected:  26*time.Hour + 3*time.Minute + 4*time.Second,
+		},
+		{
+			MAC:   "ff:ff:ff:00:00:02",
+			Flags: hostapd.FlagAuth,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got Stations() %+v; want %+v", got, want)
+	}
+
+	got, err = Stations(root, "wlan2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("got Stations() %+v; want none", got)
+	}
+
+	_, err = Stations(root, "wlan3")
+	if !errors.Is(err, ErrInterfaceNotFound) {
+		t.Fatalf("got Stations() err %v; want %v", err, ErrInterfaceNotFound)
+	}
+}
+
+func TestStations_invalid(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"phy0/netdev:wlan0/stations/ff:ff:ff:00:00:01/aid": "x\n",
+	})
+
+	if _, err := Stations(root, "wlan0"); err == nil {
+		t.Fatal("expected error")
+	}
+}
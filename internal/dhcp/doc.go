@@ -0,0 +1,4 @@
+// Package dhcp reads the lease files of OpenWrt's DHCP servers, dnsmasq and
+// odhcpd, to find the hostname and IP addresses of a WiFi station by its MAC
+// address.
+package dhcp
@@ -0,0 +1,219 @@
+package dhcp
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Lease is a single address leased to a client.
+type Lease struct {
+	MAC      string // Lower-case, e.g. "04:ab:00:12:34:56".
+	IP       net.IP
+	Hostname string    // Empty if the client did not send a hostname.
+	Expires  time.Time // Zero if the lease does not expire.
+}
+
+// Format is the format of a lease file.
+type Format int
+
+// Lease file formats.
+const (
+	// FormatDnsmasq is the format of dnsmasq's lease file, typically
+	// /tmp/dhcp.leases on OpenWrt.
+	FormatDnsmasq Format = iota
+	// FormatOdhcpd is the format of odhcpd's state file, configured
+	// with its 'leasefile' option, e.g. /tmp/hosts/odhcpd.
+	FormatOdhcpd
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatDnsmasq:
+		return "dnsmasq"
+	case FormatOdhcpd:
+		return "odhcpd"
+	default:
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+}
+
+// Parse parses the leases of a file in the given format.
+func (f Format) Parse(r io.Reader) ([]Lease, error) {
+	switch f {
+	case FormatDnsmasq:
+		return ParseDnsmasq(r)
+	case FormatOdhcpd:
+		return ParseOdhcpd(r)
+	default:
+		return nil, fmt.Errorf("unknown lease file format %d", int(f))
+	}
+}
+
+// ParseDnsmasq parses dnsmasq's lease file. Each line of a DHCPv4 lease is in
+// the form:
+//
+//	<expiry> <MAC> <IPv4> <hostname> <client ID>
+//
+// DHCPv6 leases have the IAID in place of the MAC, and the client's DUID as the
+// client ID. The MAC is taken from the DUID when it contains one. Unknown
+// fields are "*", and an expiry of 0 means the lease does not expire.
+// Invalid lines, e.g. one being written, are skipped.
+func ParseDnsmasq(r io.Reader) ([]Lease, error) {
+	var leases []Lease
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] == "duid" {
+			// The server's DUID precedes DHCPv6 leases.
+			continue
+		}
+		if len(fields) < 4 {
+			continue
+		}
+
+		ip := net.ParseIP(fields[2])
+		if ip == nil {
+			continue
+		}
+		expires, err := parseExpiry(fields[0])
+		if err != nil {
+			continue
+		}
+
+		var mac string
+		if ip.To4() != nil {
+			mac = normalizeMAC(fields[1])
+		} else if len(fields) > 4 {
+			mac = duidMAC(strings.ReplaceAll(fields[4], ":", ""))
+		}
+		if mac == "" {
+			// Unable to correlate the lease with a station.
+			continue
+		}
+
+		leases = append(leases, Lease{
+			MAC:      mac,
+			IP:       ip,
+			Hostname: hostname(fields[3], "*"),
+			Expires:  expires,
+		})
+	}
+
+	return leases, scanner.Err()
+}
+
+// ParseOdhcpd parses odhcpd's state file. Each lease line is in the form:
+//
+//	# <interface> <DUID or MAC> <IAID or "ipv4"> <hostname> <expiry> <assigned> <prefix length> <address/length>...
+//
+// The MAC of DHCPv6 leases is taken from the DUID when it contains one. Unknown
+// hostnames are "-", and an expiry of -1 means the lease does not expire.
+// Other lines, in the hosts file format, are ignored, as are invalid leases
+// and addresses.
+func ParseOdhcpd(r io.Reader) ([]Lease, error) {
+	var leases []Lease
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "#" {
+			continue
+		}
+		if len(fields) < 9 {
+			continue
+		}
+
+		var mac string
+		if fields[3] == "ipv4" {
+			mac = normalizeMAC(fields[2])
+		} else {
+			mac = duidMAC(fields[2])
+		}
+		if mac == "" {
+			continue
+		}
+
+		var expires time.Time
+		if fields[5] != "-1" {
+			var err error
+			if expires, err = parseExpiry(fields[5]); err != nil {
+				continue
+			}
+		}
+
+		for _, addr := range fields[8:] {
+			ip := net.ParseIP(strings.SplitN(addr, "/", 2)[0])
+			if ip == nil {
+				continue
+			}
+			leases = append(leases, Lease{
+				MAC:      mac,
+				IP:       ip,
+				Hostname: hostname(fields[4], "-"),
+				Expires:  expires,
+			})
+		}
+	}
+
+	return leases, scanner.Err()
+}
+
+// parseExpiry parses a Unix timestamp, where 0 means never.
+func parseExpiry(v string) (time.Time, error) {
+	sec, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid lease expiry %q: %w", v, err)
+	}
+	if sec <= 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(sec, 0), nil
+}
+
+// hostname returns the hostname, or an empty string if
+// the hostname is the placeholder used for no hostname.
+func hostname(v, none string) string {
+	if v == none {
+		return ""
+	}
+	return v
+}
+
+// normalizeMAC returns the MAC address in lower-case, colon separated, form.
+// The address may be given without separators. An empty string is returned
+// if the address is invalid.
+func normalizeMAC(v string) string {
+	v = strings.NewReplacer(":", "", "-", "").Replace(v)
+	b, err := hex.DecodeString(v)
+	if err != nil || len(b) != 6 {
+		return ""
+	}
+	return net.HardwareAddr(b).String()
+}
+
+// duidMAC returns the MAC address of a hex encoded DUID, if it is
+// link-layer based (DUID-LLT or DUID-LL) with an Ethernet address.
+func duidMAC(duid string) string {
+	b, err := hex.DecodeString(duid)
+	if err != nil || len(b) < 4 {
+		return ""
+	}
+	duidType, hwType := int(b[0])<<8|int(b[1]), int(b[2])<<8|int(b[3])
+	if hwType != 1 {
+		return ""
+	}
+	switch {
+	case duidType == 1 && len(b) == 14: // DUID-LLT, with a 4 byte time.
+		return net.HardwareAddr(b[8:]).String()
+	case duidType == 3 && len(b) == 10: // DUID-LL.
+		return net.HardwareAddr(b[4:]).String()
+	}
+	return ""
+}
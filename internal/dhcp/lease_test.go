@@ -0,0 +1,72 @@
+package dhcp
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDnsmasq(t *testing.T) {
+	const leases = `1700000000 04:ab:00:12:34:56 192.168.1.100 phone 01:04:ab:00:12:34:56
+0 04:AB:00:12:34:57 192.168.1.101 * *
+duid 00:01:00:01:2a:2b:2c:2d:aa:bb:cc:dd:ee:ff
+1700000000 1234 fd00::100 phone 00:01:00:01:2a:2b:2c:2d:04:ab:00:12:34:56
+1700000000 1234 fd00::101 laptop 00:02:00:00:ab:11:01:02:03:04:05:06:07:08
+`
+	got, err := ParseDnsmasq(strings.NewReader(leases))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Lease{
+		{MAC: "04:ab:00:12:34:56", IP: net.ParseIP("192.168.1.100"), Hostname: "phone", Expires: time.Unix(1700000000, 0)},
+		{MAC: "04:ab:00:12:34:57", IP: net.ParseIP("192.168.1.101")},
+		// The DUID-EN lease has no MAC.
+		{MAC: "04:ab:00:12:34:56", IP: net.ParseIP("fd00::100"), Hostname: "phone", Expires: time.Unix(1700000000, 0)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got ParseDnsmasq() %+v; want %+v", got, want)
+	}
+
+	// Invalid and truncated lines are skipped.
+	got, err = ParseDnsmasq(strings.NewReader("1700000000 04:ab:00:12:34:56 invalid phone *\n0 04:ab:00:12:34:57 192.168.1.101 * *\n1700000000 04:ab:00:12:34:58 192.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []Lease{{MAC: "04:ab:00:12:34:57", IP: net.ParseIP("192.168.1.101")}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got ParseDnsmasq() %+v; want %+v", got, want)
+	}
+}
+
+func TestParseOdhcpd(t *testing.T) {
+	const leases = `# br-lan 04ab00123456 ipv4 phone 1700000000 64 32 192.168.1.100/32
+# br-lan 0003000104ab00123457 5a6b7c8d laptop -1 100 128 fd00::100/128 fd00::101/128
+# br-lan 0002000000ab1101020304050607 5a6b7c8e - 1700000000 101 128 fd00::102/128
+192.168.1.100	phone.lan	phone
+fd00::100	laptop.lan	laptop
+`
+	got, err := ParseOdhcpd(strings.NewReader(leases))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Lease{
+		{MAC: "04:ab:00:12:34:56", IP: net.ParseIP("192.168.1.100"), Hostname: "phone", Expires: time.Unix(1700000000, 0)},
+		{MAC: "04:ab:00:12:34:57", IP: net.ParseIP("fd00::100"), Hostname: "laptop"},
+		{MAC: "04:ab:00:12:34:57", IP: net.ParseIP("fd00::101"), Hostname: "laptop"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got ParseOdhcpd() %+v; want %+v", got, want)
+	}
+
+	// Invalid and truncated leases are skipped.
+	got, err = ParseOdhcpd(strings.NewReader("# br-lan 04ab00123456 ipv4 phone never 64 32 192.168.1.100/32\n# br-lan 04ab00123457 ipv4 laptop -1 65 32 192.168.1.101/32\n# br-lan 04ab00123458 ipv4 tablet 1700000000 66"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []Lease{{MAC: "04:ab:00:12:34:57", IP: net.ParseIP("192.168.1.101"), Hostname: "laptop"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got ParseOdhcpd() %+v; want %+v", got, want)
+	}
+}
@@ -0,0 +1,205 @@
+package dhcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// File is a lease file.
+type File struct {
+	Path   string
+	Format Format
+}
+
+// Host is the information about a client found in its leases.
+type Host struct {
+	Hostname string
+	IPv4     []string
+	IPv6     []string
+}
+
+// Opt is a configuration option for Leases.
+type Opt func(*Leases)
+
+// WithLogger sets the logger of Leases, which logs
+// lease files that could not be read while watching.
+func WithLogger(logger *log.Logger) Opt {
+	return func(l *Leases) {
+		l.logger = logger
+	}
+}
+
+// NewLeases returns Leases of the given files. Refresh or Watch must be
+// called to read the files.
+func NewLeases(files []File, opts ...Opt) *Leases {
+	l := Leases{
+		files:  files,
+		logger: log.New(io.Discard, "", 0),
+		hosts:  make(map[string]Host),
+	}
+	for _, opt := range opts {
+		opt(&l)
+	}
+	return &l
+}
+
+// Leases are the leases read from one or more lease files,
+// indexed by MAC address. Methods may be called concurrently.
+type Leases struct {
+	files  []File
+	logger *log.Logger
+
+	mu    sync.Mutex
+	hosts map[string]Host
+}
+
+// Lookup returns the host with the given MAC address, in any format
+// accepted by net.ParseMAC. Expired leases are ignored.
+func (l *Leases) Lookup(mac string) (Host, bool) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return Host{}, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h, ok := l.hosts[hw.String()]
+	return h, ok
+}
+
+// Refresh reads the lease files, replacing all previously read leases.
+// Files that do not exist are ignored, since the DHCP servers only create
+// them once the first lease is given.
+func (l *Leases) Refresh() error {
+	var leases []Lease
+	for _, f := range l.files {
+		fl, err := readFile(f)
+		if err != nil {
+			return err
+		}
+		leases = append(leases, fl...)
+	}
+
+	hosts := make(map[string]Host)
+	now := time.Now()
+	for _, lease := range leases {
+		if !lease.Expires.IsZero() && lease.Expires.Before(now) {
+			continue
+		}
+		h := hosts[lease.MAC]
+		if h.Hostname == "" {
+			h.Hostname = lease.Hostname
+		}
+		if ip4 := lease.IP.To4(); ip4 != nil {
+			h.IPv4 = appendIP(h.IPv4, ip4.String())
+		} else {
+			h.IPv6 = appendIP(h.IPv6, lease.IP.String())
+		}
+		hosts[lease.MAC] = h
+	}
+
+	l.mu.Lock()
+	l.hosts = hosts
+	l.mu.Unlock()
+	return nil
+}
+
+// appendIP adds the IP to the sorted list, unless already present.
+func appendIP(ips []string, ip string) []string {
+	i := sort.SearchStrings(ips, ip)
+	if i < len(ips) && ips[i] == ip {
+		return ips
+	}
+	ips = append(ips, "")
+	copy(ips[i+1:], ips[i:])
+	ips[i] = ip
+	return ips
+}
+
+func readFile(f File) ([]Lease, error) {
+	file, err := os.Open(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	leases, err := f.Format.Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Path, err)
+	}
+	return leases, nil
+}
+
+// Watch refreshes the leases, and then checks the lease files for changes
+// every interval. When a file has changed, the leases are refreshed, and fn is
+// called if any host has changed. Only an error of the initial refresh is
+// returned; later ones are logged, keeping the previously read leases. It
+// blocks until the context is done, or fn returns an error.
+func (l *Leases) Watch(ctx context.Context, interval time.Duration, fn func() error) error {
+	stats := l.stat()
+	if err := l.Refresh(); err != nil {
+		return err
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+
+		current := l.stat()
+		if reflect.DeepEqual(current, stats) {
+			continue
+		}
+		stats = current
+
+		l.mu.Lock()
+		prev := l.hosts
+		l.mu.Unlock()
+		if err := l.Refresh(); err != nil {
+			l.logger.Printf("unable to refresh DHCP leases: %v", err)
+			continue
+		}
+		l.mu.Lock()
+		changed := !reflect.DeepEqual(prev, l.hosts)
+		l.mu.Unlock()
+
+		if changed {
+			if err := fn(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// fileStat is the part of a file's information used to detect changes.
+type fileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+// stat returns the information of each file that exists.
+func (l *Leases) stat() map[string]fileStat {
+	stats := make(map[string]fileStat, len(l.files))
+	for _, f := range l.files {
+		if fi, err := os.Stat(f.Path); err == nil {
+			stats[f.Path] = fileStat{modTime: fi.ModTime(), size: fi.Size()}
+		}
+	}
+	return stats
+}
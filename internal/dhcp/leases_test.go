@@ -0,0 +1,112 @@
+package dhcp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLeases_Watch(t *testing.T) {
+	dir := t.TempDir()
+	dnsmasq := filepath.Join(dir, "dhcp.leases")
+	odhcpd := filepath.Join(dir, "odhcpd")
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	future := time.Now().Add(time.Hour).Unix()
+
+	write(dnsmasq, fmt.Sprintf("%d 04:ab:00:12:34:56 192.168.1.100 phone *\n", future))
+	leases := NewLeases([]File{
+		{Path: dnsmasq, Format: FormatDnsmasq},
+		{Path: odhcpd, Format: FormatOdhcpd}, // Does not exist yet.
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 1)
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- leases.Watch(ctx, 10*time.Millisecond, func() error {
+			changed <- struct{}{}
+			return nil
+		})
+	}()
+	waitChanged := func() {
+		t.Helper()
+		select {
+		case <-changed:
+		case err := <-watchErr:
+			t.Fatalf("Watch() err: %v", err)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for change")
+		}
+	}
+	lookup := func(mac string, want Host) {
+		t.Helper()
+		got, ok := leases.Lookup(mac)
+		if !ok {
+			t.Fatalf("Lookup(%q) not found", mac)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got Lookup(%q) %+v; want %+v", mac, got, want)
+		}
+	}
+
+	// Wait for the initial refresh before changing the files.
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if _, ok := leases.Lookup("04:ab:00:12:34:56"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for initial refresh")
+		}
+	}
+
+	write(odhcpd, fmt.Sprintf("# br-lan 0003000104ab00123456 5a6b7c8d phone %d 100 128 fd00::100/128\n", future))
+	waitChanged()
+	lookup("04:AB:00:12:34:56", Host{
+		Hostname: "phone",
+		IPv4:     []string{"192.168.1.100"},
+		IPv6:     []string{"fd00::100"},
+	})
+
+	// Expired leases are ignored.
+	past := time.Now().Add(-time.Hour).Unix()
+	write(dnsmasq, fmt.Sprintf("%d 04:ab:00:12:34:56 192.168.1.100 phone *\n%d 04:ab:00:12:34:57 192.168.1.101 laptop *\n", past, future))
+	waitChanged()
+	lookup("04:ab:00:12:34:56", Host{
+		Hostname: "phone",
+		IPv6:     []string{"fd00::100"},
+	})
+	lookup("04:ab:00:12:34:57", Host{
+		Hostname: "laptop",
+		IPv4:     []string{"192.168.1.101"},
+	})
+
+	if _, ok := leases.Lookup("04:ab:00:12:34:58"); ok {
+		t.Fatal("got Lookup() found; want not found")
+	}
+
+	// A partially written lease is skipped.
+	write(dnsmasq, fmt.Sprintf("%d 04:ab:00:12:34:59 192.168.1.103 tablet *\n%d 04:ab:00:12:34:58 192.168", future, future))
+	waitChanged()
+	lookup("04:ab:00:12:34:59", Host{
+		Hostname: "tablet",
+		IPv4:     []string{"192.168.1.103"},
+	})
+	if _, ok := leases.Lookup("04:ab:00:12:34:58"); ok {
+		t.Fatal("got Lookup() found; want not found")
+	}
+
+	cancel()
+	if err := <-watchErr; err != nil {
+		t.Fatalf("Watch() err: %v", err)
+	}
+}
@@ -1,6 +1,10 @@
 package hass
 
-import "time"
+import (
+	"path"
+	"strings"
+	"time"
+)
 
 // Documentation:
 // https://www.home-assistant.io/integrations/device_tracker.mqtt/
@@ -12,9 +16,28 @@ type Configuration struct {
 }
 
 // TrackConfig describes a single Wifi station/device to monitor for state changes.
+// A device is identified by its MAC, or when MAC is empty, by matching the hostname
+// of its DHCP lease against the Hostname pattern. Each device matching the pattern
+// is tracked, named after its hostname if Name is empty.
+//
+// A device with Mesh set is a mesh (802.11s) node, such as a backhaul access
+// point. Its availability is published as a connectivity binary sensor
+// instead of a device tracker.
 type TrackConfig struct {
-	Name string `json:"name"`
-	MAC  string `json:"mac"`
+	Name     string `json:"name"`
+	MAC      string `json:"mac"`
+	Hostname string `json:"hostname,omitempty"` // Pattern in path.Match syntax, e.g. "*-iphone".
+	Mesh     bool   `json:"mesh,omitempty"`
+}
+
+// MatchHostname returns true if the hostname matches the Hostname
+// pattern, ignoring case.
+func (t TrackConfig) MatchHostname(hostname string) bool {
+	if t.Hostname == "" || hostname == "" {
+		return false
+	}
+	ok, _ := path.Match(strings.ToLower(t.Hostname), strings.ToLower(hostname))
+	return ok
 }
 
 // DeviceTracker is used to configure HomeAssistant to track a device.
@@ -35,6 +58,27 @@ type DeviceTracker struct {
 	UniqueID            string `json:"unique_id,omitempty"`             // An ID that uniquely identifies this device_tracker. If two device_trackers have the same unique ID, Home Assistant will raise an exception.
 }
 
+// BinarySensor is used to configure HomeAssistant to show the connectivity
+// of a mesh node. It uses the same state topic and payloads as DeviceTracker.
+// https://www.home-assistant.io/integrations/binary_sensor.mqtt/
+type BinarySensor struct {
+	AvailabilityTopic   string `json:"availability_topic,omitempty"`    // The MQTT topic subscribed to receive availability (online/offline) updates.
+	Device              Device `json:"device,omitempty"`                // Information about the device this binary sensor is a part of.
+	DeviceClass         string `json:"device_class,omitempty"`          // Type of sensor, e.g. "connectivity".
+	Icon                string `json:"icon,omitempty"`                  // Icon for the entity. https://materialdesignicons.com
+	JSONAttributesTopic string `json:"json_attributes_topic,omitempty"` // The MQTT topic subscribed to receive a JSON dictionary payload and then set as sensor attributes.
+	Name                string `json:"name,omitempty"`                  // The name of the binary sensor.
+	ObjectID            string `json:"object_id,omitempty"`             // Used instead of name for automatic generation of entity_id.
+	PayloadAvailable    string `json:"payload_available,omitempty"`     // Default: online. The payload that represents the available state.
+	PayloadNotAvailable string `json:"payload_not_available,omitempty"` // Default: offline. The payload that represents the unavailable state.
+	PayloadOn           string `json:"payload_on,omitempty"`            // Default: ON. The payload that represents the on (connected) state.
+	PayloadOff          string `json:"payload_off,omitempty"`           // Default: OFF. The payload that represents the off (disconnected) state.
+	QOS                 int    `json:"qos"`                             // The QoS level of the topic.
+	StateTopic          string `json:"state_topic"`                     // Required. The MQTT topic subscribed to receive sensor state changes.
+	UniqueID            string `json:"unique_id,omitempty"`             // An ID that uniquely identifies this binary sensor.
+	ValueTemplate       string `json:"value_template,omitempty"`        // Template to extract the state from the payload, e.g. "{{ value_json.state }}".
+}
+
 // Device is part of the DeviceTracker configuration.
 type Device struct {
 	Connections  [][2]string `json:"connections"`            // A list of connections of the device to the outside world as a list of tuples [connection_type, connection_identifier]. For example the MAC address of a network interface: 'connections': ['mac', '02:5b:26:a8:dc:12'].
@@ -43,6 +87,18 @@ type Device struct {
 	Manufacturer string      `json:"manufacturer,omitempty"` // The manufacturer of the device.
 }
 
+// Nearby is published when a device that is not connected is seen nearby,
+// and once it no longer is.
+type Nearby struct {
+	State  string     `json:"state"` // PayloadNearby or PayloadNotNearby.
+	MAC    string     `json:"mac_address"`
+	APName string     `json:"ap_name"`
+	SSID   string     `json:"ssid,omitempty"`
+	BSSID  string     `json:"bssid,omitempty"`
+	Signal int        `json:"signal,omitempty"` // dBm of the last probe request, if known.
+	SeenAt *time.Time `json:"seen_at,omitempty"`
+}
+
 // Attrs are a device's attributes.
 type Attrs struct {
 	Name            string     `json:"name"`
@@ -55,4 +111,8 @@ type Attrs struct {
 	ConnectedFor    int        `json:"connected_for,omitempty"`
 	DisconnectedAt  *time.Time `json:"disconnected_at,omitempty"`
 	DisconnectedFor int        `json:"disconnected_for,omitempty"`
+	DepartureCheck  string     `json:"departure_check,omitempty"` // Result of confirming a departure, if enabled.
+	Hostname        string     `json:"hostname,omitempty"`        // From the station's DHCP lease, if any.
+	IPv4            []string   `json:"ipv4,omitempty"`
+	IPv6            []string   `json:"ipv6,omitempty"`
 }
@@ -0,0 +1,25 @@
+package hass
+
+import "testing"
+
+func TestTrackConfig_MatchHostname(t *testing.T) {
+	testCases := []struct {
+		pattern  string
+		hostname string
+		want     bool
+	}{
+		{pattern: "phone", hostname: "phone", want: true},
+		{pattern: "*-iPhone", hostname: "Johns-iphone", want: true},
+		{pattern: "*-iphone", hostname: "johns-ipad", want: false},
+		{pattern: "laptop-?", hostname: "laptop-2", want: true},
+		{pattern: "", hostname: "phone", want: false},
+		{pattern: "*", hostname: "", want: false},
+		{pattern: "[", hostname: "phone", want: false}, // Invalid pattern.
+	}
+	for _, tc := range testCases {
+		cfg := TrackConfig{Hostname: tc.pattern}
+		if got := cfg.MatchHostname(tc.hostname); got != tc.want {
+			t.Errorf("got MatchHostname(%q) with pattern %q = %v; want %v", tc.hostname, tc.pattern, got, tc.want)
+		}
+	}
+}
@@ -21,10 +21,24 @@ const (
 	PayloadHome = "connected"
 	// PayloadNotHome is the MQTT message when WiFi client disconnects.
 	PayloadNotHome = "not_connected"
+	// PayloadNearby is the state of a Nearby message when a device that is
+	// not connected is nearby.
+	PayloadNearby = "nearby"
+	// PayloadNotNearby is the state of a Nearby message when a device is
+	// no longer nearby.
+	PayloadNotNearby = "not_nearby"
 	// SourceRouter is 'source' of the device tracker.
 	SourceRouter = "router"
 
-	icon = "mdi:wifi-marker" // https://materialdesignicons.com/icon/wifi-marker
+	// DeviceClassConnectivity is the device class of mesh node binary sensors.
+	DeviceClassConnectivity = "connectivity"
+	// DeviceClassPresence is the device class of nearby binary sensors.
+	DeviceClassPresence = "presence"
+
+	icon     = "mdi:wifi-marker" // https://materialdesignicons.com/icon/wifi-marker
+	meshIcon = "mdi:access-point-network"
+	// https://materialdesignicons.com/icon/map-marker-radius
+	nearbyIcon = "mdi:map-marker-radius"
 )
 
 // MQTT QoS Values.
@@ -170,12 +184,7 @@ func (m *MQTT) RegisterDeviceTracker(ctx context.Context, dsc Discovery) error {
 		return errors.New("invalid Discovery; MAC cannot be blank")
 	}
 
-	// HomeAssistant will replace ':' with '_', so here we proactively
-	// create more friendly versions of the MAC and AP name.
-	deviceID := strings.ToLower(
-		strings.ReplaceAll(dsc.MAC, ":", "") + "_" + hassObjectIDRe.ReplaceAllString(m.apName, ""),
-	)
-
+	deviceID := m.deviceID(dsc.MAC)
 	dt := DeviceTracker{
 		AvailabilityTopic: m.topics.Will(),
 		Device: Device{
@@ -206,6 +215,120 @@ func (m *MQTT) RegisterDeviceTracker(ctx context.Context, dsc Discovery) error {
 	return tokenWait(ctx, tkn, "publish station discovery")
 }
 
+// RegisterMeshNode publishes a message for Home Assistant to show the
+// connectivity of the defined mesh node, as a binary sensor.
+func (m *MQTT) RegisterMeshNode(ctx context.Context, dsc Discovery) error {
+	if dsc.Name == "" {
+		return errors.New("invalid Discovery; Name cannot be blank")
+	}
+	if dsc.MAC == "" {
+		return errors.New("invalid Discovery; MAC cannot be blank")
+	}
+
+	deviceID := m.deviceID(dsc.MAC)
+	bs := BinarySensor{
+		AvailabilityTopic: m.topics.Will(),
+		Device: Device{
+			Name:         dsc.Name,
+			Connections:  [][2]string{{"mac", dsc.MAC}},
+			Manufacturer: VendorByMAC(dsc.MAC),
+			ViaDevice:    m.apName,
+		},
+		DeviceClass:         DeviceClassConnectivity,
+		Icon:                meshIcon,
+		JSONAttributesTopic: m.topics.DeviceJSONAttrs(dsc.MAC),
+		Name:                fmt.Sprintf("%s %s", dsc.Name, m.apName),
+		ObjectID:            deviceID,
+		PayloadAvailable:    StatusOnline,
+		PayloadNotAvailable: StatusOffline,
+		PayloadOn:           PayloadHome,
+		PayloadOff:          PayloadNotHome,
+		QOS:                 qosExactlyOnce,
+		StateTopic:          m.topics.DeviceState(dsc.MAC),
+		UniqueID:            fmt.Sprintf("wifipresence_mesh_%s", deviceID),
+	}
+	payload, err := json.Marshal(bs)
+	if err != nil {
+		return err
+	}
+
+	tkn := m.c.Publish(m.topics.MeshNodeDiscovery(dsc.MAC), qosExactlyOnce, true, payload)
+	return tokenWait(ctx, tkn, "publish mesh node discovery")
+}
+
+// UnregisterMeshNode publishes a message for Home Assistant to stop showing
+// the defined mesh node.
+func (m *MQTT) UnregisterMeshNode(ctx context.Context, mac string) error {
+	if mac == "" {
+		return errors.New("MAC cannot be blank")
+	}
+
+	tkn := m.c.Publish(m.topics.MeshNodeDiscovery(mac), qosExactlyOnce, true, []byte{})
+	return tokenWait(ctx, tkn, "publish mesh node un-discovery")
+}
+
+// RegisterNearby publishes a message for Home Assistant to show whether the
+// defined device is nearby, as a binary sensor.
+func (m *MQTT) RegisterNearby(ctx context.Context, dsc Discovery) error {
+	if dsc.Name == "" {
+		return errors.New("invalid Discovery; Name cannot be blank")
+	}
+	if dsc.MAC == "" {
+		return errors.New("invalid Discovery; MAC cannot be blank")
+	}
+
+	deviceID := m.deviceID(dsc.MAC)
+	bs := BinarySensor{
+		AvailabilityTopic: m.topics.Will(),
+		Device: Device{
+			Name:         dsc.Name,
+			Connections:  [][2]string{{"mac", dsc.MAC}},
+			Manufacturer: VendorByMAC(dsc.MAC),
+			ViaDevice:    m.apName,
+		},
+		DeviceClass:         DeviceClassPresence,
+		Icon:                nearbyIcon,
+		JSONAttributesTopic: m.topics.DeviceNearby(dsc.MAC),
+		Name:                fmt.Sprintf("%s %s nearby", dsc.Name, m.apName),
+		ObjectID:            deviceID + "_nearby",
+		PayloadAvailable:    StatusOnline,
+		PayloadNotAvailable: StatusOffline,
+		PayloadOn:           PayloadNearby,
+		PayloadOff:          PayloadNotNearby,
+		QOS:                 qosExactlyOnce,
+		StateTopic:          m.topics.DeviceNearby(dsc.MAC),
+		UniqueID:            fmt.Sprintf("wifipresence_nearby_%s", deviceID),
+		ValueTemplate:       "{{ value_json.state }}",
+	}
+	payload, err := json.Marshal(bs)
+	if err != nil {
+		return err
+	}
+
+	tkn := m.c.Publish(m.topics.NearbyDiscovery(dsc.MAC), qosExactlyOnce, true, payload)
+	return tokenWait(ctx, tkn, "publish nearby discovery")
+}
+
+// UnregisterNearby publishes a message for Home Assistant to stop showing
+// whether the defined device is nearby.
+func (m *MQTT) UnregisterNearby(ctx context.Context, mac string) error {
+	if mac == "" {
+		return errors.New("MAC cannot be blank")
+	}
+
+	tkn := m.c.Publish(m.topics.NearbyDiscovery(mac), qosExactlyOnce, true, []byte{})
+	return tokenWait(ctx, tkn, "publish nearby un-discovery")
+}
+
+// deviceID returns the object ID of the device's entity. HomeAssistant
+// will replace ':' with '_', so here we proactively create more friendly
+// versions of the MAC and AP name.
+func (m *MQTT) deviceID(mac string) string {
+	return strings.ToLower(
+		strings.ReplaceAll(mac, ":", "") + "_" + hassObjectIDRe.ReplaceAllString(m.apName, ""),
+	)
+}
+
 // UnregisterDeviceTracker publishes a message for Home Assistant to stop tracking
 // the defined device.
 func (m *MQTT) UnregisterDeviceTracker(ctx context.Context, mac string) error {
@@ -243,6 +366,17 @@ func (m *MQTT) StationAttributes(ctx context.Context, mac string, attrs Attrs) e
 	return tokenWait(ctx, tkn, "publish station attrs")
 }
 
+// StationNearby publishes whether the device is nearby.
+func (m *MQTT) StationNearby(ctx context.Context, mac string, nearby Nearby) error {
+	payload, err := json.Marshal(nearby)
+	if err != nil {
+		return err
+	}
+
+	tkn := m.c.Publish(m.topics.DeviceNearby(mac), qosAtLeastOnce, true, payload)
+	return tokenWait(ctx, tkn, "publish station nearby")
+}
+
 // ConfigTopic is the MQTT topic that SubscribeConfig will listen to.
 func (m *MQTT) ConfigTopic() string {
 	return m.topics.Config()
@@ -6,8 +6,11 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
 var mqttAddr = flag.String("mqttAddr", "", "MQTT broker address")
@@ -162,6 +165,265 @@ func TestMQTTSubscribeConfig_Cancel(t *testing.T) {
 	}
 }
 
+func TestMQTTRegisterMeshNode(t *testing.T) {
+	const testMAC = "FF:00:FF:00:FF:00"
+
+	var (
+		c           = mqttClient(t)
+		dsc         = Discovery{Name: "Test Node", MAC: testMAC}
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	)
+	defer cancel()
+
+	topic := c.topics.MeshNodeDiscovery(testMAC)
+	if want := "/binary_sensor/"; !strings.Contains(topic, want) {
+		t.Fatalf("got topic %q; want topic containing %q", topic, want)
+	}
+	if topic == c.topics.DeviceDiscovery(testMAC) {
+		t.Fatalf("got topic %q; want topic different from DeviceDiscovery", topic)
+	}
+	discovery := subscribe(t, c, topic)
+
+	if err := c.RegisterMeshNode(ctx, Discovery{MAC: testMAC}); err == nil {
+		t.Fatal("RegisterMeshNode() without Name; want error")
+	}
+	if err := c.RegisterMeshNode(ctx, dsc); err != nil {
+		t.Fatalf("RegisterMeshNode() err: %v", err)
+	}
+
+	var got BinarySensor
+	if err := json.Unmarshal(receive(t, discovery), &got); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("got: %+v", got)
+	if got.DeviceClass != DeviceClassConnectivity {
+		t.Errorf("got DeviceClass %q; want %q", got.DeviceClass, DeviceClassConnectivity)
+	}
+	// The state is that of the station, as used by device trackers.
+	if want := c.topics.DeviceState(testMAC); got.StateTopic != want {
+		t.Errorf("got StateTopic %q; want %q", got.StateTopic, want)
+	}
+	if want := c.topics.DeviceJSONAttrs(testMAC); got.JSONAttributesTopic != want {
+		t.Errorf("got JSONAttributesTopic %q; want %q", got.JSONAttributesTopic, want)
+	}
+	if got.PayloadOn != PayloadHome || got.PayloadOff != PayloadNotHome {
+		t.Errorf("got PayloadOn %q, PayloadOff %q; want %q, %q", got.PayloadOn, got.PayloadOff, PayloadHome, PayloadNotHome)
+	}
+	if want := c.topics.Will(); got.AvailabilityTopic != want {
+		t.Errorf("got AvailabilityTopic %q; want %q", got.AvailabilityTopic, want)
+	}
+	if len(got.Device.Connections) != 1 || got.Device.Connections[0] != [2]string{"mac", testMAC} {
+		t.Errorf("got Device.Connections %v; want [[mac %s]]", got.Device.Connections, testMAC)
+	}
+	if want := "wifipresence_mesh_" + c.deviceID(testMAC); got.UniqueID != want {
+		t.Errorf("got UniqueID %q; want %q", got.UniqueID, want)
+	}
+
+	// The state is published to the sensor's state topic.
+	state := subscribe(t, c, got.StateTopic)
+	if err := c.StationNotHome(ctx, testMAC); err != nil {
+		t.Fatalf("StationNotHome() err: %v", err)
+	}
+	if payload := string(receive(t, state)); payload != got.PayloadOff {
+		t.Errorf("got state %q; want %q", payload, got.PayloadOff)
+	}
+}
+
+func TestMQTTUnregisterMeshNode(t *testing.T) {
+	const testMAC = "FF:00:FF:00:FF:00"
+
+	var (
+		c           = mqttClient(t)
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	)
+	defer cancel()
+
+	discovery := subscribe(t, c, c.topics.MeshNodeDiscovery(testMAC))
+
+	if err := c.UnregisterMeshNode(ctx, ""); err == nil {
+		t.Fatal("UnregisterMeshNode() without MAC; want error")
+	}
+	if err := c.RegisterMeshNode(ctx, Discovery{Name: "Test Node", MAC: testMAC}); err != nil {
+		t.Fatalf("RegisterMeshNode() err: %v", err)
+	}
+	if payload := receive(t, discovery); len(payload) == 0 {
+		t.Fatal("got empty discovery message")
+	}
+
+	// An empty message removes the sensor.
+	if err := c.UnregisterMeshNode(ctx, testMAC); err != nil {
+		t.Fatalf("UnregisterMeshNode() err: %v", err)
+	}
+	if payload := receive(t, discovery); len(payload) != 0 {
+		t.Fatalf("got discovery message %q; want empty", payload)
+	}
+}
+
+func TestMQTTRegisterNearby(t *testing.T) {
+	const testMAC = "FF:00:FF:00:FF:00"
+
+	var (
+		c           = mqttClient(t)
+		dsc         = Discovery{Name: "Test Phone", MAC: testMAC}
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	)
+	defer cancel()
+
+	topic := c.topics.NearbyDiscovery(testMAC)
+	if want := "/binary_sensor/"; !strings.Contains(topic, want) {
+		t.Fatalf("got topic %q; want topic containing %q", topic, want)
+	}
+	// The sensor is in addition to the device tracker, or mesh node.
+	if topic == c.topics.DeviceDiscovery(testMAC) || topic == c.topics.MeshNodeDiscovery(testMAC) {
+		t.Fatalf("got topic %q; want topic different from DeviceDiscovery and MeshNodeDiscovery", topic)
+	}
+	discovery := subscribe(t, c, topic)
+
+	if err := c.RegisterNearby(ctx, Discovery{Name: "Test Phone"}); err == nil {
+		t.Fatal("RegisterNearby() without MAC; want error")
+	}
+	if err := c.RegisterNearby(ctx, dsc); err != nil {
+		t.Fatalf("RegisterNearby() err: %v", err)
+	}
+
+	var got BinarySensor
+	if err := json.Unmarshal(receive(t, discovery), &got); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("got: %+v", got)
+	if got.DeviceClass != DeviceClassPresence {
+		t.Errorf("got DeviceClass %q; want %q", got.DeviceClass, DeviceClassPresence)
+	}
+	// Both the state and attributes are from the nearby message.
+	want := c.topics.DeviceNearby(testMAC)
+	if got.StateTopic != want || got.JSONAttributesTopic != want {
+		t.Errorf("got StateTopic %q, JSONAttributesTopic %q; want %q", got.StateTopic, got.JSONAttributesTopic, want)
+	}
+	if got.ValueTemplate != "{{ value_json.state }}" {
+		t.Errorf("got ValueTemplate %q; want %q", got.ValueTemplate, "{{ value_json.state }}")
+	}
+	if got.PayloadOn != PayloadNearby || got.PayloadOff != PayloadNotNearby {
+		t.Errorf("got PayloadOn %q, PayloadOff %q; want %q, %q", got.PayloadOn, got.PayloadOff, PayloadNearby, PayloadNotNearby)
+	}
+	if want := c.deviceID(testMAC) + "_nearby"; got.ObjectID != want {
+		t.Errorf("got ObjectID %q; want %q", got.ObjectID, want)
+	}
+	if want := "wifipresence_nearby_" + c.deviceID(testMAC); got.UniqueID != want {
+		t.Errorf("got UniqueID %q; want %q", got.UniqueID, want)
+	}
+}
+
+func TestMQTTUnregisterNearby(t *testing.T) {
+	const testMAC = "FF:00:FF:00:FF:00"
+
+	var (
+		c           = mqttClient(t)
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	)
+	defer cancel()
+
+	discovery := subscribe(t, c, c.topics.NearbyDiscovery(testMAC))
+
+	if err := c.UnregisterNearby(ctx, ""); err == nil {
+		t.Fatal("UnregisterNearby() without MAC; want error")
+	}
+	if err := c.RegisterNearby(ctx, Discovery{Name: "Test Phone", MAC: testMAC}); err != nil {
+		t.Fatalf("RegisterNearby() err: %v", err)
+	}
+	if payload := receive(t, discovery); len(payload) == 0 {
+		t.Fatal("got empty discovery message")
+	}
+
+	// An empty message removes the sensor.
+	if err := c.UnregisterNearby(ctx, testMAC); err != nil {
+		t.Fatalf("UnregisterNearby() err: %v", err)
+	}
+	if payload := receive(t, discovery); len(payload) != 0 {
+		t.Fatalf("got discovery message %q; want empty", payload)
+	}
+}
+
+func TestMQTTStationNearby(t *testing.T) {
+	const testMAC = "FF:00:FF:00:FF:00"
+
+	var (
+		c           = mqttClient(t)
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		seenAt      = time.Date(2022, 10, 6, 12, 0, 0, 0, time.UTC)
+	)
+	defer cancel()
+
+	nearby := subscribe(t, c, c.topics.DeviceNearby(testMAC))
+	// The nearby state is separate from the station's state.
+	state := subscribe(t, c, c.topics.DeviceState(testMAC))
+
+	for _, want := range []Nearby{
+		{
+			State:  PayloadNearby,
+			MAC:    testMAC,
+			APName: "test-ap",
+			SSID:   "test-ssid",
+			BSSID:  "AA:BB:CC:DD:EE:FF",
+			Signal: -67,
+			SeenAt: &seenAt,
+		},
+		{
+			State:  PayloadNotNearby,
+			MAC:    testMAC,
+			APName: "test-ap",
+			SeenAt: &seenAt,
+		},
+	} {
+		if err := c.StationNearby(ctx, testMAC, want); err != nil {
+			t.Fatalf("StationNearby() err: %v", err)
+		}
+
+		var got Nearby
+		if err := json.Unmarshal(receive(t, nearby), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.State != want.State || got.MAC != want.MAC || got.APName != want.APName || got.SSID != want.SSID ||
+			got.BSSID != want.BSSID || got.Signal != want.Signal || got.SeenAt == nil || !got.SeenAt.Equal(seenAt) {
+			t.Errorf("got %+v; want %+v", got, want)
+		}
+	}
+
+	select {
+	case msg := <-state:
+		t.Fatalf("got unexpected state message %q", msg.Payload())
+	default:
+	}
+}
+
+// subscribe returns a channel of the messages received on the topic.
+func subscribe(t *testing.T, c *MQTT, topic string) <-chan mqtt.Message {
+	t.Helper()
+	msgs := make(chan mqtt.Message, 4)
+	tkn := c.c.Subscribe(topic, qosExactlyOnce, func(_ mqtt.Client, msg mqtt.Message) {
+		msgs <- msg
+	})
+	if !tkn.WaitTimeout(time.Second) {
+		t.Fatalf("timeout subscribing to %q", topic)
+	}
+	if err := tkn.Error(); err != nil {
+		t.Fatalf("Subscribe(%q) err: %v", topic, err)
+	}
+	t.Cleanup(func() { c.c.Unsubscribe(topic).WaitTimeout(time.Second) })
+	return msgs
+}
+
+// receive returns the payload of the next message.
+func receive(t *testing.T, msgs <-chan mqtt.Message) []byte {
+	t.Helper()
+	select {
+	case msg := <-msgs:
+		return msg.Payload()
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for message")
+		return nil
+	}
+}
+
 func mqttClient(t *testing.T) *MQTT {
 	t.Helper()
 	if *mqttAddr == "" {
@@ -35,11 +35,28 @@ func (m *MQTTTopics) DeviceDiscovery(mac string) string {
 	return mkTopic(m.HASSPrefix, "device_tracker", sanitizeTopic(m.Name), sanitizeMACTopic(mac), "config")
 }
 
+// MeshNodeDiscovery topic for Home Assistant binary sensor configuration
+// of a mesh node. The node_id and object_id are the same as DeviceDiscovery.
+func (m *MQTTTopics) MeshNodeDiscovery(mac string) string {
+	return mkTopic(m.HASSPrefix, "binary_sensor", sanitizeTopic(m.Name), sanitizeMACTopic(mac), "config")
+}
+
+// NearbyDiscovery topic for Home Assistant binary sensor configuration
+// of whether a device is nearby.
+func (m *MQTTTopics) NearbyDiscovery(mac string) string {
+	return mkTopic(m.HASSPrefix, "binary_sensor", sanitizeTopic(m.Name), sanitizeMACTopic(mac)+"_nearby", "config")
+}
+
 // DeviceState topic for device's state.
 func (m *MQTTTopics) DeviceState(mac string) string {
 	return mkTopic(m.Prefix, "station", sanitizeTopic(m.Name), sanitizeMACTopic(mac), "state")
 }
 
+// DeviceNearby topic for whether a device that is not connected is nearby.
+func (m *MQTTTopics) DeviceNearby(mac string) string {
+	return mkTopic(m.Prefix, "station", sanitizeTopic(m.Name), sanitizeMACTopic(mac), "nearby")
+}
+
 // DeviceJSONAttrs topic for device's attributes.
 func (m *MQTTTopics) DeviceJSONAttrs(mac string) string {
 	return mkTopic(m.Prefix, "station", sanitizeTopic(m.Name), sanitizeMACTopic(mac), "attrs")
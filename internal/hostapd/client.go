@@ -2,73 +2,376 @@ package hostapd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path"
 	"runtime"
+	"strings"
+	"sync"
 	"time"
 )
 
+// Backoff limits used by Reconnect while waiting for the control
+// interface to become available again.
+const (
+	reconnectMinBackoff = 250 * time.Millisecond
+	reconnectMaxBackoff = 15 * time.Second
+)
+
+// ErrNotAttached is returned by PollStation when Attach is not running.
+var ErrNotAttached = errors.New("client is not attached")
+
+// ErrNoPollResponse is returned by PollStation when the station does not
+// acknowledge the poll in time.
+var ErrNoPollResponse = errors.New("no poll response from station")
+
+// defaultMaxMissedPongs is the number of consecutive unanswered PINGs
+// after which a socket is considered unresponsive.
+const defaultMaxMissedPongs = 3
+
+// ClientOpt is a configuration option for Client.
+type ClientOpt func(*Client)
+
+// WithPingInterval enables liveness monitoring. While attached, both the
+// command and attach sockets are sent a PING at the given interval. If either
+// socket misses too many consecutive PONG responses, Attach returns
+// an error wrapping ErrUnresponsive. 0 disables monitoring (the default).
+func WithPingInterval(interval time.Duration) ClientOpt {
+	return func(c *Client) {
+		c.pingInterval = interval
+	}
+}
+
+// WithMaxMissedPongs sets the number of consecutive unanswered PINGs after
+// which a socket is considered unresponsive. Only used when WithPingInterval
+// is also set.
+func WithMaxMissedPongs(n int) ClientOpt {
+	return func(c *Client) {
+		if n > 0 {
+			c.cmdHealth.maxMissed = n
+			c.attachHealth.maxMissed = n
+		}
+	}
+}
+
+// WithProbeRequests requests that hostapd also sends an RX-PROBE-REQUEST
+// event, parsed as an EventProbeRequest, for each probe request received
+// while attached.
+func WithProbeRequests() ClientOpt {
+	return func(c *Client) {
+		c.probeRequests = true
+	}
+}
+
+// defaultTimeout is the default time to wait for the response to a command.
+const defaultTimeout = time.Second
+
+// WithTimeout sets the time to wait for the response to a command. A
+// command's context may further limit the time. The default is 1 second.
+func WithTimeout(timeout time.Duration) ClientOpt {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithCommandTimeout sets the time to wait for the response to the named
+// command, e.g. "STA-FIRST", overriding WithTimeout for that command.
+func WithCommandTimeout(cmd string, timeout time.Duration) ClientOpt {
+	return func(c *Client) {
+		if c.cmdTimeouts == nil {
+			c.cmdTimeouts = make(map[string]time.Duration)
+		}
+		c.cmdTimeouts[cmd] = timeout
+	}
+}
+
+// WithMaxMessageSize sets the maximum size, in bytes, of messages received
+// from the control interface. Larger command responses result in an
+// ErrTruncated, and larger events are discarded. The default is 64 KiB.
+func WithMaxMessageSize(size int) ClientOpt {
+	return func(c *Client) {
+		c.maxMsgSize = size
+	}
+}
+
+// WithLogger sets the logger of the Client, which logs messages from the
+// control interface that are discarded, e.g. those larger than the maximum
+// message size.
+func WithLogger(l *log.Logger) ClientOpt {
+	return func(c *Client) {
+		c.logger = l
+	}
+}
+
 // NewClient connects to the hostap control interface located
-// at ctrlSock.
-func NewClient(localSockDir, ctrlSock string) (*Client, error) {
+// at ctrlSock. This is either the path of a Unix socket, or the address of
+// a UDP control interface of the form udp://host:port, in which case
+// localSockDir is unused.
+func NewClient(localSockDir, ctrlSock string, opts ...ClientOpt) (*Client, error) {
+	return newClient(localSockDir, ctrlSock, "", nil, opts...)
+}
+
+// newClient returns a connected Client. If ifname is non-blank, then
+// ctrlSock is a global control interface, and the client's commands are
+// routed to the named interface.
+func newClient(localSockDir, ctrlSock, ifname string, global *Global, opts ...ClientOpt) (*Client, error) {
 	if localSockDir == "" {
 		localSockDir = os.TempDir()
 	}
+	name := path.Base(ctrlSock)
+	if ifname != "" {
+		name = fmt.Sprintf("%s.%s", name, ifname)
+	}
 	lpath := path.Join(
 		localSockDir,
-		fmt.Sprintf("wp.%s", path.Base(ctrlSock)),
+		fmt.Sprintf("wp.%s", name),
 	)
-	if err := isValidSocketPath(lpath); err != nil {
+	if !isUDPAddr(ctrlSock) {
+		if err := isValidSocketPath(lpath); err != nil {
+			return nil, err
+		}
+	}
+
+	c := Client{
+		localSockDir: localSockDir,
+		localSock:    lpath,
+		ctrlSock:     ctrlSock,
+		ifname:       ifname,
+		global:       global,
+		timeout:      defaultTimeout,
+		cmdHealth:    socketHealth{maxMissed: defaultMaxMissedPongs},
+		attachHealth: socketHealth{maxMissed: defaultMaxMissedPongs},
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if err := c.dial(context.Background()); err != nil {
 		return nil, err
 	}
+	if isUDPAddr(ctrlSock) && ifname == "" {
+		c.udpIfname = c.findInterface(context.Background())
+	}
+	return &c, nil
+}
+
+// Client is a hostapd control interface client.
+type Client struct {
+	localSockDir string
+	localSock    string
+	ctrlSock     string
+	pingInterval time.Duration
+	maxMsgSize   int         // If zero, the ctrl's default is used.
+	logger       *log.Logger // If nil, the ctrl's default is used.
+	timeout      time.Duration
+	cmdTimeouts  map[string]time.Duration
+	// If true, Attach requests probe request events.
+	probeRequests bool
 
-	conn, err := newUnixSocketConn(lpath, ctrlSock)
+	// Set when the client uses the global control interface.
+	ifname string
+	global *Global
+
+	// Interface of a UDP control interface, if found.
+	udpIfname string
+
+	cmdHealth    socketHealth
+	attachHealth socketHealth
+
+	pollMu   sync.Mutex // Protects following.
+	attached int        // Number of active Attach calls.
+	pollers  map[string][]chan struct{}
+
+	mu   sync.Mutex // Protects following.
+	conn *conn
+	ctrl *ctrl
+}
+
+// dial connects to the control interface, replacing any existing
+// connection.
+func (c *Client) dial(ctx context.Context) error {
+	conn, err := c.newConn(ctx, c.localSock)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	ctrl, err := newCtrl(conn, time.Second, time.Second)
+	ctrl, err := c.newCtrl(conn)
 	if err != nil {
 		conn.Close()
-		return nil, err
+		return err
 	}
+	ctrl.health = &c.cmdHealth
+	// Unsolicited events are not expected on the command socket, but
+	// poll acknowledgements are still useful.
+	ctrl.stray = func(_ string, e Event) {
+		if poll, ok := e.(EventStationPollOK); ok {
+			c.notifyPollers(poll.MAC)
+		}
+	}
+	if ctrl.ifname = c.ifname; ctrl.ifname != "" {
+		// Ensure that the interface exists.
+		if err = ctrl.ping(ctx); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	c.cmdHealth.pong() // newCtrl has successfully sent a PING.
 
-	return &Client{
-		localSockDir: localSockDir,
-		ctrlSock:     ctrlSock,
-		conn:         conn,
-		ctrl:         ctrl,
-	}, nil
+	c.mu.Lock()
+	c.conn, c.ctrl = conn, ctrl
+	c.mu.Unlock()
+
+	return nil
 }
 
-// Client is a hostapd control interface client.
-type Client struct {
-	localSockDir string
-	ctrlSock     string
-	conn         *conn
-	ctrl         *ctrl
+// newConn creates a connection with the control interface. The localPath is
+// used for the local socket file of Unix socket connections.
+func (c *Client) newConn(ctx context.Context, localPath string) (*conn, error) {
+	if isUDPAddr(c.ctrlSock) {
+		return newUDPConn(ctx, c.ctrlSock, c.timeout)
+	}
+	return newUnixSocketConn(localPath, c.ctrlSock)
+}
+
+// newCtrl returns a ctrl using the given connection, configured
+// with the client's options.
+func (c *Client) newCtrl(conn *conn) (*ctrl, error) {
+	ctrl, err := newCtrl(conn, c.timeout, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	ctrl.timeouts = c.cmdTimeouts
+	if c.logger != nil {
+		ctrl.logger = c.logger
+	}
+	if c.maxMsgSize > 0 {
+		ctrl.setMaxMsgSize(c.maxMsgSize)
+	}
+	return ctrl, nil
+}
+
+// getCtrl returns the ctrl of the current connection.
+func (c *Client) getCtrl() *ctrl {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ctrl
 }
 
 // Close closes the connection to the control interface. The client
 // is no longer usable after closing.
 func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.conn.Close()
 }
 
+// Reconnect closes the current connection to the control interface and
+// dials it again, retrying with an increasing backoff until the control
+// interface responds or the context is cancelled. This is typically used
+// after Attach returns ErrTerminating, since hostapd removes and re-creates
+// its control socket when restarting.
+func (c *Client) Reconnect(ctx context.Context) error {
+	c.mu.Lock()
+	c.conn.Close()
+	c.mu.Unlock()
+
+	backoff := reconnectMinBackoff
+	for {
+		err := c.dial(ctx)
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("unable to reconnect to %q: %w (last error: %v)", c.ctrlSock, ctx.Err(), err)
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > reconnectMaxBackoff {
+			backoff = reconnectMaxBackoff
+		}
+	}
+}
+
+// Ping tests whether the command socket is responding, updating
+// the socket's health accordingly.
+func (c *Client) Ping(ctx context.Context) error {
+	if err := c.getCtrl().ping(ctx); err != nil {
+		c.cmdHealth.miss()
+		return err
+	}
+	c.cmdHealth.pong()
+	return nil
+}
+
+// Health returns the liveness state of the client's sockets. For clients
+// of the global control interface, the attach socket is shared by all
+// interfaces.
+func (c *Client) Health() Health {
+	attach := &c.attachHealth
+	if c.global != nil {
+		attach = &c.global.client.attachHealth
+	}
+	return Health{
+		Command: c.cmdHealth.get(),
+		Attach:  attach.get(),
+	}
+}
+
+// Interface returns the name of the network interface the control
+// interface belongs to. hostapd names each control socket after its
+// interface, e.g. /var/run/hostapd/wlan0-1. The address of a UDP control
+// interface does not include it, so it is found from hostapd's status when
+// the client is created, falling back to the address if it is not found.
+func (c *Client) Interface() string {
+	if c.ifname != "" {
+		return c.ifname
+	}
+	if c.udpIfname != "" {
+		return c.udpIfname
+	}
+	return path.Base(c.ctrlSock)
+}
+
+// findInterface returns the interface of the BSS, listed in the status,
+// whose BSSID is that of the control interface's configuration, or
+// blank if it is not found.
+func (c *Client) findInterface(ctx context.Context) string {
+	ctrl := c.getCtrl()
+	bssid, err := ctrl.configBSSID(ctx)
+	if err != nil {
+		return ""
+	}
+	status, err := ctrl.status(ctx)
+	if err != nil {
+		return ""
+	}
+	for _, bss := range status.BSS {
+		if bss.Interface != "" && strings.EqualFold(bss.BSSID, bssid) {
+			return bss.Interface
+		}
+	}
+	return ""
+}
+
 // Status returns the station's status.
-func (c *Client) Status() (Status, error) {
-	return c.ctrl.status()
+func (c *Client) Status(ctx context.Context) (Status, error) {
+	return c.getCtrl().status(ctx)
 }
 
 // Stations returns the connected stations.
 // Note that stations with Associated=false should
 // not be considered as connected. This state can happen if
 // Stations is called immediately after a station disconnects.
-func (c *Client) Stations() ([]Station, error) {
-	var stations []Station
+func (c *Client) Stations(ctx context.Context) ([]Station, error) {
+	var (
+		stations []Station
+		ctrl     = c.getCtrl()
+	)
 
-	station, ok, err := c.ctrl.stationFirst()
+	station, ok, err := ctrl.stationFirst(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -78,25 +381,158 @@ func (c *Client) Stations() ([]Station, error) {
 	stations = append(stations, station)
 
 	for {
-		station, ok, err = c.ctrl.stationNext(station.MAC)
-		if !ok {
-			break
-		}
+		station, ok, err = ctrl.stationNext(ctx, station.MAC)
 		if err != nil {
 			return nil, err
 		}
+		if !ok {
+			break
+		}
 		stations = append(stations, station)
 	}
 
 	return stations, nil
 }
 
+// Station returns information about the station with the given MAC address.
+// ErrStationNotFound is returned if hostapd does not know of the station.
+func (c *Client) Station(ctx context.Context, mac string) (Station, error) {
+	return c.getCtrl().station(ctx, mac)
+}
+
+// PollStation actively polls the station with the given MAC address, by
+// sending it a null data frame. It blocks until the station acknowledges
+// the poll, or until the context is done, in which case an error wrapping
+// ErrNoPollResponse is returned. Use context.WithTimeout to limit the time
+// spent waiting.
+//
+// hostapd reports the acknowledgement asynchronously as an AP-STA-POLL-OK
+// event, so Attach must be running for PollStation to succeed. ErrNotAttached
+// is returned otherwise.
+func (c *Client) PollStation(ctx context.Context, mac string) error {
+	acked, unregister, err := c.registerPoller(mac)
+	if err != nil {
+		return err
+	}
+	defer unregister()
+
+	if err = c.getCtrl().pollStation(ctx, mac); err != nil {
+		return err
+	}
+
+	select {
+	case <-acked:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%s: %w (%v)", mac, ErrNoPollResponse, ctx.Err())
+	}
+}
+
+// registerPoller returns a channel that is closed when an AP-STA-POLL-OK
+// event is received for the given mac, along with a function to unregister
+// the channel.
+func (c *Client) registerPoller(mac string) (<-chan struct{}, func(), error) {
+	key := strings.ToLower(mac)
+	acked := make(chan struct{})
+
+	c.pollMu.Lock()
+	defer c.pollMu.Unlock()
+
+	if c.attached == 0 {
+		return nil, nil, ErrNotAttached
+	}
+	if c.pollers == nil {
+		c.pollers = make(map[string][]chan struct{})
+	}
+	c.pollers[key] = append(c.pollers[key], acked)
+
+	unregister := func() {
+		c.pollMu.Lock()
+		defer c.pollMu.Unlock()
+		pollers := c.pollers[key]
+		for i, p := range pollers {
+			if p == acked {
+				pollers = append(pollers[:i], pollers[i+1:]...)
+				break
+			}
+		}
+		if len(pollers) == 0 {
+			delete(c.pollers, key)
+		} else {
+			c.pollers[key] = pollers
+		}
+	}
+	return acked, unregister, nil
+}
+
+// notifyPollers unblocks all PollStation calls waiting on the given mac.
+func (c *Client) notifyPollers(mac string) {
+	key := strings.ToLower(mac)
+
+	c.pollMu.Lock()
+	defer c.pollMu.Unlock()
+
+	for _, acked := range c.pollers[key] {
+		close(acked)
+	}
+	delete(c.pollers, key)
+}
+
+// setAttached records the start (+1) or end (-1) of an Attach call.
+func (c *Client) setAttached(delta int) {
+	c.pollMu.Lock()
+	c.attached += delta
+	c.pollMu.Unlock()
+}
+
 // Attach subscribes to hostapd events. For each event, the provided
 // callback function will be called. The callback should return quickly, since
 // it blocks attach from processing. Attach blocks until an error occurs
 // or the provided context is canceled. Any error returned from the provided
 // callback will stop attach and be returned.
+//
+// For clients of the global control interface, events are received through
+// Global.Attach, which must also be running.
 func (c *Client) Attach(ctx context.Context, events func(Event) error) error {
+	c.setAttached(1)
+	defer c.setAttached(-1)
+
+	// Deliver poll acknowledgements to any waiting PollStation calls
+	// before passing the event along.
+	cb := events
+	events = func(e Event) error {
+		if poll, ok := e.(EventStationPollOK); ok {
+			c.notifyPollers(poll.MAC)
+		}
+		return cb(e)
+	}
+
+	// Monitor the command socket while attached. If it becomes
+	// unresponsive, then stop attach and return the error.
+	attachCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmdErr := c.monitorCmd(attachCtx, cancel)
+
+	var err error
+	if c.global != nil {
+		err = c.global.subscribe(attachCtx, c.ifname, events)
+	} else {
+		err = c.attach(attachCtx, func(_ string, e Event) error {
+			return events(e)
+		})
+	}
+
+	select {
+	case cErr := <-cmdErr:
+		return cErr
+	default:
+		return err
+	}
+}
+
+// attach creates a socket dedicated to receiving events and attaches it
+// to the control interface.
+func (c *Client) attach(ctx context.Context, events func(ifname string, e Event) error) error {
 	// Create a separate socket local to this method. This allows
 	// the Client's main socket to still be used while attach is in use.
 
@@ -104,24 +540,63 @@ func (c *Client) Attach(ctx context.Context, events func(Event) error) error {
 		c.localSockDir,
 		fmt.Sprintf("wp-attach.%s", path.Base(c.ctrlSock)),
 	)
-	if err := isValidSocketPath(lpath); err != nil {
-		return err
+	if !isUDPAddr(c.ctrlSock) {
+		if err := isValidSocketPath(lpath); err != nil {
+			return err
+		}
 	}
 
-	conn, err := newUnixSocketConn(lpath, c.ctrlSock)
+	conn, err := c.newConn(ctx, lpath)
 	if err != nil {
 		return fmt.Errorf("unable to create 'attach' socket: %w", err)
 	}
 	defer conn.Close()
 
-	ctrl, err := newCtrl(conn, time.Second, time.Second)
+	c.attachHealth.reset()
+	ctrl, err := c.newCtrl(conn)
 	if err != nil {
 		return err
 	}
+	ctrl.pingInterval = c.pingInterval
+	ctrl.health = &c.attachHealth
+	ctrl.probeRequests = c.probeRequests
+	c.attachHealth.pong() // newCtrl has successfully sent a PING.
+	defer c.attachHealth.reset()
 
 	return ctrl.attach(ctx, events)
 }
 
+// monitorCmd periodically pings the command socket until the context is
+// done. If the socket becomes unresponsive, an error is sent on the returned
+// channel and cancel is called. Nothing is done if pinging is disabled.
+func (c *Client) monitorCmd(ctx context.Context, cancel func()) <-chan error {
+	cmdErr := make(chan error, 1)
+	if c.pingInterval == 0 {
+		return cmdErr
+	}
+
+	go func() {
+		t := time.NewTicker(c.pingInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			if c.Ping(ctx) == nil {
+				continue
+			}
+			if h := c.cmdHealth.get(); h.State == HealthUnresponsive {
+				cmdErr <- fmt.Errorf("command socket missed %d PONG responses: %w", h.MissedPongs, ErrUnresponsive)
+				cancel()
+				return
+			}
+		}
+	}()
+	return cmdErr
+}
+
 // isValidSocketPath returns an error if the given path is invalid for a
 // Unix socket.
 func isValidSocketPath(p string) error {
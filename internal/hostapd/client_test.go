@@ -4,7 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
+	"os"
 	"path"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -34,7 +38,7 @@ func TestClient_Status(t *testing.T) {
 	}
 	defer client.Close()
 
-	got, err := client.Status()
+	got, err := client.Status(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -57,6 +61,52 @@ func TestClient_Status(t *testing.T) {
 	}
 }
 
+func TestClient_Status_multiBSS(t *testing.T) {
+	sockDir := t.TempDir()
+	hostapd, err := hostapdtest.NewHostAPD(path.Join(sockDir, "wlan0-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hostapd.Close() })
+
+	statusResp := hostapdtest.StatusResp{
+		State: "ENABLED",
+		SSID:  "main",
+		BSSID: "AA:AA:AA:AA:AA:01",
+		BSS: []hostapdtest.BSSResp{
+			{Interface: "wlan0-1", SSID: "guest", BSSID: "AA:AA:AA:AA:AA:02"},
+		},
+	}
+	go hostapd.Serve(hostapdtest.DefaultHostAPDHandler(statusResp, nil))
+
+	client, err := NewClient(t.TempDir(), hostapd.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if got := client.Interface(); got != "wlan0-1" {
+		t.Fatalf("got Interface %q; want %q", got, "wlan0-1")
+	}
+
+	status, err := client.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.BSS) != 2 {
+		t.Fatalf("got %d BSSs; want 2", len(status.BSS))
+	}
+
+	bss, ok := status.BSSByInterface(client.Interface())
+	if !ok {
+		t.Fatalf("BSSByInterface(%q) not found", client.Interface())
+	}
+	if bss.SSID != "guest" || bss.BSSID != "AA:AA:AA:AA:AA:02" {
+		t.Fatalf("got BSS %+v; want guest BSS", bss)
+	}
+	t.Logf("got BSS: %+v", bss)
+}
+
 func TestClient_Stations(t *testing.T) {
 	hostapd, err := hostapdtest.NewHostAPD(path.Join(t.TempDir(), "hap"))
 	if err != nil {
@@ -91,7 +141,7 @@ func TestClient_Stations(t *testing.T) {
 	}
 	defer client.Close()
 
-	got, err := client.Stations()
+	got, err := client.Stations(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -134,7 +184,7 @@ func TestClient_Stations_unknownCmd(t *testing.T) {
 	}
 	defer client.Close()
 
-	_, err = client.Stations()
+	_, err = client.Stations(context.Background())
 
 	var unknown ErrUnknownCmd
 	if !errors.As(err, &unknown) {
@@ -143,6 +193,50 @@ func TestClient_Stations_unknownCmd(t *testing.T) {
 	t.Logf("client.Stations() (expected) err: %v (type: %T)", err, err)
 }
 
+func TestClient_Stations_nextTimeout(t *testing.T) {
+	hostapd, err := hostapdtest.NewHostAPD(path.Join(t.TempDir(), "hap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hostapd.Close() })
+
+	stations := []hostapdtest.StationResp{
+		{MAC: "FF:FF:FF:00:00:01", Assoc: true},
+		{MAC: "FF:FF:00:00:00:02", Assoc: true},
+		{MAC: "FF:00:00:00:00:03", Assoc: true},
+	}
+
+	handler := hostapdtest.DefaultHostAPDHandler(hostapdtest.StatusResp{}, stations)
+	// Delay the response to the STA-NEXT request following the second
+	// station, so that the listing fails partway through.
+	handler.OnStationNext(func(mac string) (hostapdtest.StationResp, bool) {
+		switch mac {
+		case stations[0].MAC:
+			return stations[1], true
+		case stations[1].MAC:
+			time.Sleep(200 * time.Millisecond)
+			return stations[2], true
+		}
+		return hostapdtest.StationResp{}, false
+	})
+	go hostapd.Serve(handler)
+
+	client, err := NewClient(t.TempDir(), hostapd.Addr, WithCommandTimeout("STA-NEXT", 50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	got, err := client.Stations(context.Background())
+	if err == nil {
+		t.Fatalf("client.Stations() returned %d stations and no error; want error", len(got))
+	}
+	if got != nil {
+		t.Errorf("client.Stations() returned %d stations with error; want none", len(got))
+	}
+	t.Logf("client.Stations() (expected) err: %v", err)
+}
+
 func TestClient_Attach(t *testing.T) {
 	hostapd, err := hostapdtest.NewHostAPD(path.Join(t.TempDir(), "hap"))
 	if err != nil {
@@ -215,6 +309,238 @@ func TestClient_Attach(t *testing.T) {
 	}
 }
 
+func TestClient_Attach_probeRequests(t *testing.T) {
+	hostapd, err := hostapdtest.NewHostAPD(path.Join(t.TempDir(), "hap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hostapd.Close() })
+
+	handler := hostapdtest.DefaultHostAPDHandler(hostapdtest.StatusResp{}, nil)
+	attachCmds := make(chan string, 1)
+	handler.OnMessage(func(msg string) {
+		if strings.HasPrefix(msg, cmdAttach) {
+			attachCmds <- msg
+		}
+	})
+	hostapdAttach := make(chan string)
+	handler.OnAttach(func() <-chan string {
+		return hostapdAttach
+	})
+	go hostapd.Serve(handler)
+
+	client, err := NewClient(t.TempDir(), hostapd.Addr, WithProbeRequests())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, attachCancel := context.WithCancel(context.Background())
+	defer attachCancel()
+	attachEvents := make(chan Event, 1)
+	attachErr := make(chan error, 1)
+	go func() {
+		attachErr <- client.Attach(ctx, func(event Event) error {
+			attachEvents <- event
+			return nil
+		})
+	}()
+
+	select {
+	case got := <-attachCmds:
+		if want := cmdAttach + " " + attachProbeRxEvents; got != want {
+			t.Fatalf("got command %q; want %q", got, want)
+		}
+	case err := <-attachErr:
+		t.Fatalf("Attach() error: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for ATTACH")
+	}
+
+	const probe = "<3>RX-PROBE-REQUEST sa=04:ab:00:12:34:56 signal=-67"
+	select {
+	case hostapdAttach <- probe:
+	case err := <-attachErr:
+		t.Fatalf("Attach() error: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("timeout sending to hostapd events chan")
+	}
+	select {
+	case got := <-attachEvents:
+		want := EventProbeRequest{raw: probe, MAC: "04:ab:00:12:34:56", Signal: -67}
+		if got != want {
+			t.Fatalf("got event %#v; want %#v", got, want)
+		}
+	case err := <-attachErr:
+		t.Fatalf("Attach() error: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("timeout reading from events chan")
+	}
+}
+
+func TestClient_UDP(t *testing.T) {
+	const testMAC = "FF:FF:FF:00:00:01"
+
+	hostapd, err := hostapdtest.NewUDPHostAPD("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hostapd.Close() })
+
+	handler := hostapdtest.DefaultHostAPDHandler(hostapdtest.StatusResp{SSID: "udp-ssid"}, []hostapdtest.StationResp{
+		{MAC: testMAC, Assoc: true},
+	})
+	hostapdAttach := make(chan string)
+	handler.OnAttach(func() <-chan string {
+		return hostapdAttach
+	})
+	go hostapd.Serve(handler)
+
+	// Commands without the cookie are dropped.
+	raw, err := net.Dial("udp", strings.TrimPrefix(hostapd.Addr, "udp://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	if _, err = raw.Write([]byte("PING")); err != nil {
+		t.Fatal(err)
+	}
+	raw.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if n, err := raw.Read(make([]byte, 16)); err == nil {
+		t.Fatalf("got response to PING without cookie (%d bytes); want none", n)
+	}
+
+	client, err := NewClient("", hostapd.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	status, err := client.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.SSID != "udp-ssid" {
+		t.Errorf("got SSID %q; want %q", status.SSID, "udp-ssid")
+	}
+
+	stations, err := client.Stations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stations) != 1 || stations[0].MAC != testMAC {
+		t.Errorf("got Stations() %+v; want single station %q", stations, testMAC)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	attachEvents := make(chan Event, 1)
+	attachErr := make(chan error, 1)
+	go func() {
+		attachErr <- client.Attach(ctx, func(e Event) error {
+			attachEvents <- e
+			return nil
+		})
+	}()
+
+	msg := fmt.Sprintf("<3>%s %s", eventAPStaConnected, testMAC)
+	select {
+	case hostapdAttach <- msg:
+	case err := <-attachErr:
+		t.Fatalf("Attach() error: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("timeout sending to hostapd events chan")
+	}
+	select {
+	case got := <-attachEvents:
+		if want := (EventStationConnect{raw: msg, MAC: testMAC}); !reflect.DeepEqual(got, want) {
+			t.Fatalf("got event %#v; want %#v", got, want)
+		}
+	case err := <-attachErr:
+		t.Fatalf("Attach() error: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("timeout reading from events chan")
+	}
+
+	cancel()
+	select {
+	case err := <-attachErr:
+		if err != nil {
+			t.Fatalf("Attach() error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for attach to finish")
+	}
+}
+
+func TestClient_UDPMultiBSS(t *testing.T) {
+	// Both BSSs of the radio have their own UDP control interface,
+	// whose addresses do not identify their interface.
+	status := hostapdtest.StatusResp{
+		Interface: "wlan0",
+		SSID:      "main",
+		BSSID:     "aa:bb:cc:dd:ee:00",
+		BSS: []hostapdtest.BSSResp{
+			{Interface: "wlan0-1", SSID: "guest", BSSID: "aa:bb:cc:dd:ee:01"},
+		},
+	}
+	for _, want := range []hostapdtest.BSSResp{
+		{Interface: "wlan0", SSID: status.SSID, BSSID: status.BSSID},
+		status.BSS[0],
+	} {
+		want := want
+		hostapd, err := hostapdtest.NewUDPHostAPD("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { hostapd.Close() })
+
+		handler := hostapdtest.DefaultHostAPDHandler(status, nil)
+		handler.OnGetConfig(func() hostapdtest.BSSResp {
+			// The BSSID is upper case in the configuration.
+			return hostapdtest.BSSResp{SSID: want.SSID, BSSID: strings.ToUpper(want.BSSID)}
+		})
+		go hostapd.Serve(handler)
+
+		client, err := NewClient("", hostapd.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		if got := client.Interface(); got != want.Interface {
+			t.Fatalf("got Interface() %q; want %q", got, want.Interface)
+		}
+		s, err := client.Status(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		bss, ok := s.BSSByInterface(client.Interface())
+		if !ok || bss.SSID != want.SSID {
+			t.Fatalf("got BSSByInterface(%q) %+v, %t; want SSID %q", client.Interface(), bss, ok, want.SSID)
+		}
+	}
+
+	// Without the configuration, the interface is unknown.
+	hostapd, err := hostapdtest.NewUDPHostAPD("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hostapd.Close() })
+	handler := hostapdtest.DefaultHostAPDHandler(status, nil)
+	handler.OnGetConfig(nil)
+	go hostapd.Serve(handler)
+
+	client, err := NewClient("", hostapd.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if got, want := client.Interface(), strings.TrimPrefix(hostapd.Addr, "udp://"); got != want {
+		t.Fatalf("got Interface() %q; want %q", got, want)
+	}
+}
+
 func TestClient_Attach_term(t *testing.T) {
 	hostapd, err := hostapdtest.NewHostAPD(path.Join(t.TempDir(), "hap"))
 	if err != nil {
@@ -271,3 +597,209 @@ func TestClient_Attach_term(t *testing.T) {
 		t.Fatal("timeout waiting for attach error")
 	}
 }
+
+func TestClient_Reconnect(t *testing.T) {
+	sockPath := path.Join(t.TempDir(), "hap")
+	hostapd, err := hostapdtest.NewHostAPD(sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hostapd.Close() })
+
+	handler := hostapdtest.DefaultHostAPDHandler(hostapdtest.StatusResp{SSID: "before"}, nil)
+	go hostapd.Serve(handler)
+
+	client, err := NewClient(t.TempDir(), hostapd.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Simulate hostapd restarting, which removes and later re-creates
+	// the control socket.
+	hostapd.Close()
+	if err := os.Remove(sockPath); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := make(chan error, 1)
+	go func() {
+		time.Sleep(2 * reconnectMinBackoff)
+		hostapd, err := hostapdtest.NewHostAPD(sockPath)
+		if err != nil {
+			restarted <- err
+			return
+		}
+		t.Cleanup(func() { hostapd.Close() })
+		restarted <- nil
+
+		handler := hostapdtest.DefaultHostAPDHandler(hostapdtest.StatusResp{SSID: "after"}, nil)
+		hostapd.Serve(handler)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Reconnect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-restarted; err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := client.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SSID != "after" {
+		t.Fatalf("got SSID %q; want %q", got.SSID, "after")
+	}
+	t.Logf("got Status after reconnect: %+v", got)
+}
+
+func TestClient_Reconnect_cancel(t *testing.T) {
+	sockPath := path.Join(t.TempDir(), "hap")
+	hostapd, err := hostapdtest.NewHostAPD(sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hostapd.Close() })
+	go hostapd.Serve(hostapdtest.DefaultHostAPDHandler(hostapdtest.StatusResp{}, nil))
+
+	client, err := NewClient(t.TempDir(), hostapd.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	hostapd.Close()
+	if err := os.Remove(sockPath); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*reconnectMinBackoff)
+	defer cancel()
+	err = client.Reconnect(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Reconnect() err: %v; want %v", err, context.DeadlineExceeded)
+	}
+	t.Logf("Reconnect() err (expected): %v", err)
+}
+
+func TestClient_Station(t *testing.T) {
+	hostapd, err := hostapdtest.NewHostAPD(path.Join(t.TempDir(), "hap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hostapd.Close() })
+
+	stations := []hostapdtest.StationResp{
+		{MAC: "FF:FF:FF:00:00:01", Assoc: true, Signal: -41},
+		{MAC: "FF:FF:FF:00:00:02", Assoc: true, Signal: -42},
+	}
+	go hostapd.Serve(hostapdtest.DefaultHostAPDHandler(hostapdtest.StatusResp{}, stations))
+
+	client, err := NewClient(t.TempDir(), hostapd.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	got, err := client.Station(context.Background(), stations[1].MAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.MAC != stations[1].MAC || got.Signal != stations[1].Signal || !got.Associated {
+		t.Fatalf("got Station %+v; want %+v", got, stations[1])
+	}
+	t.Logf("got Station: %+v", got)
+
+	_, err = client.Station(context.Background(), "00:00:00:00:00:00")
+	if !errors.Is(err, ErrStationNotFound) {
+		t.Fatalf("Station() err: %v; want %v", err, ErrStationNotFound)
+	}
+}
+
+func TestClient_PollStation(t *testing.T) {
+	const (
+		ackMAC   = "FF:FF:FF:00:00:01"
+		noAckMAC = "FF:FF:FF:00:00:02"
+	)
+
+	hostapd, err := hostapdtest.NewHostAPD(path.Join(t.TempDir(), "hap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hostapd.Close() })
+
+	handler := hostapdtest.DefaultHostAPDHandler(hostapdtest.StatusResp{}, nil)
+	hostapdAttach := make(chan string)
+	attached := make(chan struct{})
+	handler.OnAttach(func() <-chan string {
+		close(attached)
+		return hostapdAttach
+	})
+	handler.OnPollStation(func(mac string) bool {
+		switch mac {
+		case ackMAC:
+			// Station acknowledges the poll.
+			go func() { hostapdAttach <- fmt.Sprintf("<3>%s %s", eventAPStaPollOK, mac) }()
+			return true
+		case noAckMAC:
+			// Station is known, but does not acknowledge.
+			return true
+		default:
+			return false
+		}
+	})
+	go hostapd.Serve(handler)
+
+	client, err := NewClient(t.TempDir(), hostapd.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Polling requires Attach to be running.
+	if err := client.PollStation(context.Background(), ackMAC); !errors.Is(err, ErrNotAttached) {
+		t.Fatalf("PollStation() err: %v; want %v", err, ErrNotAttached)
+	}
+
+	ctx, attachCancel := context.WithCancel(context.Background())
+	defer attachCancel()
+	attachEvents := make(chan Event, 1)
+	go client.Attach(ctx, func(event Event) error {
+		attachEvents <- event
+		return nil
+	})
+	select {
+	case <-attached:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for attach")
+	}
+
+	pollCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := client.PollStation(pollCtx, ackMAC); err != nil {
+		t.Fatalf("PollStation(%q) err: %v", ackMAC, err)
+	}
+
+	// The event is still delivered to the Attach callback.
+	select {
+	case e := <-attachEvents:
+		if _, ok := e.(EventStationPollOK); !ok {
+			t.Fatalf("got event %T; want %T", e, EventStationPollOK{})
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for poll event")
+	}
+
+	pollCtx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := client.PollStation(pollCtx, noAckMAC); !errors.Is(err, ErrNoPollResponse) {
+		t.Fatalf("PollStation(%q) err: %v; want %v", noAckMAC, err, ErrNoPollResponse)
+	}
+
+	if err := client.PollStation(context.Background(), "00:00:00:00:00:00"); !errors.Is(err, ErrStationNotFound) {
+		t.Fatalf("PollStation() err: %v; want %v", err, ErrStationNotFound)
+	}
+}
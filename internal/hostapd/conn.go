@@ -1,11 +1,33 @@
 package hostapd
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"net"
 	"os"
+	"strings"
+	"syscall"
 	"time"
 )
 
+// udpScheme prefixes the address of a UDP control interface, as used by
+// hostapd when built with ctrl_interface=udp:<port>.
+// For example, udp://192.168.1.1:8877.
+const udpScheme = "udp://"
+
+// UDP control interface cookie handshake. hostapd silently drops commands
+// without a valid cookie.
+const (
+	cmdGetCookie = "GET_COOKIE"
+	cookiePrefix = "COOKIE="
+)
+
+// isUDPAddr returns true if addr is that of a UDP control interface.
+func isUDPAddr(addr string) bool {
+	return strings.HasPrefix(addr, udpScheme)
+}
+
 // newUnixSocketConn creates a connection with a Unix domain socket at
 // remotePath. The localPath is used for the local Unix socket file and
 // is typically in a temporary directory.
@@ -22,19 +44,143 @@ func newUnixSocketConn(localPath, remotePath string) (*conn, error) {
 
 	c, err := net.DialUnix("unixgram", laddr, raddr)
 	if err != nil {
+		// The local socket file may have been created (bound) before
+		// the dial failed. Remove it so that a later attempt using the
+		// same path does not fail with "address already in use".
+		os.Remove(localPath)
 		return nil, err
 	}
 
 	return &conn{
 		localSock: laddr.String(),
-		UnixConn:  *c,
+		netConn:   c,
 	}, nil
 }
 
+// newUDPConn creates a connection with the UDP control interface at
+// addr, which has the form udp://host:port. The cookie required by
+// subsequent commands is requested from hostapd, waiting at most
+// timeout for the response.
+func newUDPConn(ctx context.Context, addr string, timeout time.Duration) (*conn, error) {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "udp", strings.TrimPrefix(addr, udpScheme))
+	if err != nil {
+		return nil, err
+	}
+	conn := conn{netConn: c.(*net.UDPConn)}
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err = conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err = conn.Write([]byte(cmdGetCookie)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to send %q command: %w", cmdGetCookie, err)
+	}
+	buf := make([]byte, 128)
+	n, err := conn.Read(buf)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("read error from %q command: %w", cmdGetCookie, err)
+	}
+	cookie := bytes.TrimSpace(buf[:n])
+	if !bytes.HasPrefix(cookie, []byte(cookiePrefix)) || len(cookie) == len(cookiePrefix) {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected %q response: %q", cmdGetCookie, cookie)
+	}
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.cookie = string(cookie)
+
+	return &conn, nil
+}
+
+// netConn is implemented by both *net.UnixConn and *net.UDPConn.
+type netConn interface {
+	net.Conn
+	SyscallConn() (syscall.RawConn, error)
+}
+
 // conn is a connection to hostapd's control interface.
 type conn struct {
-	localSock string
-	net.UnixConn
+	localSock string // Blank for UDP connections.
+	cookie    string // Prefixes each command sent over UDP.
+	netConn
+}
+
+// writeCmd sends the command, prefixed by the cookie if required.
+func (c *conn) writeCmd(cmd string) error {
+	if c.cookie != "" {
+		cmd = c.cookie + " " + cmd
+	}
+	_, err := c.Write([]byte(cmd))
+	return err
+}
+
+// peek reads the next datagram into p without removing it from the socket's
+// queue. The returned bool is true if the datagram was larger than p, in which
+// case it was truncated. Read deadlines are respected.
+func (c *conn) peek(p []byte) (int, bool, error) {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return 0, false, err
+	}
+
+	var (
+		n         int
+		truncated bool
+		peekErr   error
+	)
+	err = rc.Read(func(fd uintptr) bool {
+		var flags int
+		n, _, flags, _, peekErr = syscall.Recvmsg(int(fd), p, nil, syscall.MSG_PEEK)
+		if peekErr == syscall.EAGAIN || peekErr == syscall.EINTR {
+			// Wait until the socket is readable.
+			return false
+		}
+		truncated = flags&syscall.MSG_TRUNC != 0
+		return true
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	if peekErr != nil {
+		return 0, false, os.NewSyscallError("recvmsg", peekErr)
+	}
+	return n, truncated, nil
+}
+
+// pending returns true if a datagram is waiting to be read.
+func (c *conn) pending() (bool, error) {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return false, err
+	}
+
+	var (
+		b       [1]byte
+		peekErr error
+	)
+	err = rc.Read(func(fd uintptr) bool {
+		_, _, _, _, peekErr = syscall.Recvmsg(int(fd), b[:], nil, syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		return peekErr != syscall.EINTR
+	})
+	switch {
+	case err != nil:
+		return false, err
+	case peekErr == syscall.EAGAIN:
+		return false, nil
+	case peekErr != nil:
+		return false, os.NewSyscallError("recvmsg", peekErr)
+	default:
+		return true, nil
+	}
 }
 
 func (c *conn) setReadDeadline(timeout time.Duration) error {
@@ -50,9 +196,12 @@ func (c *conn) setWriteDeadline(timeout time.Duration) error {
 }
 
 // Close closes the connection and deletes the local
-// socket file.
+// socket file, if any.
 func (c *conn) Close() error {
-	cErr := c.UnixConn.Close()
+	cErr := c.netConn.Close()
+	if c.localSock == "" {
+		return cErr
+	}
 	// Remove local socket file.
 	fErr := os.Remove(c.localSock)
 
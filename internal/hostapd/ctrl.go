@@ -5,6 +5,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
 	"strings"
 	"sync"
 	"time"
@@ -13,8 +17,15 @@ import (
 // Hostapd control interface command and response strings.
 const (
 	cmdStatus       = "STATUS"
+	cmdGetConfig    = "GET_CONFIG"
 	cmdStationFirst = "STA-FIRST"
 	cmdStationNext  = "STA-NEXT"
+	cmdStation      = "STA"
+	cmdPollStation  = "POLL_STA"
+	cmdInterfaces   = "INTERFACES"
+	cmdIfnamePrefix = "IFNAME="
+	respOK          = "OK"
+	respFail        = "FAIL"
 	cmdPing         = "PING"
 	respPong        = "PONG"
 	cmdAttach       = "ATTACH"
@@ -22,6 +33,10 @@ const (
 	cmdDetach       = "DETACH"
 	respDetach      = "OK"
 	unknownCommand  = "UNKNOWN COMMAND"
+	noIfnameMatch   = "FAIL-NO-IFNAME-MATCH"
+
+	// Argument of ATTACH requesting RX-PROBE-REQUEST events.
+	attachProbeRxEvents = "probe_rx_events=1"
 )
 
 // ErrTerminating is returned by attach when and if the control
@@ -29,6 +44,36 @@ const (
 // WiFi interface configuration was changed and hostapd is restarting.
 var ErrTerminating = errors.New("wpa_supplicant is exiting")
 
+// ErrUnresponsive is returned by attach when the control interface
+// stops responding to PING commands. This can happen if hostapd is killed
+// without sending a terminating event, or if its socket is re-created.
+var ErrUnresponsive = errors.New("control interface is unresponsive")
+
+// ErrStationNotFound is returned when a command refers to a station
+// that is not known to hostapd.
+var ErrStationNotFound = errors.New("station not found")
+
+// ErrInterfaceNotFound is returned when a command is sent through the
+// global control interface to an interface that does not exist.
+var ErrInterfaceNotFound = errors.New("interface not found")
+
+// Sizes of the buffer used to read messages from the control interface.
+// The buffer grows as needed, up to the maximum size.
+const (
+	initialMsgSize    = 4 * 1024
+	defaultMaxMsgSize = 64 * 1024
+)
+
+// ErrTruncated is returned when a message received from the control interface
+// is larger than the maximum message size. The message is discarded.
+type ErrTruncated struct {
+	Limit int // Maximum message size, in bytes.
+}
+
+func (e ErrTruncated) Error() string {
+	return fmt.Sprintf("message truncated; larger than maximum size of %d bytes", e.Limit)
+}
+
 // ErrUnknownCmd is returned when the hostapd socket returns an unknownCommand
 // response.
 type ErrUnknownCmd string
@@ -43,11 +88,14 @@ func newCtrl(cn *conn, rTimeout, wTimeout time.Duration) (*ctrl, error) {
 		readTimeout:  rTimeout,
 		writeTimeout: wTimeout,
 		conn:         cn,
-		// It's unclear what the correct size to make this buffer is.
-		// See <https://github.com/awilliams/wifi-presence/issues/30> for details.
-		buf:          make([]byte, 4*1024),
+		// Most messages are small, but some responses, e.g. to STA
+		// commands, can be large. See <https://github.com/awilliams/wifi-presence/issues/30>.
+		buf:        make([]byte, initialMsgSize),
+		maxMsgSize: defaultMaxMsgSize,
+		health:     &socketHealth{maxMissed: defaultMaxMissedPongs},
+		logger:     log.New(io.Discard, "", 0),
 	}
-	if err := c.ping(); err != nil {
+	if err := c.ping(context.Background()); err != nil {
 		return nil, fmt.Errorf("ping error: %w", err)
 	}
 
@@ -57,51 +105,270 @@ func newCtrl(cn *conn, rTimeout, wTimeout time.Duration) (*ctrl, error) {
 // ctrl manages communication with the hostapd control interface.
 type ctrl struct {
 	readTimeout, writeTimeout time.Duration
+	// Timeouts of specific commands, by command name,
+	// overriding readTimeout.
+	timeouts map[string]time.Duration
+
+	// If non-nil, called with unsolicited events received while
+	// waiting for a command's response. Otherwise they are discarded.
+	stray func(ifname string, e Event)
+
+	// If non-zero, a PING is sent at this interval while attached,
+	// and health is updated according to the responses.
+	pingInterval time.Duration
+	health       *socketHealth
+
+	// If true, attach requests RX-PROBE-REQUEST events.
+	probeRequests bool
 
-	mu   sync.Mutex // Protects following.
-	conn *conn
-	buf  []byte
+	// If non-empty, commands are prefixed with "IFNAME=<ifname>", which
+	// the global control interface uses to route them to the interface.
+	ifname string
+
+	// Logs messages that are discarded.
+	logger *log.Logger
+
+	mu         sync.Mutex // Protects following.
+	conn       *conn
+	buf        []byte
+	maxMsgSize int // Size that buf may grow to.
 }
 
 // cmd sends the given command and waits for the response. On success, the
 // response's data is given to the resp function. Any error returned from
 // the resp function is returned by this method. This method is threadsafe.
 // The resp function should not retain p.
-func (c *ctrl) cmd(cmd string, resp func(p []byte) error) error {
+//
+// The response is awaited until the command's timeout, or until the context is
+// done. The control interface protocol does not identify which command a
+// response belongs to. Since commands are sent one at a time, messages that
+// are received before sending the command, such as late responses to commands
+// that timed out, are discarded. Unsolicited events are also skipped over.
+func (c *ctrl) cmd(ctx context.Context, cmd string, resp func(p []byte) error) error {
+	_, name := cutIfname(cmd)
+	name, _, _ = strings.Cut(name, " ")
+	if c.ifname != "" {
+		cmd = ifnameCmd(c.ifname, cmd)
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.writeTimeout > 0 {
-		if err := c.conn.setWriteDeadline(c.writeTimeout); err != nil {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("unable to send %q command: %w", cmd, err)
+	}
+
+	// Interrupt reading and writing when the context is done. The
+	// goroutine must exit before the lock is released, so that it cannot
+	// interrupt a subsequent command.
+	if ctx.Done() != nil {
+		done, exited := make(chan struct{}), make(chan struct{})
+		defer func() {
+			close(done)
+			<-exited
+		}()
+		go func() {
+			defer close(exited)
+			select {
+			case <-ctx.Done():
+				c.conn.SetDeadline(time.Now())
+			case <-done:
+			}
+		}()
+	}
+
+	if err := c.drain(); err != nil {
+		return fmt.Errorf("unable to send %q command: %w", cmd, err)
+	}
+
+	var (
+		deadline    time.Time
+		ctxDeadline bool // Whether the deadline is that of the context.
+	)
+	if timeout := c.timeout(name); timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline, ctxDeadline = d, true
+	}
+	// ctxErr returns the context's error if it caused err. The socket's
+	// deadline may pass moments before the context reports it is done.
+	ctxErr := func(err error) error {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
+		if ctxDeadline && errors.Is(err, os.ErrDeadlineExceeded) {
+			return context.DeadlineExceeded
+		}
+		return nil
 	}
-	if _, err := c.conn.Write([]byte(cmd)); err != nil {
+
+	writeDeadline := deadline
+	if c.writeTimeout > 0 {
+		if d := time.Now().Add(c.writeTimeout); writeDeadline.IsZero() || d.Before(writeDeadline) {
+			writeDeadline = d
+		}
+	}
+	if err := c.conn.SetWriteDeadline(writeDeadline); err != nil {
 		return err
 	}
+	if err := c.conn.writeCmd(cmd); err != nil {
+		if cerr := ctxErr(err); cerr != nil {
+			return fmt.Errorf("unable to send %q command: %w", cmd, cerr)
+		}
+		return err
+	}
+
+	if err := c.conn.SetReadDeadline(deadline); err != nil {
+		return err
+	}
+
+	for {
+		p, err := c.read()
+		if err != nil {
+			if cerr := ctxErr(err); cerr != nil {
+				return fmt.Errorf("read error from %q command: %w", cmd, cerr)
+			}
+			return fmt.Errorf("read error from %q command: %w", cmd, err)
+		}
+
+		if c.handleStray(p) {
+			continue
+		}
 
-	if c.readTimeout > 0 {
-		if err := c.conn.setReadDeadline(c.readTimeout); err != nil {
+		if bytes.HasPrefix(p, []byte(unknownCommand)) {
+			return ErrUnknownCmd(cmd)
+		}
+		if bytes.HasPrefix(p, []byte(noIfnameMatch)) {
+			return fmt.Errorf("sent command %q: %w", cmd, ErrInterfaceNotFound)
+		}
+
+		return resp(p)
+	}
+}
+
+// timeout returns the time to wait for the response to the named command.
+func (c *ctrl) timeout(name string) time.Duration {
+	if timeout, ok := c.timeouts[name]; ok {
+		return timeout
+	}
+	return c.readTimeout
+}
+
+// drain discards any messages waiting to be read, passing along unsolicited
+// events. Must be called under the mutex lock.
+func (c *ctrl) drain() error {
+	if err := c.conn.unsetReadDeadline(); err != nil {
+		return err
+	}
+	for {
+		ok, err := c.conn.pending()
+		if err != nil || !ok {
 			return err
 		}
+
+		p, err := c.read()
+		if err != nil {
+			var truncated ErrTruncated
+			if errors.As(err, &truncated) {
+				c.logger.Printf("discarded message: %v", err)
+				continue
+			}
+			return err
+		}
+		c.handleStray(p)
+	}
+}
+
+// handleStray returns true if the message is an unsolicited event, in which
+// case it is passed to the stray function.
+func (c *ctrl) handleStray(p []byte) bool {
+	ifname, msg := cutIfname(string(p))
+	if !isEvent(msg) {
+		return false
+	}
+	if c.stray != nil {
+		if event, err := parseEvent(strings.TrimSpace(msg)); err == nil {
+			c.stray(ifname, event)
+		}
+	}
+	return true
+}
+
+// isEvent returns true if msg is an unsolicited event, which are prefixed
+// with a priority level, e.g. '<3>'. Command responses never start with '<'.
+func isEvent(msg string) bool {
+	return len(msg) >= 3 && msg[0] == '<' && msg[1] >= '0' && msg[1] <= '9' && msg[2] == '>'
+}
+
+// setMaxMsgSize sets the maximum size of messages that can be read.
+func (c *ctrl) setMaxMsgSize(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxMsgSize = size
+	if len(c.buf) > size {
+		c.buf = make([]byte, size)
+	}
+}
+
+// read reads the next message. If the message is larger than the buffer, then
+// the buffer is grown, up to the maximum message size. Larger messages are
+// discarded, and an ErrTruncated is returned. The returned slice is only valid
+// until the next read. Must be called under the mutex lock.
+func (c *ctrl) read() ([]byte, error) {
+	for {
+		// Peek at the message to determine whether it fits in the buffer,
+		// since any part that doesn't fit would be lost when reading.
+		_, truncated, err := c.conn.peek(c.buf)
+		if err != nil {
+			return nil, err
+		}
+		if !truncated {
+			break
+		}
+
+		if len(c.buf) >= c.maxMsgSize {
+			// Remove the message from the socket's queue.
+			if _, err = c.conn.Read(c.buf); err != nil {
+				return nil, err
+			}
+			return nil, ErrTruncated{Limit: c.maxMsgSize}
+		}
+
+		size := 2 * len(c.buf)
+		if size > c.maxMsgSize {
+			size = c.maxMsgSize
+		}
+		c.buf = make([]byte, size)
 	}
 
 	n, err := c.conn.Read(c.buf)
 	if err != nil {
-		return fmt.Errorf("read error from %q command: %w", cmd, err)
+		return nil, err
 	}
+	return c.buf[:n], nil
+}
 
-	if bytes.HasPrefix(c.buf[:n], []byte(unknownCommand)) {
-		return ErrUnknownCmd(cmd)
-	}
+// ifnameCmd returns cmd prefixed with the given interface name.
+func ifnameCmd(ifname, cmd string) string {
+	return fmt.Sprintf("%s%s %s", cmdIfnamePrefix, ifname, cmd)
+}
 
-	return resp(c.buf[:n])
+// cutIfname removes an "IFNAME=<ifname> " prefix from msg, returning
+// the interface name and the remainder of the message. The global
+// control interface prefixes events with the interface they belong to.
+func cutIfname(msg string) (string, string) {
+	if !strings.HasPrefix(msg, cmdIfnamePrefix) {
+		return "", msg
+	}
+	ifname, rest, _ := strings.Cut(strings.TrimPrefix(msg, cmdIfnamePrefix), " ")
+	return ifname, rest
 }
 
 // ping tests whether the control interface is responding
 // to requests.
-func (c *ctrl) ping() error {
-	return c.cmd(cmdPing, func(resp []byte) error {
+func (c *ctrl) ping(ctx context.Context) error {
+	return c.cmd(ctx, cmdPing, func(resp []byte) error {
 		if s := strings.TrimSpace(string(resp)); s != respPong {
 			return fmt.Errorf("unexpected response to %s: %q", cmdPing, s)
 		}
@@ -110,21 +377,60 @@ func (c *ctrl) ping() error {
 }
 
 // status returns the station's status.
-func (c *ctrl) status() (Status, error) {
+func (c *ctrl) status(ctx context.Context) (Status, error) {
 	var s Status
-	return s, c.cmd(cmdStatus, func(resp []byte) error {
+	return s, c.cmd(ctx, cmdStatus, func(resp []byte) error {
+		return s.parse(resp)
+	})
+}
+
+// configBSSID returns the BSSID of the BSS the control interface
+// belongs to, as listed by its configuration.
+func (c *ctrl) configBSSID(ctx context.Context) (string, error) {
+	var bssid string
+	return bssid, c.cmd(ctx, cmdGetConfig, func(resp []byte) error {
+		for _, line := range strings.Split(string(resp), "\n") {
+			if strings.HasPrefix(line, "bssid=") {
+				bssid = strings.TrimPrefix(line, "bssid=")
+				return nil
+			}
+		}
+		return fmt.Errorf("command %q error: no bssid", cmdGetConfig)
+	})
+}
+
+// interfaces returns the names of the interfaces listed by the global
+// control interface. hostapd lists the first BSS of each radio.
+func (c *ctrl) interfaces(ctx context.Context) ([]string, error) {
+	var ifnames []string
+	return ifnames, c.cmd(ctx, cmdInterfaces, func(resp []byte) error {
+		for _, line := range strings.Split(string(resp), "\n") {
+			// Lines may include additional fields, e.g. "wlan0 ctrl_iface=...".
+			if fields := strings.Fields(line); len(fields) > 0 {
+				ifnames = append(ifnames, fields[0])
+			}
+		}
+		return nil
+	})
+}
+
+// interfaceStatus returns the status of the given interface, using the
+// global control interface.
+func (c *ctrl) interfaceStatus(ctx context.Context, ifname string) (Status, error) {
+	var s Status
+	return s, c.cmd(ctx, ifnameCmd(ifname, cmdStatus), func(resp []byte) error {
 		return s.parse(resp)
 	})
 }
 
 // stationFirst returns the start of the linked list of stations.
 // If no station is found, the returned bool is false.
-func (c *ctrl) stationFirst() (Station, bool, error) {
+func (c *ctrl) stationFirst(ctx context.Context) (Station, bool, error) {
 	var (
 		s  Station
 		ok bool
 	)
-	return s, ok, c.cmd(cmdStationFirst, func(resp []byte) error {
+	return s, ok, c.cmd(ctx, cmdStationFirst, func(resp []byte) error {
 		if len(resp) == 0 {
 			return nil
 		}
@@ -139,12 +445,12 @@ func (c *ctrl) stationFirst() (Station, bool, error) {
 // stationNext returns the station following the given mac address
 // in the linked list of stations.
 // If no station is found, the returned bool is false.
-func (c *ctrl) stationNext(mac string) (Station, bool, error) {
+func (c *ctrl) stationNext(ctx context.Context, mac string) (Station, bool, error) {
 	var (
 		s  Station
 		ok bool
 	)
-	return s, ok, c.cmd(fmt.Sprintf("%s %s", cmdStationNext, mac), func(resp []byte) error {
+	return s, ok, c.cmd(ctx, fmt.Sprintf("%s %s", cmdStationNext, mac), func(resp []byte) error {
 		if len(resp) == 0 {
 			return nil
 		}
@@ -153,12 +459,46 @@ func (c *ctrl) stationNext(mac string) (Station, bool, error) {
 	})
 }
 
+// station returns the station with the given mac address.
+func (c *ctrl) station(ctx context.Context, mac string) (Station, error) {
+	var s Station
+	return s, c.cmd(ctx, fmt.Sprintf("%s %s", cmdStation, mac), func(resp []byte) error {
+		if r := strings.TrimSpace(string(resp)); r == "" || r == respFail {
+			return fmt.Errorf("%s: %w", mac, ErrStationNotFound)
+		}
+		return s.parse(resp)
+	})
+}
+
+// pollStation requests that hostapd poll the station with the given
+// mac address. If the station responds, an AP-STA-POLL-OK event is
+// sent to attached sockets.
+func (c *ctrl) pollStation(ctx context.Context, mac string) error {
+	return c.cmd(ctx, fmt.Sprintf("%s %s", cmdPollStation, mac), func(resp []byte) error {
+		switch r := strings.TrimSpace(string(resp)); r {
+		case respOK:
+			return nil
+		case respFail:
+			return fmt.Errorf("%s: %w", mac, ErrStationNotFound)
+		default:
+			return fmt.Errorf("unexpected response to %s: %q", cmdPollStation, r)
+		}
+	})
+}
+
 // attach requests that the control interface send unsolicited
 // event messages. These include station connection and disconnect events.
 // This method blocks until the context is canceled or an error occurs.
 // While this method is blocking, no other ctrl methods can be used.
-func (c *ctrl) attach(ctx context.Context, cb func(Event) error) error {
-	err := c.cmd(cmdAttach, func(resp []byte) error {
+// Events received from the global control interface include the name of
+// the interface they belong to, which is given to cb. Otherwise the name
+// is blank.
+func (c *ctrl) attach(ctx context.Context, cb func(ifname string, e Event) error) error {
+	cmd := cmdAttach
+	if c.probeRequests {
+		cmd += " " + attachProbeRxEvents
+	}
+	err := c.cmd(ctx, cmd, func(resp []byte) error {
 		if s := strings.TrimSpace(string(resp)); s != respAttach {
 			return fmt.Errorf("unexpected response to %s: %q", cmdAttach, s)
 		}
@@ -189,21 +529,64 @@ func (c *ctrl) attach(ctx context.Context, cb func(Event) error) error {
 	}()
 
 	// Remove any read timeouts from the connection, otherwise
-	// it could trigger while waiting for events.
+	// it could trigger while waiting for events. When pinging is
+	// enabled, the read deadline is instead used to schedule PINGs.
 	if err = c.conn.unsetReadDeadline(); err != nil {
 		return err
 	}
 
-	// Read and process unsolicited messages.
-
-	var msg string
+	var (
+		msg          string
+		awaitingPong bool
+		nextPing     = time.Now().Add(c.pingInterval)
+	)
 	for {
-		n, err := c.conn.Read(c.buf)
+		if c.pingInterval > 0 && !isClosed(detached) {
+			if err = c.conn.SetReadDeadline(nextPing); err != nil {
+				return err
+			}
+		}
+
+		p, err := c.read()
 		if err != nil {
-			return err
+			// An oversized event is skipped, rather than ending attach.
+			var truncated ErrTruncated
+			if errors.As(err, &truncated) {
+				c.logger.Printf("discarded event: %v", err)
+				continue
+			}
+
+			var netErr net.Error
+			if c.pingInterval == 0 || isClosed(detached) || !errors.As(err, &netErr) || !netErr.Timeout() {
+				return err
+			}
+
+			// Time to send a PING. If the previous one was not
+			// answered, then count it as missed.
+			if awaitingPong && c.health.miss() {
+				return fmt.Errorf("attach socket missed %d PONG responses: %w", c.health.get().MissedPongs, ErrUnresponsive)
+			}
+			if err = c.conn.setWriteDeadline(c.writeTimeout); err != nil {
+				return err
+			}
+			if err = c.conn.writeCmd(cmdPing); err != nil {
+				// The remote socket has likely been removed.
+				c.health.miss()
+				return fmt.Errorf("unable to send PING on attach socket (%v): %w", err, ErrUnresponsive)
+			}
+			awaitingPong = true
+			nextPing = time.Now().Add(c.pingInterval)
+			continue
 		}
 
-		msg = strings.TrimSpace(string(c.buf[:n]))
+		msg = strings.TrimSpace(string(p))
+
+		// Check if this is a response to a PING.
+		if msg == respPong {
+			awaitingPong = false
+			c.health.pong()
+			continue
+		}
 
 		// Check if this is a DETACH response (OK).
 		if msg == respDetach {
@@ -216,21 +599,34 @@ func (c *ctrl) attach(ctx context.Context, cb func(Event) error) error {
 			}
 		}
 
+		ifname, msg := cutIfname(msg)
 		event, err := parseEvent(msg)
 		if err != nil {
 			return err
 		}
 
-		if _, ok := event.(EventTerminating); ok {
+		// A terminating event from a single interface of the global
+		// control interface does not terminate the connection.
+		if _, ok := event.(EventTerminating); ok && ifname == "" {
 			return ErrTerminating
 		}
 
-		if err = cb(event); err != nil {
+		if err = cb(ifname, event); err != nil {
 			return err
 		}
 	}
 }
 
+// isClosed returns true if the given channel is closed.
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
 // detacher returns a function that, when called, sends a detach
 // command to stop receiving unsolicited events. The function is threadsafe and
 // can be called multiple times. Only the first call will perform the detach.
@@ -259,7 +655,7 @@ func (c *ctrl) detacher() (func(), <-chan struct{}) {
 
 		// Send detach request.
 		c.conn.setWriteDeadline(c.writeTimeout)
-		c.conn.Write([]byte(cmdDetach))
+		c.conn.writeCmd(cmdDetach)
 
 		// Apply read timeout for the expected response.
 		// Response will be handled by main read loop.
@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
+	"os"
 	"path"
+	"strings"
 	"testing"
 	"time"
 
@@ -58,7 +61,7 @@ func TestCtrl_cmd(t *testing.T) {
 	}
 
 	for _, m := range messages {
-		err := c.cmd(m.cmd, func(resp []byte) error {
+		err := c.cmd(context.Background(), m.cmd, func(resp []byte) error {
 			got := string(resp)
 			if got != m.expected {
 				t.Errorf("cmd(%q): got response %q; want %q", m.cmd, got, m.expected)
@@ -104,7 +107,7 @@ func TestCtrl_cmd_unknown(t *testing.T) {
 	}
 
 	const cmd = "HI"
-	err = c.cmd(cmd, func(resp []byte) error {
+	err = c.cmd(context.Background(), cmd, func(resp []byte) error {
 		t.Logf("cmd(%q): got response %q", cmd, string(resp))
 		return nil
 	})
@@ -124,6 +127,237 @@ func TestCtrl_cmd_unknown(t *testing.T) {
 	}
 }
 
+func TestCtrl_cmd_correlated(t *testing.T) {
+	const testMAC = "ff:ff:ff:00:00:01"
+
+	hostapd, err := hostapdtest.NewHostAPD(path.Join(t.TempDir(), "hap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hostapd.Close() })
+
+	// Commands which hostapd does not reply to in time. The test
+	// replies once the command has given up waiting.
+	slow := make(chan net.Addr, 1)
+	go func() {
+		for {
+			msg, raddr, err := hostapd.ReadFrom()
+			if err != nil {
+				return
+			}
+			switch msg {
+			case "PING":
+				hostapd.WriteTo("PONG", raddr)
+			case "EVENT":
+				// An unsolicited event arrives before the response.
+				hostapd.WriteTo(fmt.Sprintf("<3>%s %s", eventAPStaPollOK, testMAC), raddr)
+				hostapd.WriteTo("event-resp", raddr)
+			case "SLOW":
+				slow <- raddr
+			default:
+				hostapd.WriteTo(strings.ToLower(msg)+"-resp", raddr)
+			}
+		}
+	}()
+
+	c, err := newCtrl(newConn(t, hostapd.Addr), time.Second, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.timeouts = map[string]time.Duration{"SLOW": 20 * time.Millisecond}
+	stray := make(chan Event, 1)
+	c.stray = func(_ string, e Event) { stray <- e }
+
+	cmd := func(ctx context.Context, cmd string) (string, error) {
+		var got string
+		err := c.cmd(ctx, cmd, func(resp []byte) error {
+			got = string(resp)
+			return nil
+		})
+		return got, err
+	}
+	expectResp := func(command, want string) {
+		t.Helper()
+		got, err := cmd(context.Background(), command)
+		if err != nil {
+			t.Fatalf("cmd(%q) err: %v", command, err)
+		}
+		if got != want {
+			t.Fatalf("cmd(%q): got response %q; want %q", command, got, want)
+		}
+	}
+	replyLate := func() {
+		t.Helper()
+		select {
+		case raddr := <-slow:
+			hostapd.WriteTo("slow-resp", raddr)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for SLOW command")
+		}
+	}
+
+	t.Run("stray event", func(t *testing.T) {
+		expectResp("EVENT", "event-resp")
+		select {
+		case e := <-stray:
+			if poll, ok := e.(EventStationPollOK); !ok || poll.MAC != testMAC {
+				t.Fatalf("got stray event %#v; want %T for %q", e, poll, testMAC)
+			}
+		default:
+			t.Fatal("stray event was not received")
+		}
+	})
+
+	t.Run("command timeout", func(t *testing.T) {
+		_, err := cmd(context.Background(), "SLOW")
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("cmd(%q) err: %v; want %v", "SLOW", err, os.ErrDeadlineExceeded)
+		}
+		replyLate()
+		// The late response must not be mistaken for this one.
+		expectResp("NEXT", "next-resp")
+	})
+
+	t.Run("context deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		// The context's deadline is sooner than the command's timeout.
+		_, err := cmd(ctx, "QUIET")
+		if err != nil {
+			t.Fatalf("cmd(%q) err: %v", "QUIET", err)
+		}
+
+		c.timeouts["SLOW"] = time.Second
+		_, err = cmd(ctx, "SLOW")
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("cmd(%q) err: %v; want %v", "SLOW", err, context.DeadlineExceeded)
+		}
+		replyLate()
+		expectResp("NEXT", "next-resp")
+	})
+
+	t.Run("context canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			raddr := <-slow
+			cancel()
+			slow <- raddr
+		}()
+		_, err := cmd(ctx, "SLOW")
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("cmd(%q) err: %v; want %v", "SLOW", err, context.Canceled)
+		}
+		replyLate()
+		expectResp("NEXT", "next-resp")
+
+		if _, err = cmd(ctx, "NEXT"); !errors.Is(err, context.Canceled) {
+			t.Fatalf("cmd(%q) with canceled context err: %v; want %v", "NEXT", err, context.Canceled)
+		}
+	})
+}
+
+func TestCtrl_cmd_large(t *testing.T) {
+	const maxMsgSize = 32 * 1024
+
+	var handler hostapdtest.Handler
+	handler.OnUndef(func(msg string) string {
+		// The command is the size of the response.
+		var size int
+		fmt.Sscanf(msg, "SIZE %d", &size)
+		return strings.Repeat("x", size)
+	})
+
+	hostapd, err := hostapdtest.NewHostAPD(path.Join(t.TempDir(), "hap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hostapd.Close() })
+	go hostapd.Serve(&handler)
+
+	c, err := newCtrl(newConn(t, hostapd.Addr), time.Second, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.setMaxMsgSize(maxMsgSize)
+
+	testCases := []struct {
+		size      int
+		truncated bool
+	}{
+		{size: 10},
+		{size: initialMsgSize},
+		{size: initialMsgSize + 1},
+		{size: 3*initialMsgSize + 7},
+		{size: maxMsgSize},
+		{size: maxMsgSize + 1, truncated: true},
+		{size: 2 * maxMsgSize, truncated: true},
+		// Subsequent commands still work after a truncated message.
+		{size: 10},
+	}
+
+	for _, tc := range testCases {
+		var got int
+		err := c.cmd(context.Background(), fmt.Sprintf("SIZE %d", tc.size), func(resp []byte) error {
+			got = len(resp)
+			return nil
+		})
+
+		if tc.truncated {
+			var truncErr ErrTruncated
+			if !errors.As(err, &truncErr) {
+				t.Fatalf("size %d: got err %v; want %T", tc.size, err, truncErr)
+			}
+			if truncErr.Limit != maxMsgSize {
+				t.Errorf("size %d: got Limit %d; want %d", tc.size, truncErr.Limit, maxMsgSize)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("size %d: unexpected err: %v", tc.size, err)
+		}
+		if got != tc.size {
+			t.Errorf("got response of %d bytes; want %d", got, tc.size)
+		}
+	}
+}
+
+func TestCtrl_station_large(t *testing.T) {
+	const testMAC = "ff:ff:ff:00:00:01"
+
+	// A response with many lines, larger than the initial buffer.
+	var lines []string
+	for i := 0; i < 500; i++ {
+		lines = append(lines, fmt.Sprintf("ext_capab_%03d=%s", i, strings.Repeat("0", 16)))
+	}
+	handler := hostapdtest.DefaultHostAPDHandler(hostapdtest.StatusResp{}, []hostapdtest.StationResp{
+		{MAC: testMAC, Assoc: true, Signal: -60, Lines: lines},
+	})
+
+	hostapd, err := hostapdtest.NewHostAPD(path.Join(t.TempDir(), "hap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hostapd.Close() })
+	go hostapd.Serve(handler)
+
+	c, err := newCtrl(newConn(t, hostapd.Addr), time.Second, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sta, err := c.station(context.Background(), testMAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sta.MAC != testMAC || !sta.Associated || sta.Signal != -60 {
+		t.Errorf("got station %q (associated=%t, signal=%d); want %q (associated=true, signal=-60)", sta.MAC, sta.Associated, sta.Signal, testMAC)
+	}
+	if len(sta.Extra) != len(lines) {
+		t.Errorf("got %d extra fields; want %d", len(sta.Extra), len(lines))
+	}
+}
+
 func TestCtrl_attach(t *testing.T) {
 	hostapd, err := hostapdtest.NewHostAPD(path.Join(t.TempDir(), "hap"))
 	if err != nil {
@@ -160,7 +394,7 @@ func TestCtrl_attach(t *testing.T) {
 	ctx, cancelAttach := context.WithCancel(context.Background())
 	defer cancelAttach()
 	go func() {
-		err := c.attach(ctx, func(e Event) error {
+		err := c.attach(ctx, func(_ string, e Event) error {
 			attachEvent <- e
 			return nil
 		})
@@ -170,10 +404,10 @@ func TestCtrl_attach(t *testing.T) {
 	}()
 
 	events := []Event{
-		EventStationConnect{fmt.Sprintf("<1>%s %s", eventAPStaConnected, "AB:CD:12:34:56:78"), "AB:CD:12:34:56:78"},
-		EventStationDisconnect{fmt.Sprintf("<3>%s %s", eventAPStaDisconnected, "FA:CE:BE:EF:56:78"), "FA:CE:BE:EF:56:78"},
-		EventStationConnect{fmt.Sprintf("<3>%s %s", eventAPStaConnected, "12:BA:00:34:56:78"), "12:BA:00:34:56:78"},
-		EventStationConnect{fmt.Sprintf("%s %s", eventAPStaConnected, "12:EE:FF:34:56:78"), "12:EE:FF:34:56:78"},
+		EventStationConnect{raw: fmt.Sprintf("<1>%s %s", eventAPStaConnected, "AB:CD:12:34:56:78"), MAC: "AB:CD:12:34:56:78"},
+		EventStationDisconnect{raw: fmt.Sprintf("<3>%s %s", eventAPStaDisconnected, "FA:CE:BE:EF:56:78"), MAC: "FA:CE:BE:EF:56:78"},
+		EventStationConnect{raw: fmt.Sprintf("<3>%s %s", eventAPStaConnected, "12:BA:00:34:56:78"), MAC: "12:BA:00:34:56:78"},
+		EventStationConnect{raw: fmt.Sprintf("%s %s", eventAPStaConnected, "12:EE:FF:34:56:78"), MAC: "12:EE:FF:34:56:78"},
 	}
 
 	for _, event := range events {
@@ -220,6 +454,55 @@ func TestCtrl_attach(t *testing.T) {
 	}
 }
 
+func TestCtrl_attach_truncated(t *testing.T) {
+	const testMAC = "AB:CD:12:34:56:78"
+
+	hostapd, err := hostapdtest.NewHostAPD(path.Join(t.TempDir(), "hap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hostapd.Close() })
+
+	var handler hostapdtest.Handler
+	hostapdEvents := make(chan string, 2)
+	handler.OnAttach(func() <-chan string {
+		return hostapdEvents
+	})
+	go hostapd.Serve(&handler)
+
+	c, err := newCtrl(newConn(t, hostapd.Addr), time.Second, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.setMaxMsgSize(initialMsgSize)
+
+	attachErr := make(chan error, 1)
+	attachEvent := make(chan Event, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		attachErr <- c.attach(ctx, func(_ string, e Event) error {
+			attachEvent <- e
+			return nil
+		})
+	}()
+
+	// The oversized event is discarded, and the following one received.
+	hostapdEvents <- fmt.Sprintf("<3>%s %s %s", eventAPStaConnected, testMAC, strings.Repeat("x", initialMsgSize))
+	hostapdEvents <- fmt.Sprintf("<3>%s %s", eventAPStaDisconnected, testMAC)
+
+	select {
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for event")
+	case err := <-attachErr:
+		t.Fatalf("attach error: %v", err)
+	case got := <-attachEvent:
+		if _, ok := got.(EventStationDisconnect); !ok {
+			t.Fatalf("got event %#v; want %T", got, EventStationDisconnect{})
+		}
+	}
+}
+
 func TestCtrl_attach_terminated(t *testing.T) {
 	hostapd, err := hostapdtest.NewHostAPD(path.Join(t.TempDir(), "hap"))
 	if err != nil {
@@ -253,7 +536,7 @@ func TestCtrl_attach_terminated(t *testing.T) {
 	defer cancelAttach()
 	go func() {
 		defer close(attachErr)
-		err := c.attach(ctx, func(e Event) error {
+		err := c.attach(ctx, func(_ string, e Event) error {
 			return nil
 		})
 		if err != nil {
@@ -310,3 +593,128 @@ func newConn(t *testing.T, rAddr string) *conn {
 
 	return conn
 }
+
+func TestCtrl_attach_ping(t *testing.T) {
+	hostapd, err := hostapdtest.NewHostAPD(path.Join(t.TempDir(), "hap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hostapd.Close() })
+
+	var handler hostapdtest.Handler
+	hostapdEvents := make(chan string)
+	pings := make(chan struct{}, 10)
+	handler.OnAttach(func() <-chan string {
+		return hostapdEvents
+	})
+	handler.OnPing(func() bool {
+		select {
+		case pings <- struct{}{}:
+		default:
+		}
+		return true
+	})
+
+	hostapdErr := make(chan error, 1)
+	go func() {
+		if err := hostapd.Serve(&handler); err != nil {
+			hostapdErr <- err
+		}
+	}()
+
+	c, err := newCtrl(newConn(t, hostapd.Addr), time.Second, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.pingInterval = 20 * time.Millisecond
+	<-pings // Sent by newCtrl.
+
+	attachErr := make(chan error, 1)
+	ctx, cancelAttach := context.WithCancel(context.Background())
+	defer cancelAttach()
+	go func() {
+		attachErr <- c.attach(ctx, func(_ string, e Event) error { return nil })
+	}()
+
+	// Ensure multiple PINGs are received while attached.
+	for i := 0; i < 3; i++ {
+		select {
+		case <-pings:
+		case err := <-hostapdErr:
+			t.Fatalf("hostapd conn error: %v", err)
+		case err := <-attachErr:
+			t.Fatalf("attach error: %v", err)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for PING")
+		}
+	}
+
+	// Allow time for the last PONG to be received.
+	time.Sleep(10 * time.Millisecond)
+	h := c.health.get()
+	if h.State != HealthOK {
+		t.Errorf("got health state %s; want %s", h.State, HealthOK)
+	}
+	if h.LastPong.IsZero() {
+		t.Error("got zero LastPong")
+	}
+	t.Logf("got health: %+v", h)
+
+	cancelAttach()
+	select {
+	case err := <-attachErr:
+		if err != nil {
+			t.Fatalf("attach error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for attach to return")
+	}
+}
+
+func TestCtrl_attach_unresponsive(t *testing.T) {
+	hostapd, err := hostapdtest.NewHostAPD(path.Join(t.TempDir(), "hap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hostapd.Close() })
+
+	var (
+		handler   hostapdtest.Handler
+		pingCount int
+	)
+	handler.OnAttach(func() <-chan string {
+		return make(chan string)
+	})
+	// Only reply to the initial PING sent by newCtrl.
+	handler.OnPing(func() bool {
+		pingCount++
+		return pingCount == 1
+	})
+
+	go hostapd.Serve(&handler)
+
+	c, err := newCtrl(newConn(t, hostapd.Addr), time.Second, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.pingInterval = 20 * time.Millisecond
+
+	attachErr := make(chan error, 1)
+	go func() {
+		attachErr <- c.attach(context.Background(), func(_ string, e Event) error { return nil })
+	}()
+
+	select {
+	case err := <-attachErr:
+		if !errors.Is(err, ErrUnresponsive) {
+			t.Fatalf("attach error %v; want %v", err, ErrUnresponsive)
+		}
+		t.Logf("attach error (expected): %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for attach error")
+	}
+
+	if h := c.health.get(); h.State != HealthUnresponsive || h.MissedPongs != defaultMaxMissedPongs {
+		t.Fatalf("got health %+v; want state %s with %d missed PONGs", h, HealthUnresponsive, defaultMaxMissedPongs)
+	}
+}
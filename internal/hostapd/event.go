@@ -1,8 +1,11 @@
 package hostapd
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
 )
 
@@ -10,9 +13,22 @@ import (
 // others, is in the wpa_supplicant / hostapd documentation and source
 // code: https://w1.fi/wpa_supplicant/devel/ctrl_iface_page.html.
 const (
-	eventAPStaDisconnected = "AP-STA-DISCONNECTED"
-	eventAPStaConnected    = "AP-STA-CONNECTED"
-	eventWPATerminating    = "CTRL-EVENT-TERMINATING"
+	eventAPStaDisconnected    = "AP-STA-DISCONNECTED"
+	eventAPStaConnected       = "AP-STA-CONNECTED"
+	eventAPStaPollOK          = "AP-STA-POLL-OK"
+	eventMeshPeerConnected    = "MESH-PEER-CONNECTED"
+	eventMeshPeerDisconnected = "MESH-PEER-DISCONNECTED"
+	eventRxProbeRequest       = "RX-PROBE-REQUEST"
+	eventMgmtFrameReceived    = "AP-MGMT-FRAME-RECEIVED"
+	eventAPStaPSKMismatch     = "AP-STA-POSSIBLE-PSK-MISMATCH"
+	eventAPEnabled            = "AP-ENABLED"
+	eventAPDisabled           = "AP-DISABLED"
+	eventAPCSAFinished        = "AP-CSA-FINISHED"
+	eventEAPOL4WayHSCompleted = "EAPOL-4WAY-HS-COMPLETED"
+	eventEAPPrefix            = "CTRL-EVENT-EAP-"
+	eventDFSPrefix            = "DFS-"
+	eventWPSPrefix            = "WPS-"
+	eventWPATerminating       = "CTRL-EVENT-TERMINATING"
 )
 
 // Event is an unsolicited message received from the hostapd control interface.
@@ -22,7 +38,16 @@ type Event interface {
 	Raw() string
 }
 
-// parseEvent parses the received msg into an Event.
+// ParseEvent parses a message in the format used by the control interface,
+// e.g. "<3>AP-STA-CONNECTED 04:ab:00:12:34:56", into an Event.
+func ParseEvent(msg string) (Event, error) {
+	return parseEvent(msg)
+}
+
+// parseEvent parses the received msg into an Event. Only station connect
+// and disconnect events without a valid MAC address are errors. Other events
+// without a valid MAC address are unrecognized, and invalid optional
+// arguments are ignored.
 func parseEvent(msg string) (Event, error) {
 	if len(msg) == 0 {
 		return nil, errors.New("empty message")
@@ -36,48 +61,223 @@ func parseEvent(msg string) (Event, error) {
 		msg = msg[3:]
 	}
 
-	switch {
-	case strings.HasPrefix(msg, eventAPStaConnected):
+	// Split the event name from its arguments.
+	name, args, _ := strings.Cut(msg, " ")
+	params := parseEventParams(args)
+
+	switch name {
+	case eventAPStaConnected:
 		// Station connect event. Examples:
 		// AP-STA-CONNECTED 04:ab:00:12:34:56
 		// AP-STA-CONNECTED 04:ab:00:12:34:56 auth_alg=open
+		// AP-STA-CONNECTED 04:ab:00:12:34:56 keyid=guest vlan_id=10
+		// AP-STA-CONNECTED 04:ab:00:12:34:56 mld_addr=06:ab:00:12:34:56
+		// https://github.com/awilliams/wifi-presence/issues/12
 
-		mac := strings.TrimSpace(strings.TrimPrefix(msg, eventAPStaConnected))
+		mac, err := params.mac()
+		if err != nil {
+			return nil, err
+		}
+		e := EventStationConnect{
+			raw:       raw,
+			MAC:       mac,
+			MLDAddr:   params.named["mld_addr"],
+			KeyID:     params.named["keyid"],
+			AuthAlg:   params.named["auth_alg"],
+			DPPPKHash: params.named["dpp_pkhash"],
+			IPAddr:    params.named["ip_addr"],
+			VLANID:    params.int("vlan_id"),
+		}
+		return e, nil
 
-		// Strip any trailing data after the MAC address.
-		// https://github.com/awilliams/wifi-presence/issues/12
-		if len(mac) > macLength {
-			mac = mac[:macLength]
+	case eventAPStaDisconnected:
+		// Station disconnect event. Examples:
+		// "<3>AP-STA-DISCONNECTED 04:ab:00:12:34:56"
+		// "<3>AP-STA-DISCONNECTED 04:ab:00:12:34:56 mld_addr=06:ab:00:12:34:56"
+
+		mac, err := params.mac()
+		if err != nil {
+			return nil, err
 		}
+		return EventStationDisconnect{raw: raw, MAC: mac, MLDAddr: params.named["mld_addr"]}, nil
+
+	case eventMeshPeerConnected, eventMeshPeerDisconnected:
+		// Mesh (802.11s) peer events, sent by wpa_supplicant or hostapd
+		// running a mesh interface. The peer is treated as a station.
+		// Example:
+		// <3>MESH-PEER-CONNECTED 04:ab:00:12:34:56
 
-		if !isMAC(mac) {
-			return nil, fmt.Errorf("invalid MAC address %q", mac)
+		mac, err := params.mac()
+		if err != nil {
+			return EventUnrecognized(raw), nil
 		}
-		return EventStationConnect{raw: raw, MAC: mac}, nil
+		if name == eventMeshPeerConnected {
+			return EventStationConnect{raw: raw, MAC: mac, Mesh: true}, nil
+		}
+		return EventStationDisconnect{raw: raw, MAC: mac, Mesh: true}, nil
 
-	case strings.HasPrefix(msg, eventAPStaDisconnected):
-		// Station disconnect event. Example:
-		// "<3>AP-STA-DISCONNECTED 04:ab:00:12:34:56"
+	case eventAPStaPollOK:
+		mac, err := params.mac()
+		if err != nil {
+			return EventUnrecognized(raw), nil
+		}
+		return EventStationPollOK{raw: raw, MAC: mac}, nil
+
+	case eventAPStaPSKMismatch:
+		mac, err := params.mac()
+		if err != nil {
+			return EventUnrecognized(raw), nil
+		}
+		return EventStationPSKMismatch{raw: raw, MAC: mac}, nil
 
-		mac := strings.TrimSpace(strings.TrimPrefix(msg, eventAPStaDisconnected))
-		if !isMAC(mac) {
-			return nil, fmt.Errorf("invalid MAC address %q", mac)
+	case eventEAPOL4WayHSCompleted:
+		mac, err := params.mac()
+		if err != nil {
+			return EventUnrecognized(raw), nil
 		}
-		return EventStationDisconnect{raw: raw, MAC: mac}, nil
+		return EventStationHandshakeCompleted{raw: raw, MAC: mac}, nil
+
+	case eventRxProbeRequest:
+		// Sent to sockets attached with "probe_rx_events=1". Example:
+		// RX-PROBE-REQUEST sa=04:ab:00:12:34:56 signal=-67
 
-	case msg == eventWPATerminating:
+		e := EventProbeRequest{raw: raw, MAC: params.named["sa"], Signal: params.int("signal")}
+		if !isMAC(e.MAC) {
+			return EventUnrecognized(raw), nil
+		}
+		return e, nil
+
+	case eventMgmtFrameReceived:
+		// Sent when hostapd is configured with notify_mgmt_frames=1.
+		// Only probe requests are recognized. Example:
+		// AP-MGMT-FRAME-RECEIVED buf=40000000ffffffffffff04ab00123456ffffffffffff...
+
+		if sa, ok := probeRequestSA(params.named["buf"]); ok {
+			return EventProbeRequest{raw: raw, MAC: sa}, nil
+		}
+		return EventUnrecognized(raw), nil
+
+	case eventAPEnabled:
+		return EventAPEnabled(raw), nil
+
+	case eventAPDisabled:
+		return EventAPDisabled(raw), nil
+
+	case eventAPCSAFinished:
+		// Example:
+		// AP-CSA-FINISHED freq=5180 dfs=0
+		return EventChannelSwitch{raw: raw, Freq: params.int("freq"), DFS: params.named["dfs"] == "1"}, nil
+
+	case eventWPATerminating:
 		return EventTerminating(raw), nil
+	}
+
+	switch {
+	case strings.HasPrefix(name, eventEAPPrefix):
+		// Examples:
+		// CTRL-EVENT-EAP-STARTED 04:ab:00:12:34:56
+		// CTRL-EVENT-EAP-PROPOSED-METHOD 04:ab:00:12:34:56 vendor=0 method=25
+		// CTRL-EVENT-EAP-SUCCESS2 04:ab:00:12:34:56
+		e := EventEAP{raw: raw, Type: strings.TrimPrefix(name, eventEAPPrefix)}
+		if len(params.positional) > 0 && isMAC(params.positional[0]) {
+			e.MAC = params.positional[0]
+		}
+		return e, nil
+
+	case strings.HasPrefix(name, eventDFSPrefix):
+		// Examples:
+		// DFS-RADAR-DETECTED freq=5260 ht_enabled=1 chan_offset=0 chan_width=1 cf1=5270 cf2=0
+		// DFS-CAC-COMPLETED success=1 freq=5260 ht_enabled=0 chan_offset=0 chan_width=3 cf1=5290 cf2=0
+		return EventDFS{raw: raw, Type: strings.TrimPrefix(name, eventDFSPrefix), Freq: params.int("freq")}, nil
+
+	case strings.HasPrefix(name, eventWPSPrefix):
+		// Examples:
+		// WPS-PBC-ACTIVE
+		// WPS-REG-SUCCESS 04:ab:00:12:34:56 12345678-9abc-def0-1234-56789abcdef0
+		e := EventWPS{raw: raw, Type: strings.TrimPrefix(name, eventWPSPrefix)}
+		if len(params.positional) > 0 && isMAC(params.positional[0]) {
+			e.MAC = params.positional[0]
+		}
+		return e, nil
 
 	default:
 		return EventUnrecognized(raw), nil
 	}
 }
 
+// probeRequestSA returns the source address of the hex encoded management
+// frame, if it is a probe request.
+func probeRequestSA(buf string) (string, bool) {
+	// Frame control (2), duration (2), DA (6), SA (6).
+	const saOffset, saLen = 10, 6
+	frame, err := hex.DecodeString(buf)
+	if err != nil || len(frame) < saOffset+saLen {
+		return "", false
+	}
+	// Management frame type (0) with the probe request subtype (4).
+	if frame[0]&0xfc != 0x40 {
+		return "", false
+	}
+	return net.HardwareAddr(frame[saOffset : saOffset+saLen]).String(), true
+}
+
+// eventParams are the arguments following an event's name.
+type eventParams struct {
+	positional []string          // Arguments without a '=', e.g. a MAC address.
+	named      map[string]string // Arguments in "key=value" form.
+}
+
+// parseEventParams parses space separated event arguments.
+func parseEventParams(args string) eventParams {
+	var p eventParams
+	for _, f := range strings.Fields(args) {
+		if k, v, ok := strings.Cut(f, "="); ok {
+			if p.named == nil {
+				p.named = make(map[string]string)
+			}
+			p.named[k] = v
+			continue
+		}
+		p.positional = append(p.positional, f)
+	}
+	return p
+}
+
+// mac returns the first positional argument, which must be a MAC address.
+func (p eventParams) mac() (string, error) {
+	if len(p.positional) == 0 {
+		return "", errors.New("missing MAC address")
+	}
+	if mac := p.positional[0]; !isMAC(mac) {
+		return "", fmt.Errorf("invalid MAC address %q", mac)
+	}
+	return p.positional[0], nil
+}
+
+// int returns the integer value of the named argument, or 0 if it is
+// not present. Since such arguments are optional, invalid values are
+// also ignored by returning 0.
+func (p eventParams) int(key string) int {
+	v, err := strconv.Atoi(p.named[key])
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
 // EventStationConnect is an event that happens when a
-// station (WiFi client) connects to the AP.
+// station (WiFi client) connects to the AP, or when a
+// mesh peer connects.
 type EventStationConnect struct {
-	raw string
-	MAC string
+	raw       string
+	MAC       string
+	MLDAddr   string // MLD address of a multi-link (Wi-Fi 7) station, if any.
+	Mesh      bool   // True for MESH-PEER-CONNECTED.
+	KeyID     string // Identifier of the PSK used, if any.
+	VLANID    int
+	AuthAlg   string // E.g. "open" or "sae".
+	DPPPKHash string
+	IPAddr    string
 }
 
 // Raw returns event as given by hostapd. Satisfies
@@ -86,16 +286,161 @@ func (e EventStationConnect) Raw() string {
 	return e.raw
 }
 
+// ID returns the address identifying the station. See Station.ID.
+func (e EventStationConnect) ID() string {
+	if e.MLDAddr != "" {
+		return e.MLDAddr
+	}
+	return e.MAC
+}
+
 // EventStationDisconnect is an event that happens when a
-// station (WiFi client) disconnects from the AP.
+// station (WiFi client) disconnects from the AP, or when a
+// mesh peer disconnects.
 type EventStationDisconnect struct {
+	raw     string
+	MAC     string
+	MLDAddr string // MLD address of a multi-link (Wi-Fi 7) station, if any.
+	Mesh    bool   // True for MESH-PEER-DISCONNECTED.
+}
+
+// Raw returns event as given by hostapd. Satisfies
+// the Event interface.
+func (e EventStationDisconnect) Raw() string {
+	return e.raw
+}
+
+// ID returns the address identifying the station. See Station.ID.
+func (e EventStationDisconnect) ID() string {
+	if e.MLDAddr != "" {
+		return e.MLDAddr
+	}
+	return e.MAC
+}
+
+// EventStationPollOK is received in response to a station
+// being polled, and indicates that the station is still reachable.
+type EventStationPollOK struct {
 	raw string
 	MAC string
 }
 
 // Raw returns event as given by hostapd. Satisfies
 // the Event interface.
-func (e EventStationDisconnect) Raw() string {
+func (e EventStationPollOK) Raw() string {
+	return e.raw
+}
+
+// EventStationPSKMismatch is received when a station likely
+// attempted to connect using an incorrect passphrase.
+type EventStationPSKMismatch struct {
+	raw string
+	MAC string
+}
+
+// Raw returns event as given by hostapd. Satisfies
+// the Event interface.
+func (e EventStationPSKMismatch) Raw() string {
+	return e.raw
+}
+
+// EventStationHandshakeCompleted is received when the EAPOL 4-way
+// handshake with a station completes.
+type EventStationHandshakeCompleted struct {
+	raw string
+	MAC string
+}
+
+// Raw returns event as given by hostapd. Satisfies
+// the Event interface.
+func (e EventStationHandshakeCompleted) Raw() string {
+	return e.raw
+}
+
+// EventProbeRequest is received when a probe request is received from a
+// station, which is usually not connected. Probe requests are only reported
+// to sockets attached using WithProbeRequests, or when hostapd is configured
+// with notify_mgmt_frames=1.
+type EventProbeRequest struct {
+	raw    string
+	MAC    string
+	Signal int // dBm. Zero if not reported.
+}
+
+// Raw returns event as given by hostapd. Satisfies
+// the Event interface.
+func (e EventProbeRequest) Raw() string {
+	return e.raw
+}
+
+// EventEAP is an EAP authentication event, e.g. CTRL-EVENT-EAP-SUCCESS.
+type EventEAP struct {
+	raw  string
+	Type string // Event name following "CTRL-EVENT-EAP-", e.g. "SUCCESS".
+	MAC  string // May be blank.
+}
+
+// Raw returns event as given by hostapd. Satisfies
+// the Event interface.
+func (e EventEAP) Raw() string {
+	return e.raw
+}
+
+// EventAPEnabled is received when the AP is enabled.
+type EventAPEnabled string
+
+// Raw returns event as given by hostapd. Satisfies
+// the Event interface.
+func (e EventAPEnabled) Raw() string {
+	return string(e)
+}
+
+// EventAPDisabled is received when the AP is disabled.
+type EventAPDisabled string
+
+// Raw returns event as given by hostapd. Satisfies
+// the Event interface.
+func (e EventAPDisabled) Raw() string {
+	return string(e)
+}
+
+// EventChannelSwitch is received when the AP has finished
+// switching channels.
+type EventChannelSwitch struct {
+	raw  string
+	Freq int
+	DFS  bool
+}
+
+// Raw returns event as given by hostapd. Satisfies
+// the Event interface.
+func (e EventChannelSwitch) Raw() string {
+	return e.raw
+}
+
+// EventDFS is a dynamic frequency selection event, e.g. DFS-RADAR-DETECTED.
+type EventDFS struct {
+	raw  string
+	Type string // Event name following "DFS-", e.g. "RADAR-DETECTED".
+	Freq int
+}
+
+// Raw returns event as given by hostapd. Satisfies
+// the Event interface.
+func (e EventDFS) Raw() string {
+	return e.raw
+}
+
+// EventWPS is a WiFi Protected Setup event, e.g. WPS-PBC-ACTIVE.
+type EventWPS struct {
+	raw  string
+	Type string // Event name following "WPS-", e.g. "PBC-ACTIVE".
+	MAC  string // May be blank.
+}
+
+// Raw returns event as given by hostapd. Satisfies
+// the Event interface.
+func (e EventWPS) Raw() string {
 	return e.raw
 }
 
@@ -20,8 +20,21 @@ func TestParseEvent(t *testing.T) {
 			name:  "connect with algo",
 			input: "AP-STA-CONNECTED 04:ab:00:12:34:56 auth_alg=open",
 			expected: EventStationConnect{
-				raw: "AP-STA-CONNECTED 04:ab:00:12:34:56 auth_alg=open",
-				MAC: "04:ab:00:12:34:56",
+				raw:     "AP-STA-CONNECTED 04:ab:00:12:34:56 auth_alg=open",
+				MAC:     "04:ab:00:12:34:56",
+				AuthAlg: "open",
+			},
+		},
+		{
+			name:  "connect with params",
+			input: "<3>AP-STA-CONNECTED 04:ab:00:12:34:56 keyid=guest vlan_id=10 auth_alg=sae dpp_pkhash=abc123",
+			expected: EventStationConnect{
+				raw:       "<3>AP-STA-CONNECTED 04:ab:00:12:34:56 keyid=guest vlan_id=10 auth_alg=sae dpp_pkhash=abc123",
+				MAC:       "04:ab:00:12:34:56",
+				KeyID:     "guest",
+				VLANID:    10,
+				AuthAlg:   "sae",
+				DPPPKHash: "abc123",
 			},
 		},
 		{
@@ -48,11 +61,188 @@ func TestParseEvent(t *testing.T) {
 				MAC: "04:ab:00:12:34:56",
 			},
 		},
+		{
+			name:  "connect mld",
+			input: "<3>AP-STA-CONNECTED 04:ab:00:12:34:56 mld_addr=06:ab:00:12:34:56",
+			expected: EventStationConnect{
+				raw:     "<3>AP-STA-CONNECTED 04:ab:00:12:34:56 mld_addr=06:ab:00:12:34:56",
+				MAC:     "04:ab:00:12:34:56",
+				MLDAddr: "06:ab:00:12:34:56",
+			},
+		},
+		{
+			name:  "disconnect mld",
+			input: "<3>AP-STA-DISCONNECTED 04:ab:00:12:34:56 mld_addr=06:ab:00:12:34:56",
+			expected: EventStationDisconnect{
+				raw:     "<3>AP-STA-DISCONNECTED 04:ab:00:12:34:56 mld_addr=06:ab:00:12:34:56",
+				MAC:     "04:ab:00:12:34:56",
+				MLDAddr: "06:ab:00:12:34:56",
+			},
+		},
+		{
+			name:  "mesh peer connect",
+			input: "<3>MESH-PEER-CONNECTED 04:ab:00:12:34:56",
+			expected: EventStationConnect{
+				raw:  "<3>MESH-PEER-CONNECTED 04:ab:00:12:34:56",
+				MAC:  "04:ab:00:12:34:56",
+				Mesh: true,
+			},
+		},
+		{
+			name:  "mesh peer disconnect",
+			input: "<3>MESH-PEER-DISCONNECTED 04:ab:00:12:34:56",
+			expected: EventStationDisconnect{
+				raw:  "<3>MESH-PEER-DISCONNECTED 04:ab:00:12:34:56",
+				MAC:  "04:ab:00:12:34:56",
+				Mesh: true,
+			},
+		},
+		{
+			name:  "probe request",
+			input: "<3>RX-PROBE-REQUEST sa=04:ab:00:12:34:56 signal=-67",
+			expected: EventProbeRequest{
+				raw:    "<3>RX-PROBE-REQUEST sa=04:ab:00:12:34:56 signal=-67",
+				MAC:    "04:ab:00:12:34:56",
+				Signal: -67,
+			},
+		},
+		{
+			name:  "mgmt frame probe request",
+			input: "AP-MGMT-FRAME-RECEIVED buf=40000000ffffffffffff04ab00123456ffffffffffff10000000",
+			expected: EventProbeRequest{
+				raw: "AP-MGMT-FRAME-RECEIVED buf=40000000ffffffffffff04ab00123456ffffffffffff10000000",
+				MAC: "04:ab:00:12:34:56",
+			},
+		},
+		{
+			name:     "mgmt frame auth",
+			input:    "AP-MGMT-FRAME-RECEIVED buf=b0003a01aabbccddeeff04ab00123456aabbccddeeff00000000",
+			expected: EventUnrecognized("AP-MGMT-FRAME-RECEIVED buf=b0003a01aabbccddeeff04ab00123456aabbccddeeff00000000"),
+		},
+		{
+			name:  "poll ok",
+			input: "<3>AP-STA-POLL-OK 04:ab:00:12:34:56",
+			expected: EventStationPollOK{
+				raw: "<3>AP-STA-POLL-OK 04:ab:00:12:34:56",
+				MAC: "04:ab:00:12:34:56",
+			},
+		},
+		{
+			name:  "psk mismatch",
+			input: "<3>AP-STA-POSSIBLE-PSK-MISMATCH 04:ab:00:12:34:56",
+			expected: EventStationPSKMismatch{
+				raw: "<3>AP-STA-POSSIBLE-PSK-MISMATCH 04:ab:00:12:34:56",
+				MAC: "04:ab:00:12:34:56",
+			},
+		},
+		{
+			name:  "4way handshake",
+			input: "<3>EAPOL-4WAY-HS-COMPLETED 04:ab:00:12:34:56",
+			expected: EventStationHandshakeCompleted{
+				raw: "<3>EAPOL-4WAY-HS-COMPLETED 04:ab:00:12:34:56",
+				MAC: "04:ab:00:12:34:56",
+			},
+		},
+		{
+			name:  "eap",
+			input: "<3>CTRL-EVENT-EAP-PROPOSED-METHOD 04:ab:00:12:34:56 vendor=0 method=25",
+			expected: EventEAP{
+				raw:  "<3>CTRL-EVENT-EAP-PROPOSED-METHOD 04:ab:00:12:34:56 vendor=0 method=25",
+				Type: "PROPOSED-METHOD",
+				MAC:  "04:ab:00:12:34:56",
+			},
+		},
+		{
+			name:     "ap enabled",
+			input:    "<3>AP-ENABLED",
+			expected: EventAPEnabled("<3>AP-ENABLED"),
+		},
+		{
+			name:     "ap disabled",
+			input:    "<3>AP-DISABLED",
+			expected: EventAPDisabled("<3>AP-DISABLED"),
+		},
+		{
+			name:  "csa finished",
+			input: "<3>AP-CSA-FINISHED freq=5180 dfs=1",
+			expected: EventChannelSwitch{
+				raw:  "<3>AP-CSA-FINISHED freq=5180 dfs=1",
+				Freq: 5180,
+				DFS:  true,
+			},
+		},
+		{
+			name:  "dfs",
+			input: "<3>DFS-RADAR-DETECTED freq=5260 ht_enabled=1 chan_offset=0 chan_width=1 cf1=5270 cf2=0",
+			expected: EventDFS{
+				raw:  "<3>DFS-RADAR-DETECTED freq=5260 ht_enabled=1 chan_offset=0 chan_width=1 cf1=5270 cf2=0",
+				Type: "RADAR-DETECTED",
+				Freq: 5260,
+			},
+		},
+		{
+			name:  "wps",
+			input: "<3>WPS-PBC-ACTIVE",
+			expected: EventWPS{
+				raw:  "<3>WPS-PBC-ACTIVE",
+				Type: "PBC-ACTIVE",
+			},
+		},
+		{
+			name:  "wps with mac",
+			input: "<3>WPS-REG-SUCCESS 04:ab:00:12:34:56 12345678-9abc-def0-1234-56789abcdef0",
+			expected: EventWPS{
+				raw:  "<3>WPS-REG-SUCCESS 04:ab:00:12:34:56 12345678-9abc-def0-1234-56789abcdef0",
+				Type: "REG-SUCCESS",
+				MAC:  "04:ab:00:12:34:56",
+			},
+		},
 		{
 			name:     "terminating",
 			input:    "<3>CTRL-EVENT-TERMINATING",
 			expected: EventTerminating("<3>CTRL-EVENT-TERMINATING"),
 		},
+		{
+			name:  "invalid vlan_id",
+			input: "<3>AP-STA-CONNECTED 04:ab:00:12:34:56 vlan_id=x",
+			expected: EventStationConnect{
+				raw: "<3>AP-STA-CONNECTED 04:ab:00:12:34:56 vlan_id=x",
+				MAC: "04:ab:00:12:34:56",
+			},
+		},
+		{
+			name:  "invalid probe signal",
+			input: "<3>RX-PROBE-REQUEST sa=04:ab:00:12:34:56 signal=x",
+			expected: EventProbeRequest{
+				raw: "<3>RX-PROBE-REQUEST sa=04:ab:00:12:34:56 signal=x",
+				MAC: "04:ab:00:12:34:56",
+			},
+		},
+		{
+			name:  "invalid dfs freq",
+			input: "<3>DFS-CAC-START freq=abc",
+			expected: EventDFS{
+				raw:  "<3>DFS-CAC-START freq=abc",
+				Type: "CAC-START",
+			},
+		},
+		{
+			name:  "invalid csa freq",
+			input: "<3>AP-CSA-FINISHED freq=abc dfs=0",
+			expected: EventChannelSwitch{
+				raw: "<3>AP-CSA-FINISHED freq=abc dfs=0",
+			},
+		},
+		{
+			name:     "invalid poll mac",
+			input:    "<3>AP-STA-POLL-OK ?",
+			expected: EventUnrecognized("<3>AP-STA-POLL-OK ?"),
+		},
+		{
+			name:     "invalid probe mac",
+			input:    "<3>RX-PROBE-REQUEST sa=? signal=-67",
+			expected: EventUnrecognized("<3>RX-PROBE-REQUEST sa=? signal=-67"),
+		},
 		{
 			name:     "unrecognized",
 			input:    "<3>TEST",
@@ -78,3 +268,17 @@ func TestParseEvent(t *testing.T) {
 		})
 	}
 }
+
+func TestParseEvent_invalid(t *testing.T) {
+	cases := []string{
+		"",
+		"<3>AP-STA-CONNECTED",
+		"<3>AP-STA-CONNECTED not-a-mac",
+		"<3>AP-STA-DISCONNECTED",
+	}
+	for _, input := range cases {
+		if got, err := parseEvent(input); err == nil {
+			t.Errorf("parseEvent(%q) = %#v; want error", input, got)
+		}
+	}
+}
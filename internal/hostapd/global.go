@@ -0,0 +1,199 @@
+package hostapd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// NewGlobal connects to hostapd's global control interface located at
+// ctrlSock, typically /var/run/hostapd/global. The given options are used
+// for the global connection, as well as for each Client returned by
+// Global.Client.
+func NewGlobal(localSockDir, ctrlSock string, opts ...ClientOpt) (*Global, error) {
+	client, err := NewClient(localSockDir, ctrlSock, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Global{
+		client: client,
+		opts:   opts,
+	}, nil
+}
+
+// Global is a client of hostapd's global control interface. A single
+// global control interface serves all of hostapd's interfaces (radios and
+// their BSSs). Commands for a specific interface are prefixed with
+// "IFNAME=<ifname>", and events are likewise prefixed with the interface
+// they belong to.
+type Global struct {
+	client *Client
+	opts   []ClientOpt
+
+	mu   sync.Mutex // Protects following.
+	subs map[string]*subscriber
+}
+
+// subscriber receives the events of a single interface while
+// Client.Attach is running.
+type subscriber struct {
+	events func(Event) error
+	done   chan error // Receives the reason the subscription ended.
+}
+
+// Close closes the connection to the global control interface. Clients
+// returned by Client must be closed separately.
+func (g *Global) Close() error {
+	return g.client.Close()
+}
+
+// Reconnect re-dials the global control interface.
+// See Client.Reconnect for details.
+func (g *Global) Reconnect(ctx context.Context) error {
+	return g.client.Reconnect(ctx)
+}
+
+// Health returns the liveness state of the global control interface's
+// sockets.
+func (g *Global) Health() Health {
+	return g.client.Health()
+}
+
+// Interfaces returns the names of all of hostapd's BSS interfaces. hostapd
+// only lists the first BSS of each radio, so the status of each radio is
+// used to find the remaining BSSs.
+func (g *Global) Interfaces(ctx context.Context) ([]string, error) {
+	ctrl := g.client.getCtrl()
+
+	radios, err := ctrl.interfaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		ifnames []string
+		seen    = make(map[string]bool)
+	)
+	add := func(ifname string) {
+		if ifname != "" && !seen[ifname] {
+			seen[ifname] = true
+			ifnames = append(ifnames, ifname)
+		}
+	}
+	for _, radio := range radios {
+		status, err := ctrl.interfaceStatus(ctx, radio)
+		if err != nil {
+			return nil, err
+		}
+		add(radio)
+		for _, bss := range status.BSS {
+			add(bss.Interface)
+		}
+	}
+	return ifnames, nil
+}
+
+// Client returns a Client for the named interface. Its commands are sent
+// through the global control interface, and events are received while
+// Global.Attach is running. ErrInterfaceNotFound is returned if hostapd does
+// not know of the interface.
+func (g *Global) Client(ifname string) (*Client, error) {
+	return newClient(g.client.localSockDir, g.client.ctrlSock, ifname, g, g.opts...)
+}
+
+// Attach subscribes to the events of all interfaces. The provided callback
+// is called for every event, along with the name of the interface it belongs
+// to, which is blank for global events. Events are then delivered to the
+// Attach method of the interface's Client, if running. An error returned from
+// the callback stops Attach.
+//
+// When Attach returns, the Attach method of each Client also returns.
+func (g *Global) Attach(ctx context.Context, events func(ifname string, e Event) error) error {
+	err := g.client.attach(ctx, func(ifname string, e Event) error {
+		if err := events(ifname, e); err != nil {
+			return err
+		}
+		g.dispatch(ifname, e)
+		return nil
+	})
+
+	// End all subscriptions, since no more events will be received.
+	subErr := err
+	if subErr == nil {
+		subErr = fmt.Errorf("global control interface detached: %w", ErrNotAttached)
+	}
+	g.mu.Lock()
+	for ifname, sub := range g.subs {
+		sub.done <- subErr
+		delete(g.subs, ifname)
+	}
+	g.mu.Unlock()
+
+	return err
+}
+
+// dispatch delivers the event to the subscriber of the given interface.
+// If the subscriber's callback returns an error, or if the interface is
+// terminating, then the subscription is ended.
+func (g *Global) dispatch(ifname string, e Event) {
+	g.mu.Lock()
+	sub, ok := g.subs[ifname]
+	g.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	err := sub.events(e)
+	if _, ok := e.(EventTerminating); ok && err == nil {
+		err = ErrTerminating
+	}
+	if err == nil {
+		return
+	}
+
+	g.mu.Lock()
+	if g.subs[ifname] == sub {
+		sub.done <- err
+		delete(g.subs, ifname)
+	}
+	g.mu.Unlock()
+}
+
+// subscribe delivers events of the given interface to the callback. It
+// blocks until the context is done, or the subscription is ended by
+// Attach.
+func (g *Global) subscribe(ctx context.Context, ifname string, events func(Event) error) error {
+	sub := &subscriber{
+		events: events,
+		done:   make(chan error, 1),
+	}
+
+	g.mu.Lock()
+	if _, ok := g.subs[ifname]; ok {
+		g.mu.Unlock()
+		return fmt.Errorf("interface %q is already attached", ifname)
+	}
+	if g.subs == nil {
+		g.subs = make(map[string]*subscriber)
+	}
+	g.subs[ifname] = sub
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		if g.subs[ifname] == sub {
+			delete(g.subs, ifname)
+		}
+		g.mu.Unlock()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-sub.done:
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+}
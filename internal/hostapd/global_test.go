@@ -0,0 +1,243 @@
+package hostapd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/awilliams/wifi-presence/internal/hostapd/hostapdtest"
+)
+
+// newGlobalTest returns a mock global control interface serving the
+// given interface handlers, along with a connected Global.
+func newGlobalTest(t *testing.T, handler *hostapdtest.Handler, interfaces map[string]*hostapdtest.Handler) *Global {
+	t.Helper()
+
+	hostapd, err := hostapdtest.NewHostAPD(path.Join(t.TempDir(), "global"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hostapd.Close() })
+
+	for ifname, ih := range interfaces {
+		handler.OnInterface(ifname, ih)
+	}
+	go hostapd.Serve(handler)
+
+	global, err := NewGlobal(t.TempDir(), hostapd.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { global.Close() })
+
+	return global
+}
+
+func TestGlobal_Interfaces(t *testing.T) {
+	handler := hostapdtest.DefaultHostAPDHandler(hostapdtest.StatusResp{}, nil)
+	handler.OnInterfaces(func() []string {
+		return []string{"wlan0", "wlan1 ctrl_iface=/var/run/hostapd"}
+	})
+
+	global := newGlobalTest(t, handler, map[string]*hostapdtest.Handler{
+		"wlan0": hostapdtest.DefaultHostAPDHandler(hostapdtest.StatusResp{
+			SSID:  "main",
+			BSSID: "AA:AA:AA:AA:AA:01",
+			BSS: []hostapdtest.BSSResp{
+				{Interface: "wlan0-1", SSID: "guest", BSSID: "AA:AA:AA:AA:AA:02"},
+			},
+		}, nil),
+		"wlan1": hostapdtest.DefaultHostAPDHandler(hostapdtest.StatusResp{
+			SSID:  "main",
+			BSSID: "BB:BB:BB:BB:BB:01",
+		}, nil),
+	})
+
+	got, err := global.Interfaces(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"wlan0", "wlan0-1", "wlan1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got Interfaces() %v; want %v", got, want)
+	}
+}
+
+func TestGlobal_Client(t *testing.T) {
+	const testMAC = "FF:FF:FF:00:00:01"
+
+	handler := hostapdtest.DefaultHostAPDHandler(hostapdtest.StatusResp{}, nil)
+	global := newGlobalTest(t, handler, map[string]*hostapdtest.Handler{
+		"wlan0": hostapdtest.DefaultHostAPDHandler(hostapdtest.StatusResp{
+			SSID:  "wlan0-ssid",
+			BSSID: "AA:AA:AA:AA:AA:01",
+		}, nil),
+		"wlan1": hostapdtest.DefaultHostAPDHandler(hostapdtest.StatusResp{
+			SSID:  "wlan1-ssid",
+			BSSID: "BB:BB:BB:BB:BB:01",
+		}, []hostapdtest.StationResp{
+			{MAC: testMAC, Assoc: true},
+		}),
+	})
+
+	client, err := global.Client("wlan1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if got := client.Interface(); got != "wlan1" {
+		t.Errorf("got Interface() %q; want %q", got, "wlan1")
+	}
+
+	status, err := client.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.SSID != "wlan1-ssid" {
+		t.Errorf("got SSID %q; want %q", status.SSID, "wlan1-ssid")
+	}
+
+	stations, err := client.Stations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stations) != 1 || stations[0].MAC != testMAC {
+		t.Errorf("got Stations() %+v; want single station %q", stations, testMAC)
+	}
+
+	if _, err = global.Client("wlan2"); !errors.Is(err, ErrInterfaceNotFound) {
+		t.Fatalf("Client(%q) err: %v; want %v", "wlan2", err, ErrInterfaceNotFound)
+	}
+
+	// The interface is removed after the client is created.
+	handler.OnInterface("wlan1", nil)
+	if _, err = client.Status(context.Background()); !errors.Is(err, ErrInterfaceNotFound) {
+		t.Fatalf("Status() err: %v; want %v", err, ErrInterfaceNotFound)
+	}
+}
+
+func TestGlobal_Attach(t *testing.T) {
+	const testMAC = "ff:ff:ff:00:00:01"
+
+	handler := hostapdtest.DefaultHostAPDHandler(hostapdtest.StatusResp{}, nil)
+	hostapdAttach := make(chan string)
+	attached := make(chan struct{})
+	handler.OnAttach(func() <-chan string {
+		close(attached)
+		return hostapdAttach
+	})
+	global := newGlobalTest(t, handler, map[string]*hostapdtest.Handler{
+		"wlan0": hostapdtest.DefaultHostAPDHandler(hostapdtest.StatusResp{}, nil),
+		"wlan1": hostapdtest.DefaultHostAPDHandler(hostapdtest.StatusResp{}, nil),
+	})
+
+	wlan1, err := global.Client("wlan1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wlan1.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type ifaceEvent struct {
+		ifname string
+		event  Event
+	}
+	globalEvents := make(chan ifaceEvent, 1)
+	globalErr := make(chan error, 1)
+	go func() {
+		globalErr <- global.Attach(ctx, func(ifname string, e Event) error {
+			globalEvents <- ifaceEvent{ifname, e}
+			return nil
+		})
+	}()
+	select {
+	case <-attached:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for attach")
+	}
+
+	wlan1Events := make(chan Event, 1)
+	wlan1Err := make(chan error, 1)
+	go func() {
+		wlan1Err <- wlan1.Attach(ctx, func(e Event) error {
+			wlan1Events <- e
+			return nil
+		})
+	}()
+
+	send := func(msg string) {
+		select {
+		case hostapdAttach <- msg:
+		case <-time.After(time.Second):
+			t.Fatalf("timeout sending %q", msg)
+		}
+	}
+	recvGlobal := func(want ifaceEvent) {
+		select {
+		case got := <-globalEvents:
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got global event %+v; want %+v", got, want)
+			}
+		case err := <-globalErr:
+			t.Fatalf("global Attach err: %v", err)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for global event")
+		}
+	}
+
+	// All events are delivered to the global callback, including those of
+	// interfaces without a running Attach.
+	msg := fmt.Sprintf("IFNAME=wlan0 <3>%s %s", eventAPStaConnected, testMAC)
+	send(msg)
+	recvGlobal(ifaceEvent{"wlan0", EventStationConnect{raw: msg[len("IFNAME=wlan0 "):], MAC: testMAC}})
+
+	// The subscription may not be registered yet, so retry until the
+	// interface's Attach receives the event.
+	msg = fmt.Sprintf("IFNAME=wlan1 <3>%s %s", eventAPStaDisconnected, testMAC)
+	want := EventStationDisconnect{raw: msg[len("IFNAME=wlan1 "):], MAC: testMAC}
+	for received := false; !received; {
+		send(msg)
+		recvGlobal(ifaceEvent{"wlan1", want})
+		select {
+		case got := <-wlan1Events:
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got wlan1 event %+v; want %+v", got, want)
+			}
+			received = true
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	// A terminating interface ends only its own Attach.
+	send(fmt.Sprintf("IFNAME=wlan1 <3>%s", eventWPATerminating))
+	select {
+	case err := <-wlan1Err:
+		if !errors.Is(err, ErrTerminating) {
+			t.Fatalf("got wlan1 Attach err %v; want %v", err, ErrTerminating)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for wlan1 Attach to return")
+	}
+	select {
+	case <-globalEvents:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for global terminating event")
+	}
+
+	cancel()
+	select {
+	case err := <-globalErr:
+		if err != nil {
+			t.Fatalf("global Attach err: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for global Attach to return")
+	}
+}
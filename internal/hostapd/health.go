@@ -0,0 +1,90 @@
+package hostapd
+
+import (
+	"sync"
+	"time"
+)
+
+// Liveness states of a control interface socket.
+const (
+	HealthUnknown HealthState = iota
+	HealthOK
+	HealthDegraded
+	HealthUnresponsive
+)
+
+// HealthState is the liveness state of a control interface socket.
+type HealthState int
+
+func (h HealthState) String() string {
+	switch h {
+	case HealthUnknown:
+		return "unknown"
+	case HealthOK:
+		return "ok"
+	case HealthDegraded:
+		return "degraded"
+	case HealthUnresponsive:
+		return "unresponsive"
+	default:
+		return "?"
+	}
+}
+
+// Health describes the liveness of each of the sockets used by a Client.
+type Health struct {
+	Command SocketHealth // Socket used for commands, e.g. STATUS.
+	Attach  SocketHealth // Socket used to receive events while attached.
+}
+
+// SocketHealth describes the liveness of a single control interface socket,
+// as determined by periodic PING commands.
+type SocketHealth struct {
+	State       HealthState
+	LastPong    time.Time // Time of the most recent PONG response.
+	MissedPongs int       // Consecutive PINGs without a PONG response.
+}
+
+// socketHealth is a threadsafe SocketHealth.
+type socketHealth struct {
+	mu        sync.Mutex // Protects following.
+	h         SocketHealth
+	maxMissed int
+}
+
+// get returns the current SocketHealth.
+func (s *socketHealth) get() SocketHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.h
+}
+
+// pong records that a PONG was received.
+func (s *socketHealth) pong() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.h.State = HealthOK
+	s.h.LastPong = time.Now()
+	s.h.MissedPongs = 0
+}
+
+// miss records that a PING went unanswered. It returns true if the number
+// of consecutive missed PONGs has reached the maximum.
+func (s *socketHealth) miss() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.h.MissedPongs++
+	if s.h.MissedPongs >= s.maxMissed {
+		s.h.State = HealthUnresponsive
+		return true
+	}
+	s.h.State = HealthDegraded
+	return false
+}
+
+// reset returns the health to its initial state.
+func (s *socketHealth) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.h = SocketHealth{}
+}
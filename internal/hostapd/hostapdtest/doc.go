@@ -1,3 +1,3 @@
-// Package hostapdtest provides a server listening on a Unix Socket
-// that imitates a HostAPD control socket. Useful to testing.
+// Package hostapdtest provides a server listening on a Unix Socket, or
+// UDP, that imitates a HostAPD control socket. Useful to testing.
 package hostapdtest
@@ -14,10 +14,15 @@ type Handler struct {
 	onUndef        func(msg string) string
 	onPing         func() bool // Reply to PING with PONG unless onPing is defined and returns false.
 	onStatus       func() StatusResp
+	onGetConfig    func() BSSResp
 	onStationFirst func() (resp StationResp, unknown bool, ok bool) // If unknown is true, then an "UNKNOWN COMMAND" response will be sent.
 	onStationNext  func(mac string) (resp StationResp, ok bool)
+	onStation      func(mac string) (resp StationResp, ok bool)
+	onPollStation  func(mac string) bool
 	onAttach       func() <-chan string
 	onDetach       func()
+	onInterfaces   func() []string
+	interfaces     map[string]*Handler
 }
 
 // DefaultHostAPDHandler is a convenience function to define a Handler
@@ -26,6 +31,9 @@ func DefaultHostAPDHandler(status StatusResp, stations []StationResp) *Handler {
 	var h Handler
 	h.OnPing(func() bool { return true })
 	h.OnStatus(func() StatusResp { return status })
+	h.OnGetConfig(func() BSSResp {
+		return BSSResp{SSID: status.SSID, BSSID: status.BSSID}
+	})
 	h.OnStationFirst(func() (StationResp, bool, bool) {
 		if len(stations) > 0 {
 			return stations[0], false, true
@@ -43,6 +51,14 @@ func DefaultHostAPDHandler(status StatusResp, stations []StationResp) *Handler {
 		}
 		return StationResp{}, false
 	})
+	h.OnStation(func(mac string) (StationResp, bool) {
+		for _, s := range stations {
+			if s.MAC == mac {
+				return s, true
+			}
+		}
+		return StationResp{}, false
+	})
 	return &h
 }
 
@@ -116,6 +132,24 @@ func (h *Handler) handleStatus() (StatusResp, bool) {
 	return h.onStatus(), true
 }
 
+// OnGetConfig registers a callback which determines the BSS
+// of the response to a GET_CONFIG message. If this callback
+// isn't set, then an "UNKNOWN COMMAND" response is sent.
+func (h *Handler) OnGetConfig(f func() BSSResp) {
+	h.Lock()
+	h.onGetConfig = f
+	h.Unlock()
+}
+
+func (h *Handler) handleGetConfig() (BSSResp, bool) {
+	h.Lock()
+	defer h.Unlock()
+	if h.onGetConfig == nil {
+		return BSSResp{}, false
+	}
+	return h.onGetConfig(), true
+}
+
 // OnStationFirst registers a callback which determines the response
 // to a STA-FIRST message.
 func (h *Handler) OnStationFirst(f func() (resp StationResp, unknown, ok bool)) {
@@ -150,6 +184,43 @@ func (h *Handler) handleStationNext(mac string) (StationResp, bool) {
 	return h.onStationNext(mac)
 }
 
+// OnStation registers a callback which determines the response
+// to a STA message. The callback is passed the mac address received.
+// If false is returned, then a FAIL response is sent.
+func (h *Handler) OnStation(f func(mac string) (StationResp, bool)) {
+	h.Lock()
+	h.onStation = f
+	h.Unlock()
+}
+
+func (h *Handler) handleStation(mac string) (StationResp, bool) {
+	h.Lock()
+	defer h.Unlock()
+	if h.onStation == nil {
+		return StationResp{}, false
+	}
+	return h.onStation(mac)
+}
+
+// OnPollStation registers a callback for when a POLL_STA message is
+// received. The callback is passed the mac address received. If true is
+// returned, then OK is sent, otherwise FAIL. Any AP-STA-POLL-OK event
+// must be sent separately via the OnAttach channel.
+func (h *Handler) OnPollStation(f func(mac string) bool) {
+	h.Lock()
+	h.onPollStation = f
+	h.Unlock()
+}
+
+func (h *Handler) handlePollStation(mac string) bool {
+	h.Lock()
+	defer h.Unlock()
+	if h.onPollStation == nil {
+		return false
+	}
+	return h.onPollStation(mac)
+}
+
 // OnAttach registers a callback function for when ATTACH is received.
 // The returned channel will be read from and sent to the remote connection.
 // The channel may be closed by the caller.
@@ -183,22 +254,85 @@ func (h *Handler) handleDetach() {
 	}
 }
 
+// OnInterfaces registers a callback for when INTERFACES is received,
+// as sent to hostapd's global control interface.
+func (h *Handler) OnInterfaces(f func() []string) {
+	h.Lock()
+	h.onInterfaces = f
+	h.Unlock()
+}
+
+func (h *Handler) handleInterfaces() []string {
+	h.Lock()
+	defer h.Unlock()
+	if h.onInterfaces == nil {
+		return nil
+	}
+	return h.onInterfaces()
+}
+
+// OnInterface registers a handler for commands prefixed with
+// "IFNAME=<ifname>", as sent to hostapd's global control interface.
+// A nil handler removes the interface.
+func (h *Handler) OnInterface(ifname string, ih *Handler) {
+	h.Lock()
+	defer h.Unlock()
+	if h.interfaces == nil {
+		h.interfaces = make(map[string]*Handler)
+	}
+	if ih == nil {
+		delete(h.interfaces, ifname)
+		return
+	}
+	h.interfaces[ifname] = ih
+}
+
+func (h *Handler) handleInterface(ifname string) (*Handler, bool) {
+	h.Lock()
+	defer h.Unlock()
+	ih, ok := h.interfaces[ifname]
+	return ih, ok
+}
+
 // StatusResp forms the response to a STATUS message.
 type StatusResp struct {
 	State      string
 	Channel    int
+	Freq       int
+	Interface  string // Interface of the first BSS, if set.
 	SSID       string
 	BSSID      string
 	MaxTxPower int
+	// Additional BSSs, following the first one
+	// defined by SSID and BSSID.
+	BSS []BSSResp
+}
+
+// BSSResp forms the per-BSS part of a STATUS response.
+type BSSResp struct {
+	Interface string
+	SSID      string
+	BSSID     string
 }
 
 func (s *StatusResp) encode() string {
 	var b strings.Builder
 	fmt.Fprintf(&b, "state=%s\n", s.State)
 	fmt.Fprintf(&b, "channel=%d\n", s.Channel)
+	if s.Freq != 0 {
+		fmt.Fprintf(&b, "freq=%d\n", s.Freq)
+	}
 	fmt.Fprintf(&b, "max_txpower=%d\n", s.MaxTxPower)
+	if s.Interface != "" {
+		fmt.Fprintf(&b, "bss[0]=%s\n", s.Interface)
+	}
 	fmt.Fprintf(&b, "ssid[0]=%s\n", s.SSID)
 	fmt.Fprintf(&b, "bssid[0]=%s\n", s.BSSID)
+	for i, bss := range s.BSS {
+		fmt.Fprintf(&b, "bss[%d]=%s\n", i+1, bss.Interface)
+		fmt.Fprintf(&b, "ssid[%d]=%s\n", i+1, bss.SSID)
+		fmt.Fprintf(&b, "bssid[%d]=%s\n", i+1, bss.BSSID)
+	}
 	return b.String()
 }
 
@@ -207,6 +341,7 @@ type StationResp struct {
 	MAC    string
 	Assoc  bool
 	Signal int
+	Lines  []string // Additional "key=value" lines.
 }
 
 func (s *StationResp) encode() string {
@@ -216,5 +351,8 @@ func (s *StationResp) encode() string {
 		fmt.Fprintln(&b, "flags=[ASSOC]")
 	}
 	fmt.Fprintf(&b, "signal=%d", s.Signal)
+	for _, l := range s.Lines {
+		fmt.Fprintf(&b, "\n%s", l)
+	}
 	return b.String()
 }
@@ -1,6 +1,8 @@
 package hostapdtest
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"net"
 	"strings"
@@ -26,11 +28,35 @@ func NewHostAPD(sockPath string) (*HostAPD, error) {
 	}, nil
 }
 
+// NewUDPHostAPD creates a mock HostAPD listening on the UDP address, e.g.
+// "127.0.0.1:0". Like hostapd's UDP control interface, commands must be
+// prefixed with the cookie returned by the GET_COOKIE command, otherwise
+// they are dropped. Addr has the form udp://host:port.
+func NewUDPHostAPD(addr string) (*HostAPD, error) {
+	cookie := make([]byte, 8)
+	if _, err := rand.Read(cookie); err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HostAPD{
+		Addr:   "udp://" + conn.LocalAddr().String(),
+		conn:   conn,
+		buf:    make([]byte, 128),
+		cookie: "COOKIE=" + hex.EncodeToString(cookie),
+	}, nil
+}
+
 // HostAPD mocks a HostAPD socket interface.
 type HostAPD struct {
-	Addr string
-	conn *net.UnixConn
-	buf  []byte
+	Addr   string
+	conn   net.PacketConn
+	buf    []byte
+	cookie string // Required prefix of commands, if non-blank.
 
 	mu     sync.Mutex
 	closed bool
@@ -58,7 +84,8 @@ func (h *HostAPD) WriteTo(msg string, addr net.Addr) error {
 }
 
 // ReadFrom reads a message and returns it as a string along with the
-// remote address. Must not be used after calling Serve.
+// remote address. Must not be used after calling Serve. Messages are
+// returned as received, including any cookie.
 func (h *HostAPD) ReadFrom() (string, net.Addr, error) {
 	n, raddr, err := h.conn.ReadFrom(h.buf)
 	if err != nil {
@@ -84,84 +111,165 @@ func (h *HostAPD) Serve(handler *Handler) error {
 			return err
 		}
 
+		if h.cookie != "" {
+			var ok bool
+			if msg, ok, err = h.checkCookie(msg, raddr); err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+		}
+
 		handler.handleMessage(msg)
 
-		switch {
-		case msg == "PING":
-			if handler.handlePing() {
-				if err := h.WriteTo("PONG", raddr); err != nil {
-					return err
-				}
+		detached, err := h.handle(handler, msg, raddr, done)
+		if err != nil {
+			return err
+		}
+		if detached {
+			return nil
+		}
+	}
+}
+
+// checkCookie handles the GET_COOKIE command and strips the cookie from
+// other commands. The returned bool is false if the message should not be
+// handled further, either because it was a GET_COOKIE command, or because it
+// lacked the cookie.
+func (h *HostAPD) checkCookie(msg string, raddr net.Addr) (string, bool, error) {
+	if msg == "GET_COOKIE" {
+		return "", false, h.WriteTo(h.cookie, raddr)
+	}
+	if !strings.HasPrefix(msg, h.cookie+" ") {
+		return "", false, nil
+	}
+	return strings.TrimLeft(msg[len(h.cookie):], " "), true, nil
+}
+
+// handle responds to a single message using the handler. The returned
+// bool is true if the message was a DETACH command.
+func (h *HostAPD) handle(handler *Handler, msg string, raddr net.Addr, done <-chan struct{}) (bool, error) {
+	switch {
+	case msg == "PING":
+		if handler.handlePing() {
+			if err := h.WriteTo("PONG", raddr); err != nil {
+				return false, err
 			}
+		}
 
-		case msg == "STATUS":
-			if resp, ok := handler.handleStatus(); ok {
-				if err := h.WriteTo(resp.encode(), raddr); err != nil {
-					return err
-				}
+	case msg == "STATUS":
+		if resp, ok := handler.handleStatus(); ok {
+			if err := h.WriteTo(resp.encode(), raddr); err != nil {
+				return false, err
 			}
+		}
 
-		case msg == "STA-FIRST":
-			var resp string
-
-			station, unknown, ok := handler.handleStationFirst()
-			switch {
-			case unknown:
-				resp = "UNKNOWN COMMAND"
-			case ok:
-				resp = station.encode()
-			default:
-				// Empty response
+	case msg == "GET_CONFIG":
+		resp := "UNKNOWN COMMAND"
+		if bss, ok := handler.handleGetConfig(); ok {
+			resp = fmt.Sprintf("bssid=%s\nssid=%s\n", bss.BSSID, bss.SSID)
+		}
+		if err := h.WriteTo(resp, raddr); err != nil {
+			return false, err
+		}
+
+	case msg == "STA-FIRST":
+		var resp string
+
+		station, unknown, ok := handler.handleStationFirst()
+		switch {
+		case unknown:
+			resp = "UNKNOWN COMMAND"
+		case ok:
+			resp = station.encode()
+		default:
+			// Empty response
+		}
+		if err := h.WriteTo(resp, raddr); err != nil {
+			return false, err
+		}
+
+	case strings.HasPrefix(msg, "STA-NEXT"):
+		mac := strings.TrimPrefix(msg, "STA-NEXT ")
+		if station, ok := handler.onStationNext(mac); ok {
+			if err := h.WriteTo(station.encode(), raddr); err != nil {
+				return false, err
 			}
-			if err := h.WriteTo(resp, raddr); err != nil {
-				return err
+		} else {
+			if err := h.WriteTo("", raddr); err != nil {
+				return false, err
 			}
+		}
 
-		case strings.HasPrefix(msg, "STA-NEXT"):
-			mac := strings.TrimPrefix(msg, "STA-NEXT ")
-			if station, ok := handler.onStationNext(mac); ok {
-				if err := h.WriteTo(station.encode(), raddr); err != nil {
-					return err
-				}
-			} else {
-				if err := h.WriteTo("", raddr); err != nil {
-					return err
-				}
-			}
+	case strings.HasPrefix(msg, "STA "):
+		resp := "FAIL"
+		if station, ok := handler.handleStation(strings.TrimPrefix(msg, "STA ")); ok {
+			resp = station.encode()
+		}
+		if err := h.WriteTo(resp, raddr); err != nil {
+			return false, err
+		}
 
-		case msg == "ATTACH":
-			if msgs := handler.handleAttach(); msgs != nil {
-				if err := h.WriteTo("OK", raddr); err != nil {
-					return err
-				}
+	case strings.HasPrefix(msg, "POLL_STA "):
+		resp := "FAIL"
+		if handler.handlePollStation(strings.TrimPrefix(msg, "POLL_STA ")) {
+			resp = "OK"
+		}
+		if err := h.WriteTo(resp, raddr); err != nil {
+			return false, err
+		}
+
+	case msg == "ATTACH" || strings.HasPrefix(msg, "ATTACH "):
+		if msgs := handler.handleAttach(); msgs != nil {
+			if err := h.WriteTo("OK", raddr); err != nil {
+				return false, err
+			}
 
-				go func(msgs <-chan string) {
-					for {
-						select {
-						case <-done:
+			go func(msgs <-chan string) {
+				for {
+					select {
+					case <-done:
+						return
+					case msg := <-msgs:
+						if err := h.WriteTo(msg, raddr); err != nil {
 							return
-						case msg := <-msgs:
-							if err := h.WriteTo(msg, raddr); err != nil {
-								return
-							}
 						}
 					}
-				}(msgs)
-			}
+				}
+			}(msgs)
+		}
 
-		case msg == "DETACH":
-			// Ignore this error, since the other side of
-			// the connection may already have closed.
-			_ = h.WriteTo("OK", raddr)
-			handler.handleDetach()
-			return nil
+	case msg == "DETACH":
+		// Ignore this error, since the other side of
+		// the connection may already have closed.
+		_ = h.WriteTo("OK", raddr)
+		handler.handleDetach()
+		return true, nil
 
-		default:
-			if resp, ok := handler.handleUndef(msg); ok {
-				if err := h.WriteTo(resp, raddr); err != nil {
-					return err
-				}
+	case msg == "INTERFACES":
+		var b strings.Builder
+		for _, ifname := range handler.handleInterfaces() {
+			fmt.Fprintln(&b, ifname)
+		}
+		if err := h.WriteTo(b.String(), raddr); err != nil {
+			return false, err
+		}
+
+	case strings.HasPrefix(msg, "IFNAME="):
+		ifname, cmd, _ := strings.Cut(strings.TrimPrefix(msg, "IFNAME="), " ")
+		ih, ok := handler.handleInterface(ifname)
+		if !ok {
+			return false, h.WriteTo("FAIL-NO-IFNAME-MATCH", raddr)
+		}
+		return h.handle(ih, cmd, raddr, done)
+
+	default:
+		if resp, ok := handler.handleUndef(msg); ok {
+			if err := h.WriteTo(resp, raddr); err != nil {
+				return false, err
 			}
 		}
 	}
+	return false, nil
 }
@@ -0,0 +1,94 @@
+package hostapd
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// globalSockName is the name of hostapd's global control interface
+// socket, which cannot be used as a per-interface control interface.
+const globalSockName = "global"
+
+// ControlSockets returns paths to all Unix domain sockets in the given
+// directory, excluding the global control interface.
+func ControlSockets(dir string) []string {
+	var sockets []string
+	_ = filepath.WalkDir(dir, func(path string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// The "global" control interface cannot be used for wifi-presence
+		// purposes, e.g. getting station information. See NewGlobal.
+		if filepath.Base(path) == globalSockName {
+			return nil
+		}
+
+		// Test if file is a Unix domain socket.
+		if de.Type()&os.ModeSocket != 0 {
+			sockets = append(sockets, path)
+		}
+		return nil
+	})
+	return sockets
+}
+
+// WatchSockets calls fn with the control interface sockets found in dir (see
+// ControlSockets) whenever they may have changed: once at the start, whenever
+// the directory's contents change, and at least every interval. It blocks
+// until the context is done, or fn returns an error.
+//
+// On Linux, inotify is used to detect changes. Elsewhere, or when the
+// directory cannot be watched (e.g. because it does not yet exist),
+// the directory is polled every interval.
+func WatchSockets(ctx context.Context, dir string, interval time.Duration, fn func(sockets []string) error) error {
+	return watchSockets(ctx, dir, interval, watchDir, fn)
+}
+
+// dirWatcher watches a directory, sending on the returned channel when
+// its contents change. The channel is closed if the directory can no longer
+// be watched. The returned function stops watching.
+type dirWatcher func(dir string) (<-chan struct{}, func() error, error)
+
+func watchSockets(ctx context.Context, dir string, interval time.Duration, watch dirWatcher, fn func(sockets []string) error) error {
+	var (
+		changed <-chan struct{}
+		stop    func() error
+	)
+	defer func() {
+		if stop != nil {
+			stop()
+		}
+	}()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if changed == nil && watch != nil {
+			// Fallback to polling if the directory cannot be watched.
+			if c, s, err := watch(dir); err == nil {
+				changed, stop = c, s
+			}
+		}
+
+		if err := fn(ControlSockets(dir)); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		case _, ok := <-changed:
+			if !ok {
+				// The directory is no longer being watched,
+				// e.g. because it was removed.
+				stop()
+				changed, stop = nil, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package hostapd
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// watchDir uses inotify to watch for files being created or removed in dir.
+func watchDir(dir string) (<-chan struct{}, func() error, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	const mask = syscall.IN_CREATE | syscall.IN_DELETE |
+		syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM |
+		syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+	if _, err = syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+		syscall.Close(fd)
+		return nil, nil, os.NewSyscallError("inotify_add_watch", err)
+	}
+
+	// Since the file descriptor is non-blocking, reads use the runtime's
+	// poller, and closing the file unblocks any pending read.
+	f := os.NewFile(uintptr(fd), "inotify")
+
+	changed := make(chan struct{}, 1)
+	go func() {
+		defer close(changed)
+
+		buf := make([]byte, 4096)
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+
+			var removed bool
+			for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				if event.Mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF|syscall.IN_IGNORED) != 0 {
+					removed = true
+				}
+				off += syscall.SizeofInotifyEvent + int(event.Len)
+			}
+
+			select {
+			case changed <- struct{}{}:
+			default:
+				// A change is already pending.
+			}
+
+			if removed {
+				// The directory itself is gone, so no
+				// further events will be received.
+				return
+			}
+		}
+	}()
+
+	return changed, f.Close, nil
+}
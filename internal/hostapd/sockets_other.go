@@ -0,0 +1,10 @@
+//go:build !linux
+
+package hostapd
+
+import "errors"
+
+// watchDir is not supported on this platform, so directories are polled.
+func watchDir(dir string) (<-chan struct{}, func() error, error) {
+	return nil, nil, errors.New("watching directories is not supported")
+}
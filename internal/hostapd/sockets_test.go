@@ -0,0 +1,113 @@
+package hostapd
+
+import (
+	"context"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/awilliams/wifi-presence/internal/hostapd/hostapdtest"
+)
+
+func TestControlSockets(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"wlan0", "wlan1", "global"} {
+		hostapd, err := hostapdtest.NewHostAPD(path.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { hostapd.Close() })
+	}
+	// Regular files are ignored.
+	if err := os.WriteFile(path.Join(dir, "wlan2"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got := ControlSockets(dir)
+	want := []string{path.Join(dir, "wlan0"), path.Join(dir, "wlan1")}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got ControlSockets() %v; want %v", got, want)
+	}
+}
+
+func TestWatchSockets(t *testing.T) {
+	testCases := []struct {
+		name     string
+		interval time.Duration
+		watch    dirWatcher
+	}{
+		// The interval is long enough that changes must
+		// be detected by watching the directory.
+		{name: "watch", interval: time.Hour, watch: watchDir},
+		{name: "poll", interval: 10 * time.Millisecond, watch: nil},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.watch != nil {
+				if _, stop, err := tc.watch(t.TempDir()); err != nil {
+					t.Skipf("unable to watch directory: %v", err)
+				} else {
+					stop()
+				}
+			}
+
+			dir := t.TempDir()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			updates := make(chan []string, 1)
+			watchErr := make(chan error, 1)
+			go func() {
+				watchErr <- watchSockets(ctx, dir, tc.interval, tc.watch, func(sockets []string) error {
+					select {
+					case updates <- sockets:
+					case <-ctx.Done():
+					}
+					return nil
+				})
+			}()
+
+			// Wait for an update matching want.
+			ensureSockets := func(want []string) {
+				t.Helper()
+				timeout := time.After(time.Second)
+				for {
+					select {
+					case got := <-updates:
+						if reflect.DeepEqual(got, want) {
+							return
+						}
+					case err := <-watchErr:
+						t.Fatalf("watchSockets err: %v", err)
+					case <-timeout:
+						t.Fatalf("timeout waiting for sockets %v", want)
+					}
+				}
+			}
+
+			ensureSockets(nil)
+
+			sock := path.Join(dir, "wlan0")
+			hostapd, err := hostapdtest.NewHostAPD(sock)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ensureSockets([]string{sock})
+
+			hostapd.Close()
+			if err := os.Remove(sock); err != nil {
+				t.Fatal(err)
+			}
+			ensureSockets(nil)
+
+			cancel()
+			if err := <-watchErr; err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -11,25 +11,70 @@ import (
 )
 
 // Station contains information about a WiFi station (client).
+// More info about the fields:
+// https://w1.fi/cgit/hostap/tree/src/ap/ctrl_iface_ap.c
 type Station struct {
 	MAC        string
-	Associated bool
+	Associated bool // Equivalent to Flags.Has(FlagAssoc).
+	Flags      StationFlags
+	AID        int // Association ID.
 	RxBytes    int64
 	TxBytes    int64
+	RxPackets  int64
+	TxPackets  int64
+	RxRate     RateInfo
+	TxRate     RateInfo
 	Connected  time.Duration
 	Inactive   time.Duration
-	Signal     int
+	Signal     int // dBm
+	SignalAvg  int // dBm. Zero if not reported.
+	VLANID     int
+	KeyID      string // Identifier of the PSK used, if any.
+
+	// MLDAddr is the MLD address of a multi-link (Wi-Fi 7) station,
+	// which is the same across all of its links. Blank otherwise.
+	MLDAddr string
+	// Links of a multi-link station, other than the one MAC is
+	// the address of.
+	Links []StationLink
+
+	ListenInterval int
+	SupportedRates []int // In kbps.
+	Capability     uint16
+	HTCaps         uint16
+	VHTCaps        uint32
+
+	// Extra contains all other "key=value" fields of the response,
+	// including those added by future versions of hostapd.
+	Extra map[string]string
+}
+
+// StationLink is a link of a multi-link (Wi-Fi 7) station.
+type StationLink struct {
+	ID   int    // Link ID.
+	Addr string // The station's address on the link.
+}
+
+// ID returns the address identifying the station, which is its MLD address
+// for multi-link stations, otherwise its MAC. The stations of each link of
+// a multi-link station have the same ID.
+func (s Station) ID() string {
+	if s.MLDAddr != "" {
+		return s.MLDAddr
+	}
+	return s.MAC
 }
 
 // parse parses the hostapd control interface
 // message representing a station and updates s.
+// Only the MAC address is required; fields with an
+// invalid value are ignored, keeping their zero value.
 func (s *Station) parse(p []byte) error {
 	if msg := string(p); msg == "FAIL\n" {
 		return errors.New("station: fail")
 	}
 
 	var (
-		err      error
 		line     string
 		parts    []string
 		key, val string
@@ -53,44 +98,273 @@ func (s *Station) parse(p []byte) error {
 
 		parts = strings.SplitN(line, "=", 2)
 		if len(parts) != 2 {
-			return fmt.Errorf("invalid station response line %q", line)
+			continue
 		}
 		key, val = parts[0], parts[1]
 
+		// The links of a multi-link station are indexed by
+		// link ID, e.g. "peer_addr[1]".
+		if name, id, ok := indexedKey(key); ok && name == "peer_addr" {
+			if isMAC(val) {
+				s.Links = append(s.Links, StationLink{ID: id, Addr: val})
+			}
+			continue
+		}
+
 		switch key {
 		case "flags":
-			s.Associated = strings.Contains(val, "[ASSOC]")
+			s.Flags = parseStationFlags(val)
+			s.Associated = s.Flags.Has(FlagAssoc)
+
+		case "aid":
+			if v, err := strconv.Atoi(val); err == nil {
+				s.AID = v
+			}
 
 		case "rx_bytes":
-			if s.RxBytes, err = strconv.ParseInt(val, 10, 64); err != nil {
-				return err
+			if v, err := strconv.ParseInt(val, 10, 64); err == nil {
+				s.RxBytes = v
 			}
 
 		case "tx_bytes":
-			if s.TxBytes, err = strconv.ParseInt(val, 10, 64); err != nil {
-				return err
+			if v, err := strconv.ParseInt(val, 10, 64); err == nil {
+				s.TxBytes = v
+			}
+
+		case "rx_packets":
+			if v, err := strconv.ParseInt(val, 10, 64); err == nil {
+				s.RxPackets = v
+			}
+
+		case "tx_packets":
+			if v, err := strconv.ParseInt(val, 10, 64); err == nil {
+				s.TxPackets = v
+			}
+
+		case "rx_rate_info":
+			if v, err := parseRateInfo(val); err == nil {
+				s.RxRate = v
+			}
+
+		case "tx_rate_info":
+			if v, err := parseRateInfo(val); err == nil {
+				s.TxRate = v
 			}
 
 		case "connected_time":
-			seconds, err := strconv.Atoi(val)
-			if err != nil {
-				return err
+			if seconds, err := strconv.Atoi(val); err == nil {
+				s.Connected = time.Second * time.Duration(seconds)
 			}
-			s.Connected = time.Second * time.Duration(seconds)
 
 		case "inactive_msec":
-			msec, err := strconv.Atoi(val)
-			if err != nil {
-				return err
+			if msec, err := strconv.Atoi(val); err == nil {
+				s.Inactive = time.Millisecond * time.Duration(msec)
 			}
-			s.Inactive = time.Millisecond * time.Duration(msec)
 
 		case "signal":
-			if s.Signal, err = strconv.Atoi(val); err != nil {
-				return err
+			if v, err := strconv.Atoi(val); err == nil {
+				s.Signal = v
+			}
+
+		case "avg_signal":
+			if v, err := strconv.Atoi(val); err == nil {
+				s.SignalAvg = v
+			}
+
+		case "vlan_id":
+			if v, err := strconv.Atoi(val); err == nil {
+				s.VLANID = v
+			}
+
+		case "keyid":
+			s.KeyID = val
+
+		case "mld_addr":
+			if isMAC(val) {
+				s.MLDAddr = val
+			}
+
+		case "listen_interval":
+			if v, err := strconv.Atoi(val); err == nil {
+				s.ListenInterval = v
+			}
+
+		case "supported_rates":
+			if v, err := parseSupportedRates(val); err == nil {
+				s.SupportedRates = v
+			}
+
+		case "capability":
+			if v, err := strconv.ParseUint(val, 0, 16); err == nil {
+				s.Capability = uint16(v)
 			}
+
+		case "ht_caps_info":
+			if v, err := strconv.ParseUint(val, 0, 16); err == nil {
+				s.HTCaps = uint16(v)
+			}
+
+		case "vht_caps_info":
+			if v, err := strconv.ParseUint(val, 0, 32); err == nil {
+				s.VHTCaps = uint32(v)
+			}
+
+		default:
+			if s.Extra == nil {
+				s.Extra = make(map[string]string)
+			}
+			s.Extra[key] = val
 		}
 	}
 
 	return scanner.Err()
 }
+
+// Station flags, as reported by hostapd in the "flags" field.
+const (
+	FlagAuth StationFlags = 1 << iota
+	FlagAssoc
+	FlagAuthorized
+	FlagPendingPoll
+	FlagShortPreamble
+	FlagPreAuth
+	FlagWMM
+	FlagMFP
+	FlagHT
+	FlagVHT
+	FlagHE
+	FlagEHT
+	FlagPS
+	FlagWPS
+	FlagNonERP
+	Flag6GHz
+)
+
+var stationFlagNames = []struct {
+	flag StationFlags
+	name string
+}{
+	{FlagAuth, "AUTH"},
+	{FlagAssoc, "ASSOC"},
+	{FlagAuthorized, "AUTHORIZED"},
+	{FlagPendingPoll, "PENDING_POLL"},
+	{FlagShortPreamble, "SHORT_PREAMBLE"},
+	{FlagPreAuth, "PREAUTH"},
+	{FlagWMM, "WMM"},
+	{FlagMFP, "MFP"},
+	{FlagHT, "HT"},
+	{FlagVHT, "VHT"},
+	{FlagHE, "HE"},
+	{FlagEHT, "EHT"},
+	{FlagPS, "PS"},
+	{FlagWPS, "WPS"},
+	{FlagNonERP, "NONERP"},
+	{Flag6GHz, "6GHZ"},
+}
+
+// StationFlags is the set of flags reported for a station.
+type StationFlags uint32
+
+// Has returns true if all of the given flags are set.
+func (f StationFlags) Has(flags StationFlags) bool {
+	return f&flags == flags
+}
+
+// String returns the flags in the same format used by hostapd,
+// e.g. "[AUTH][ASSOC]".
+func (f StationFlags) String() string {
+	var b strings.Builder
+	for _, fn := range stationFlagNames {
+		if f.Has(fn.flag) {
+			fmt.Fprintf(&b, "[%s]", fn.name)
+		}
+	}
+	return b.String()
+}
+
+// parseStationFlags parses flags in the form "[AUTH][ASSOC]".
+// Unknown flags are ignored.
+func parseStationFlags(v string) StationFlags {
+	var f StationFlags
+	for _, name := range strings.Split(v, "]") {
+		name = strings.TrimPrefix(name, "[")
+		for _, fn := range stationFlagNames {
+			if fn.name == name {
+				f |= fn.flag
+				break
+			}
+		}
+	}
+	return f
+}
+
+// RateInfo describes the bitrate used to send or receive data
+// from a station.
+type RateInfo struct {
+	Kbps    int
+	MCS     int // MCS index, of the highest reported PHY mode (HT, VHT, HE or EHT).
+	NSS     int // Number of spatial streams. Not reported for HT.
+	ShortGI bool
+	Raw     string
+}
+
+// parseRateInfo parses rate info in the form
+// "3240 vhtmcs 8 vhtnss 2 shortGI".
+func parseRateInfo(v string) (RateInfo, error) {
+	r := RateInfo{Raw: v}
+
+	fields := strings.Fields(v)
+	if len(fields) == 0 {
+		return r, nil
+	}
+
+	// The first field is the rate in 100 kbps units.
+	rate, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return r, fmt.Errorf("invalid rate info %q: %w", v, err)
+	}
+	r.Kbps = rate * 100
+
+	for i := 1; i < len(fields); i++ {
+		name := fields[i]
+		if name == "shortGI" {
+			r.ShortGI = true
+			continue
+		}
+
+		if i+1 >= len(fields) {
+			break
+		}
+		n, err := strconv.Atoi(fields[i+1])
+		if err != nil {
+			// Unknown field without a numeric value.
+			continue
+		}
+		i++
+
+		switch name {
+		case "mcs", "vhtmcs", "hemcs", "ehtmcs":
+			r.MCS = n
+		case "vhtnss", "henss", "ehtnss":
+			r.NSS = n
+		}
+	}
+
+	return r, nil
+}
+
+// parseSupportedRates parses a list of hex encoded rates, e.g.
+// "8c 12 98 24". Each rate is in 500 kbps units, with the high bit
+// indicating whether the rate is a basic rate.
+func parseSupportedRates(v string) ([]int, error) {
+	fields := strings.Fields(v)
+	rates := make([]int, 0, len(fields))
+	for _, f := range fields {
+		b, err := strconv.ParseUint(f, 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid supported rate %q: %w", f, err)
+		}
+		rates = append(rates, int(b&0x7f)*500)
+	}
+	return rates, nil
+}
@@ -1,6 +1,7 @@
 package hostapd
 
 import (
+	"reflect"
 	"testing"
 	"time"
 )
@@ -14,17 +15,168 @@ func TestStationParse(t *testing.T) {
 	expected := Station{
 		MAC:        "fa:ce:aa:bb:12:34",
 		Associated: true,
+		Flags:      FlagAuth | FlagAssoc | FlagAuthorized | FlagWMM | FlagHT | FlagVHT,
+		AID:        6,
 		RxBytes:    13716568000,
 		TxBytes:    90628298,
+		RxPackets:  91280,
+		TxPackets:  110847,
+		RxRate:     RateInfo{Kbps: 6000, Raw: "60"},
+		TxRate:     RateInfo{Kbps: 324000, MCS: 8, NSS: 2, Raw: "3240 vhtmcs 8 vhtnss 2"},
 		Inactive:   590 * time.Millisecond,
 		Signal:     -64,
+
+		ListenInterval: 20,
+		SupportedRates: []int{6000, 9000, 12000, 18000, 24000, 36000, 48000, 54000},
+		Capability:     0x111,
+		HTCaps:         0x006f,
+		VHTCaps:        0x0f817032,
+
+		Extra: map[string]string{
+			"timeout_next":                         "NULLFUNC POLL",
+			"dot11RSNAStatsSTAAddress":             "fa:ce:aa:bb:12:34",
+			"dot11RSNAStatsVersion":                "1",
+			"dot11RSNAStatsSelectedPairwiseCipher": "00-12-23-4",
+			"dot11RSNAStatsTKIPLocalMICFailures":   "0",
+			"dot11RSNAStatsTKIPRemoteMICFailures":  "0",
+			"wpa":                                  "2",
+			"AKMSuiteSelector":                     "00-23-45-4",
+			"hostapdWPAPTKState":                   "11",
+			"hostapdWPAPTKGroupState":              "0",
+			"rx_vht_mcs_map":                       "fffa",
+			"tx_vht_mcs_map":                       "fffa",
+			"ht_mcs_bitmask":                       "ffff0000000000000000",
+			"last_ack_signal":                      "-95",
+			"min_txpower":                          "-7",
+			"max_txpower":                          "21",
+			"ext_capab":                            "0000000000000040",
+		},
 	}
-	if got != expected {
+	if !reflect.DeepEqual(got, expected) {
 		t.Fatalf("got:\n%#v\nexpected:\n%#v", got, expected)
 	}
 	t.Logf("got:\n%#v", got)
 }
 
+func TestStationParse_extended(t *testing.T) {
+	const msg = `02:00:00:00:01:00
+flags=[AUTH][ASSOC][AUTHORIZED][WMM][MFP][HE][EHT][UNKNOWN_FUTURE_FLAG]
+aid=1
+vlan_id=10
+keyid=guests
+avg_signal=-51
+signal=-49
+connected_time=4
+rx_rate_info=11529 hemcs 11 henss 2 shortGI
+new_field=some value`
+
+	var got Station
+	if err := got.parse([]byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := FlagAuth | FlagAssoc | FlagAuthorized | FlagWMM | FlagMFP | FlagHE | FlagEHT; got.Flags != want {
+		t.Errorf("got Flags %s; want %s", got.Flags, want)
+	}
+	if !got.Associated {
+		t.Error("got Associated false; want true")
+	}
+	if got.Flags.Has(FlagVHT) {
+		t.Error("got Flags with VHT; want without")
+	}
+	if got.VLANID != 10 {
+		t.Errorf("got VLANID %d; want 10", got.VLANID)
+	}
+	if got.KeyID != "guests" {
+		t.Errorf("got KeyID %q; want %q", got.KeyID, "guests")
+	}
+	if got.SignalAvg != -51 {
+		t.Errorf("got SignalAvg %d; want -51", got.SignalAvg)
+	}
+	if got.Connected != 4*time.Second {
+		t.Errorf("got Connected %s; want 4s", got.Connected)
+	}
+	if want := (RateInfo{Kbps: 1152900, MCS: 11, NSS: 2, ShortGI: true, Raw: "11529 hemcs 11 henss 2 shortGI"}); got.RxRate != want {
+		t.Errorf("got RxRate %+v; want %+v", got.RxRate, want)
+	}
+	if v := got.Extra["new_field"]; v != "some value" {
+		t.Errorf("got Extra[new_field] %q; want %q", v, "some value")
+	}
+	t.Logf("got:\n%#v", got)
+}
+
+func TestStationParse_multiLink(t *testing.T) {
+	const msg = `02:00:00:00:01:00
+flags=[AUTH][ASSOC][AUTHORIZED][WMM][MFP][HE][EHT]
+aid=1
+mld_addr=06:00:00:00:01:00
+peer_addr[1]=02:00:00:00:01:01
+peer_addr[2]=02:00:00:00:01:02
+connected_time=4`
+
+	var got Station
+	if err := got.parse([]byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.MLDAddr != "06:00:00:00:01:00" {
+		t.Errorf("got MLDAddr %q; want %q", got.MLDAddr, "06:00:00:00:01:00")
+	}
+	if got.ID() != got.MLDAddr {
+		t.Errorf("got ID() %q; want MLDAddr %q", got.ID(), got.MLDAddr)
+	}
+	wantLinks := []StationLink{
+		{ID: 1, Addr: "02:00:00:00:01:01"},
+		{ID: 2, Addr: "02:00:00:00:01:02"},
+	}
+	if !reflect.DeepEqual(got.Links, wantLinks) {
+		t.Errorf("got Links %+v; want %+v", got.Links, wantLinks)
+	}
+	if len(got.Extra) != 0 {
+		t.Errorf("got Extra %v; want none", got.Extra)
+	}
+
+	var invalid Station
+	if err := invalid.parse([]byte("02:00:00:00:01:00\npeer_addr[1]=invalid")); err != nil {
+		t.Fatal(err)
+	}
+	if len(invalid.Links) != 0 {
+		t.Errorf("got Links %+v for invalid link address; want none", invalid.Links)
+	}
+}
+
+func TestStationParse_invalidFields(t *testing.T) {
+	const msg = `fa:ce:aa:bb:12:34
+flags=[AUTH][ASSOC]
+aid=six
+signal=-
+rx_bytes=12x
+rx_rate_info=fast
+supported_rates=8c zz
+capability=0x1ffff
+mld_addr=invalid
+not a field
+inactive_msec=1500`
+
+	var got Station
+	if err := got.parse([]byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	want := Station{
+		MAC:        "fa:ce:aa:bb:12:34",
+		Associated: true,
+		Flags:      FlagAuth | FlagAssoc,
+		Inactive:   1500 * time.Millisecond,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+
+	if err := new(Station).parse([]byte("invalid\nsignal=-50")); err == nil {
+		t.Error("got nil error parsing invalid MAC address")
+	}
+}
+
 const stationMsg = `fa:ce:aa:bb:12:34
 flags=[AUTH][ASSOC][AUTHORIZED][WMM][HT][VHT]
 aid=6
@@ -3,24 +3,71 @@ package hostapd
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"strconv"
 	"strings"
 )
 
 // Status holds information about the WPA. This is a subset
 // of all the fields returned from the control interface.
-// More info:
+// The status of wpa_supplicant, e.g. of a mesh interface,
+// is also parsed. More info:
 // https://w1.fi/wpa_supplicant/devel/ctrl_iface_page.html#ctrl_iface_STATUS
 type Status struct {
-	State      string
-	Channel    int
-	MaxTxPower int
-	SSID       string
-	BSSID      string
+	State       string
+	Mode        string // wpa_supplicant's mode, e.g. "mesh". Blank for hostapd.
+	Channel     int
+	Freq        int    // Operating frequency in MHz.
+	HWMode      string // E.g. "g" or "a". Not reported by all versions of hostapd.
+	IEEE80211N  bool
+	IEEE80211AC bool
+	IEEE80211AX bool
+	MaxTxPower  int
+	SSID        string // SSID of the first BSS.
+	BSSID       string // BSSID of the first BSS.
+	BSS         []BSS  // All BSSs operating on the radio.
+}
+
+// maxBSS is an upper bound on the number of BSSs parsed from a
+// status response. hostapd's own limit is far lower.
+const maxBSS = 256
+
+// BSS is a basic service set, i.e. a single SSID operated by a radio.
+// A radio may operate multiple BSSs, for example a main and a guest network.
+type BSS struct {
+	Interface   string // Network interface name, e.g. wlan0-1.
+	SSID        string
+	BSSID       string
+	NumStations int
+}
+
+// BSSByInterface returns the BSS with the given network interface name.
+// If no BSS matches, then the first BSS (if any) is returned along with false.
+func (s Status) BSSByInterface(ifname string) (BSS, bool) {
+	for _, bss := range s.BSS {
+		if bss.Interface == ifname {
+			return bss, true
+		}
+	}
+	if len(s.BSS) > 0 {
+		return s.BSS[0], false
+	}
+	return BSS{SSID: s.SSID, BSSID: s.BSSID}, false
+}
+
+// PseudoBSSID returns a locally administered BSSID derived from the name, for
+// sources whose stations are not attributed to an actual BSS. The same name
+// always gives the same BSSID, and different names give different BSSIDs.
+func PseudoBSSID(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	b := sum[:6]
+	b[0] = (b[0] | 0x02) &^ 0x01 // Locally administered, unicast.
+	return net.HardwareAddr(b).String()
 }
 
 // parse parses the hostapd control interface
@@ -31,6 +78,8 @@ func (s *Status) parse(p []byte) error {
 		line     string
 		parts    []string
 		key, val string
+		idx      int
+		ok       bool
 	)
 
 	scanner := bufio.NewScanner(bytes.NewReader(p))
@@ -43,30 +92,101 @@ func (s *Status) parse(p []byte) error {
 		}
 		key, val = parts[0], parts[1]
 
+		// Per-BSS values are indexed, e.g. "ssid[1]".
+		if key, idx, ok = indexedKey(key); ok {
+			if idx >= maxBSS {
+				return fmt.Errorf("invalid status BSS index in line %q", line)
+			}
+			for len(s.BSS) <= idx {
+				s.BSS = append(s.BSS, BSS{})
+			}
+			bss := &s.BSS[idx]
+
+			switch key {
+			case "bss":
+				bss.Interface = val
+			case "ssid":
+				if bss.SSID, err = decodeSSID([]byte(val)); err != nil {
+					return err
+				}
+			case "bssid":
+				bss.BSSID = val
+			case "num_sta":
+				if bss.NumStations, err = strconv.Atoi(val); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
 		switch key {
-		case "state":
+		case "state", "wpa_state":
 			s.State = val
 
+		case "mode":
+			s.Mode = val
+
+		case "ssid":
+			// wpa_supplicant's status has a single, unindexed SSID.
+			if s.SSID, err = decodeSSID([]byte(val)); err != nil {
+				return err
+			}
+
+		case "bssid":
+			s.BSSID = val
+
 		case "channel":
 			if s.Channel, err = strconv.Atoi(val); err != nil {
 				return err
 			}
 
-		case "max_txpower":
-			if s.MaxTxPower, err = strconv.Atoi(val); err != nil {
+		case "freq":
+			if s.Freq, err = strconv.Atoi(val); err != nil {
 				return err
 			}
 
-		case "ssid[0]":
-			if s.SSID, err = decodeSSID([]byte(val)); err != nil {
+		case "hw_mode":
+			s.HWMode = val
+
+		case "ieee80211n":
+			s.IEEE80211N = val == "1"
+
+		case "ieee80211ac":
+			s.IEEE80211AC = val == "1"
+
+		case "ieee80211ax":
+			s.IEEE80211AX = val == "1"
+
+		case "max_txpower":
+			if s.MaxTxPower, err = strconv.Atoi(val); err != nil {
 				return err
 			}
-		case "bssid[0]":
-			s.BSSID = val
 		}
 	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	if len(s.BSS) > 0 {
+		s.SSID = s.BSS[0].SSID
+		s.BSSID = s.BSS[0].BSSID
+	}
+
+	return nil
+}
 
-	return scanner.Err()
+// indexedKey splits a key of the form "name[index]" into its name and index.
+// If the key is not of this form, the returned bool is false.
+func indexedKey(key string) (string, int, bool) {
+	open := strings.IndexByte(key, '[')
+	if open < 1 || key[len(key)-1] != ']' {
+		return key, 0, false
+	}
+	idx, err := strconv.Atoi(key[open+1 : len(key)-1])
+	if err != nil || idx < 0 {
+		return key, 0, false
+	}
+	return key[:open], idx, true
 }
 
 // decodeSSID converts the hostap encoding of the SSID into a string,
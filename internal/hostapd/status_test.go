@@ -1,6 +1,9 @@
 package hostapd
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestStatusParse(t *testing.T) {
 	var got Status
@@ -9,18 +12,125 @@ func TestStatusParse(t *testing.T) {
 	}
 
 	expected := Status{
-		State:      "ENABLED",
-		Channel:    52,
-		MaxTxPower: 23,
-		SSID:       "🌝",
-		BSSID:      "aa:bb:cc:ee:12:34",
+		State:       "ENABLED",
+		Channel:     52,
+		Freq:        5260,
+		IEEE80211N:  true,
+		IEEE80211AC: true,
+		MaxTxPower:  23,
+		SSID:        "🌝",
+		BSSID:       "aa:bb:cc:ee:12:34",
+		BSS: []BSS{
+			{Interface: "wlan0", SSID: "🌝", BSSID: "aa:bb:cc:ee:12:34", NumStations: 5},
+		},
 	}
-	if got != expected {
+	if !reflect.DeepEqual(got, expected) {
 		t.Fatalf("got:\n%#v\nexpected:\n%#v", got, expected)
 	}
 	t.Logf("got:\n%#v", got)
 }
 
+func TestStatusParse_multiBSS(t *testing.T) {
+	var got Status
+	if err := got.parse([]byte(statusMultiBSSMsg)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Status{
+		State:       "ENABLED",
+		Channel:     1,
+		Freq:        2412,
+		HWMode:      "g",
+		IEEE80211N:  true,
+		IEEE80211AX: true,
+		MaxTxPower:  20,
+		SSID:        "main",
+		BSSID:       "aa:bb:cc:00:00:01",
+		BSS: []BSS{
+			{Interface: "wlan1", SSID: "main", BSSID: "aa:bb:cc:00:00:01", NumStations: 3},
+			{Interface: "wlan1-1", SSID: "guest", BSSID: "aa:bb:cc:00:00:02", NumStations: 1},
+			{Interface: "wlan1-2", SSID: "iot", BSSID: "aa:bb:cc:00:00:03", NumStations: 0},
+		},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got:\n%#v\nexpected:\n%#v", got, expected)
+	}
+
+	cases := []struct {
+		ifname string
+		want   string
+		found  bool
+	}{
+		{"wlan1", "main", true},
+		{"wlan1-1", "guest", true},
+		{"wlan1-2", "iot", true},
+		{"wlan9", "main", false},
+	}
+	for _, tc := range cases {
+		bss, found := got.BSSByInterface(tc.ifname)
+		if bss.SSID != tc.want || found != tc.found {
+			t.Errorf("BSSByInterface(%q) = %q, %v; want %q, %v", tc.ifname, bss.SSID, found, tc.want, tc.found)
+		}
+	}
+}
+
+func TestStatusParse_mesh(t *testing.T) {
+	var got Status
+	if err := got.parse([]byte(statusMeshMsg)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Status{
+		State: "COMPLETED",
+		Mode:  "mesh",
+		Freq:  5180,
+		SSID:  "backhaul",
+		BSSID: "aa:bb:cc:00:00:10",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got:\n%#v\nexpected:\n%#v", got, expected)
+	}
+
+	bss, found := got.BSSByInterface("mesh0")
+	if found || bss.SSID != "backhaul" || bss.BSSID != "aa:bb:cc:00:00:10" {
+		t.Errorf("BSSByInterface(%q) = %+v, %v; want SSID & BSSID of status", "mesh0", bss, found)
+	}
+}
+
+// Status of wpa_supplicant operating a mesh interface.
+const statusMeshMsg = `bssid=aa:bb:cc:00:00:10
+freq=5180
+ssid=backhaul
+id=0
+mode=mesh
+pairwise_cipher=CCMP
+group_cipher=CCMP
+key_mgmt=SAE
+wpa_state=COMPLETED
+address=aa:bb:cc:00:00:10`
+
+const statusMultiBSSMsg = `state=ENABLED
+phy=phy1
+freq=2412
+hw_mode=g
+channel=1
+ieee80211n=1
+ieee80211ac=0
+ieee80211ax=1
+max_txpower=20
+bss[0]=wlan1
+bssid[0]=aa:bb:cc:00:00:01
+ssid[0]=main
+num_sta[0]=3
+bss[1]=wlan1-1
+bssid[1]=aa:bb:cc:00:00:02
+ssid[1]=guest
+num_sta[1]=1
+bss[2]=wlan1-2
+bssid[2]=aa:bb:cc:00:00:03
+ssid[2]=iot
+num_sta[2]=0`
+
 const statusMsg = `state=ENABLED
 phy=phy0
 freq=5260
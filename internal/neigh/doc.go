@@ -0,0 +1,4 @@
+// Package neigh uses the Linux kernel's neighbour tables (ARP for IPv4, NDP for
+// IPv6) as a source of stations, so that the presence of wired devices can be
+// tracked in the same way as WiFi stations.
+package neigh
@@ -0,0 +1,163 @@
+package neigh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultARPPath is the location of the kernel's IPv4 neighbour table.
+const DefaultARPPath = "/proc/net/arp"
+
+// State is the state of a neighbour entry, as used by the kernel's
+// neighbour unreachability detection (NUD).
+type State uint16
+
+// Neighbour states, from the kernel's neighbour.h.
+const (
+	StateIncomplete State = 0x01
+	StateReachable  State = 0x02
+	StateStale      State = 0x04
+	StateDelay      State = 0x08
+	StateProbe      State = 0x10
+	StateFailed     State = 0x20
+	StateNoARP      State = 0x40
+	StatePermanent  State = 0x80
+)
+
+var stateNames = map[State]string{
+	StateIncomplete: "INCOMPLETE",
+	StateReachable:  "REACHABLE",
+	StateStale:      "STALE",
+	StateDelay:      "DELAY",
+	StateProbe:      "PROBE",
+	StateFailed:     "FAILED",
+	StateNoARP:      "NOARP",
+	StatePermanent:  "PERMANENT",
+}
+
+func (s State) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("State(%#x)", uint16(s))
+}
+
+// Present returns true if the state indicates that the neighbour is present.
+// A REACHABLE neighbour has recently been confirmed to be reachable. A STALE
+// neighbour has not, but was reachable and has not yet failed to respond,
+// and neither have neighbours in the DELAY or PROBE states, which are being
+// confirmed. Static (PERMANENT or NOARP) entries say nothing about presence.
+func (s State) Present() bool {
+	switch s {
+	case StateReachable, StateStale, StateDelay, StateProbe:
+		return true
+	default:
+		return false
+	}
+}
+
+// Neighbour is an entry of a neighbour table.
+type Neighbour struct {
+	IP     net.IP
+	MAC    string // Lower-case. Empty if unresolved.
+	Device string // Network interface, e.g. "br-lan".
+	State  State
+}
+
+// Reader returns the entries of the neighbour tables.
+type Reader func() ([]Neighbour, error)
+
+// ReadSystem reads the kernel's IPv4 neighbour table from DefaultARPPath, and
+// its IPv6 neighbour table using netlink. The IPv6 table is only read on Linux.
+func ReadSystem() ([]Neighbour, error) {
+	f, err := os.Open(DefaultARPPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	neighbours, err := ParseARP(f)
+	if err != nil {
+		return nil, err
+	}
+
+	ipv6, err := readIPv6()
+	if err != nil && !errors.Is(err, errUnsupported) {
+		return nil, fmt.Errorf("unable to read IPv6 neighbours: %w", err)
+	}
+	return append(neighbours, ipv6...), nil
+}
+
+// errUnsupported is returned by readIPv6 on platforms other than Linux.
+var errUnsupported = errors.New("not supported on this platform")
+
+// ARP flags, from the kernel's if_arp.h.
+const (
+	atfComplete  = 0x02
+	atfPermanent = 0x04
+)
+
+// ParseARP parses the IPv4 neighbour table in the format of /proc/net/arp:
+//
+//	IP address       HW type     Flags       HW address            Mask     Device
+//	192.168.1.10     0x1         0x2         04:ab:00:12:34:56     *        br-lan
+//
+// The table does not distinguish between the REACHABLE and STALE states, so
+// completed entries are reported as REACHABLE. Incomplete and failed entries
+// are reported as INCOMPLETE, without a MAC.
+func ParseARP(r io.Reader) ([]Neighbour, error) {
+	var neighbours []Neighbour
+
+	scanner := bufio.NewScanner(r)
+	for i := 0; scanner.Scan(); i++ {
+		if i == 0 {
+			// Header.
+			continue
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("invalid ARP entry %q", scanner.Text())
+		}
+
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ARP entry IP %q", fields[0])
+		}
+		flags, err := strconv.ParseUint(fields[2], 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ARP entry flags %q: %w", fields[2], err)
+		}
+
+		n := Neighbour{
+			IP:     ip,
+			Device: fields[5],
+			State:  StateIncomplete,
+		}
+		switch {
+		case flags&atfPermanent != 0:
+			n.State = StatePermanent
+		case flags&atfComplete != 0:
+			n.State = StateReachable
+		}
+		if n.State != StateIncomplete {
+			hw, err := net.ParseMAC(fields[3])
+			if err != nil {
+				return nil, fmt.Errorf("invalid ARP entry MAC %q: %w", fields[3], err)
+			}
+			n.MAC = hw.String()
+		}
+
+		neighbours = append(neighbours, n)
+	}
+
+	return neighbours, scanner.Err()
+}
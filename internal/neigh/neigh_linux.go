@@ -0,0 +1,95 @@
+package neigh
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Netlink neighbour attributes, from the kernel's neighbour.h.
+const (
+	ndaDst    = 1
+	ndaLLAddr = 2
+)
+
+// sizeofNdMsg is the size of the kernel's struct ndmsg.
+const sizeofNdMsg = 12
+
+// readIPv6 dumps the kernel's IPv6 neighbour table using netlink.
+func readIPv6() ([]Neighbour, error) {
+	rib, err := syscall.NetlinkRIB(syscall.RTM_GETNEIGH, syscall.AF_INET6)
+	if err != nil {
+		return nil, os.NewSyscallError("netlinkrib", err)
+	}
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, os.NewSyscallError("parsenetlinkmessage", err)
+	}
+
+	devices := make(map[int32]string)
+	return parseNeighMsgs(msgs, func(index int32) string {
+		name, ok := devices[index]
+		if !ok {
+			if ifi, err := net.InterfaceByIndex(int(index)); err == nil {
+				name = ifi.Name
+			}
+			devices[index] = name
+		}
+		return name
+	})
+}
+
+// parseNeighMsgs parses the RTM_NEWNEIGH messages, using device to find
+// the name of each interface.
+func parseNeighMsgs(msgs []syscall.NetlinkMessage, device func(index int32) string) ([]Neighbour, error) {
+	var neighbours []Neighbour
+	for _, m := range msgs {
+		if m.Header.Type != syscall.RTM_NEWNEIGH {
+			continue
+		}
+		if len(m.Data) < sizeofNdMsg {
+			return nil, fmt.Errorf("neighbour message too short: %d bytes", len(m.Data))
+		}
+
+		// struct ndmsg {
+		//   u8 family; u8 pad1; u16 pad2;
+		//   s32 ifindex; u16 state; u8 flags; u8 type;
+		// }
+		n := Neighbour{
+			Device: device(*(*int32)(unsafe.Pointer(&m.Data[4]))),
+			State:  State(*(*uint16)(unsafe.Pointer(&m.Data[8]))),
+		}
+
+		for b := m.Data[sizeofNdMsg:]; len(b) >= syscall.SizeofRtAttr; {
+			attrLen := int(*(*uint16)(unsafe.Pointer(&b[0])))
+			attrType := *(*uint16)(unsafe.Pointer(&b[2]))
+			if attrLen < syscall.SizeofRtAttr || attrLen > len(b) {
+				return nil, fmt.Errorf("invalid neighbour attribute length %d", attrLen)
+			}
+			data := b[syscall.SizeofRtAttr:attrLen]
+			switch attrType {
+			case ndaDst:
+				n.IP = append(net.IP(nil), data...)
+			case ndaLLAddr:
+				if len(data) == 6 {
+					n.MAC = net.HardwareAddr(data).String()
+				}
+			}
+			if attrLen = rtaAlign(attrLen); attrLen > len(b) {
+				attrLen = len(b)
+			}
+			b = b[attrLen:]
+		}
+
+		neighbours = append(neighbours, n)
+	}
+	return neighbours, nil
+}
+
+// rtaAlign rounds the attribute length up to the alignment
+// of netlink route attributes.
+func rtaAlign(n int) int {
+	return (n + syscall.RTA_ALIGNTO - 1) &^ (syscall.RTA_ALIGNTO - 1)
+}
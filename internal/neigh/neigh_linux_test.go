@@ -0,0 +1,69 @@
+package neigh
+
+import (
+	"net"
+	"reflect"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// neighMsg encodes an RTM_NEWNEIGH message in the native byte order.
+func neighMsg(ifindex int32, state State, attrs map[uint16][]byte) syscall.NetlinkMessage {
+	data := make([]byte, sizeofNdMsg)
+	data[0] = syscall.AF_INET6
+	*(*int32)(unsafe.Pointer(&data[4])) = ifindex
+	*(*uint16)(unsafe.Pointer(&data[8])) = uint16(state)
+
+	for _, typ := range []uint16{ndaDst, ndaLLAddr} {
+		v, ok := attrs[typ]
+		if !ok {
+			continue
+		}
+		attr := make([]byte, rtaAlign(syscall.SizeofRtAttr+len(v)))
+		*(*uint16)(unsafe.Pointer(&attr[0])) = uint16(syscall.SizeofRtAttr + len(v))
+		*(*uint16)(unsafe.Pointer(&attr[2])) = typ
+		copy(attr[syscall.SizeofRtAttr:], v)
+		data = append(data, attr...)
+	}
+
+	return syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{Type: syscall.RTM_NEWNEIGH},
+		Data:   data,
+	}
+}
+
+func TestParseNeighMsgs(t *testing.T) {
+	mac, _ := net.ParseMAC("04:ab:00:12:34:56")
+	msgs := []syscall.NetlinkMessage{
+		neighMsg(2, StateReachable, map[uint16][]byte{
+			ndaDst:    net.ParseIP("fd00::10"),
+			ndaLLAddr: mac,
+		}),
+		// Unresolved.
+		neighMsg(3, StateFailed, map[uint16][]byte{
+			ndaDst: net.ParseIP("fe80::1"),
+		}),
+		{Header: syscall.NlMsghdr{Type: syscall.NLMSG_DONE}},
+	}
+
+	got, err := parseNeighMsgs(msgs, func(index int32) string {
+		return map[int32]string{2: "br-lan", 3: "eth1"}[index]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Neighbour{
+		{IP: net.ParseIP("fd00::10"), MAC: "04:ab:00:12:34:56", Device: "br-lan", State: StateReachable},
+		{IP: net.ParseIP("fe80::1"), Device: "eth1", State: StateFailed},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got parseNeighMsgs() %+v; want %+v", got, want)
+	}
+}
+
+func TestReadSystem(t *testing.T) {
+	if _, err := ReadSystem(); err != nil {
+		t.Skipf("unable to read neighbour tables: %v", err)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package neigh
+
+// readIPv6 is not supported on this platform.
+func readIPv6() ([]Neighbour, error) {
+	return nil, errUnsupported
+}
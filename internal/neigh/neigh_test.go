@@ -0,0 +1,50 @@
+package neigh
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseARP(t *testing.T) {
+	const arp = `IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.10     0x1         0x2         04:AB:00:12:34:56     *        br-lan
+192.168.1.11     0x1         0x0         00:00:00:00:00:00     *        br-lan
+192.168.1.1      0x1         0x6         04:ab:00:12:34:57     *        eth1
+`
+	got, err := ParseARP(strings.NewReader(arp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Neighbour{
+		{IP: net.ParseIP("192.168.1.10"), MAC: "04:ab:00:12:34:56", Device: "br-lan", State: StateReachable},
+		{IP: net.ParseIP("192.168.1.11"), Device: "br-lan", State: StateIncomplete},
+		{IP: net.ParseIP("192.168.1.1"), MAC: "04:ab:00:12:34:57", Device: "eth1", State: StatePermanent},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got ParseARP() %+v; want %+v", got, want)
+	}
+
+	if _, err = ParseARP(strings.NewReader("header\n192.168.1.10 0x1 0x2\n")); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestState_Present(t *testing.T) {
+	present := map[State]bool{
+		StateIncomplete: false,
+		StateReachable:  true,
+		StateStale:      true,
+		StateDelay:      true,
+		StateProbe:      true,
+		StateFailed:     false,
+		StateNoARP:      false,
+		StatePermanent:  false,
+	}
+	for state, want := range present {
+		if got := state.Present(); got != want {
+			t.Errorf("got %v.Present() %v; want %v", state, got, want)
+		}
+	}
+}
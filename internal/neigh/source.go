@@ -0,0 +1,157 @@
+package neigh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/awilliams/wifi-presence/internal/hostapd"
+)
+
+// DefaultInterval is the default interval at which
+// the neighbour tables are read.
+const DefaultInterval = 10 * time.Second
+
+// NewSource returns a Source of the neighbours of the network interface dev,
+// e.g. "br-lan", reading the neighbour tables every interval using read,
+// typically ReadSystem.
+func NewSource(dev string, read Reader, interval time.Duration) *Source {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Source{
+		dev:      dev,
+		read:     read,
+		interval: interval,
+	}
+}
+
+// Source reports the devices in the neighbour tables of a network interface
+// as stations. A device is connected while any of its entries is in a state
+// indicating that it is present (see State.Present), and disconnects once all
+// of its entries have failed or been removed.
+//
+// Note that the kernel only confirms that a neighbour is reachable when there is
+// traffic to it, so a device may remain STALE for some time after it leaves.
+//
+// Source implements presence.Source.
+type Source struct {
+	dev      string
+	read     Reader
+	interval time.Duration
+}
+
+// Interface returns the name of the network interface.
+func (s *Source) Interface() string {
+	return s.dev
+}
+
+// Status returns a status with a single BSS for the network interface. Since
+// wired networks have neither, the interface's name is used as the SSID, and
+// its MAC as the BSSID. If its MAC is unknown, e.g. because the interface is
+// not yet up, then a pseudo BSSID derived from its name is used.
+func (s *Source) Status(ctx context.Context) (hostapd.Status, error) {
+	bssid := hostapd.PseudoBSSID("neigh/" + s.dev)
+	if ifi, err := net.InterfaceByName(s.dev); err == nil && len(ifi.HardwareAddr) > 0 {
+		bssid = ifi.HardwareAddr.String()
+	}
+	return hostapd.Status{
+		State: "ENABLED",
+		SSID:  s.dev,
+		BSSID: bssid,
+		BSS: []hostapd.BSS{
+			{Interface: s.dev, SSID: s.dev, BSSID: bssid},
+		},
+	}, nil
+}
+
+// Stations returns the devices that are present.
+func (s *Source) Stations(ctx context.Context) ([]hostapd.Station, error) {
+	present, err := s.present()
+	if err != nil {
+		return nil, err
+	}
+	stations := make([]hostapd.Station, 0, len(present))
+	for _, mac := range sortedMACs(present) {
+		stations = append(stations, hostapd.Station{
+			MAC:        mac,
+			Associated: true,
+		})
+	}
+	return stations, nil
+}
+
+// Attach reads the neighbour tables every interval, calling events with a
+// station connect event for each device that becomes present, and a disconnect
+// event for each device that is no longer present. Devices that are present
+// when first read are reported as connecting. It blocks until the context is
+// done, or an error occurs.
+func (s *Source) Attach(ctx context.Context, events func(hostapd.Event) error) error {
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+
+	var prev map[string]bool
+	for {
+		present, err := s.present()
+		if err != nil {
+			return err
+		}
+
+		for _, mac := range sortedMACs(present) {
+			if !prev[mac] {
+				if err = s.event(events, "AP-STA-CONNECTED", mac); err != nil {
+					return err
+				}
+			}
+		}
+		for _, mac := range sortedMACs(prev) {
+			if !present[mac] {
+				if err = s.event(events, "AP-STA-DISCONNECTED", mac); err != nil {
+					return err
+				}
+			}
+		}
+		prev = present
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+// event calls events with the control interface event of the given name.
+func (s *Source) event(events func(hostapd.Event) error, name, mac string) error {
+	e, err := hostapd.ParseEvent(fmt.Sprintf("%s %s", name, mac))
+	if err != nil {
+		return err
+	}
+	return events(e)
+}
+
+// present returns the MACs of the interface's neighbours that are present.
+func (s *Source) present() (map[string]bool, error) {
+	neighbours, err := s.read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: unable to read neighbours: %w", s.dev, err)
+	}
+	present := make(map[string]bool)
+	for _, n := range neighbours {
+		if n.Device == s.dev && n.MAC != "" && n.State.Present() {
+			present[n.MAC] = true
+		}
+	}
+	return present, nil
+}
+
+func sortedMACs(macs map[string]bool) []string {
+	sorted := make([]string, 0, len(macs))
+	for mac := range macs {
+		sorted = append(sorted, mac)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,138 @@
+package neigh
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/awilliams/wifi-presence/internal/hostapd"
+)
+
+// fakeTable is a neighbour table whose entries are set by the test.
+type fakeTable struct {
+	mu         sync.Mutex
+	neighbours []Neighbour
+}
+
+func (f *fakeTable) set(neighbours ...Neighbour) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.neighbours = neighbours
+}
+
+func (f *fakeTable) read() ([]Neighbour, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.neighbours, nil
+}
+
+func TestSource_Stations(t *testing.T) {
+	var table fakeTable
+	table.set(
+		Neighbour{IP: net.ParseIP("192.168.1.11"), MAC: "04:ab:00:12:34:57", Device: "br-lan", State: StateStale},
+		Neighbour{IP: net.ParseIP("192.168.1.10"), MAC: "04:ab:00:12:34:56", Device: "br-lan", State: StateFailed},
+		Neighbour{IP: net.ParseIP("fd00::10"), MAC: "04:ab:00:12:34:56", Device: "br-lan", State: StateReachable},
+		Neighbour{IP: net.ParseIP("192.168.1.12"), MAC: "04:ab:00:12:34:58", Device: "br-lan", State: StateFailed},
+		Neighbour{IP: net.ParseIP("192.168.1.13"), MAC: "04:ab:00:12:34:59", Device: "eth1", State: StateReachable},
+	)
+
+	got, err := NewSource("br-lan", table.read, time.Second).Stations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []hostapd.Station{
+		{MAC: "04:ab:00:12:34:56", Associated: true},
+		{MAC: "04:ab:00:12:34:57", Associated: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got Stations() %+v; want %+v", got, want)
+	}
+}
+
+func TestSource_Status_noInterface(t *testing.T) {
+	const dev = "wp-missing0"
+	status, err := NewSource(dev, new(fakeTable).read, time.Second).Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bss, ok := status.BSSByInterface(dev)
+	if !ok {
+		t.Fatalf("no BSS for interface %q", dev)
+	}
+	if _, err = net.ParseMAC(bss.BSSID); err != nil {
+		t.Fatalf("got invalid BSSID %q: %v", bss.BSSID, err)
+	}
+	if status.BSSID != bss.BSSID {
+		t.Fatalf("got status BSSID %q; want %q", status.BSSID, bss.BSSID)
+	}
+}
+
+func TestSource_Attach(t *testing.T) {
+	const testMAC = "04:ab:00:12:34:56"
+
+	var table fakeTable
+	table.set(Neighbour{MAC: testMAC, Device: "br-lan", State: StateReachable})
+	src := NewSource("br-lan", table.read, 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan hostapd.Event, 1)
+	attachErr := make(chan error, 1)
+	go func() {
+		attachErr <- src.Attach(ctx, func(e hostapd.Event) error {
+			events <- e
+			return nil
+		})
+	}()
+
+	expectEvent := func(want hostapd.Event) {
+		t.Helper()
+		select {
+		case got := <-events:
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got event %#v; want %#v", got, want)
+			}
+		case err := <-attachErr:
+			t.Fatalf("Attach() err: %v", err)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for event")
+		}
+	}
+	parse := func(msg string) hostapd.Event {
+		e, err := hostapd.ParseEvent(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	// Present when first read.
+	expectEvent(parse("AP-STA-CONNECTED " + testMAC))
+
+	// STALE entries remain present, until they fail.
+	table.set(Neighbour{MAC: testMAC, Device: "br-lan", State: StateStale})
+	time.Sleep(50 * time.Millisecond)
+	table.set(Neighbour{MAC: testMAC, Device: "br-lan", State: StateFailed})
+	expectEvent(parse("AP-STA-DISCONNECTED " + testMAC))
+
+	table.set(Neighbour{MAC: testMAC, Device: "br-lan", State: StateReachable})
+	expectEvent(parse("AP-STA-CONNECTED " + testMAC))
+
+	// Entries may also be removed.
+	table.set()
+	expectEvent(parse("AP-STA-DISCONNECTED " + testMAC))
+
+	select {
+	case e := <-events:
+		t.Fatalf("got unexpected event %#v", e)
+	default:
+	}
+
+	cancel()
+	if err := <-attachErr; err != nil {
+		t.Fatalf("Attach() err: %v", err)
+	}
+}
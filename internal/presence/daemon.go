@@ -6,10 +6,13 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"path"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/awilliams/wifi-presence/internal/dhcp"
 	"github.com/awilliams/wifi-presence/internal/hass"
 	"github.com/awilliams/wifi-presence/internal/hostapd"
 
@@ -34,11 +37,83 @@ func WithHassOpt(hm *hass.MQTT) Opt {
 	}
 }
 
-// WithHostAPD is required at least once and sets the hostapd.Client the daemon will use.
-// Multple hostapd.Clients may be used.
+// WithHostAPD is required at least once, unless WithHostAPDGlobal, WithHostAPDDir,
+// WithSourceWatcher or WithSource is used, and sets the hostapd.Client the daemon
+// will use. Multple hostapd.Clients may be used.
 func WithHostAPD(ha *hostapd.Client) Opt {
+	return WithSource(ha)
+}
+
+// WithSource adds a Source of stations to the daemon. It is the generalization
+// of WithHostAPD, and may be used multiple times.
+func WithSource(src Source) Opt {
+	return func(d *Daemon) {
+		d.haps = append(d.haps, hap{src: src})
+	}
+}
+
+// WithHostAPDGlobal sets the hostapd global control interface the daemon will use.
+// All of hostapd's interfaces are monitored, including interfaces that are added
+// while the daemon is running. See WithInterfaceRefresh.
+func WithHostAPDGlobal(g *hostapd.Global) Opt {
+	return func(d *Daemon) {
+		d.global = g
+	}
+}
+
+// WithHostAPDDir configures the daemon to use every hostapd control interface
+// socket in dir (see hostapd.ControlSockets), including sockets that are created
+// while the daemon is running. Clients are created using hostapd.NewClient with the
+// given localSockDir and options, and are closed when their socket is removed.
+func WithHostAPDDir(dir, localSockDir string, opts ...hostapd.ClientOpt) Opt {
+	return func(d *Daemon) {
+		d.sockDir = dir
+		d.localSockDir = localSockDir
+		d.clientOpts = opts
+	}
+}
+
+// WithSourceWatcher configures the daemon to use the sources reported by the
+// watcher, adding and removing them as the set of sources changes. It may be
+// used multiple times.
+func WithSourceWatcher(w SourceWatcher) Opt {
+	return func(d *Daemon) {
+		d.watchers = append(d.watchers, w)
+	}
+}
+
+// WithInterfaceRefresh sets the interval at which the list of interfaces of the
+// global control interface is refreshed. The list is also refreshed whenever an
+// interface is enabled or disabled. When using WithHostAPDDir, it is the interval
+// at which the directory is scanned for sockets.
+func WithInterfaceRefresh(interval time.Duration) Opt {
+	return func(d *Daemon) {
+		d.refreshInterval = interval
+	}
+}
+
+// StationsFunc lists the stations connected to the network interface ifname.
+// See WithStationFallback.
+type StationsFunc func(ctx context.Context, ifname string) ([]hostapd.Station, error)
+
+// WithStationFallback sets the function used to list the stations of a source's
+// interface when the source is unable to, i.e. when hostapd.ErrUnknownCmd is
+// returned by hostapd builds lacking the STA-FIRST command. For example, the
+// stations may be read from debugfs.
+func WithStationFallback(f StationsFunc) Opt {
+	return func(d *Daemon) {
+		d.stationFallback = f
+	}
+}
+
+// WithLeases configures the daemon to include the hostname and IP addresses of
+// stations, found in their DHCP leases, in the published attributes. Devices may
+// then also be configured by hostname pattern (see hass.TrackConfig). The lease
+// files are checked for changes every interval.
+func WithLeases(leases *dhcp.Leases, interval time.Duration) Opt {
 	return func(d *Daemon) {
-		d.haps = append(d.haps, hap{client: ha})
+		d.leases = leases
+		d.leaseInterval = interval
 	}
 }
 
@@ -57,6 +132,17 @@ func WithDebounce(db time.Duration) Opt {
 	}
 }
 
+// WithConfirmDeparture configures the daemon to confirm that a station has
+// departed before publishing its disconnected state. When the debounce time
+// has elapsed, hostapd is asked whether the station is still connected, and
+// if not, the station is polled. The station is only considered disconnected
+// if it does not respond within the given timeout. 0 disables confirmation.
+func WithConfirmDeparture(pollTimeout time.Duration) Opt {
+	return func(d *Daemon) {
+		d.confirmTimeout = pollTimeout
+	}
+}
+
 // WithHASSAutodiscovery configures whether daemon will publish MQTT autodiscovery
 // messages for Home Assistant.
 func WithHASSAutodiscovery(ad bool) Opt {
@@ -65,37 +151,143 @@ func WithHASSAutodiscovery(ad bool) Opt {
 	}
 }
 
+// WithReattach configures whether the daemon re-attaches to a hostapd
+// control interface after hostapd indicates that it is terminating
+// (for example, when the wireless configuration is changed), or after it
+// becomes unresponsive. When false, hostapd.ErrTerminating or
+// hostapd.ErrUnresponsive is returned from Run.
+func WithReattach(reattach bool) Opt {
+	return func(d *Daemon) {
+		d.reattach = reattach
+	}
+}
+
+// WithNearby configures the daemon to publish whether tracked stations that
+// are not connected are nearby, using the probe requests they send while
+// scanning for networks. A station is no longer nearby once it connects, or
+// when no probe request has been received from it for the given timeout.
+// Sources must report probe requests, see hostapd.WithProbeRequests.
+// 0 disables nearby stations.
+func WithNearby(timeout time.Duration) Opt {
+	return func(d *Daemon) {
+		d.nearbyTimeout = timeout
+		d.nearby = newDebouncer(timeout)
+	}
+}
+
+// WithReconcileInterval configures the daemon to periodically compare the
+// tracked stations against the stations listed by each source, correcting
+// any connect or disconnect events that were missed, e.g. because the
+// receive buffer of the control interface's socket overflowed. 0 disables
+// periodic reconciliation; stations are still reconciled after re-attaching.
+func WithReconcileInterval(interval time.Duration) Opt {
+	return func(d *Daemon) {
+		d.reconcileInterval = interval
+	}
+}
+
+// WithEventQueue sets the maximum number of station events waiting to be
+// handled. Events are read from sources as they occur, and handled in order
+// for each station, but concurrently for different stations, so that slowly
+// publishing to MQTT does not hold up reading further events. When the queue
+// is full, reading events waits for room.
+func WithEventQueue(size int) Opt {
+	return func(d *Daemon) {
+		d.queue = newEventQueue(size)
+	}
+}
+
 // Daemon runs the main wifi-presence program loop.
 type Daemon struct {
 	apName       string
 	hass         *hass.MQTT
-	haps         []hap
+	haps         []hap // Protected by mu once running.
+	global       *hostapd.Global
+	sockDir      string // Directory watched for control interface sockets.
+	localSockDir string
+	clientOpts   []hostapd.ClientOpt
+	watchers     []SourceWatcher
 	logger       *log.Logger
 	db           *debouncer
 	hassAutoDisc bool
+	reattach     bool
+	// If non-zero, the time to wait for a station to respond
+	// to a poll before publishing its departure.
+	confirmTimeout time.Duration
+	// Interval at which the global control interface's
+	// interfaces, or the socket directory, are refreshed.
+	refreshInterval time.Duration
+	// If non-nil, used to list stations when a source is unable to.
+	stationFallback StationsFunc
+	// If non-nil, the DHCP leases of stations.
+	leases        *dhcp.Leases
+	leaseInterval time.Duration
+	// If non-zero, the time a station remains nearby after its
+	// last probe request. The nearby debouncer expires stations.
+	nearbyTimeout time.Duration
+	nearby        *debouncer
+	// If non-zero, the interval at which stations are reconciled.
+	reconcileInterval time.Duration
+	// Events waiting to be handled.
+	queue *eventQueue
 
 	mu sync.Mutex
+	// The number of stations whose state was corrected by reconciliation.
+	reconciled uint64
 	// An entry here implies that the stations is configured to be tracked.
 	stations map[MAC]station
+	// Configured devices identified by hostname pattern instead of MAC.
+	patterns []hass.TrackConfig
 }
 
 type hap struct {
-	client *hostapd.Client
+	src    Source
 	status hostapd.Status
+	// The BSS corresponding to the source's interface. A radio
+	// may operate multiple BSSs, each with its own control interface.
+	bss hostapd.BSS
+}
+
+// setStatus updates the hap's status along with its BSS.
+func (h *hap) setStatus(status hostapd.Status) {
+	h.status = status
+	h.bss, _ = status.BSSByInterface(h.src.Interface())
 }
 
 type connectedStation struct {
-	hapStatus hostapd.Status
-	sta       hostapd.Station
+	bss   hostapd.BSS
+	sta   hostapd.Station
+	links map[string]bool // BSSIDs of all BSSs the station is connected to.
 }
 
 type station struct {
-	name           string
-	mac            MAC
-	connected      bool
-	bssid          string
+	name      string
+	mac       MAC
+	connected bool
+	// The BSSID the station most recently connected to.
+	bssid string
+	// The BSSIDs the station is connected to. Usually one, but a
+	// multi-link (Wi-Fi 7) station may be connected to a BSS of each
+	// of its links, or a roaming station may be connected to the new
+	// BSS before its disconnect from the previous one is received.
+	// The map is replaced rather than modified.
+	links          map[string]bool
 	connectedAt    time.Time
 	disconnectedAt time.Time
+	// When the links were last changed. Used to ignore stations that
+	// changed since being listed by a source, when reconciling.
+	linksChangedAt time.Time
+	// The hostname pattern the station was matched by,
+	// if not configured by its MAC.
+	pattern string
+	// Whether the station is configured as a mesh node.
+	mesh bool
+	// Whether the station is nearby while not connected, and the
+	// signal of its probe requests as of the last published state.
+	nearby       bool
+	nearbySignal int
+	// The station's leases, as of the last change of leases.
+	host dhcp.Host
 }
 
 // NewDaemon returns a Daemon, configured via the Opt arguments.
@@ -111,18 +303,19 @@ func NewDaemon(opts ...Opt) (*Daemon, error) {
 		return nil, errors.New("WithHassOpt is required")
 	}
 
-	if len(d.haps) == 0 {
-		return nil, errors.New("WithHostAPD is required at least once")
+	if len(d.haps) == 0 && d.global == nil && d.sockDir == "" && len(d.watchers) == 0 {
+		return nil, errors.New("WithHostAPD or WithSource is required at least once")
 	}
 
 	// The hostapd's status is collected once at startup since the values we use,
 	// such as SSID, are not expected to change. If we need to use dynamic values,
 	// such as TxPower, then this will need to be re-worked.
-	var err error
 	for i, hap := range d.haps {
-		if d.haps[i].status, err = hap.client.Status(); err != nil {
+		status, err := hap.src.Status(context.Background())
+		if err != nil {
 			return nil, err
 		}
+		d.haps[i].setStatus(status)
 	}
 
 	if d.logger == nil {
@@ -131,6 +324,21 @@ func NewDaemon(opts ...Opt) (*Daemon, error) {
 	if d.db == nil {
 		d.db = newDebouncer(5 * time.Second)
 	}
+	if d.nearby == nil {
+		d.nearby = newDebouncer(0)
+	}
+	if d.queue == nil {
+		d.queue = newEventQueue(1024)
+	}
+	if cap(d.queue.slots) < 1 {
+		return nil, errors.New("WithEventQueue size must be at least 1")
+	}
+	if d.refreshInterval <= 0 {
+		d.refreshInterval = 30 * time.Second
+	}
+	if d.leaseInterval <= 0 {
+		d.leaseInterval = 5 * time.Second
+	}
 
 	return &d, nil
 }
@@ -157,358 +365,1320 @@ func (d *Daemon) Run(ctx context.Context) error {
 	eg.Go(func() error {
 		d.logger.Printf("Subscribed to config topic: %q", d.hass.ConfigTopic())
 		return d.hass.SubscribeConfig(ctx, func(retained bool, cfg hass.Configuration) error {
-			return d.onConfigChange(ctx, retained, cfg)
+			return d.onConfigChange(ctx, retained, cfg, errs)
 		})
 	})
 
 	// Watch each hostapd for events.
 	for _, hap := range d.haps {
-		d.logger.Printf("Connected to AP\n  SSID: %q\n  BSSID: %q\n  CHANNEL: %02d\n  STATE: %q\n",
-			hap.status.SSID,
-			hap.status.BSSID,
-			hap.status.Channel,
-			hap.status.State,
-		)
+		src := hap.src
+		eg.Go(func() error {
+			return d.watchSource(ctx, src, errs)
+		})
+	}
 
-		hap := hap
+	// Watch the global control interface for interfaces.
+	if d.global != nil {
 		eg.Go(func() error {
-			return hap.client.Attach(ctx, func(event hostapd.Event) error {
-				return d.onHostapdEvent(ctx, hap, event, errs)
-			})
+			return d.watchGlobal(ctx, eg, errs)
 		})
 	}
 
-	return eg.Wait()
-}
+	// Watch the socket directory for control interfaces.
+	if d.sockDir != "" {
+		eg.Go(func() error {
+			return d.watchSockDir(ctx, eg, errs)
+		})
+	}
 
-const (
-	staNoChange staChange = iota
-	staRemoved
-	staAdded
-	staUpdated
-)
+	// Watch for sources being added or removed.
+	for _, w := range d.watchers {
+		w := w
+		eg.Go(func() error {
+			return d.watchSources(ctx, eg, w, errs)
+		})
+	}
 
-type staChange int
+	// Periodically reconcile the tracked stations.
+	if d.reconcileInterval > 0 {
+		eg.Go(func() error {
+			return d.reconcileLoop(ctx, errs)
+		})
+	}
 
-func (s staChange) String() string {
-	switch s {
-	case staNoChange:
-		return "no-change"
-	case staRemoved:
-		return "removed"
-	case staAdded:
-		return "added"
-	case staUpdated:
-		return "updated"
-	default:
-		return "?"
+	// Watch the DHCP lease files.
+	if d.leases != nil {
+		eg.Go(func() error {
+			return d.leases.Watch(ctx, d.leaseInterval, func() error {
+				return d.onLeasesChange(ctx, errs)
+			})
+		})
 	}
+
+	return eg.Wait()
 }
 
-func (d *Daemon) onConfigChange(ctx context.Context, retained bool, cfg hass.Configuration) error {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+// watchSource attaches to the source and processes its events. If re-attaching
+// is enabled and the source is able to reconnect, then when hostapd terminates
+// the connection or becomes unresponsive, the control interface is re-dialed and
+// the state of tracked stations is reconciled before attaching again. It returns
+// when the context is done, or when the source is removed.
+func (d *Daemon) watchSource(ctx context.Context, src Source, errs chan<- error) error {
+	for {
+		hap, ok := d.getHAP(src)
+		if !ok {
+			return nil
+		}
 
-	// Diff the new vs the current configuration.
+		d.logger.Printf("Connected to AP\n  INTERFACE: %q\n  SSID: %q\n  BSSID: %q\n  CHANNEL: %02d\n  FREQ: %d\n  STATE: %q\n",
+			hap.src.Interface(),
+			hap.bss.SSID,
+			hap.bss.BSSID,
+			hap.status.Channel,
+			hap.status.Freq,
+			hap.status.State,
+		)
 
-	changes := make(map[MAC]staChange, len(cfg.Devices)+len(d.stations))
-	var hasUpdates bool
-	for _, devCfg := range cfg.Devices {
-		var mac MAC
-		if err := mac.Decode(devCfg.MAC); err != nil {
+		err := hap.src.Attach(ctx, func(event hostapd.Event) error {
+			return d.onHostapdEvent(ctx, hap, event, errs)
+		})
+		rc, ok := hap.src.(reconnecter)
+		if !d.reattach || !ok || !(errors.Is(err, hostapd.ErrTerminating) || errors.Is(err, hostapd.ErrUnresponsive)) {
 			return err
 		}
 
-		sta, ok := d.stations[mac]
-		switch {
-		case !ok:
-			changes[mac] = staAdded
-			hasUpdates = true
-		case sta.name != devCfg.Name:
-			changes[mac] = staUpdated
-			hasUpdates = true
-		default:
-			changes[mac] = staNoChange
+		if h, ok := hap.src.(healther); ok {
+			health := h.Health()
+			d.logger.Printf("%s: %v (command socket: %s, attach socket: %s); waiting to reconnect",
+				hap.bss.SSID, err, health.Command.State, health.Attach.State)
+		} else {
+			d.logger.Printf("%s: %v; waiting to reconnect", hap.bss.SSID, err)
+		}
+		if err = rc.Reconnect(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
 		}
 
-		sta.name = devCfg.Name
-		sta.mac = mac
-		d.stations[mac] = sta
-	}
-	// Find previously configured stations that are no longer
-	// present in the new configuration.
-	for mac := range d.stations {
-		if _, ok := changes[mac]; !ok {
-			changes[mac] = staRemoved
-			delete(d.stations, mac)
+		status, err := hap.src.Status(ctx)
+		if err != nil {
+			return err
+		}
+		if hap, ok = d.setHAPStatus(src, status); !ok {
+			return nil
 		}
-	}
 
-	var logMsg strings.Builder
-	fmt.Fprintf(&logMsg, "Received config update (retained=%v):\n", retained)
-	defer func() {
-		d.logger.Print(logMsg.String())
-	}()
+		if err = d.reconcile(ctx, hap, errs); err != nil {
+			return err
+		}
+	}
+}
 
-	if len(changes) == 0 {
-		fmt.Fprintln(&logMsg, "(no stations configured)")
-		return nil
+// getHAP returns the hap using the given source.
+func (d *Daemon) getHAP(src Source) (hap, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, h := range d.haps {
+		if h.src == src {
+			return h, true
+		}
 	}
+	return hap{}, false
+}
 
-	var connected map[MAC]connectedStation
-	if hasUpdates {
-		// Avoid calling Stations on each hostap client unless
-		// necessary.
-		var err error
-		if connected, err = d.connectedStations(); err != nil {
-			var unknown hostapd.ErrUnknownCmd
-			if errors.As(err, &unknown) {
-				// At this point, we can still continue. The 'connected' map will be empty, meaning
-				// all stations will be considered disconnected. This is better than failing completely.
-				d.logger.Print("Unable to retrieve list of connected stations. Marking all new stations (if any) as disconnected.\nSee https://github.com/awilliams/wifi-presence/#hostapd-full-version for more information")
-			} else {
-				return err
-			}
+// setHAPStatus updates the status of the hap using the given source,
+// returning the updated hap.
+func (d *Daemon) setHAPStatus(src Source, status hostapd.Status) (hap, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := range d.haps {
+		if d.haps[i].src == src {
+			d.haps[i].setStatus(status)
+			return d.haps[i], true
 		}
 	}
+	return hap{}, false
+}
 
-	// Process each configuration change.
-	for mac, change := range changes {
-		sta := d.stations[mac] // May be zero value.
+// addSource adds a source to the running daemon. Tracked stations that are
+// already connected to it are reconciled, and its events are then processed
+// using eg. The returned function stops processing events and removes the
+// source, treating any tracked stations connected to it as disconnected.
+// The source is not closed.
+func (d *Daemon) addSource(ctx context.Context, eg *errgroup.Group, src Source, errs chan<- error) (func(), error) {
+	status, err := src.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	added := hap{src: src}
+	added.setStatus(status)
 
-		switch change {
-		case staNoChange:
-			// Nothing to do here.
+	d.mu.Lock()
+	d.haps = append(d.haps, added)
+	d.mu.Unlock()
 
-		case staUpdated:
-			if d.hassAutoDisc {
-				err := d.hass.RegisterDeviceTracker(ctx, hass.Discovery{
-					Name: sta.name,
-					MAC:  sta.mac.String(),
-				})
-				if err != nil {
-					return err
-				}
-			}
+	if err = d.reconcile(ctx, added, errs); err != nil {
+		d.removeHAP(src)
+		return nil, err
+	}
 
-		case staAdded:
-			if d.hassAutoDisc {
-				err := d.hass.RegisterDeviceTracker(ctx, hass.Discovery{
-					Name: sta.name,
-					MAC:  sta.mac.String(),
-				})
-				if err != nil {
-					return err
-				}
-			}
+	watchCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	eg.Go(func() error {
+		defer close(done)
+		return d.watchSource(watchCtx, src, errs)
+	})
 
-			// Check whether this station is connected or not.
-			cs, ok := connected[mac]
-			if !ok {
-				sta.connected = false
-				d.stations[mac] = sta
+	return func() {
+		cancel()
+		<-done
 
-				// Station is not connected.
-				pubCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
-				defer cancel()
-				if err := d.hass.StationNotHome(pubCtx, mac.String()); err != nil {
-					return err
-				}
-				break
+		removed, ok := d.removeHAP(src)
+		if !ok {
+			return
+		}
+
+		var departed []MAC
+		d.mu.Lock()
+		for mac, sta := range d.stations {
+			if sta.links[removed.bss.BSSID] {
+				departed = append(departed, mac)
 			}
+		}
+		d.mu.Unlock()
 
-			// Station is connected.
+		for _, mac := range departed {
+			mac := mac
+			d.handle(ctx, mac, errs, func() error {
+				return d.onStationDisconnect(ctx, removed, mac, errs)
+			})
+		}
+	}, nil
+}
 
-			sta.connected = true
-			sta.connectedAt = time.Now().Add(-cs.sta.Connected)
-			sta.bssid = cs.hapStatus.BSSID
-			d.stations[mac] = sta
+// removeHAP removes the hap using the given source, returning it.
+func (d *Daemon) removeHAP(src Source) (hap, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, h := range d.haps {
+		if h.src == src {
+			d.haps = append(d.haps[:i], d.haps[i+1:]...)
+			return h, true
+		}
+	}
+	return hap{}, false
+}
 
-			d.db.cancel(mac)
+// watchGlobal attaches to the global control interface, and adds or removes
+// hostapds as its interfaces come and go. The list of interfaces is refreshed
+// periodically, and whenever an interface is enabled or disabled.
+func (d *Daemon) watchGlobal(ctx context.Context, eg *errgroup.Group, errs chan<- error) error {
+	refresh := make(chan struct{}, 1)
+	requestRefresh := func() {
+		select {
+		case refresh <- struct{}{}:
+		default:
+		}
+	}
 
-			attrs := hass.Attrs{
-				Name:         sta.name,
-				MAC:          sta.mac.String(),
-				IsConnected:  true,
-				APName:       d.apName,
-				SSID:         cs.hapStatus.SSID,
-				BSSID:        cs.hapStatus.BSSID,
-				ConnectedAt:  &sta.connectedAt,
-				ConnectedFor: int(time.Since(sta.connectedAt).Seconds()),
+	eg.Go(func() error {
+		for {
+			err := d.global.Attach(ctx, func(ifname string, event hostapd.Event) error {
+				switch event.(type) {
+				case hostapd.EventAPEnabled, hostapd.EventAPDisabled:
+					d.logger.Printf("%s: %q; refreshing interfaces", ifname, event.Raw())
+					requestRefresh()
+				}
+				return nil
+			})
+			if ctx.Err() != nil {
+				return nil
 			}
-
-			pubCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
-			defer cancel()
-			if err := d.hass.StationHome(pubCtx, mac.String()); err != nil {
+			if !d.reattach || !(errors.Is(err, hostapd.ErrTerminating) || errors.Is(err, hostapd.ErrUnresponsive)) {
 				return err
 			}
 
-			pubCtx, cancel = context.WithTimeout(ctx, 2*time.Second)
-			defer cancel()
-			if err := d.hass.StationAttributes(pubCtx, mac.String(), attrs); err != nil {
+			d.logger.Printf("global control interface: %v; waiting to reconnect", err)
+			if err = d.global.Reconnect(ctx); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
 				return err
 			}
+			requestRefresh()
+		}
+	})
 
-		case staRemoved:
-			d.db.cancel(mac)
-
-			// TODO: send disconnected state here?
-			// HomeAssistant removes the devices from its registry, but other systems may depend
-			// more on this state message.
+	// Removal functions of added interfaces, by name.
+	watched := make(map[string]func())
 
-			if d.hassAutoDisc {
-				if err := d.hass.UnregisterDeviceTracker(ctx, mac.String()); err != nil {
-					return err
-				}
+	t := time.NewTicker(d.refreshInterval)
+	defer t.Stop()
+	for {
+		if err := d.refreshInterfaces(ctx, eg, watched, errs); err != nil {
+			if !d.reattach {
+				return err
 			}
+			// hostapd may be restarting.
+			d.logger.Printf("unable to refresh interfaces: %v", err)
 		}
 
-		fmt.Fprintf(&logMsg, "  %q (%s): %s\n", sta.name, mac, change.String())
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		case <-refresh:
+		}
 	}
-
-	return nil
 }
 
-func (d *Daemon) onHostapdEvent(ctx context.Context, hap hap, event hostapd.Event, errs chan<- error) error {
-	d.logger.Printf("%s: Event %T: %q", hap.status.SSID, event, event.Raw())
-
-	switch e := event.(type) {
+// watchSockDir adds or removes hostapds as their control interface sockets
+// are created or removed in the socket directory.
+func (d *Daemon) watchSockDir(ctx context.Context, eg *errgroup.Group, errs chan<- error) error {
+	// Removal functions of added sockets, by path.
+	watched := make(map[string]func())
+
+	return hostapd.WatchSockets(ctx, d.sockDir, d.refreshInterval, func(sockets []string) error {
+		current := make(map[string]bool, len(sockets))
+		for _, sock := range sockets {
+			current[sock] = true
+			if _, ok := watched[sock]; ok {
+				continue
+			}
 
-	case hostapd.EventStationConnect:
-		var mac MAC
-		if err := mac.Decode(e.MAC); err != nil {
-			return err
+			// The socket may be stale, or hostapd may not yet be ready.
+			// Either way, it will be retried on the next refresh.
+			client, err := hostapd.NewClient(d.localSockDir, sock, d.clientOpts...)
+			if err != nil {
+				d.logger.Printf("unable to connect to hostapd control socket %q: %v", sock, err)
+				continue
+			}
+			remove, err := d.addSource(ctx, eg, client, errs)
+			if err != nil {
+				client.Close()
+				d.logger.Printf("unable to add hostapd control socket %q: %v", sock, err)
+				continue
+			}
+			d.logger.Printf("Added control socket %q", sock)
+			watched[sock] = func() {
+				remove()
+				client.Close()
+			}
 		}
 
-		var shouldUpdate bool
-		d.mu.Lock()
-		sta, ok := d.stations[mac]
-		if ok {
-			shouldUpdate = !sta.connected || sta.bssid != hap.status.BSSID
-			sta.bssid = hap.status.BSSID
-			sta.connected = true
-			sta.connectedAt = time.Now()
-			d.stations[mac] = sta
+		for sock, remove := range watched {
+			if !current[sock] {
+				d.logger.Printf("Removed control socket %q", sock)
+				remove()
+				delete(watched, sock)
+			}
 		}
-		d.mu.Unlock()
-		if !ok {
-			// Station is not being tracked.
-			return nil
+
+		return nil
+	})
+}
+
+// watchSources adds or removes sources as the set of sources reported
+// by the watcher changes.
+func (d *Daemon) watchSources(ctx context.Context, eg *errgroup.Group, w SourceWatcher, errs chan<- error) error {
+	// Removal functions of added sources.
+	watched := make(map[Source]func())
+
+	return w.Watch(ctx, func(sources []Source) error {
+		current := make(map[Source]bool, len(sources))
+		for _, src := range sources {
+			current[src] = true
+			if _, ok := watched[src]; ok {
+				continue
+			}
+
+			remove, err := d.addSource(ctx, eg, src, errs)
+			if err != nil {
+				d.logger.Printf("unable to add source %q: %v", src.Interface(), err)
+				continue
+			}
+			d.logger.Printf("Added source %q", src.Interface())
+			watched[src] = remove
 		}
 
-		if d.db.cancel(mac) {
-			d.logger.Printf("cancelled disconnect event for %s", mac)
+		for src, remove := range watched {
+			if !current[src] {
+				d.logger.Printf("Removed source %q", src.Interface())
+				remove()
+				delete(watched, src)
+			}
 		}
 
-		if !shouldUpdate {
-			break
+		return nil
+	})
+}
+
+// refreshInterfaces lists the interfaces of the global control interface,
+// adding new interfaces to the daemon and removing those that no longer
+// exist.
+func (d *Daemon) refreshInterfaces(ctx context.Context, eg *errgroup.Group, watched map[string]func(), errs chan<- error) error {
+	ifnames, err := d.global.Interfaces(ctx)
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]bool, len(ifnames))
+	for _, ifname := range ifnames {
+		current[ifname] = true
+		if _, ok := watched[ifname]; ok {
+			continue
 		}
 
-		pubCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
-		defer cancel()
-		if err := d.hass.StationHome(pubCtx, mac.String()); err != nil {
+		client, err := d.global.Client(ifname)
+		if err != nil {
+			if errors.Is(err, hostapd.ErrInterfaceNotFound) {
+				// Removed since being listed.
+				continue
+			}
 			return err
 		}
+		remove, err := d.addSource(ctx, eg, client, errs)
+		if err != nil {
+			client.Close()
+			return err
+		}
+		d.logger.Printf("Added interface %q", ifname)
+		watched[ifname] = func() {
+			remove()
+			client.Close()
+		}
+	}
+
+	for ifname, remove := range watched {
+		if !current[ifname] {
+			d.logger.Printf("Removed interface %q", ifname)
+			remove()
+			delete(watched, ifname)
+		}
+	}
+
+	return nil
+}
+
+// reconcile compares the tracked stations against the list of stations
+// currently connected to the given hostapd, handling any connect or disconnect
+// events that may have been missed.
+func (d *Daemon) reconcile(ctx context.Context, hap hap, errs chan<- error) error {
+	listedAt := time.Now()
+	stations, err := d.sourceStations(ctx, hap)
+	if err != nil {
+		var unknown hostapd.ErrUnknownCmd
+		if errors.As(err, &unknown) {
+			d.logger.Printf("%s: unable to retrieve list of connected stations; skipping reconciliation", hap.bss.SSID)
+			return nil
+		}
+		return err
+	}
+
+	_, err = d.reconcileStations(ctx, hap, stations, listedAt, errs)
+	return err
+}
+
+// reconcileLoop reconciles the tracked stations against each hostapd at the
+// reconcile interval, until the context is done. Sources that are unable to
+// list their stations, e.g. while reconnecting, are skipped.
+func (d *Daemon) reconcileLoop(ctx context.Context, errs chan<- error) error {
+	ticker := time.NewTicker(d.reconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		d.mu.Lock()
+		haps := append([]hap(nil), d.haps...)
+		d.mu.Unlock()
 
-		attrs := hass.Attrs{
-			Name:        sta.name,
-			MAC:         sta.mac.String(),
-			IsConnected: true,
-			APName:      d.apName,
-			SSID:        hap.status.SSID,
-			BSSID:       hap.status.BSSID,
-			ConnectedAt: &sta.connectedAt,
-			DisconnectedFor: func() int {
-				if sta.disconnectedAt.IsZero() {
-					return 0
+		for _, hap := range haps {
+			listedAt := time.Now()
+			stations, err := d.sourceStations(ctx, hap)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
 				}
-				return int(time.Since(sta.disconnectedAt).Seconds())
-			}(),
+				d.logger.Printf("%s: unable to list stations; skipping reconciliation: %v", hap.bss.SSID, err)
+				continue
+			}
+
+			n, err := d.reconcileStations(ctx, hap, stations, listedAt, errs)
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				d.mu.Lock()
+				total := d.reconciled
+				d.mu.Unlock()
+				d.logger.Printf("%s: reconciled %d station(s); %d in total", hap.bss.SSID, n, total)
+			}
+		}
+	}
+}
+
+// reconcileStations handles the connect or disconnect of each tracked station
+// whose state differs from the stations listed by the hostapd at listedAt,
+// returning the number of stations corrected. Stations whose links changed
+// since then are skipped, since the list may predate their events.
+func (d *Daemon) reconcileStations(ctx context.Context, hap hap, stations []hostapd.Station, listedAt time.Time, errs chan<- error) (int, error) {
+	connected := make(map[MAC]bool, len(stations))
+	for _, sta := range stations {
+		if !sta.Associated {
+			continue
+		}
+		var mac MAC
+		if err := mac.Decode(sta.ID()); err != nil {
+			return 0, err
+		}
+		connected[mac] = true
+	}
+
+	var added, removed []MAC
+	d.mu.Lock()
+	for mac, sta := range d.stations {
+		if sta.linksChangedAt.After(listedAt) {
+			continue
+		}
+		switch {
+		case connected[mac] && !sta.links[hap.bss.BSSID]:
+			added = append(added, mac)
+		case !connected[mac] && sta.links[hap.bss.BSSID]:
+			removed = append(removed, mac)
+		}
+	}
+	d.reconciled += uint64(len(added) + len(removed))
+	d.mu.Unlock()
+
+	for _, mac := range added {
+		mac := mac
+		d.logger.Printf("%s: reconciled %s as connected", hap.bss.SSID, mac)
+		d.handle(ctx, mac, errs, func() error {
+			return d.onStationConnect(ctx, hap, mac)
+		})
+	}
+	for _, mac := range removed {
+		mac := mac
+		d.logger.Printf("%s: reconciled %s as disconnected", hap.bss.SSID, mac)
+		d.handle(ctx, mac, errs, func() error {
+			return d.onStationDisconnect(ctx, hap, mac, errs)
+		})
+	}
+
+	return len(added) + len(removed), nil
+}
+
+// Stats are counters of the daemon's activity.
+type Stats struct {
+	// The number of stations whose state was corrected by
+	// reconciling with the stations listed by each source.
+	Reconciled uint64
+	// The number of events waiting to be handled, the size of the
+	// queue, and the most events that have been waiting at once.
+	Queued, QueueSize, MaxQueued int
+	// The number of events handled.
+	Handled uint64
+	// The number of events that waited for room in the full queue,
+	// and the total time spent waiting. While waiting, no further
+	// events are read from the event's source.
+	Blocked     uint64
+	BlockedTime time.Duration
+}
+
+// Stats returns the daemon's counters.
+func (d *Daemon) Stats() Stats {
+	d.mu.Lock()
+	s := Stats{
+		Reconciled: d.reconciled,
+	}
+	d.mu.Unlock()
+	d.queue.stats(&s)
+	return s
+}
+
+const (
+	staNoChange staChange = iota
+	staRemoved
+	staAdded
+	staUpdated
+)
+
+type staChange int
+
+func (s staChange) String() string {
+	switch s {
+	case staNoChange:
+		return "no-change"
+	case staRemoved:
+		return "removed"
+	case staAdded:
+		return "added"
+	case staUpdated:
+		return "updated"
+	default:
+		return "?"
+	}
+}
+
+// onConfigChange handles a configuration update, publishing the resulting
+// messages of each station using handle. Errors encountered while publishing
+// are sent to errs.
+func (d *Daemon) onConfigChange(ctx context.Context, retained bool, cfg hass.Configuration, errs chan<- error) error {
+	jobs, err := d.applyConfig(ctx, retained, cfg)
+	for mac, job := range jobs {
+		d.handle(ctx, mac, errs, job)
+	}
+	return err
+}
+
+// needsStations returns true if applying the configuration requires the list
+// of connected stations, i.e. if it adds or updates a station, or configures a
+// hostname pattern. d.mu must be held.
+func (d *Daemon) needsStations(cfg hass.Configuration) bool {
+	for _, devCfg := range cfg.Devices {
+		if devCfg.MAC == "" && devCfg.Hostname != "" {
+			if d.leases != nil {
+				return true
+			}
+			continue
+		}
+
+		var mac MAC
+		if err := mac.Decode(devCfg.MAC); err != nil {
+			// The error is returned by applyConfig.
+			continue
+		}
+		sta, ok := d.stations[mac]
+		if !ok || sta.mesh != devCfg.Mesh || sta.name != devCfg.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// applyConfig updates the tracked stations using the configuration,
+// returning the function that publishes the changes of each station.
+func (d *Daemon) applyConfig(ctx context.Context, retained bool, cfg hass.Configuration) (map[MAC]func() error, error) {
+	// List the connected stations before taking the lock, since doing so
+	// requires a request to each source. Avoid calling Stations on each
+	// source unless necessary.
+	d.mu.Lock()
+	haps := append([]hap(nil), d.haps...)
+	needed := d.needsStations(cfg)
+	d.mu.Unlock()
+
+	var connected map[MAC]connectedStation
+	if needed {
+		var err error
+		if connected, err = d.connectedStations(ctx, haps); err != nil {
+			var unknown hostapd.ErrUnknownCmd
+			if errors.As(err, &unknown) {
+				// At this point, we can still continue. The 'connected' map will be empty, meaning
+				// all stations will be considered disconnected. This is better than failing completely.
+				d.logger.Print("Unable to retrieve list of connected stations. Marking all new stations (if any) as disconnected.\nSee https://github.com/awilliams/wifi-presence/#hostapd-full-version for more information")
+			} else {
+				return nil, err
+			}
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Diff the new vs the current configuration.
+
+	changes := make(map[MAC]staChange, len(cfg.Devices)+len(d.stations))
+	// Stations that were removed, or whose entity type changed,
+	// as previously configured.
+	previous := make(map[MAC]station)
+	d.patterns = nil
+	for _, devCfg := range cfg.Devices {
+		if devCfg.MAC == "" && devCfg.Hostname != "" {
+			if _, err := path.Match(devCfg.Hostname, ""); err != nil {
+				return nil, fmt.Errorf("invalid hostname pattern %q: %w", devCfg.Hostname, err)
+			}
+			if d.leases == nil {
+				d.logger.Printf("Ignoring hostname pattern %q; DHCP leases are not configured", devCfg.Hostname)
+				continue
+			}
+			d.patterns = append(d.patterns, devCfg)
+			continue
+		}
+
+		var mac MAC
+		if err := mac.Decode(devCfg.MAC); err != nil {
+			return nil, err
+		}
+
+		sta, ok := d.stations[mac]
+		switch {
+		case !ok:
+			changes[mac] = staAdded
+		case sta.mesh != devCfg.Mesh:
+			previous[mac] = sta
+			fallthrough
+		case sta.name != devCfg.Name:
+			changes[mac] = staUpdated
+		default:
+			changes[mac] = staNoChange
 		}
 
-		pubCtx, cancel = context.WithTimeout(ctx, 2*time.Second)
-		defer cancel()
-		if err := d.hass.StationAttributes(pubCtx, mac.String(), attrs); err != nil {
+		sta.name = devCfg.Name
+		sta.mac = mac
+		sta.pattern = ""
+		sta.mesh = devCfg.Mesh
+		d.stations[mac] = sta
+	}
+	// Find previously configured stations that are no longer
+	// present in the new configuration.
+	for mac, sta := range d.stations {
+		if _, ok := changes[mac]; ok {
+			continue
+		}
+		if d.hasPattern(sta.pattern) {
+			// Station was matched by a pattern that is still configured.
+			changes[mac] = staNoChange
+			continue
+		}
+		changes[mac] = staRemoved
+		previous[mac] = sta
+		delete(d.stations, mac)
+	}
+
+	var logMsg strings.Builder
+	fmt.Fprintf(&logMsg, "Received config update (retained=%v):\n", retained)
+	defer func() {
+		d.logger.Print(logMsg.String())
+	}()
+
+	if len(changes) == 0 && len(d.patterns) == 0 {
+		fmt.Fprintln(&logMsg, "(no stations configured)")
+		return nil, nil
+	}
+
+	jobs := make(map[MAC]func() error)
+
+	// Track connected stations whose hostname matches a pattern.
+	for mac := range connected {
+		if _, ok := d.stations[mac]; ok {
+			continue
+		}
+		if sta, ok := d.matchHostname(mac); ok {
+			d.stations[mac] = sta
+			changes[mac] = staAdded
+		}
+	}
+
+	// Process each configuration change. The resulting messages
+	// are published in order with each station's events.
+	for mac, change := range changes {
+		mac := mac
+		sta := d.stations[mac] // May be zero value.
+
+		switch change {
+		case staNoChange:
+			// Nothing to do here.
+
+		case staUpdated:
+			if d.hassAutoDisc {
+				prev, retyped := previous[mac]
+				jobs[mac] = func() error {
+					if retyped {
+						// The station's entity type changed.
+						if err := d.unregister(ctx, prev); err != nil {
+							return err
+						}
+					}
+					return d.register(ctx, sta)
+				}
+			}
+
+		case staAdded:
+			resetNearby := d.nearbyTimeout > 0 && !sta.mesh
+			if resetNearby {
+				sta.nearby = false
+			}
+
+			// Check whether this station is connected or not.
+			cs, ok := connected[mac]
+			if !ok {
+				sta.connected = false
+				sta.links = nil
+				sta.linksChangedAt = time.Now()
+				d.stations[mac] = sta
+			} else {
+				sta.connected = true
+				sta.connectedAt = time.Now().Add(-cs.sta.Connected)
+				sta.bssid = cs.bss.BSSID
+				sta.links = cs.links
+				sta.linksChangedAt = time.Now()
+				d.stations[mac] = sta
+
+				d.db.cancel(mac)
+			}
+
+			jobs[mac] = func() error {
+				if d.hassAutoDisc {
+					if err := d.register(ctx, sta); err != nil {
+						return err
+					}
+				}
+
+				if resetNearby {
+					if err := d.publishNearby(ctx, hap{}, sta); err != nil {
+						return err
+					}
+				}
+
+				if !ok {
+					// Station is not connected.
+					pubCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+					defer cancel()
+					return d.hass.StationNotHome(pubCtx, mac.String())
+				}
+
+				// Station is connected.
+
+				attrs := hass.Attrs{
+					Name:         sta.name,
+					MAC:          sta.mac.String(),
+					IsConnected:  true,
+					APName:       d.apName,
+					SSID:         cs.bss.SSID,
+					BSSID:        cs.bss.BSSID,
+					ConnectedAt:  &sta.connectedAt,
+					ConnectedFor: int(time.Since(sta.connectedAt).Seconds()),
+				}
+				d.setHost(&attrs)
+
+				pubCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+				defer cancel()
+				if err := d.hass.StationHome(pubCtx, mac.String()); err != nil {
+					return err
+				}
+
+				pubCtx, cancel = context.WithTimeout(ctx, 2*time.Second)
+				defer cancel()
+				return d.hass.StationAttributes(pubCtx, mac.String(), attrs)
+			}
+
+		case staRemoved:
+			d.db.cancel(mac)
+			d.nearby.cancel(mac)
+
+			// TODO: send disconnected state here?
+			// HomeAssistant removes the devices from its registry, but other systems may depend
+			// more on this state message.
+
+			sta = previous[mac]
+			if d.hassAutoDisc {
+				jobs[mac] = func() error {
+					return d.unregister(ctx, sta)
+				}
+			}
+		}
+
+		fmt.Fprintf(&logMsg, "  %q (%s): %s\n", sta.name, mac, change.String())
+	}
+
+	return jobs, nil
+}
+
+// register publishes the Home Assistant discovery configuration of the
+// station: a device tracker, or a binary sensor for mesh nodes.
+func (d *Daemon) register(ctx context.Context, sta station) error {
+	dsc := hass.Discovery{
+		Name: sta.name,
+		MAC:  sta.mac.String(),
+	}
+	if sta.mesh {
+		return d.hass.RegisterMeshNode(ctx, dsc)
+	}
+	if err := d.hass.RegisterDeviceTracker(ctx, dsc); err != nil {
+		return err
+	}
+	if d.nearbyTimeout > 0 {
+		return d.hass.RegisterNearby(ctx, dsc)
+	}
+	return nil
+}
+
+// unregister removes the Home Assistant discovery configuration
+// published by register.
+func (d *Daemon) unregister(ctx context.Context, sta station) error {
+	if sta.mesh {
+		return d.hass.UnregisterMeshNode(ctx, sta.mac.String())
+	}
+	if err := d.hass.UnregisterDeviceTracker(ctx, sta.mac.String()); err != nil {
+		return err
+	}
+	if d.nearbyTimeout > 0 {
+		return d.hass.UnregisterNearby(ctx, sta.mac.String())
+	}
+	return nil
+}
+
+// onHostapdEvent enqueues the handling of a station's event. Errors
+// encountered while handling it are sent to errs.
+func (d *Daemon) onHostapdEvent(ctx context.Context, hap hap, event hostapd.Event, errs chan<- error) error {
+	if e, ok := event.(hostapd.EventProbeRequest); ok {
+		if d.nearbyTimeout <= 0 {
+			return nil
+		}
+		// Probe requests are frequent, and not logged.
+		var mac MAC
+		if err := mac.Decode(e.MAC); err != nil {
+			return err
+		}
+		d.handle(ctx, mac, errs, func() error {
+			return d.onProbeRequest(ctx, hap, mac, e.Signal, errs)
+		})
+		return nil
+	}
+
+	d.logger.Printf("%s: Event %T: %q", hap.bss.SSID, event, event.Raw())
+
+	switch e := event.(type) {
+
+	case hostapd.EventStationConnect:
+		var mac MAC
+		if err := mac.Decode(e.ID()); err != nil {
 			return err
 		}
+		d.handle(ctx, mac, errs, func() error {
+			return d.onStationConnect(ctx, hap, mac)
+		})
 
 	case hostapd.EventStationDisconnect:
 		var mac MAC
-		if err := mac.Decode(e.MAC); err != nil {
+		if err := mac.Decode(e.ID()); err != nil {
 			return err
 		}
+		d.handle(ctx, mac, errs, func() error {
+			return d.onStationDisconnect(ctx, hap, mac, errs)
+		})
 
-		d.mu.Lock()
-		sta, ok := d.stations[mac]
-		if ok {
-			if sta.connected && sta.bssid != hap.status.BSSID {
-				// Assume that station previously connected to another AP, and
-				// that this is a delayed disconnect event from the previous AP.
-				d.mu.Unlock()
-				d.logger.Printf("ignoring latent disconnect for %s; connected to other bssid %s", mac, sta.bssid)
-				return nil
+	default:
+		d.logger.Printf("%s: event not handled %T: %q", hap.bss.SSID, event, event.Raw())
+	}
+
+	return nil
+}
+
+// handle enqueues f, which handles an event of the station, to be run once
+// the station's previously enqueued events have been handled. Any error
+// returned by f is sent to errs.
+func (d *Daemon) handle(ctx context.Context, mac MAC, errs chan<- error, f func() error) {
+	waited, err := d.queue.enqueue(ctx, mac, func() {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := f(); err != nil {
+			select {
+			case errs <- err:
+			case <-ctx.Done():
 			}
-			sta.connected = false
-			sta.disconnectedAt = time.Now()
-			d.stations[mac] = sta
 		}
-		d.mu.Unlock()
-		if !ok {
-			// Station is not being tracked.
-			return nil
+	})
+	if err != nil {
+		// The daemon is stopping.
+		return
+	}
+	if waited > 0 {
+		d.logger.Printf("event queue is full (%d events); waited %s to enqueue event of %s", cap(d.queue.slots), waited, mac)
+	}
+}
+
+// onStationConnect handles a station connecting to the given hostapd.
+func (d *Daemon) onStationConnect(ctx context.Context, hap hap, mac MAC) error {
+	var shouldUpdate, matched, wasNearby bool
+	d.mu.Lock()
+	sta, ok := d.stations[mac]
+	if !ok {
+		// The station may be configured by hostname.
+		sta, matched = d.matchHostname(mac)
+		ok = matched
+	}
+	if ok {
+		shouldUpdate = !sta.connected || sta.bssid != hap.bss.BSSID
+		if !sta.connected {
+			sta.connectedAt = time.Now()
 		}
+		sta.bssid = hap.bss.BSSID
+		sta.links = withLink(sta.links, hap.bss.BSSID)
+		sta.linksChangedAt = time.Now()
+		sta.connected = true
+		wasNearby = sta.nearby
+		sta.nearby = false
+		d.stations[mac] = sta
+	}
+	d.mu.Unlock()
+	if !ok {
+		// Station is not being tracked.
+		return nil
+	}
 
-		d.db.enqueue(mac, func() {
-			pubCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
-			defer cancel()
-			if err := d.hass.StationNotHome(pubCtx, mac.String()); err != nil {
-				errs <- err
-				return
+	if wasNearby {
+		d.nearby.cancel(mac)
+		if err := d.publishNearby(ctx, hap, sta); err != nil {
+			return err
+		}
+	}
+
+	if matched {
+		d.logger.Printf("%s: tracking %s, matching hostname pattern %q", hap.bss.SSID, mac, sta.pattern)
+		if d.hassAutoDisc {
+			if err := d.register(ctx, sta); err != nil {
+				return err
 			}
+		}
+	}
 
-			d.mu.Lock()
-			sta, ok := d.stations[mac]
-			d.mu.Unlock()
+	if d.db.cancel(mac) {
+		d.logger.Printf("cancelled disconnect event for %s", mac)
+	}
 
-			if !ok {
-				// Somehow station was removed.
-				return
+	if !shouldUpdate {
+		return nil
+	}
+
+	pubCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := d.hass.StationHome(pubCtx, mac.String()); err != nil {
+		return err
+	}
+
+	attrs := hass.Attrs{
+		Name:        sta.name,
+		MAC:         sta.mac.String(),
+		IsConnected: true,
+		APName:      d.apName,
+		SSID:        hap.bss.SSID,
+		BSSID:       hap.bss.BSSID,
+		ConnectedAt: &sta.connectedAt,
+		DisconnectedFor: func() int {
+			if sta.disconnectedAt.IsZero() {
+				return 0
 			}
+			return int(time.Since(sta.disconnectedAt).Seconds())
+		}(),
+	}
+	d.setHost(&attrs)
+
+	pubCtx, cancel = context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return d.hass.StationAttributes(pubCtx, mac.String(), attrs)
+}
 
-			attrs := hass.Attrs{
-				Name:           sta.name,
-				MAC:            sta.mac.String(),
-				IsConnected:    false,
-				APName:         d.apName,
-				SSID:           hap.status.SSID,
-				BSSID:          hap.status.BSSID,
-				ConnectedFor:   int(time.Since(sta.connectedAt).Seconds()),
-				DisconnectedAt: &sta.disconnectedAt,
+// onStationDisconnect handles a station disconnecting from the given hostapd.
+// It must be called using handle. The resulting MQTT messages are published
+// after the debounce period, also using handle; any errors encountered while
+// publishing them are sent to errs.
+func (d *Daemon) onStationDisconnect(ctx context.Context, hap hap, mac MAC, errs chan<- error) error {
+	d.mu.Lock()
+	sta, ok := d.stations[mac]
+	if ok && sta.connected {
+		if len(sta.links) > 0 && !sta.links[hap.bss.BSSID] {
+			// Assume that station previously connected to another AP, and
+			// that this is a delayed disconnect event from the previous AP.
+			d.mu.Unlock()
+			d.logger.Printf("ignoring latent disconnect for %s; connected to other bssid %s", mac, sta.bssid)
+			return nil
+		}
+		sta.linksChangedAt = time.Now()
+		if sta.links = withoutLink(sta.links, hap.bss.BSSID); len(sta.links) > 0 {
+			// The station remains connected to another BSS, either using
+			// another of its links, or since roaming to another AP.
+			moved := sta.bssid == hap.bss.BSSID
+			if moved {
+				sta.bssid = sortedLinks(sta.links)[0]
 			}
+			movedTo, found := d.hapByBSSID(sta.bssid)
+			d.stations[mac] = sta
+			d.mu.Unlock()
+			d.logger.Printf("%s: %s disconnected; still connected to bssid(s) %s", hap.bss.SSID, mac, strings.Join(sortedLinks(sta.links), ", "))
+			if moved && found {
+				return d.publishConnected(ctx, movedTo, sta)
+			}
+			return nil
+		}
+	}
+	if ok {
+		if sta.connected || sta.disconnectedAt.IsZero() {
+			// Otherwise, this is a repeated disconnect, whose departure
+			// is identified by the time of the first.
+			sta.disconnectedAt = time.Now()
+		}
+		sta.connected = false
+		sta.links = nil
+		sta.linksChangedAt = time.Now()
+		d.stations[mac] = sta
+	}
+	d.mu.Unlock()
+	if !ok {
+		// Station is not being tracked.
+		return nil
+	}
+
+	d.db.enqueue(mac, func() {
+		// Handled in order with the station's other events.
+		d.handle(ctx, mac, errs, func() error {
+			return d.onDeparture(ctx, hap, mac, sta.disconnectedAt)
+		})
+	})
+	return nil
+}
+
+// onDeparture publishes the disconnected state of a station once the debounce
+// period has elapsed since it disconnected from the given hostapd at
+// disconnectedAt, unless its departure could not be confirmed.
+func (d *Daemon) onDeparture(ctx context.Context, hap hap, mac MAC, disconnectedAt time.Time) error {
+	// departed returns the station, and whether it remains disconnected
+	// since disconnectedAt. The station may have re-connected (and possibly
+	// disconnected again) while the departure was waiting to be handled or
+	// being confirmed, or it may have been removed.
+	departed := func() (station, bool) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		sta, ok := d.stations[mac]
+		return sta, ok && !sta.connected && sta.disconnectedAt.Equal(disconnectedAt)
+	}
+	if _, ok := departed(); !ok {
+		return nil
+	}
 
-			pubCtx, cancel = context.WithTimeout(ctx, 2*time.Second)
-			defer cancel()
-			if err := d.hass.StationAttributes(pubCtx, mac.String(), attrs); err != nil {
-				errs <- err
-				return
+	var check string
+	if d.confirmTimeout > 0 {
+		var present bool
+		if present, check = d.confirmDeparture(ctx, hap, mac); present {
+			return d.onStationPresent(ctx, hap, mac, check)
+		}
+	}
+
+	sta, ok := departed()
+	if !ok {
+		return nil
+	}
+
+	pubCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := d.hass.StationNotHome(pubCtx, mac.String()); err != nil {
+		return err
+	}
+
+	attrs := hass.Attrs{
+		Name:           sta.name,
+		MAC:            sta.mac.String(),
+		IsConnected:    false,
+		APName:         d.apName,
+		SSID:           hap.bss.SSID,
+		BSSID:          hap.bss.BSSID,
+		ConnectedFor:   int(time.Since(sta.connectedAt).Seconds()),
+		DisconnectedAt: &sta.disconnectedAt,
+		DepartureCheck: check,
+	}
+	d.setHost(&attrs)
+
+	pubCtx, cancel = context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return d.hass.StationAttributes(pubCtx, mac.String(), attrs)
+}
+
+// nearbySignalChange is the change in signal strength, in dB, of the probe
+// requests of a nearby station that causes its state to be published again.
+const nearbySignalChange = 5
+
+// onProbeRequest handles a probe request received by the given hostapd. A
+// tracked station that is not connected becomes nearby, until no probe
+// request has been received from it for the nearby timeout. Any errors
+// encountered while publishing its expiry are sent to errs.
+func (d *Daemon) onProbeRequest(ctx context.Context, hap hap, mac MAC, signal int, errs chan<- error) error {
+	var wasNearby, shouldUpdate bool
+	d.mu.Lock()
+	sta, ok := d.stations[mac]
+	ok = ok && !sta.connected && !sta.mesh
+	if ok {
+		wasNearby = sta.nearby
+		change := signal - sta.nearbySignal
+		if change < 0 {
+			change = -change
+		}
+		shouldUpdate = !sta.nearby || change >= nearbySignalChange
+		if shouldUpdate {
+			sta.nearbySignal = signal
+		}
+		sta.nearby = true
+		d.stations[mac] = sta
+	}
+	d.mu.Unlock()
+	if !ok {
+		// Station is not being tracked, or is connected.
+		return nil
+	}
+
+	// Each probe request extends the time the station is nearby.
+	d.nearby.cancel(mac)
+	d.nearby.enqueue(mac, func() {
+		d.handle(ctx, mac, errs, func() error {
+			d.mu.Lock()
+			sta, ok := d.stations[mac]
+			ok = ok && sta.nearby
+			if ok {
+				sta.nearby = false
+				d.stations[mac] = sta
+			}
+			d.mu.Unlock()
+			if !ok {
+				// Station connected, or was removed.
+				return nil
 			}
+
+			d.logger.Printf("%s: %s is no longer nearby", hap.bss.SSID, mac)
+			return d.publishNearby(ctx, hap, sta)
 		})
+	})
+
+	if !shouldUpdate {
+		return nil
+	}
+	if !wasNearby {
+		d.logger.Printf("%s: %s is nearby (signal %d dBm)", hap.bss.SSID, mac, signal)
+	}
+	return d.publishNearby(ctx, hap, sta)
+}
+
+// publishNearby publishes whether the station is nearby, as seen by the
+// given hostapd.
+func (d *Daemon) publishNearby(ctx context.Context, hap hap, sta station) error {
+	nearby := hass.Nearby{
+		State:  hass.PayloadNotNearby,
+		MAC:    sta.mac.String(),
+		APName: d.apName,
+		SSID:   hap.bss.SSID,
+		BSSID:  hap.bss.BSSID,
+	}
+	if sta.nearby {
+		now := time.Now()
+		nearby.State = hass.PayloadNearby
+		nearby.Signal = sta.nearbySignal
+		nearby.SeenAt = &now
+	}
+
+	pubCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return d.hass.StationNearby(pubCtx, sta.mac.String(), nearby)
+}
+
+// Results of confirmDeparture, included in the published attributes.
+const (
+	departureStationPresent = "station_present"
+	departurePollOK         = "poll_ok"
+	departureNotFound       = "station_not_found"
+	departureNoPollResponse = "no_poll_response"
+	departureCheckFailed    = "check_failed"
+)
+
+// confirmDeparture checks with hostapd whether the station is still
+// connected. It first queries the station, and then polls it. The returned
+// bool is true if the station is present, along with a description of the
+// check's result.
+func (d *Daemon) confirmDeparture(ctx context.Context, hap hap, mac MAC) (bool, string) {
+	poller, ok := hap.src.(stationPoller)
+	if !ok {
+		// Unable to check with the source.
+		return false, ""
+	}
 
+	sta, err := poller.Station(ctx, mac.String())
+	switch {
+	case err == nil && sta.Associated:
+		d.logger.Printf("%s: departure of %s not confirmed; station is associated", hap.bss.SSID, mac)
+		return true, departureStationPresent
+	case err != nil && !errors.Is(err, hostapd.ErrStationNotFound):
+		d.logger.Printf("%s: unable to query station %s: %v", hap.bss.SSID, mac, err)
+	}
+
+	pollCtx, cancel := context.WithTimeout(ctx, d.confirmTimeout)
+	defer cancel()
+	err = poller.PollStation(pollCtx, mac.String())
+	switch {
+	case err == nil:
+		d.logger.Printf("%s: departure of %s not confirmed; station responded to poll", hap.bss.SSID, mac)
+		return true, departurePollOK
+	case errors.Is(err, hostapd.ErrStationNotFound):
+		return false, departureNotFound
+	case errors.Is(err, hostapd.ErrNoPollResponse):
+		return false, departureNoPollResponse
 	default:
-		d.logger.Printf("%s: event not handled %T: %q", hap.status.SSID, event, event.Raw())
+		d.logger.Printf("%s: unable to poll station %s: %v", hap.bss.SSID, mac, err)
+		return false, departureCheckFailed
+	}
+}
+
+// onStationPresent handles a station that was found to still be connected
+// while confirming its departure. Its state is reverted to connected without
+// publishing a state change, since the disconnect was never published.
+func (d *Daemon) onStationPresent(ctx context.Context, hap hap, mac MAC, check string) error {
+	var wasNearby bool
+	d.mu.Lock()
+	sta, ok := d.stations[mac]
+	if ok {
+		sta.connected = true
+		sta.bssid = hap.bss.BSSID
+		sta.links = withLink(sta.links, hap.bss.BSSID)
+		sta.linksChangedAt = time.Now()
+		sta.disconnectedAt = time.Time{}
+		wasNearby = sta.nearby
+		sta.nearby = false
+		d.stations[mac] = sta
+	}
+	d.mu.Unlock()
+	if !ok {
+		return nil
 	}
 
-	return nil
+	if wasNearby {
+		d.nearby.cancel(mac)
+		if err := d.publishNearby(ctx, hap, sta); err != nil {
+			return err
+		}
+	}
+
+	attrs := hass.Attrs{
+		Name:           sta.name,
+		MAC:            sta.mac.String(),
+		IsConnected:    true,
+		APName:         d.apName,
+		SSID:           hap.bss.SSID,
+		BSSID:          hap.bss.BSSID,
+		ConnectedAt:    &sta.connectedAt,
+		DepartureCheck: check,
+	}
+	d.setHost(&attrs)
+
+	pubCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return d.hass.StationAttributes(pubCtx, mac.String(), attrs)
 }
 
 // connectedStations returns a mapping by MAC address of all connected
-// stations, combining each hostap client.
-func (d *Daemon) connectedStations() (map[MAC]connectedStation, error) {
+// stations, combining each of the given sources.
+func (d *Daemon) connectedStations(ctx context.Context, haps []hap) (map[MAC]connectedStation, error) {
 	cs := make(map[MAC]connectedStation)
-	for _, hap := range d.haps {
+	for _, hap := range haps {
 		// Stations returns a list of all the connected stations.
-		stations, err := hap.client.Stations()
+		stations, err := d.sourceStations(ctx, hap)
 		if err != nil {
+			if errors.Is(err, hostapd.ErrInterfaceNotFound) {
+				// Interface has been removed, but not yet from haps.
+				continue
+			}
 			return nil, err
 		}
 		for _, sta := range stations {
@@ -516,14 +1686,101 @@ func (d *Daemon) connectedStations() (map[MAC]connectedStation, error) {
 				continue
 			}
 			var mac MAC
-			if err := mac.Decode(sta.MAC); err != nil {
+			if err := mac.Decode(sta.ID()); err != nil {
 				return nil, err
 			}
-			cs[mac] = connectedStation{
-				hapStatus: hap.status,
-				sta:       sta,
+			// The links of a multi-link station are listed
+			// by the source of each link.
+			c, ok := cs[mac]
+			if !ok {
+				c = connectedStation{
+					bss: hap.bss,
+					sta: sta,
+				}
 			}
+			c.links = withLink(c.links, hap.bss.BSSID)
+			cs[mac] = c
 		}
 	}
 	return cs, nil
 }
+
+// sourceStations returns the stations of the source, using the station
+// fallback if the source is unable to list them. The source's error is
+// returned if the fallback also fails.
+func (d *Daemon) sourceStations(ctx context.Context, hap hap) ([]hostapd.Station, error) {
+	stations, err := hap.src.Stations(ctx)
+	var unknown hostapd.ErrUnknownCmd
+	if err == nil || d.stationFallback == nil || !errors.As(err, &unknown) {
+		return stations, err
+	}
+
+	ifname := hap.src.Interface()
+	stations, fallbackErr := d.stationFallback(ctx, ifname)
+	if fallbackErr != nil {
+		d.logger.Printf("%s: unable to list stations using fallback: %v", ifname, fallbackErr)
+		return nil, err
+	}
+	return stations, nil
+}
+
+// publishConnected publishes the attributes of a station that remains
+// connected, now to the given hostapd.
+func (d *Daemon) publishConnected(ctx context.Context, hap hap, sta station) error {
+	attrs := hass.Attrs{
+		Name:         sta.name,
+		MAC:          sta.mac.String(),
+		IsConnected:  true,
+		APName:       d.apName,
+		SSID:         hap.bss.SSID,
+		BSSID:        hap.bss.BSSID,
+		ConnectedAt:  &sta.connectedAt,
+		ConnectedFor: int(time.Since(sta.connectedAt).Seconds()),
+	}
+	d.setHost(&attrs)
+
+	pubCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return d.hass.StationAttributes(pubCtx, sta.mac.String(), attrs)
+}
+
+// hapByBSSID returns the hostapd operating the BSS. d.mu must be held.
+func (d *Daemon) hapByBSSID(bssid string) (hap, bool) {
+	for _, h := range d.haps {
+		if h.bss.BSSID == bssid {
+			return h, true
+		}
+	}
+	return hap{}, false
+}
+
+// withLink returns a copy of links with the BSSID added.
+func withLink(links map[string]bool, bssid string) map[string]bool {
+	c := make(map[string]bool, len(links)+1)
+	for l := range links {
+		c[l] = true
+	}
+	c[bssid] = true
+	return c
+}
+
+// withoutLink returns a copy of links without the BSSID.
+func withoutLink(links map[string]bool, bssid string) map[string]bool {
+	c := make(map[string]bool, len(links))
+	for l := range links {
+		if l != bssid {
+			c[l] = true
+		}
+	}
+	return c
+}
+
+// sortedLinks returns the BSSIDs of links in order.
+func sortedLinks(links map[string]bool) []string {
+	bssids := make([]string, 0, len(links))
+	for l := range links {
+		bssids = append(bssids, l)
+	}
+	sort.Strings(bssids)
+	return bssids
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -278,6 +279,195 @@ func TestDaemon_State(t *testing.T) {
 	ensureState(hass.PayloadHome)
 }
 
+func TestDaemon_MeshNode(t *testing.T) {
+	if *mqttAddr == "" {
+		t.Skip("skipping test; not given mqttAddr")
+	}
+
+	const (
+		testMAC = "FF:FF:FF:FF:FF:FF"
+	)
+
+	h := hostapdtest.DefaultHostAPDHandler(hostapdtest.StatusResp{
+		State:      "Enabled",
+		Channel:    42,
+		SSID:       "backhaul",
+		BSSID:      "AA:BB:CC:DD:EE:FF",
+		MaxTxPower: 11,
+	}, nil)
+	attachMsgs := make(chan string)
+	h.OnAttach(func() <-chan string {
+		t.Log("Attached to hostapd")
+		return attachMsgs
+	})
+
+	dt := newDaemonTest(t, h)
+
+	meshMessages := dt.subTopic(dt.topics.MeshNodeDiscovery(testMAC), true)
+	trackerMessages := dt.subTopic(dt.topics.DeviceDiscovery(testMAC), true)
+	testMACState := dt.subTopic(dt.topics.DeviceState(testMAC), true)
+
+	recv := func(msgs <-chan mqtt.Message, description string) mqtt.Message {
+		t.Helper()
+		select {
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timeout waiting for %s message", description)
+		case err := <-dt.errs:
+			t.Fatal(err)
+		case msg := <-msgs:
+			return msg
+		}
+		return nil
+	}
+	ensureState := func(want string) {
+		t.Helper()
+		if got := string(recv(testMACState, "state").Payload()); got != want {
+			t.Fatalf("got state %q; want %q", got, want)
+		}
+	}
+	sendEvent := func(event string) {
+		t.Helper()
+		select {
+		case attachMsgs <- fmt.Sprintf("%s %s", event, testMAC):
+		case <-time.After(time.Second):
+			t.Fatalf("timeout sending %s event", event)
+		}
+	}
+
+	// Configure Daemon to track the mesh node.
+	dt.pubTopic(dt.topics.Config(), true, hass.Configuration{
+		Devices: []hass.TrackConfig{
+			{Name: "Mesh Node", MAC: testMAC, Mesh: true},
+		},
+	})
+	var bs hass.BinarySensor
+	if err := json.Unmarshal(recv(meshMessages, "binary_sensor config").Payload(), &bs); err != nil {
+		t.Fatalf("unable to unmarshal MQTT payload into %T: %v", bs, err)
+	}
+	if bs.DeviceClass != hass.DeviceClassConnectivity || bs.StateTopic != dt.topics.DeviceState(testMAC) {
+		t.Fatalf("got binary_sensor config %+v", bs)
+	}
+	ensureState(hass.PayloadNotHome)
+
+	sendEvent("MESH-PEER-CONNECTED")
+	ensureState(hass.PayloadHome)
+	sendEvent("MESH-PEER-DISCONNECTED")
+	ensureState(hass.PayloadNotHome)
+
+	// Track the same device as a station instead.
+	dt.pubTopic(dt.topics.Config(), true, hass.Configuration{
+		Devices: []hass.TrackConfig{
+			{Name: "Mesh Node", MAC: testMAC},
+		},
+	})
+	if l := len(recv(meshMessages, "binary_sensor config").Payload()); l != 0 {
+		t.Fatalf("got binary_sensor config message of length %d; expected 0", l)
+	}
+	recv(trackerMessages, "device_tracker config")
+}
+
+func TestDaemon_Nearby(t *testing.T) {
+	if *mqttAddr == "" {
+		t.Skip("skipping test; not given mqttAddr")
+	}
+
+	const (
+		testMAC = "FF:FF:FF:FF:FF:FF"
+	)
+
+	h := hostapdtest.DefaultHostAPDHandler(hostapdtest.StatusResp{
+		State:      "Enabled",
+		Channel:    42,
+		SSID:       "💾",
+		BSSID:      "AA:BB:CC:DD:EE:FF",
+		MaxTxPower: 11,
+	}, nil)
+	attachMsgs := make(chan string)
+	h.OnAttach(func() <-chan string {
+		t.Log("Attached to hostapd")
+		return attachMsgs
+	})
+
+	dt := newDaemonTestOpts(t, []Opt{WithDebounce(0), WithNearby(200 * time.Millisecond)}, h)
+
+	nearbyDiscovery := dt.subTopic(dt.topics.NearbyDiscovery(testMAC), true)
+	testMACState := dt.subTopic(dt.topics.DeviceState(testMAC), true)
+	testMACNearby := dt.subTopic(dt.topics.DeviceNearby(testMAC), true)
+
+	recv := func(msgs <-chan mqtt.Message, description string) mqtt.Message {
+		t.Helper()
+		select {
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timeout waiting for %s message", description)
+		case err := <-dt.errs:
+			t.Fatal(err)
+		case msg := <-msgs:
+			return msg
+		}
+		return nil
+	}
+	ensureNearby := func(want string, wantSignal int) {
+		t.Helper()
+		var got hass.Nearby
+		if err := json.Unmarshal(recv(testMACNearby, "nearby").Payload(), &got); err != nil {
+			t.Fatalf("unable to unmarshal MQTT payload into %T: %v", got, err)
+		}
+		if got.State != want || got.Signal != wantSignal {
+			t.Fatalf("got nearby %q (signal %d); want %q (signal %d)", got.State, got.Signal, want, wantSignal)
+		}
+	}
+	sendEvent := func(event string) {
+		t.Helper()
+		select {
+		case attachMsgs <- event:
+		case <-time.After(time.Second):
+			t.Fatalf("timeout sending %q event", event)
+		}
+	}
+
+	dt.pubTopic(dt.topics.Config(), true, hass.Configuration{
+		Devices: []hass.TrackConfig{
+			{Name: "Test Subject", MAC: testMAC},
+		},
+	})
+	var bs hass.BinarySensor
+	if err := json.Unmarshal(recv(nearbyDiscovery, "binary_sensor config").Payload(), &bs); err != nil {
+		t.Fatalf("unable to unmarshal MQTT payload into %T: %v", bs, err)
+	}
+	if bs.DeviceClass != hass.DeviceClassPresence || bs.StateTopic != dt.topics.DeviceNearby(testMAC) {
+		t.Fatalf("got binary_sensor config %+v", bs)
+	}
+	ensureNearby(hass.PayloadNotNearby, 0)
+	recv(testMACState, "state")
+
+	// Probe requests of other stations are ignored.
+	sendEvent("RX-PROBE-REQUEST sa=00:00:00:00:00:01 signal=-40")
+	sendEvent("RX-PROBE-REQUEST sa=" + testMAC + " signal=-80")
+	ensureNearby(hass.PayloadNearby, -80)
+	// Small changes in signal are not published.
+	sendEvent("RX-PROBE-REQUEST sa=" + testMAC + " signal=-78")
+	sendEvent("RX-PROBE-REQUEST sa=" + testMAC + " signal=-70")
+	ensureNearby(hass.PayloadNearby, -70)
+
+	// Expires without further probe requests.
+	ensureNearby(hass.PayloadNotNearby, 0)
+
+	// Connecting clears the nearby state.
+	sendEvent("RX-PROBE-REQUEST sa=" + testMAC + " signal=-60")
+	ensureNearby(hass.PayloadNearby, -60)
+	sendEvent("AP-STA-CONNECTED " + testMAC)
+	ensureNearby(hass.PayloadNotNearby, 0)
+	recv(testMACState, "state")
+
+	// Connected stations are not nearby.
+	sendEvent("RX-PROBE-REQUEST sa=" + testMAC + " signal=-50")
+	select {
+	case msg := <-testMACNearby:
+		t.Fatalf("unexpected nearby message %q", msg.Payload())
+	case <-time.After(400 * time.Millisecond):
+	}
+}
+
 func TestDaemon_MultiAPs(t *testing.T) {
 	if *mqttAddr == "" {
 		t.Skip("skipping test; not given mqttAddr")
@@ -543,7 +733,252 @@ func TestDaemon_DelayedDisconnect(t *testing.T) {
 	}
 }
 
+func TestDaemon_MultiLink(t *testing.T) {
+	if *mqttAddr == "" {
+		t.Skip("skipping test; not given mqttAddr")
+	}
+
+	const (
+		testMLD   = "06:00:00:00:01:00"
+		testLink1 = "02:00:00:00:01:01"
+		testLink2 = "02:00:00:00:01:02"
+		h1SSID    = "ssid-2g"
+		h2SSID    = "ssid-5g"
+	)
+
+	// A multi-link station connects to a BSS of each of its links,
+	// affiliated with the same AP MLD.
+
+	h1 := hostapdtest.DefaultHostAPDHandler(hostapdtest.StatusResp{
+		State:      "Enabled",
+		Channel:    1,
+		SSID:       h1SSID,
+		BSSID:      "AA:BB:CC:DD:EE:01",
+		MaxTxPower: 11,
+	}, nil)
+	h1attachMsgs := make(chan string)
+	h1.OnAttach(func() <-chan string {
+		t.Log("Attached to hostapd 1")
+		return h1attachMsgs
+	})
+
+	h2 := hostapdtest.DefaultHostAPDHandler(hostapdtest.StatusResp{
+		State:      "Enabled",
+		Channel:    36,
+		SSID:       h2SSID,
+		BSSID:      "AA:BB:CC:DD:EE:02",
+		MaxTxPower: 12,
+	}, nil)
+	h2attachMsgs := make(chan string)
+	h2.OnAttach(func() <-chan string {
+		t.Log("Attached to hostapd 2")
+		return h2attachMsgs
+	})
+
+	dt := newDaemonTest(t, h1, h2)
+
+	// The station is configured using its MLD address.
+	trackingCfg := hass.Configuration{
+		Devices: []hass.TrackConfig{
+			{Name: "Test Subject", MAC: testMLD},
+		},
+	}
+	dt.pubTopic(dt.topics.Config(), true, trackingCfg)
+
+	testMACState := dt.subTopic(dt.topics.DeviceState(testMLD), true)
+	testMACAttrs := dt.subTopic(dt.topics.DeviceJSONAttrs(testMLD), true)
+
+	ensureState := func(want string) {
+		t.Helper()
+		select {
+		case <-time.After(1 * time.Second):
+			t.Fatal("timeout waiting for device state message")
+		case err := <-dt.errs:
+			t.Fatal(err)
+		case msg := <-testMACState:
+			if got := string(msg.Payload()); got != want {
+				t.Fatalf("got state %q; want %q", got, want)
+			}
+		}
+	}
+	ensureNoState := func() {
+		t.Helper()
+		select {
+		case <-time.After(250 * time.Millisecond):
+		case err := <-dt.errs:
+			t.Fatal(err)
+		case msg := <-testMACState:
+			t.Fatalf("got state message: %q; expected no update", msg.Payload())
+		}
+	}
+	ensureSSID := func(want string) {
+		t.Helper()
+		select {
+		case <-time.After(1 * time.Second):
+			t.Fatal("timeout waiting for device attrs message")
+		case err := <-dt.errs:
+			t.Fatal(err)
+		case msg := <-testMACAttrs:
+			var attrs hass.Attrs
+			if err := json.Unmarshal(msg.Payload(), &attrs); err != nil {
+				t.Fatal(err)
+			}
+			if attrs.SSID != want {
+				t.Fatalf("got SSID %q; want %q", attrs.SSID, want)
+			}
+		}
+	}
+	sendEvent := func(hap chan string, event string) {
+		t.Helper()
+		select {
+		case hap <- event:
+			t.Logf("sent %q event", event)
+		case err := <-dt.errs:
+			t.Fatal(err)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timeout sending %q event", event)
+		}
+	}
+
+	ensureState(hass.PayloadNotHome)
+
+	// Both links connect.
+	sendEvent(h1attachMsgs, fmt.Sprintf("AP-STA-CONNECTED %s mld_addr=%s", testLink1, testMLD))
+	ensureState(hass.PayloadHome)
+	ensureSSID(h1SSID)
+	sendEvent(h2attachMsgs, fmt.Sprintf("AP-STA-CONNECTED %s mld_addr=%s", testLink2, testMLD))
+	ensureState(hass.PayloadHome)
+	ensureSSID(h2SSID)
+
+	// The second link drops, while the first remains.
+	sendEvent(h2attachMsgs, fmt.Sprintf("AP-STA-DISCONNECTED %s mld_addr=%s", testLink2, testMLD))
+	ensureSSID(h1SSID)
+	ensureNoState()
+
+	// The station departs once all links are gone.
+	sendEvent(h1attachMsgs, fmt.Sprintf("AP-STA-DISCONNECTED %s mld_addr=%s", testLink1, testMLD))
+	ensureState(hass.PayloadNotHome)
+}
+
+func TestDaemon_ConfirmDeparture(t *testing.T) {
+	if *mqttAddr == "" {
+		t.Skip("skipping test; not given mqttAddr")
+	}
+
+	const (
+		testMAC = "FF:FF:FF:FF:FF:FF"
+	)
+
+	staResp := []hostapdtest.StationResp{
+		{
+			MAC:    testMAC,
+			Assoc:  true,
+			Signal: 1,
+		},
+	}
+	h := hostapdtest.DefaultHostAPDHandler(hostapdtest.StatusResp{
+		State:      "Enabled",
+		Channel:    42,
+		SSID:       "A",
+		BSSID:      "AA:BB:CC:DD:EE:FF",
+		MaxTxPower: 11,
+	}, staResp)
+	attachMsgs := make(chan string)
+	h.OnAttach(func() <-chan string {
+		t.Log("Attached to hostapd")
+		return attachMsgs
+	})
+	// The station is no longer listed, but responds to polls
+	// until respond is set to false.
+	h.OnStation(func(mac string) (hostapdtest.StationResp, bool) {
+		return hostapdtest.StationResp{}, false
+	})
+	var respond atomic.Bool
+	respond.Store(true)
+	h.OnPollStation(func(mac string) bool {
+		if respond.Load() {
+			go func() { attachMsgs <- fmt.Sprintf("AP-STA-POLL-OK %s", testMAC) }()
+		}
+		return true
+	})
+
+	dt := newDaemonTestOpts(t, []Opt{WithConfirmDeparture(100 * time.Millisecond)}, h)
+
+	// Configure Daemon to track station.
+	trackingCfg := hass.Configuration{
+		Devices: []hass.TrackConfig{
+			{Name: "Test Subject", MAC: testMAC},
+		},
+	}
+	dt.pubTopic(dt.topics.Config(), true, trackingCfg)
+
+	// Subscribe to device's state and attrs topic.
+	testMACState := dt.subTopic(dt.topics.DeviceState(testMAC), true)
+	testMACAttrs := dt.subTopic(dt.topics.DeviceJSONAttrs(testMAC), true)
+
+	ensureState := func(want string) {
+		select {
+		case <-time.After(1 * time.Second):
+			t.Fatal("timeout waiting for device state message")
+		case err := <-dt.errs:
+			t.Fatal(err)
+		case msg := <-testMACState:
+			if got := string(msg.Payload()); got != want {
+				t.Fatalf("got state %q; want %q", got, want)
+			}
+		}
+	}
+	ensureCheck := func(wantConnected bool, wantCheck string) {
+		for {
+			select {
+			case <-time.After(1 * time.Second):
+				t.Fatal("timeout waiting for device attrs message")
+			case err := <-dt.errs:
+				t.Fatal(err)
+			case msg := <-testMACAttrs:
+				var attrs hass.Attrs
+				if err := json.Unmarshal(msg.Payload(), &attrs); err != nil {
+					t.Fatal(err)
+				}
+				if attrs.DepartureCheck == "" {
+					continue
+				}
+				if attrs.IsConnected != wantConnected || attrs.DepartureCheck != wantCheck {
+					t.Fatalf("got connected=%t, check=%q; want connected=%t, check=%q", attrs.IsConnected, attrs.DepartureCheck, wantConnected, wantCheck)
+				}
+				return
+			}
+		}
+	}
+	sendDisconnect := func() {
+		select {
+		case attachMsgs <- fmt.Sprintf("AP-STA-DISCONNECTED %s", testMAC):
+			t.Log("sent disconnect event")
+		case <-time.After(time.Second):
+			t.Fatal("timeout sending disconnect event")
+		}
+	}
+
+	ensureState(hass.PayloadHome)
+
+	// The station responds to the poll, so it remains connected.
+	sendDisconnect()
+	ensureCheck(true, "poll_ok")
+
+	// The station no longer responds to the poll.
+	respond.Store(false)
+	sendDisconnect()
+	ensureState(hass.PayloadNotHome)
+	ensureCheck(false, "no_poll_response")
+}
+
 func newDaemonTest(t *testing.T, hapHandlers ...*hostapdtest.Handler) *daemonTest {
+	return newDaemonTestOpts(t, nil, hapHandlers...)
+}
+
+// newDaemonTestOpts is like newDaemonTest, but includes the given
+// options when creating the Daemon.
+func newDaemonTestOpts(t *testing.T, opts []Opt, hapHandlers ...*hostapdtest.Handler) *daemonTest {
 	if *mqttAddr == "" {
 		t.Skip("skipping test; not given mqttAddr")
 	}
@@ -562,13 +997,13 @@ func newDaemonTest(t *testing.T, hapHandlers ...*hostapdtest.Handler) *daemonTes
 	)
 
 	// Create MQTT client used for pub/sub by the test itself.
-	opts := mqtt.NewClientOptions()
-	opts.SetClientID(fmt.Sprintf("%s-%d", t.Name(), uid))
-	opts.AddBroker(*mqttAddr)
-	opts.SetCleanSession(true)
-	opts.SetOrderMatters(true)
+	mqttOpts := mqtt.NewClientOptions()
+	mqttOpts.SetClientID(fmt.Sprintf("%s-%d", t.Name(), uid))
+	mqttOpts.AddBroker(*mqttAddr)
+	mqttOpts.SetCleanSession(true)
+	mqttOpts.SetOrderMatters(true)
 
-	mc := mqtt.NewClient(opts)
+	mc := mqtt.NewClient(mqttOpts)
 	tkn := mc.Connect()
 	if !tkn.WaitTimeout(time.Second) {
 		t.Fatal("mqtt connect timeout")
@@ -623,6 +1058,7 @@ func newDaemonTest(t *testing.T, hapHandlers ...*hostapdtest.Handler) *daemonTes
 	daemonOpts = append(daemonOpts, WithHassOpt(hm))
 	daemonOpts = append(daemonOpts, WithDebounce(0)) // Handle disconnect events immediately.
 	daemonOpts = append(daemonOpts, WithHASSAutodiscovery(true))
+	daemonOpts = append(daemonOpts, opts...)
 	d, err := NewDaemon(daemonOpts...)
 	if err != nil {
 		t.Fatal(err)
@@ -635,6 +1071,7 @@ func newDaemonTest(t *testing.T, hapHandlers ...*hostapdtest.Handler) *daemonTes
 
 	return &daemonTest{
 		t:      t,
+		d:      d,
 		errs:   errs,
 		topics: topics,
 		mc:     mc,
@@ -643,6 +1080,7 @@ func newDaemonTest(t *testing.T, hapHandlers ...*hostapdtest.Handler) *daemonTes
 
 type daemonTest struct {
 	t      *testing.T
+	d      *Daemon
 	errs   <-chan error
 	topics hass.MQTTTopics
 	mc     mqtt.Client
@@ -0,0 +1,140 @@
+package presence
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/awilliams/wifi-presence/internal/hass"
+)
+
+// setHost sets the hostname and IP addresses of the station's
+// DHCP leases, if any, in its attributes.
+func (d *Daemon) setHost(attrs *hass.Attrs) {
+	if d.leases == nil {
+		return
+	}
+	if host, ok := d.leases.Lookup(attrs.MAC); ok {
+		attrs.Hostname = host.Hostname
+		attrs.IPv4 = host.IPv4
+		attrs.IPv6 = host.IPv6
+	}
+}
+
+// matchHostname returns a new station if the hostname of the station's
+// lease matches a configured hostname pattern. d.mu must be held.
+func (d *Daemon) matchHostname(mac MAC) (station, bool) {
+	if d.leases == nil || len(d.patterns) == 0 {
+		return station{}, false
+	}
+	host, ok := d.leases.Lookup(mac.String())
+	if !ok {
+		return station{}, false
+	}
+
+	for _, p := range d.patterns {
+		if !p.MatchHostname(host.Hostname) {
+			continue
+		}
+		name := p.Name
+		if name == "" {
+			name = host.Hostname
+		}
+		return station{
+			name:    name,
+			mac:     mac,
+			pattern: p.Hostname,
+			mesh:    p.Mesh,
+			host:    host,
+		}, true
+	}
+	return station{}, false
+}
+
+// hasPattern returns true if the hostname pattern is configured.
+// d.mu must be held.
+func (d *Daemon) hasPattern(pattern string) bool {
+	if pattern == "" {
+		return false
+	}
+	for _, p := range d.patterns {
+		if p.Hostname == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// onLeasesChange handles a change of the DHCP leases. Connected stations
+// whose hostname now matches a configured pattern are tracked, and the
+// attributes of connected stations whose leases changed are republished.
+// Each station is handled using handle; errors encountered while handling
+// them are sent to errs.
+func (d *Daemon) onLeasesChange(ctx context.Context, errs chan<- error) error {
+	d.mu.Lock()
+	haps := append([]hap(nil), d.haps...)
+	hasPatterns := len(d.patterns) > 0
+	d.mu.Unlock()
+
+	// A station configured by hostname can only be matched once it
+	// has a lease, which is usually shortly after it has connected.
+	if hasPatterns {
+		for _, hap := range haps {
+			stations, err := d.sourceStations(ctx, hap)
+			if err != nil {
+				d.logger.Printf("%s: unable to list stations to match hostnames: %v", hap.bss.SSID, err)
+				continue
+			}
+			for _, sta := range stations {
+				if !sta.Associated {
+					continue
+				}
+				var mac MAC
+				if err := mac.Decode(sta.ID()); err != nil {
+					return err
+				}
+				d.mu.Lock()
+				_, tracked := d.stations[mac]
+				d.mu.Unlock()
+				if tracked {
+					continue
+				}
+				hap := hap
+				d.handle(ctx, mac, errs, func() error {
+					return d.onStationConnect(ctx, hap, mac)
+				})
+			}
+		}
+	}
+
+	var updated []MAC
+	d.mu.Lock()
+	for mac, sta := range d.stations {
+		host, _ := d.leases.Lookup(mac.String())
+		if reflect.DeepEqual(host, sta.host) {
+			continue
+		}
+		sta.host = host
+		d.stations[mac] = sta
+		if sta.connected {
+			updated = append(updated, mac)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, mac := range updated {
+		mac := mac
+		d.handle(ctx, mac, errs, func() error {
+			// The station's state may have changed
+			// while waiting to be handled.
+			d.mu.Lock()
+			sta, ok := d.stations[mac]
+			hap, found := d.hapByBSSID(sta.bssid)
+			d.mu.Unlock()
+			if !ok || !sta.connected || !found {
+				return nil
+			}
+			return d.publishConnected(ctx, hap, sta)
+		})
+	}
+	return nil
+}
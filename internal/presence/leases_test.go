@@ -0,0 +1,91 @@
+package presence
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/awilliams/wifi-presence/internal/dhcp"
+	"github.com/awilliams/wifi-presence/internal/hass"
+	"github.com/awilliams/wifi-presence/internal/hostapd"
+)
+
+func TestDaemon_Leases(t *testing.T) {
+	const testMAC = "FF:FF:FF:FF:FF:FF"
+
+	leaseFile := filepath.Join(t.TempDir(), "dhcp.leases")
+	writeLease := func(ip string) {
+		t.Helper()
+		lease := fmt.Sprintf("0 %s %s johns-iphone *\n", testMAC, ip)
+		if err := os.WriteFile(leaseFile, []byte(lease), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeLease("192.168.1.100")
+	leases := dhcp.NewLeases([]dhcp.File{{Path: leaseFile, Format: dhcp.FormatDnsmasq}})
+	if err := leases.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	src := &fakeSource{
+		ifname: "fake0",
+		status: hostapd.Status{
+			BSS: []hostapd.BSS{
+				{Interface: "fake0", SSID: "fake", BSSID: "AA:BB:CC:DD:EE:FF"},
+			},
+		},
+		stations: []hostapd.Station{
+			{MAC: testMAC, Associated: true},
+		},
+		events: make(chan hostapd.Event),
+	}
+
+	dt := newDaemonTestOpts(t, []Opt{WithSource(src), WithLeases(leases, 10*time.Millisecond)})
+
+	testMACState := dt.subTopic(dt.topics.DeviceState(testMAC), true)
+	testMACAttrs := dt.subTopic(dt.topics.DeviceJSONAttrs(testMAC), true)
+	// The device is configured by hostname only.
+	dt.pubTopic(dt.topics.Config(), true, hass.Configuration{
+		Devices: []hass.TrackConfig{
+			{Name: "John", Hostname: "*-iPhone"},
+		},
+	})
+
+	select {
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for device state message")
+	case err := <-dt.errs:
+		t.Fatal(err)
+	case msg := <-testMACState:
+		if got, want := string(msg.Payload()), hass.PayloadHome; got != want {
+			t.Fatalf("got state %q; want %q", got, want)
+		}
+	}
+
+	ensureAttrs := func(wantIPv4 string) {
+		t.Helper()
+		select {
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for device attributes message")
+		case err := <-dt.errs:
+			t.Fatal(err)
+		case msg := <-testMACAttrs:
+			var attrs hass.Attrs
+			if err := json.Unmarshal(msg.Payload(), &attrs); err != nil {
+				t.Fatal(err)
+			}
+			if attrs.Name != "John" || attrs.Hostname != "johns-iphone" || !reflect.DeepEqual(attrs.IPv4, []string{wantIPv4}) {
+				t.Fatalf("got attributes %+v; want name %q, hostname %q and IPv4 %q", attrs, "John", "johns-iphone", wantIPv4)
+			}
+		}
+	}
+	ensureAttrs("192.168.1.100")
+
+	// The attributes are republished when the lease changes.
+	writeLease("192.168.1.101")
+	ensureAttrs("192.168.1.101")
+}
@@ -0,0 +1,102 @@
+package presence
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// newEventQueue returns an eventQueue that holds at most size
+// pending callbacks.
+func newEventQueue(size int) *eventQueue {
+	return &eventQueue{
+		slots:   make(chan struct{}, size),
+		pending: make(map[MAC][]func()),
+	}
+}
+
+// eventQueue runs callbacks in the order they were enqueued for each MAC,
+// and concurrently for different MACs. The callbacks of a MAC are run by a
+// goroutine that exits once there are none pending.
+type eventQueue struct {
+	// Holds a value for each pending callback, bounding their number.
+	slots chan struct{}
+
+	mu          sync.Mutex // Protects following.
+	pending     map[MAC][]func()
+	maxQueued   int
+	handled     uint64
+	blocked     uint64
+	blockedTime time.Duration
+}
+
+// enqueue adds the callback to those of the MAC. If the queue is full, then
+// enqueue blocks until there is room, returning the time spent waiting. An
+// error is returned if the context is done before the callback is enqueued.
+func (q *eventQueue) enqueue(ctx context.Context, mac MAC, cb func()) (time.Duration, error) {
+	var waited time.Duration
+	select {
+	case q.slots <- struct{}{}:
+	default:
+		// Queue is full; wait for a callback to complete.
+		start := time.Now()
+		select {
+		case q.slots <- struct{}{}:
+		case <-ctx.Done():
+			return time.Since(start), ctx.Err()
+		}
+		waited = time.Since(start)
+	}
+
+	q.mu.Lock()
+	cbs, running := q.pending[mac]
+	q.pending[mac] = append(cbs, cb)
+	if n := len(q.slots); n > q.maxQueued {
+		q.maxQueued = n
+	}
+	if waited > 0 {
+		q.blocked++
+		q.blockedTime += waited
+	}
+	q.mu.Unlock()
+
+	if !running {
+		go q.run(mac)
+	}
+	return waited, nil
+}
+
+// run runs the callbacks of the MAC until none are pending.
+func (q *eventQueue) run(mac MAC) {
+	for {
+		q.mu.Lock()
+		cbs := q.pending[mac]
+		if len(cbs) == 0 {
+			delete(q.pending, mac)
+			q.mu.Unlock()
+			return
+		}
+		cb := cbs[0]
+		q.pending[mac] = cbs[1:]
+		q.mu.Unlock()
+
+		cb()
+
+		q.mu.Lock()
+		q.handled++
+		q.mu.Unlock()
+		<-q.slots
+	}
+}
+
+// stats sets the queue's counters of s.
+func (q *eventQueue) stats(s *Stats) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s.Queued = len(q.slots)
+	s.QueueSize = cap(q.slots)
+	s.MaxQueued = q.maxQueued
+	s.Handled = q.handled
+	s.Blocked = q.blocked
+	s.BlockedTime = q.blockedTime
+}
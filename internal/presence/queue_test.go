@@ -0,0 +1,99 @@
+package presence
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEventQueue_Order(t *testing.T) {
+	var (
+		mac1 = MAC{0xFF, 0xBE, 0xEF, 0x00, 0x00, 0x01}
+		mac2 = MAC{0xFF, 0xBE, 0xEF, 0x00, 0x00, 0x02}
+	)
+
+	q := newEventQueue(16)
+
+	// The callbacks of mac1 wait until unblocked, which
+	// does not hold up those of mac2.
+	unblock := make(chan struct{})
+	got1 := make(chan int, 3)
+	for i := 1; i <= 3; i++ {
+		i := i
+		if _, err := q.enqueue(context.Background(), mac1, func() {
+			<-unblock
+			got1 <- i
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got2 := make(chan int, 1)
+	if _, err := q.enqueue(context.Background(), mac2, func() { got2 <- 1 }); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-got2:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for callback of other MAC")
+	}
+
+	close(unblock)
+	for want := 1; want <= 3; want++ {
+		select {
+		case got := <-got1:
+			if got != want {
+				t.Fatalf("got callback %d; want %d", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for callback %d", want)
+		}
+	}
+}
+
+func TestEventQueue_Full(t *testing.T) {
+	mac := MAC{0xFF, 0xBE, 0xEF, 0x00, 0x00, 0x00}
+
+	q := newEventQueue(2)
+
+	unblock := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		if _, err := q.enqueue(context.Background(), mac, func() { <-unblock }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The queue is full.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := q.enqueue(ctx, mac, func() {}); err != context.DeadlineExceeded {
+		t.Fatalf("got enqueue() err %v; want %v", err, context.DeadlineExceeded)
+	}
+
+	// Room is made once a callback completes.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		unblock <- struct{}{}
+	}()
+	done := make(chan struct{})
+	waited, err := q.enqueue(context.Background(), mac, func() { close(done) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if waited <= 0 {
+		t.Fatalf("got waited %s; want > 0", waited)
+	}
+	close(unblock)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for callback")
+	}
+
+	var s Stats
+	q.stats(&s)
+	if s.QueueSize != 2 || s.MaxQueued != 2 || s.Blocked != 1 || s.BlockedTime < waited {
+		t.Fatalf("got stats %+v", s)
+	}
+}
@@ -0,0 +1,76 @@
+package presence
+
+import (
+	"context"
+
+	"github.com/awilliams/wifi-presence/internal/hostapd"
+)
+
+// Source is a source of stations connecting to and disconnecting from an
+// access point, such as a hostapd control interface. Sources are compared
+// using ==, so implementations are typically pointers.
+//
+// A Source may optionally implement the following methods, which are used
+// when available:
+//
+//	// Reconnect is called when Attach returns hostapd.ErrTerminating or
+//	// hostapd.ErrUnresponsive, after which Attach is called again.
+//	// See WithReattach.
+//	Reconnect(ctx context.Context) error
+//
+//	// Health is logged when reconnecting.
+//	Health() hostapd.Health
+//
+//	// Station and PollStation are used to confirm departures.
+//	// See WithConfirmDeparture.
+//	Station(ctx context.Context, mac string) (hostapd.Station, error)
+//	PollStation(ctx context.Context, mac string) error
+//
+// *hostapd.Client implements Source, including the optional methods.
+type Source interface {
+	// Interface returns the name of the network interface the source
+	// belongs to. It is used to find the source's BSS in its Status.
+	Interface() string
+	// Status returns the status of the access point.
+	Status(ctx context.Context) (hostapd.Status, error)
+	// Stations returns a snapshot of the stations connected to the
+	// access point. hostapd.ErrUnknownCmd is returned if the source
+	// is unable to list stations.
+	Stations(ctx context.Context) ([]hostapd.Station, error)
+	// Attach calls events with each event of the access point until
+	// the context is done, or an error occurs. Events other than
+	// hostapd.EventStationConnect and hostapd.EventStationDisconnect
+	// are ignored by the daemon.
+	Attach(ctx context.Context, events func(hostapd.Event) error) error
+}
+
+var _ Source = (*hostapd.Client)(nil)
+
+// SourceWatcher reports a set of sources that changes while the daemon is
+// running, such as the interfaces of remote agents as they come and go.
+type SourceWatcher interface {
+	// Watch calls sources with the current set of sources each time it
+	// changes, until the context is done or an error occurs. Sources that
+	// are no longer included are removed from the daemon, and any stations
+	// connected to them are considered disconnected.
+	Watch(ctx context.Context, sources func([]Source) error) error
+}
+
+// reconnecter is implemented by sources that are able to reconnect
+// after Attach returns.
+type reconnecter interface {
+	Reconnect(ctx context.Context) error
+}
+
+// healther is implemented by sources that report the health
+// of their connection.
+type healther interface {
+	Health() hostapd.Health
+}
+
+// stationPoller is implemented by sources that can query and poll
+// individual stations.
+type stationPoller interface {
+	Station(ctx context.Context, mac string) (hostapd.Station, error)
+	PollStation(ctx context.Context, mac string) error
+}
@@ -0,0 +1,393 @@
+package presence
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/awilliams/wifi-presence/internal/hass"
+	"github.com/awilliams/wifi-presence/internal/hostapd"
+	"github.com/awilliams/wifi-presence/internal/radius"
+)
+
+// fakeSource is a Source whose stations and events are
+// controlled by the test.
+type fakeSource struct {
+	ifname   string
+	status   hostapd.Status
+	stations []hostapd.Station // Protected by mu once the daemon is running.
+	err      error             // Returned by Stations, if set.
+	events   chan hostapd.Event
+
+	mu sync.Mutex
+}
+
+func (f *fakeSource) Interface() string {
+	return f.ifname
+}
+
+func (f *fakeSource) Status(context.Context) (hostapd.Status, error) {
+	return f.status, nil
+}
+
+func (f *fakeSource) Stations(context.Context) ([]hostapd.Station, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stations, nil
+}
+
+// setStations sets the stations listed by the source.
+func (f *fakeSource) setStations(stations ...hostapd.Station) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stations = stations
+}
+
+func (f *fakeSource) Attach(ctx context.Context, events func(hostapd.Event) error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e := <-f.events:
+			if err := events(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func TestDaemon_Source(t *testing.T) {
+	const testMAC = "FF:FF:FF:FF:FF:FF"
+
+	src := &fakeSource{
+		ifname: "fake0",
+		status: hostapd.Status{
+			BSS: []hostapd.BSS{
+				{Interface: "fake0", SSID: "fake", BSSID: "AA:BB:CC:DD:EE:FF"},
+			},
+		},
+		stations: []hostapd.Station{
+			{MAC: testMAC, Associated: true},
+		},
+		events: make(chan hostapd.Event),
+	}
+
+	dt := newDaemonTestOpts(t, []Opt{WithSource(src)})
+
+	testMACState := dt.subTopic(dt.topics.DeviceState(testMAC), true)
+	dt.pubTopic(dt.topics.Config(), true, hass.Configuration{
+		Devices: []hass.TrackConfig{
+			{Name: "Test Subject", MAC: testMAC},
+		},
+	})
+
+	ensureState := func(want string) {
+		t.Helper()
+		select {
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for device state message")
+		case err := <-dt.errs:
+			t.Fatal(err)
+		case msg := <-testMACState:
+			if got := string(msg.Payload()); got != want {
+				t.Fatalf("got state %q; want %q", got, want)
+			}
+		}
+	}
+	sendEvent := func(e hostapd.Event) {
+		t.Helper()
+		select {
+		case src.events <- e:
+		case <-time.After(time.Second):
+			t.Fatalf("timeout sending %T", e)
+		}
+	}
+
+	// The station is listed by the source.
+	ensureState(hass.PayloadHome)
+
+	sendEvent(hostapd.EventStationDisconnect{MAC: testMAC})
+	ensureState(hass.PayloadNotHome)
+
+	sendEvent(hostapd.EventStationConnect{MAC: testMAC})
+	ensureState(hass.PayloadHome)
+}
+
+func TestDaemon_StationFallback(t *testing.T) {
+	const testMAC = "FF:FF:FF:FF:FF:FF"
+
+	src := &fakeSource{
+		ifname: "fake0",
+		status: hostapd.Status{
+			BSS: []hostapd.BSS{
+				{Interface: "fake0", SSID: "fake", BSSID: "AA:BB:CC:DD:EE:FF"},
+			},
+		},
+		// Like hostapd-mini, the source is unable to list stations.
+		err:    hostapd.ErrUnknownCmd("STA-FIRST"),
+		events: make(chan hostapd.Event),
+	}
+	fallback := func(_ context.Context, ifname string) ([]hostapd.Station, error) {
+		if ifname != src.ifname {
+			t.Errorf("got fallback interface %q; want %q", ifname, src.ifname)
+		}
+		return []hostapd.Station{{MAC: testMAC, Associated: true}}, nil
+	}
+
+	dt := newDaemonTestOpts(t, []Opt{WithSource(src), WithStationFallback(fallback)})
+
+	testMACState := dt.subTopic(dt.topics.DeviceState(testMAC), true)
+	dt.pubTopic(dt.topics.Config(), true, hass.Configuration{
+		Devices: []hass.TrackConfig{
+			{Name: "Test Subject", MAC: testMAC},
+		},
+	})
+
+	select {
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for device state message")
+	case err := <-dt.errs:
+		t.Fatal(err)
+	case msg := <-testMACState:
+		if got, want := string(msg.Payload()), hass.PayloadHome; got != want {
+			t.Fatalf("got state %q; want %q", got, want)
+		}
+	}
+}
+
+func TestDaemon_Reconcile(t *testing.T) {
+	const testMAC = "FF:FF:FF:FF:FF:FF"
+
+	src := &fakeSource{
+		ifname: "fake0",
+		status: hostapd.Status{
+			BSS: []hostapd.BSS{
+				{Interface: "fake0", SSID: "fake", BSSID: "AA:BB:CC:DD:EE:FF"},
+			},
+		},
+		stations: []hostapd.Station{
+			{MAC: testMAC, Associated: true},
+		},
+		events: make(chan hostapd.Event),
+	}
+
+	dt := newDaemonTestOpts(t, []Opt{WithSource(src), WithReconcileInterval(50 * time.Millisecond)})
+
+	testMACState := dt.subTopic(dt.topics.DeviceState(testMAC), true)
+	dt.pubTopic(dt.topics.Config(), true, hass.Configuration{
+		Devices: []hass.TrackConfig{
+			{Name: "Test Subject", MAC: testMAC},
+		},
+	})
+
+	ensureState := func(want string) {
+		t.Helper()
+		select {
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for device state message")
+		case err := <-dt.errs:
+			t.Fatal(err)
+		case msg := <-testMACState:
+			if got := string(msg.Payload()); got != want {
+				t.Fatalf("got state %q; want %q", got, want)
+			}
+		}
+	}
+
+	ensureState(hass.PayloadHome)
+
+	// The disconnect event is missed.
+	src.setStations()
+	ensureState(hass.PayloadNotHome)
+
+	// As is the connect event.
+	src.setStations(hostapd.Station{MAC: testMAC, Associated: true})
+	ensureState(hass.PayloadHome)
+
+	if got := dt.d.Stats().Reconciled; got != 2 {
+		t.Fatalf("got %d reconciled stations; want 2", got)
+	}
+}
+
+// fakeWatcher is a SourceWatcher whose sources are set by the test.
+type fakeWatcher struct {
+	sources chan []Source
+}
+
+func (f *fakeWatcher) Watch(ctx context.Context, sources func([]Source) error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case srcs := <-f.sources:
+			if err := sources(srcs); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func TestDaemon_SourceWatcher(t *testing.T) {
+	const testMAC = "FF:FF:FF:FF:FF:FF"
+
+	src := &fakeSource{
+		ifname: "fake0",
+		status: hostapd.Status{
+			BSS: []hostapd.BSS{
+				{Interface: "fake0", SSID: "fake", BSSID: "AA:BB:CC:DD:EE:FF"},
+			},
+		},
+		stations: []hostapd.Station{
+			{MAC: testMAC, Associated: true},
+		},
+		events: make(chan hostapd.Event),
+	}
+	w := &fakeWatcher{sources: make(chan []Source)}
+
+	dt := newDaemonTestOpts(t, []Opt{WithSourceWatcher(w), WithDebounce(10 * time.Millisecond)})
+
+	testMACState := dt.subTopic(dt.topics.DeviceState(testMAC), true)
+	dt.pubTopic(dt.topics.Config(), true, hass.Configuration{
+		Devices: []hass.TrackConfig{
+			{Name: "Test Subject", MAC: testMAC},
+		},
+	})
+
+	ensureState := func(want string) {
+		t.Helper()
+		select {
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for device state message")
+		case err := <-dt.errs:
+			t.Fatal(err)
+		case msg := <-testMACState:
+			if got := string(msg.Payload()); got != want {
+				t.Fatalf("got state %q; want %q", got, want)
+			}
+		}
+	}
+	setSources := func(sources ...Source) {
+		t.Helper()
+		select {
+		case w.sources <- sources:
+		case <-time.After(time.Second):
+			t.Fatal("timeout setting sources")
+		}
+	}
+
+	ensureState(hass.PayloadNotHome)
+
+	// The station is listed by the added source.
+	setSources(src)
+	ensureState(hass.PayloadHome)
+
+	// The station departs once its source is removed.
+	setSources()
+	ensureState(hass.PayloadNotHome)
+}
+
+func TestDaemon_RadiusSources(t *testing.T) {
+	const (
+		testMAC = "FF:FF:FF:FF:FF:FF"
+		secret  = "s3cr3t"
+	)
+
+	// Both servers are sources at once, with the station
+	// having a session on each.
+	var (
+		srcs []*radius.Accounting
+		opts []Opt
+	)
+	for i := 0; i < 2; i++ {
+		acct, err := radius.Listen("127.0.0.1:0", []byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { acct.Close() })
+		srcs = append(srcs, acct)
+		opts = append(opts, WithSource(acct))
+	}
+
+	dt := newDaemonTestOpts(t, opts)
+
+	testMACState := dt.subTopic(dt.topics.DeviceState(testMAC), true)
+	dt.pubTopic(dt.topics.Config(), true, hass.Configuration{
+		Devices: []hass.TrackConfig{
+			{Name: "Test Subject", MAC: testMAC},
+		},
+	})
+
+	ensureState := func(want string) {
+		t.Helper()
+		select {
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for device state message")
+		case err := <-dt.errs:
+			t.Fatal(err)
+		case msg := <-testMACState:
+			if got := string(msg.Payload()); got != want {
+				t.Fatalf("got state %q; want %q", got, want)
+			}
+		}
+	}
+	ensureNoState := func() {
+		t.Helper()
+		select {
+		case <-time.After(250 * time.Millisecond):
+		case err := <-dt.errs:
+			t.Fatal(err)
+		case msg := <-testMACState:
+			t.Fatalf("got state %q; expected no update", msg.Payload())
+		}
+	}
+	account := func(acct *radius.Accounting, status radius.StatusType) {
+		t.Helper()
+		statusVal := make([]byte, 4)
+		binary.BigEndian.PutUint32(statusVal, uint32(status))
+		req := radius.Packet{
+			Code: radius.CodeAccountingRequest,
+			Attrs: []radius.Attr{
+				{Type: radius.AttrAcctStatusType, Value: statusVal},
+				{Type: radius.AttrCallingStationID, Value: []byte(testMAC)},
+				{Type: radius.AttrAcctSessionID, Value: []byte("1")},
+			},
+		}
+		b, err := req.EncodeRequest([]byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := net.Dial("udp", acct.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err = conn.Write(b); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err = conn.Read(make([]byte, 4096)); err != nil {
+			t.Fatalf("no accounting response: %v", err)
+		}
+	}
+
+	ensureState(hass.PayloadNotHome)
+
+	account(srcs[0], radius.StatusStart)
+	ensureState(hass.PayloadHome)
+	// The station roams to the other server's BSS.
+	account(srcs[1], radius.StatusStart)
+	ensureState(hass.PayloadHome)
+
+	// The station is still connected to the other server's BSS.
+	account(srcs[0], radius.StatusStop)
+	ensureNoState()
+
+	account(srcs[1], radius.StatusStop)
+	ensureState(hass.PayloadNotHome)
+}
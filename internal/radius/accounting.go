@@ -0,0 +1,358 @@
+package radius
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/awilliams/wifi-presence/internal/hostapd"
+)
+
+// DefaultAddr is the address of the standard RADIUS accounting port.
+const DefaultAddr = ":1813"
+
+// sourceName is the name used as the interface and SSID of Accounting.
+const sourceName = "radius"
+
+// Opt is a configuration option for Accounting.
+type Opt func(*Accounting)
+
+// WithSessionTimeout sets the time after which a session for which no
+// accounting request has been received is considered stopped, e.g. because the
+// Stop request was lost. It should be longer than the interim update interval
+// of the access points. 0 disables the timeout, which is the default.
+func WithSessionTimeout(timeout time.Duration) Opt {
+	return func(a *Accounting) {
+		a.timeout = timeout
+	}
+}
+
+// WithLogger sets the logger of Accounting, which logs
+// responses that could not be sent.
+func WithLogger(l *log.Logger) Opt {
+	return func(a *Accounting) {
+		a.logger = l
+	}
+}
+
+// Listen returns an Accounting server listening on the UDP address, e.g.
+// DefaultAddr. Requests are validated using the shared secret.
+func Listen(addr string, secret []byte, opts ...Opt) (*Accounting, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("shared secret cannot be empty")
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	a := Accounting{
+		conn:     conn,
+		secret:   secret,
+		bssid:    hostapd.PseudoBSSID(sourceName + "/" + conn.LocalAddr().String()),
+		sessions: make(map[string]session),
+	}
+	for _, opt := range opts {
+		opt(&a)
+	}
+	if a.logger == nil {
+		a.logger = log.New(io.Discard, "", 0)
+	}
+	return &a, nil
+}
+
+// Accounting is a RADIUS accounting server. Stations are connected while they
+// have an accounting session, which an access point (the NAS) starts with an
+// Accounting-Start request and ends with an Accounting-Stop request. Stations
+// are identified by the Calling-Station-Id attribute.
+//
+// Accounting implements presence.Source.
+type Accounting struct {
+	conn    net.PacketConn
+	secret  []byte
+	timeout time.Duration
+	bssid   string // Pseudo BSSID, derived from the listening address.
+	logger  *log.Logger
+
+	mu       sync.Mutex // Protects following.
+	sessions map[string]session
+}
+
+// session is an accounting session.
+type session struct {
+	mac     string
+	nas     string // Address of the NAS.
+	updated time.Time
+}
+
+// Addr returns the address the server is listening on.
+func (a *Accounting) Addr() net.Addr {
+	return a.conn.LocalAddr()
+}
+
+// Close stops the server.
+func (a *Accounting) Close() error {
+	return a.conn.Close()
+}
+
+// Interface returns "radius".
+func (a *Accounting) Interface() string {
+	return sourceName
+}
+
+// Status returns a status with a single BSS named "radius". Stations are not
+// attributed to the access point they are connected to. The BSSID is a pseudo
+// BSSID derived from the listening address, distinguishing the server from
+// other sources.
+func (a *Accounting) Status(ctx context.Context) (hostapd.Status, error) {
+	return hostapd.Status{
+		State: "ENABLED",
+		SSID:  sourceName,
+		BSSID: a.bssid,
+		BSS: []hostapd.BSS{
+			{Interface: sourceName, SSID: sourceName, BSSID: a.bssid},
+		},
+	}, nil
+}
+
+// Stations returns the stations with an accounting session.
+func (a *Accounting) Stations(ctx context.Context) ([]hostapd.Station, error) {
+	a.mu.Lock()
+	macs := a.macs()
+	a.mu.Unlock()
+
+	stations := make([]hostapd.Station, 0, len(macs))
+	for _, mac := range sortedMACs(macs) {
+		stations = append(stations, hostapd.Station{
+			MAC:        mac,
+			Associated: true,
+		})
+	}
+	return stations, nil
+}
+
+// macs returns the MACs of all stations with a session. a.mu must be held.
+func (a *Accounting) macs() map[string]bool {
+	macs := make(map[string]bool, len(a.sessions))
+	for _, s := range a.sessions {
+		macs[s.mac] = true
+	}
+	return macs
+}
+
+// Attach serves accounting requests, calling events with a station connect
+// event when a station's first session starts, and a disconnect event when its
+// last session stops. Requests with an invalid authenticator are discarded.
+// It blocks until the context is done, or an error occurs.
+func (a *Accounting) Attach(ctx context.Context, events func(hostapd.Event) error) error {
+	// Interrupt reading once the context is done. The read deadline is
+	// only set while holding deadlineMu, so that the deadline set on
+	// cancelation cannot be overwritten by the loop below.
+	var deadlineMu sync.Mutex
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			deadlineMu.Lock()
+			a.conn.SetReadDeadline(time.Now())
+			deadlineMu.Unlock()
+		case <-done:
+		}
+	}()
+	defer func() {
+		close(done)
+		<-exited
+	}()
+
+	var (
+		buf = make([]byte, maxPacketLen)
+		// Sessions are expired at a fixed interval, regardless of
+		// how often requests are received.
+		nextExpire = time.Now().Add(a.timeout / 2)
+	)
+	for {
+		deadlineMu.Lock()
+		if ctx.Err() == nil {
+			var deadline time.Time
+			if a.timeout > 0 {
+				deadline = nextExpire
+			}
+			a.conn.SetReadDeadline(deadline)
+		}
+		deadlineMu.Unlock()
+
+		n, src, err := a.conn.ReadFrom(buf)
+		if ctx.Err() != nil {
+			return nil
+		}
+		switch {
+		case errors.Is(err, os.ErrDeadlineExceeded):
+		case err != nil:
+			return err
+		default:
+			if err = a.notify(a.handle(buf[:n], src), events); err != nil {
+				return err
+			}
+		}
+
+		if now := time.Now(); a.timeout > 0 && !now.Before(nextExpire) {
+			if err = a.notify(a.expire(now), events); err != nil {
+				return err
+			}
+			nextExpire = now.Add(a.timeout / 2)
+		}
+	}
+}
+
+// notify calls events with a connect or disconnect event for each of the
+// stations whose presence changed, in order of MAC address.
+func (a *Accounting) notify(changes map[string]bool, events func(hostapd.Event) error) error {
+	for _, mac := range sortedMACs(changes) {
+		name := "AP-STA-DISCONNECTED"
+		if changes[mac] {
+			name = "AP-STA-CONNECTED"
+		}
+		e, err := hostapd.ParseEvent(fmt.Sprintf("%s %s", name, mac))
+		if err != nil {
+			return err
+		}
+		if err = events(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handle handles a single request, returning the stations whose presence
+// changed, mapped to whether they are now present. Invalid requests are
+// discarded. The request is recorded before it is responded to, so a
+// response that cannot be sent is logged, and the NAS left to retry.
+func (a *Accounting) handle(b []byte, src net.Addr) map[string]bool {
+	if VerifyRequest(b, a.secret) != nil {
+		return nil
+	}
+	req, err := Parse(b)
+	if err != nil || req.Code != CodeAccountingRequest {
+		return nil
+	}
+
+	changes := a.record(req, src)
+
+	resp := Packet{
+		Code:       CodeAccountingResponse,
+		Identifier: req.Identifier,
+	}
+	// Proxy-State attributes must be copied to the response.
+	for _, attr := range req.Attrs {
+		if attr.Type == AttrProxyState {
+			resp.Attrs = append(resp.Attrs, attr)
+		}
+	}
+	p, err := resp.EncodeResponse(req, a.secret)
+	if err == nil {
+		_, err = a.conn.WriteTo(p, src)
+	}
+	if err != nil {
+		a.logger.Printf("%s: unable to respond to %s: %v", sourceName, src, err)
+	}
+	return changes
+}
+
+// record updates the sessions of an accounting request from src, returning
+// the stations whose presence changed.
+func (a *Accounting) record(req Packet, src net.Addr) map[string]bool {
+	status, _ := req.Uint32(AttrAcctStatusType)
+	nas := src.String()
+	if addr, ok := src.(*net.UDPAddr); ok {
+		nas = addr.IP.String()
+	}
+	mac := parseMAC(req.String(AttrCallingStationID))
+	key := nas + "/" + req.String(AttrAcctSessionID)
+	if req.String(AttrAcctSessionID) == "" {
+		key += mac
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	before := a.macs()
+	switch StatusType(status) {
+	case StatusStart, StatusInterimUpdate:
+		if mac == "" {
+			return nil
+		}
+		a.sessions[key] = session{mac: mac, nas: nas, updated: time.Now()}
+	case StatusStop:
+		delete(a.sessions, key)
+	case StatusAccountingOn, StatusAccountingOff:
+		// The NAS has restarted, or is stopping, ending all of its sessions.
+		for k, s := range a.sessions {
+			if s.nas == nas {
+				delete(a.sessions, k)
+			}
+		}
+	}
+	return diff(before, a.macs())
+}
+
+// expire removes the sessions that have timed out, returning the
+// stations that are no longer present.
+func (a *Accounting) expire(now time.Time) map[string]bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	before := a.macs()
+	for k, s := range a.sessions {
+		if now.Sub(s.updated) > a.timeout {
+			delete(a.sessions, k)
+		}
+	}
+	return diff(before, a.macs())
+}
+
+// diff returns the MACs that were added or removed, mapped
+// to whether they were added.
+func diff(before, after map[string]bool) map[string]bool {
+	changes := make(map[string]bool)
+	for mac := range after {
+		if !before[mac] {
+			changes[mac] = true
+		}
+	}
+	for mac := range before {
+		if !after[mac] {
+			changes[mac] = false
+		}
+	}
+	return changes
+}
+
+// parseMAC parses a Calling-Station-Id, which is commonly a MAC address in
+// the form "04-AB-00-12-34-56", "04:ab:00:12:34:56" or "04ab00123456". An
+// empty string is returned if it is not a MAC address.
+func parseMAC(v string) string {
+	v = strings.NewReplacer("-", "", ":", "", ".", "").Replace(v)
+	b, err := hex.DecodeString(v)
+	if err != nil || len(b) != 6 {
+		return ""
+	}
+	return net.HardwareAddr(b).String()
+}
+
+func sortedMACs(macs map[string]bool) []string {
+	sorted := make([]string, 0, len(macs))
+	for mac := range macs {
+		sorted = append(sorted, mac)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,335 @@
+package radius
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/awilliams/wifi-presence/internal/hostapd"
+)
+
+// nas is an access point sending accounting requests.
+type nas struct {
+	t      *testing.T
+	conn   net.Conn
+	secret []byte
+	id     uint8
+}
+
+func newNAS(t *testing.T, addr net.Addr, secret string) *nas {
+	t.Helper()
+	conn, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &nas{t: t, conn: conn, secret: []byte(secret)}
+}
+
+// send sends an accounting request, returning whether a valid response was received.
+func (n *nas) send(status StatusType, mac, sessionID string) bool {
+	n.t.Helper()
+
+	statusVal := make([]byte, 4)
+	binary.BigEndian.PutUint32(statusVal, uint32(status))
+	n.id++
+	req := Packet{
+		Code:       CodeAccountingRequest,
+		Identifier: n.id,
+		Attrs: []Attr{
+			{Type: AttrAcctStatusType, Value: statusVal},
+			{Type: AttrProxyState, Value: []byte("proxy")},
+		},
+	}
+	if mac != "" {
+		req.Attrs = append(req.Attrs, Attr{Type: AttrCallingStationID, Value: []byte(mac)})
+	}
+	if sessionID != "" {
+		req.Attrs = append(req.Attrs, Attr{Type: AttrAcctSessionID, Value: []byte(sessionID)})
+	}
+	b, err := req.EncodeRequest(n.secret)
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	if _, err = n.conn.Write(b); err != nil {
+		n.t.Fatal(err)
+	}
+
+	buf := make([]byte, maxPacketLen)
+	n.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	size, err := n.conn.Read(buf)
+	if err != nil {
+		return false
+	}
+	if err = VerifyResponse(buf[:size], req, n.secret); err != nil {
+		n.t.Fatalf("invalid response: %v", err)
+	}
+	resp, err := Parse(buf[:size])
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	if resp.Code != CodeAccountingResponse || resp.Identifier != req.Identifier {
+		n.t.Fatalf("got response code %d, identifier %d; want %d, %d", resp.Code, resp.Identifier, CodeAccountingResponse, req.Identifier)
+	}
+	if proxy := resp.String(AttrProxyState); proxy != "proxy" {
+		n.t.Fatalf("got Proxy-State %q; want %q", proxy, "proxy")
+	}
+	return true
+}
+
+func TestAccounting(t *testing.T) {
+	const (
+		secret  = "s3cr3t"
+		testMAC = "04:ab:00:12:34:56"
+	)
+
+	acct, err := Listen("127.0.0.1:0", []byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acct.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan hostapd.Event, 4)
+	attachErr := make(chan error, 1)
+	go func() {
+		attachErr <- acct.Attach(ctx, func(e hostapd.Event) error {
+			events <- e
+			return nil
+		})
+	}()
+
+	expectEvent := func(want string) {
+		t.Helper()
+		select {
+		case got := <-events:
+			if got.Raw() != want {
+				t.Fatalf("got event %q; want %q", got.Raw(), want)
+			}
+		case err := <-attachErr:
+			t.Fatalf("Attach() err: %v", err)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for event")
+		}
+	}
+	expectNoEvent := func() {
+		t.Helper()
+		select {
+		case e := <-events:
+			t.Fatalf("got unexpected event %q", e.Raw())
+		default:
+		}
+	}
+	stations := func(want ...string) {
+		t.Helper()
+		got, err := acct.Stations(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var macs []string
+		for _, sta := range got {
+			macs = append(macs, sta.MAC)
+		}
+		if !reflect.DeepEqual(macs, want) {
+			t.Fatalf("got Stations() %v; want %v", macs, want)
+		}
+	}
+
+	ap := newNAS(t, acct.Addr(), secret)
+
+	// Requests using a different secret are discarded.
+	if newNAS(t, acct.Addr(), "wrong").send(StatusStart, "04-AB-00-12-34-56", "1") {
+		t.Fatal("got response to request with invalid secret")
+	}
+	stations()
+
+	if !ap.send(StatusStart, "04-AB-00-12-34-56", "1") {
+		t.Fatal("no response")
+	}
+	expectEvent("AP-STA-CONNECTED " + testMAC)
+	stations(testMAC)
+
+	// A second session, e.g. after roaming, and interim updates
+	// of an existing session do not change the station's presence.
+	ap.send(StatusStart, "04-AB-00-12-34-56", "2")
+	ap.send(StatusInterimUpdate, "04-AB-00-12-34-56", "1")
+	ap.send(StatusStop, "04-AB-00-12-34-56", "1")
+	expectNoEvent()
+
+	ap.send(StatusStop, "04-AB-00-12-34-56", "2")
+	expectEvent("AP-STA-DISCONNECTED " + testMAC)
+	stations()
+
+	// An interim update of an unknown session, e.g. after a restart.
+	ap.send(StatusInterimUpdate, "04ab00123456", "3")
+	expectEvent("AP-STA-CONNECTED " + testMAC)
+
+	// The access point restarts.
+	ap.send(StatusAccountingOn, "", "")
+	expectEvent("AP-STA-DISCONNECTED " + testMAC)
+	stations()
+
+	cancel()
+	select {
+	case err := <-attachErr:
+		if err != nil {
+			t.Fatalf("Attach() err: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for Attach to return")
+	}
+}
+
+func TestAccounting_sessionTimeout(t *testing.T) {
+	const secret = "s3cr3t"
+
+	acct, err := Listen("127.0.0.1:0", []byte(secret), WithSessionTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acct.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan hostapd.Event, 2)
+	go acct.Attach(ctx, func(e hostapd.Event) error {
+		events <- e
+		return nil
+	})
+
+	newNAS(t, acct.Addr(), secret).send(StatusStart, "04-AB-00-12-34-56", "1")
+	for _, want := range []string{"AP-STA-CONNECTED 04:ab:00:12:34:56", "AP-STA-DISCONNECTED 04:ab:00:12:34:56"} {
+		select {
+		case got := <-events:
+			if got.Raw() != want {
+				t.Fatalf("got event %q; want %q", got.Raw(), want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for event")
+		}
+	}
+}
+
+func TestAccounting_sessionTimeout_busy(t *testing.T) {
+	const secret = "s3cr3t"
+
+	acct, err := Listen("127.0.0.1:0", []byte(secret), WithSessionTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acct.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan hostapd.Event, 4)
+	go acct.Attach(ctx, func(e hostapd.Event) error {
+		events <- e
+		return nil
+	})
+
+	// A session is expired even while requests for another
+	// session are received more often than the timeout.
+	n := newNAS(t, acct.Addr(), secret)
+	n.send(StatusStart, "04-AB-00-12-34-56", "1")
+	n.send(StatusStart, "04-AB-00-12-34-57", "2")
+	want := []string{
+		"AP-STA-CONNECTED 04:ab:00:12:34:56",
+		"AP-STA-CONNECTED 04:ab:00:12:34:57",
+		"AP-STA-DISCONNECTED 04:ab:00:12:34:56",
+	}
+	timeout := time.After(time.Second)
+	for len(want) > 0 {
+		select {
+		case got := <-events:
+			if got.Raw() != want[0] {
+				t.Fatalf("got event %q; want %q", got.Raw(), want[0])
+			}
+			want = want[1:]
+		case <-time.After(10 * time.Millisecond):
+			n.send(StatusInterimUpdate, "04-AB-00-12-34-57", "2")
+		case <-timeout:
+			t.Fatalf("timeout waiting for event %q", want[0])
+		}
+	}
+}
+
+func TestAccounting_multipleServers(t *testing.T) {
+	const (
+		secret  = "s3cr3t"
+		testMAC = "04:ab:00:12:34:56"
+	)
+
+	var (
+		accts  []*Accounting
+		bssids = make(map[string]bool)
+	)
+	for i := 0; i < 2; i++ {
+		acct, err := Listen("127.0.0.1:0", []byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer acct.Close()
+		accts = append(accts, acct)
+
+		status, err := acct.Status(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		bss, ok := status.BSSByInterface(acct.Interface())
+		if !ok {
+			t.Fatalf("no BSS for interface %q", acct.Interface())
+		}
+		if _, err = net.ParseMAC(bss.BSSID); err != nil {
+			t.Fatalf("got invalid BSSID %q: %v", bss.BSSID, err)
+		}
+		if bssids[bss.BSSID] {
+			t.Fatalf("got BSSID %q of another server", bss.BSSID)
+		}
+		bssids[bss.BSSID] = true
+
+		// The BSSID is stable.
+		if again, _ := acct.Status(context.Background()); again.BSS[0].BSSID != bss.BSSID {
+			t.Fatalf("got BSSID %q; want %q", again.BSS[0].BSSID, bss.BSSID)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make([]chan hostapd.Event, len(accts))
+	for i, acct := range accts {
+		i, acct := i, acct
+		events[i] = make(chan hostapd.Event, 2)
+		go acct.Attach(ctx, func(e hostapd.Event) error {
+			events[i] <- e
+			return nil
+		})
+	}
+	expectEvent := func(i int, want string) {
+		t.Helper()
+		select {
+		case got := <-events[i]:
+			if got.Raw() != want {
+				t.Fatalf("got event %q from server %d; want %q", got.Raw(), i, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for event from server %d", i)
+		}
+	}
+
+	// The station's sessions on each server are independent.
+	ap1, ap2 := newNAS(t, accts[0].Addr(), secret), newNAS(t, accts[1].Addr(), secret)
+	ap1.send(StatusStart, testMAC, "1")
+	expectEvent(0, "AP-STA-CONNECTED "+testMAC)
+	ap2.send(StatusStart, testMAC, "1")
+	expectEvent(1, "AP-STA-CONNECTED "+testMAC)
+
+	ap1.send(StatusStop, testMAC, "1")
+	expectEvent(0, "AP-STA-DISCONNECTED "+testMAC)
+	if stations, _ := accts[1].Stations(context.Background()); len(stations) != 1 {
+		t.Fatalf("got %d stations of server 2; want 1", len(stations))
+	}
+}
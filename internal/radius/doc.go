@@ -0,0 +1,5 @@
+// Package radius is a RADIUS accounting server (RFC 2866), used as a source of
+// stations. Access points that cannot run wifi-presence, such as enterprise
+// access points of other vendors, commonly send accounting requests when
+// stations connect and disconnect.
+package radius
@@ -0,0 +1,230 @@
+package radius
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Code is the type of a packet.
+type Code uint8
+
+// Packet codes used for accounting.
+const (
+	CodeAccountingRequest  Code = 4
+	CodeAccountingResponse Code = 5
+)
+
+// AttrType is the type of an attribute.
+type AttrType uint8
+
+// Attribute types, from RFC 2865 and RFC 2866.
+const (
+	AttrUserName         AttrType = 1
+	AttrNASIPAddress     AttrType = 4
+	AttrCalledStationID  AttrType = 30
+	AttrCallingStationID AttrType = 31
+	AttrNASIdentifier    AttrType = 32
+	AttrProxyState       AttrType = 33
+	AttrAcctStatusType   AttrType = 40
+	AttrAcctSessionID    AttrType = 44
+)
+
+// StatusType is the value of the Acct-Status-Type attribute.
+type StatusType uint32
+
+// Acct-Status-Type values.
+const (
+	StatusStart         StatusType = 1
+	StatusStop          StatusType = 2
+	StatusInterimUpdate StatusType = 3
+	StatusAccountingOn  StatusType = 7
+	StatusAccountingOff StatusType = 8
+)
+
+func (s StatusType) String() string {
+	switch s {
+	case StatusStart:
+		return "Start"
+	case StatusStop:
+		return "Stop"
+	case StatusInterimUpdate:
+		return "Interim-Update"
+	case StatusAccountingOn:
+		return "Accounting-On"
+	case StatusAccountingOff:
+		return "Accounting-Off"
+	default:
+		return fmt.Sprintf("StatusType(%d)", uint32(s))
+	}
+}
+
+// Sizes of the packet's parts.
+const (
+	headerLen    = 20 // Code, identifier, length and authenticator.
+	maxPacketLen = 4096
+	authLen      = 16
+)
+
+// ErrInvalidAuthenticator is returned when a packet's authenticator does
+// not match, i.e. when the client uses a different shared secret.
+var ErrInvalidAuthenticator = errors.New("invalid authenticator")
+
+// Attr is a single attribute.
+type Attr struct {
+	Type  AttrType
+	Value []byte
+}
+
+// Packet is a RADIUS packet.
+type Packet struct {
+	Code          Code
+	Identifier    uint8
+	Authenticator [authLen]byte
+	Attrs         []Attr
+}
+
+// Attr returns the value of the first attribute of the given type.
+func (p *Packet) Attr(typ AttrType) ([]byte, bool) {
+	for _, a := range p.Attrs {
+		if a.Type == typ {
+			return a.Value, true
+		}
+	}
+	return nil, false
+}
+
+// String returns the value of the first attribute of the given type as a string.
+func (p *Packet) String(typ AttrType) string {
+	v, _ := p.Attr(typ)
+	return string(v)
+}
+
+// Uint32 returns the value of the first attribute of the given
+// type as an integer.
+func (p *Packet) Uint32(typ AttrType) (uint32, bool) {
+	v, ok := p.Attr(typ)
+	if !ok || len(v) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(v), true
+}
+
+// Parse parses a packet. The authenticator is not verified; see
+// VerifyRequest.
+func Parse(b []byte) (Packet, error) {
+	var p Packet
+	if len(b) < headerLen {
+		return p, fmt.Errorf("packet too short: %d bytes", len(b))
+	}
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if length < headerLen || length > len(b) || length > maxPacketLen {
+		return p, fmt.Errorf("invalid packet length %d", length)
+	}
+	// Octets beyond the length are padding, and are ignored.
+	b = b[:length]
+
+	p.Code = Code(b[0])
+	p.Identifier = b[1]
+	copy(p.Authenticator[:], b[4:headerLen])
+
+	for attrs := b[headerLen:]; len(attrs) > 0; {
+		if len(attrs) < 2 || attrs[1] < 2 || int(attrs[1]) > len(attrs) {
+			return p, errors.New("invalid attribute length")
+		}
+		n := int(attrs[1])
+		p.Attrs = append(p.Attrs, Attr{
+			Type:  AttrType(attrs[0]),
+			Value: append([]byte(nil), attrs[2:n]...),
+		})
+		attrs = attrs[n:]
+	}
+	return p, nil
+}
+
+// encode encodes the packet, using auth as its authenticator.
+func (p *Packet) encode(auth []byte) ([]byte, error) {
+	b := make([]byte, headerLen, maxPacketLen)
+	b[0] = byte(p.Code)
+	b[1] = p.Identifier
+	copy(b[4:headerLen], auth)
+	for _, a := range p.Attrs {
+		if len(a.Value) > 253 {
+			return nil, fmt.Errorf("attribute %d too long: %d bytes", a.Type, len(a.Value))
+		}
+		b = append(b, byte(a.Type), byte(len(a.Value)+2))
+		b = append(b, a.Value...)
+	}
+	if len(b) > maxPacketLen {
+		return nil, fmt.Errorf("packet too long: %d bytes", len(b))
+	}
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	return b, nil
+}
+
+// EncodeRequest encodes an accounting request, calculating its
+// authenticator using the shared secret.
+func (p *Packet) EncodeRequest(secret []byte) ([]byte, error) {
+	var zero [authLen]byte
+	b, err := p.encode(zero[:])
+	if err != nil {
+		return nil, err
+	}
+	sum := md5Sum(b, secret)
+	copy(b[4:headerLen], sum[:])
+	copy(p.Authenticator[:], sum[:])
+	return b, nil
+}
+
+// EncodeResponse encodes a response to the request, calculating its
+// authenticator from the request's authenticator and the shared secret.
+func (p *Packet) EncodeResponse(req Packet, secret []byte) ([]byte, error) {
+	b, err := p.encode(req.Authenticator[:])
+	if err != nil {
+		return nil, err
+	}
+	sum := md5Sum(b, secret)
+	copy(b[4:headerLen], sum[:])
+	return b, nil
+}
+
+// VerifyRequest returns ErrInvalidAuthenticator unless the authenticator of
+// the accounting request, given as received in b, matches the shared secret.
+func VerifyRequest(b []byte, secret []byte) error {
+	p, err := Parse(b)
+	if err != nil {
+		return err
+	}
+	b = append([]byte(nil), b[:binary.BigEndian.Uint16(b[2:4])]...)
+	copy(b[4:headerLen], make([]byte, authLen))
+	if sum := md5Sum(b, secret); !bytes.Equal(sum[:], p.Authenticator[:]) {
+		return ErrInvalidAuthenticator
+	}
+	return nil
+}
+
+// VerifyResponse returns ErrInvalidAuthenticator unless the authenticator of
+// the response, given as received in b, matches the request and shared secret.
+func VerifyResponse(b []byte, req Packet, secret []byte) error {
+	p, err := Parse(b)
+	if err != nil {
+		return err
+	}
+	b = append([]byte(nil), b[:binary.BigEndian.Uint16(b[2:4])]...)
+	copy(b[4:headerLen], req.Authenticator[:])
+	if sum := md5Sum(b, secret); !bytes.Equal(sum[:], p.Authenticator[:]) {
+		return ErrInvalidAuthenticator
+	}
+	return nil
+}
+
+func md5Sum(b, secret []byte) [md5.Size]byte {
+	h := md5.New()
+	h.Write(b)
+	h.Write(secret)
+	var sum [md5.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
@@ -0,0 +1,88 @@
+package radius
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPacket_roundTrip(t *testing.T) {
+	secret := []byte("s3cr3t")
+	req := Packet{
+		Code:       CodeAccountingRequest,
+		Identifier: 42,
+		Attrs: []Attr{
+			{Type: AttrAcctStatusType, Value: []byte{0, 0, 0, 1}},
+			{Type: AttrCallingStationID, Value: []byte("04-AB-00-12-34-56")},
+			{Type: AttrAcctSessionID, Value: []byte("5F2B1C3D")},
+		},
+	}
+	b, err := req.EncodeRequest(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = VerifyRequest(b, secret); err != nil {
+		t.Fatalf("VerifyRequest() err: %v", err)
+	}
+	if err = VerifyRequest(b, []byte("wrong")); !errors.Is(err, ErrInvalidAuthenticator) {
+		t.Fatalf("got VerifyRequest() err %v; want %v", err, ErrInvalidAuthenticator)
+	}
+
+	got, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, req) {
+		t.Fatalf("got Parse() %+v; want %+v", got, req)
+	}
+	if status, _ := got.Uint32(AttrAcctStatusType); StatusType(status) != StatusStart {
+		t.Errorf("got status %v; want %v", StatusType(status), StatusStart)
+	}
+	if id := got.String(AttrCallingStationID); id != "04-AB-00-12-34-56" {
+		t.Errorf("got Calling-Station-Id %q; want %q", id, "04-AB-00-12-34-56")
+	}
+
+	resp := Packet{Code: CodeAccountingResponse, Identifier: req.Identifier}
+	b, err = resp.EncodeResponse(req, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = VerifyResponse(b, req, secret); err != nil {
+		t.Fatalf("VerifyResponse() err: %v", err)
+	}
+	if err = VerifyResponse(b, req, []byte("wrong")); !errors.Is(err, ErrInvalidAuthenticator) {
+		t.Fatalf("got VerifyResponse() err %v; want %v", err, ErrInvalidAuthenticator)
+	}
+}
+
+func TestParse_invalid(t *testing.T) {
+	testCases := map[string][]byte{
+		"short":          {4, 1, 0, 20},
+		"length":         append([]byte{4, 1, 0, 30}, make([]byte, 16)...),
+		"attr length":    append(append([]byte{4, 1, 0, 23}, make([]byte, 16)...), 31, 1, 0),
+		"attr past end":  append(append([]byte{4, 1, 0, 23}, make([]byte, 16)...), 31, 5, 0),
+		"header length":  append([]byte{4, 1, 0, 10}, make([]byte, 16)...),
+		"missing header": nil,
+	}
+	for name, b := range testCases {
+		if _, err := Parse(b); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestParseMAC(t *testing.T) {
+	testCases := map[string]string{
+		"04-AB-00-12-34-56": "04:ab:00:12:34:56",
+		"04:ab:00:12:34:56": "04:ab:00:12:34:56",
+		"04ab00123456":      "04:ab:00:12:34:56",
+		"04ab.0012.3456":    "04:ab:00:12:34:56",
+		"user@example.com":  "",
+		"":                  "",
+	}
+	for v, want := range testCases {
+		if got := parseMAC(v); got != want {
+			t.Errorf("got parseMAC(%q) %q; want %q", v, got, want)
+		}
+	}
+}
@@ -0,0 +1,458 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/awilliams/wifi-presence/internal/hostapd"
+	"github.com/awilliams/wifi-presence/internal/presence"
+	"golang.org/x/sync/errgroup"
+)
+
+// Backoff limits used while reconnecting to the server, or to a source.
+const (
+	reconnectMinBackoff = 250 * time.Millisecond
+	reconnectMaxBackoff = 15 * time.Second
+)
+
+// maxPending limits the number of events that an Agent keeps until they are
+// acknowledged by the server. When the server is unreachable for long enough
+// for this to be exceeded, the oldest events are dropped; the snapshot sent
+// once reconnected corrects the server's state.
+const maxPending = 1024
+
+// AgentOpt is a configuration option for an Agent.
+type AgentOpt func(*Agent)
+
+// WithSnapshotInterval sets the interval at which snapshots of the stations
+// of each source are sent. The default is DefaultSnapshotInterval.
+func WithSnapshotInterval(interval time.Duration) AgentOpt {
+	return func(a *Agent) {
+		a.interval = interval
+	}
+}
+
+// WithStationFallback sets the function used to list the stations of a source
+// that is unable to list them itself. See presence.WithStationFallback.
+func WithStationFallback(f presence.StationsFunc) AgentOpt {
+	return func(a *Agent) {
+		a.fallback = f
+	}
+}
+
+// WithAgentLogger sets the logger of the Agent.
+func WithAgentLogger(l *log.Logger) AgentOpt {
+	return func(a *Agent) {
+		a.logger = l
+	}
+}
+
+// NewAgent returns an Agent named name, e.g. the name of the access point,
+// forwarding the events and stations of the sources to the Server at addr.
+func NewAgent(name, addr string, secret []byte, sources []presence.Source, opts ...AgentOpt) (*Agent, error) {
+	if name == "" {
+		return nil, errors.New("agent name cannot be empty")
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("shared secret cannot be empty")
+	}
+	if len(sources) == 0 {
+		return nil, errors.New("at least one source is required")
+	}
+	boot, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	a := Agent{
+		name:    name,
+		addr:    addr,
+		secret:  secret,
+		sources: sources,
+		boot:    boot,
+		notify:  make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(&a)
+	}
+	if a.interval <= 0 {
+		a.interval = DefaultSnapshotInterval
+	}
+	if a.logger == nil {
+		a.logger = log.New(io.Discard, "", 0)
+	}
+	return &a, nil
+}
+
+// Agent forwards the events and stations of its sources to a Server. Events
+// that occur while the server is unreachable are kept, and replayed once
+// reconnected, followed by a snapshot of the stations of each source.
+type Agent struct {
+	name     string
+	addr     string
+	secret   []byte
+	sources  []presence.Source
+	boot     string
+	interval time.Duration
+	fallback presence.StationsFunc
+	logger   *log.Logger
+
+	notify chan struct{} // Signaled when an event is added, or a snapshot is due.
+
+	mu           sync.Mutex // Protects following.
+	seq          uint64     // Sequence number of the last event.
+	pending      []frame    // Events that have not been acknowledged, oldest first.
+	snapshotDue  bool       // Whether a snapshot should be sent without waiting for the interval.
+	droppedCount int        // Events dropped since the last warning.
+}
+
+// Run attaches to each source, and forwards their events and stations to the
+// server, reconnecting whenever the connection is lost. Sources that are able
+// to reconnect are reattached when they terminate. It blocks until the context
+// is done, or an error occurs.
+func (a *Agent) Run(ctx context.Context) error {
+	eg, ctx := errgroup.WithContext(ctx)
+	for _, src := range a.sources {
+		src := src
+		eg.Go(func() error {
+			return a.watchSource(ctx, src)
+		})
+	}
+	eg.Go(func() error {
+		return a.connect(ctx)
+	})
+	return eg.Wait()
+}
+
+// reconnecter is implemented by sources that are able to reconnect
+// after Attach returns.
+type reconnecter interface {
+	Reconnect(ctx context.Context) error
+}
+
+// watchSource attaches to the source, adding its events to those pending.
+func (a *Agent) watchSource(ctx context.Context, src presence.Source) error {
+	ifname := src.Interface()
+	for {
+		err := src.Attach(ctx, func(e hostapd.Event) error {
+			a.addEvent(ifname, e.Raw())
+			return nil
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+		rc, ok := src.(reconnecter)
+		if !ok || !(errors.Is(err, hostapd.ErrTerminating) || errors.Is(err, hostapd.ErrUnresponsive)) {
+			return fmt.Errorf("%s: %w", ifname, err)
+		}
+
+		a.logger.Printf("%s: %v; waiting to reconnect", ifname, err)
+		if err = rc.Reconnect(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("%s: %w", ifname, err)
+		}
+		// Events may have been missed.
+		a.requestSnapshot()
+	}
+}
+
+// addEvent adds an event of the interface to those pending.
+func (a *Agent) addEvent(ifname, raw string) {
+	a.mu.Lock()
+	a.seq++
+	a.pending = append(a.pending, frame{
+		Type:      frameEvent,
+		Seq:       a.seq,
+		Interface: ifname,
+		Event:     raw,
+	})
+	if n := len(a.pending) - maxPending; n > 0 {
+		a.pending = append(a.pending[:0], a.pending[n:]...)
+		a.droppedCount += n
+	}
+	a.mu.Unlock()
+	a.signal()
+}
+
+// requestSnapshot requests a snapshot to be sent as soon as possible.
+func (a *Agent) requestSnapshot() {
+	a.mu.Lock()
+	a.snapshotDue = true
+	a.mu.Unlock()
+	a.signal()
+}
+
+func (a *Agent) signal() {
+	select {
+	case a.notify <- struct{}{}:
+	default:
+	}
+}
+
+// ack removes the pending events up to and including seq.
+func (a *Agent) ack(seq uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	i := 0
+	for i < len(a.pending) && a.pending[i].Seq <= seq {
+		i++
+	}
+	a.pending = append(a.pending[:0], a.pending[i:]...)
+}
+
+// unsent returns the pending events following seq.
+func (a *Agent) unsent(seq uint64) []frame {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var frames []frame
+	for _, f := range a.pending {
+		if f.Seq > seq {
+			frames = append(frames, f)
+		}
+	}
+	if a.droppedCount > 0 {
+		a.logger.Printf("dropped %d events while unable to reach server %s", a.droppedCount, a.addr)
+		a.droppedCount = 0
+	}
+	return frames
+}
+
+// connect connects to the server, reconnecting with an increasing backoff
+// until the context is done.
+func (a *Agent) connect(ctx context.Context) error {
+	backoff := reconnectMinBackoff
+	for {
+		established, err := a.session(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if established {
+			backoff = reconnectMinBackoff
+		}
+		a.logger.Printf("connection to server %s: %v; reconnecting in %s", a.addr, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > reconnectMaxBackoff {
+			backoff = reconnectMaxBackoff
+		}
+	}
+}
+
+// session connects to the server and forwards events and snapshots until
+// the connection is lost, or the context is done. It returns whether the
+// handshake completed.
+func (a *Agent) session(ctx context.Context) (bool, error) {
+	var dialer net.Dialer
+	nc, err := dialer.DialContext(ctx, "tcp", a.addr)
+	if err != nil {
+		return false, err
+	}
+	c := newConn(nc, labelAgent, labelServer)
+	defer c.Close()
+
+	// Interrupt reads and writes once the context is done.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-stop:
+		}
+	}()
+
+	resume, ack, err := a.handshake(c)
+	if err != nil {
+		return false, err
+	}
+	a.logger.Printf("Connected to server %s", a.addr)
+
+	// The sequence number of the last event sent.
+	var sent uint64
+	a.mu.Lock()
+	if resume {
+		// Replay the events the server has not received.
+		sent = ack
+	} else {
+		// The server has no record of previous events; the
+		// snapshot replaces them.
+		a.pending = nil
+		sent = a.seq
+	}
+	a.mu.Unlock()
+	a.ack(ack)
+
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			c.SetReadDeadline(time.Now().Add(missedSnapshots * a.interval))
+			f, err := c.readFrame()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			if f.Type == frameAck {
+				a.ack(f.Seq)
+			}
+		}
+	}()
+
+	flush := func() error {
+		for _, f := range a.unsent(sent) {
+			if err := c.writeFrame(f); err != nil {
+				return err
+			}
+			sent = f.Seq
+		}
+		return nil
+	}
+	snapshot := func() error {
+		a.mu.Lock()
+		a.snapshotDue = false
+		a.mu.Unlock()
+
+		// Events preceding the snapshot are sent first.
+		if err := flush(); err != nil {
+			return err
+		}
+		for _, src := range a.sources {
+			if err := a.sendSnapshot(ctx, c, src); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err = snapshot(); err != nil {
+		return true, err
+	}
+
+	t := time.NewTicker(a.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return true, nil
+		case err := <-readErr:
+			return true, err
+		case <-t.C:
+			err = snapshot()
+		case <-a.notify:
+			a.mu.Lock()
+			due := a.snapshotDue
+			a.mu.Unlock()
+			if due {
+				err = snapshot()
+			} else {
+				err = flush()
+			}
+		}
+		if err != nil {
+			return true, err
+		}
+	}
+}
+
+// handshake authenticates the agent and the server, returning whether the
+// server knows the agent's events up to and including the acknowledged
+// sequence number.
+func (a *Agent) handshake(c *conn) (bool, uint64, error) {
+	c.SetDeadline(time.Now().Add(ioTimeout))
+	defer c.SetDeadline(time.Time{})
+
+	challenge, err := c.readFrame()
+	if err != nil {
+		return false, 0, err
+	}
+	if challenge.Type != frameChallenge {
+		return false, 0, fmt.Errorf("unexpected %q frame; want %q", challenge.Type, frameChallenge)
+	}
+
+	nonce, err := newNonce()
+	if err != nil {
+		return false, 0, err
+	}
+	ifnames := make([]string, len(a.sources))
+	for i, src := range a.sources {
+		ifnames[i] = src.Interface()
+	}
+	err = c.writeFrame(frame{
+		Type:       frameHello,
+		Nonce:      nonce,
+		Proof:      proof(a.secret, frameHello, challenge.Nonce, nonce, a.name, a.boot),
+		Agent:      a.name,
+		Boot:       a.boot,
+		Interfaces: ifnames,
+		Interval:   a.interval.Milliseconds(),
+	})
+	if err != nil {
+		return false, 0, err
+	}
+
+	welcome, err := c.readFrame()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			// The server closes the connection when the
+			// proof is invalid.
+			return false, 0, fmt.Errorf("%w: connection closed by server", ErrAuth)
+		}
+		return false, 0, err
+	}
+	if welcome.Type != frameWelcome {
+		return false, 0, fmt.Errorf("unexpected %q frame; want %q", welcome.Type, frameWelcome)
+	}
+	if !validProof(welcome.Proof, a.secret, frameWelcome, nonce, challenge.Nonce) {
+		return false, 0, fmt.Errorf("%w: invalid server proof", ErrAuth)
+	}
+
+	c.key = sessionKey(a.secret, challenge.Nonce, nonce)
+	return welcome.Resume, welcome.Seq, nil
+}
+
+// sendSnapshot sends the status and stations of the source. Sources whose
+// status cannot be retrieved, e.g. because they are reconnecting, are skipped.
+func (a *Agent) sendSnapshot(ctx context.Context, c *conn, src presence.Source) error {
+	ifname := src.Interface()
+
+	status, err := src.Status(ctx)
+	if err != nil {
+		a.logger.Printf("%s: unable to get status: %v", ifname, err)
+		return nil
+	}
+
+	f := frame{Type: frameStations, Interface: ifname}
+	f.Stations, err = src.Stations(ctx)
+	var unknown hostapd.ErrUnknownCmd
+	if errors.As(err, &unknown) && a.fallback != nil {
+		stations, fallbackErr := a.fallback(ctx, ifname)
+		if fallbackErr == nil {
+			f.Stations, err = stations, nil
+		} else {
+			a.logger.Printf("%s: unable to list stations using fallback: %v", ifname, fallbackErr)
+		}
+	}
+	switch {
+	case errors.As(err, &unknown):
+		f.Unknown = true
+	case err != nil:
+		a.logger.Printf("%s: unable to list stations: %v", ifname, err)
+		return nil
+	}
+
+	if err = c.writeFrame(frame{Type: frameStatus, Interface: ifname, Status: &status}); err != nil {
+		return err
+	}
+	return c.writeFrame(f)
+}
@@ -0,0 +1,10 @@
+// Package remote forwards the stations and events of access points to a central
+// wifi-presence. An Agent runs on each access point, streaming the events of its
+// local sources along with periodic snapshots of their stations to a Server over
+// an authenticated TCP connection. The Server turns the interfaces of all agents
+// into sources of a single presence.Daemon.
+//
+// Connections are authenticated using a shared secret: both sides prove knowledge
+// of the secret during a challenge-response handshake, after which every frame is
+// authenticated using a key derived from the secret. Frames are not encrypted.
+package remote
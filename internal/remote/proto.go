@@ -0,0 +1,223 @@
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/awilliams/wifi-presence/internal/hostapd"
+)
+
+// DefaultAddr is the default address the Server listens on.
+const DefaultAddr = ":8878"
+
+// DefaultSnapshotInterval is the default interval at which an Agent
+// sends snapshots of its stations.
+const DefaultSnapshotInterval = 30 * time.Second
+
+const (
+	// maxFrameLen is the maximum length of a frame, including
+	// its authenticator and newline.
+	maxFrameLen = 1 << 20
+	// ioTimeout limits the duration of the handshake, and of writing a frame.
+	ioTimeout = 10 * time.Second
+	// missedSnapshots is the number of snapshot intervals after which a
+	// connection without any frames is considered lost.
+	missedSnapshots = 3
+)
+
+// ErrAuth is returned when the peer does not prove knowledge of the
+// shared secret, or a frame's authenticator is invalid.
+var ErrAuth = errors.New("authentication failed")
+
+// Frame types.
+const (
+	frameChallenge = "challenge" // Server to agent, first frame of the handshake.
+	frameHello     = "hello"     // Agent to server, in response to the challenge.
+	frameWelcome   = "welcome"   // Server to agent, last frame of the handshake.
+	frameStatus    = "status"    // Agent to server, the status of an interface.
+	frameStations  = "stations"  // Agent to server, the stations of an interface.
+	frameEvent     = "event"     // Agent to server, an event of an interface.
+	frameAck       = "ack"       // Server to agent, acknowledging events.
+)
+
+// frame is a message exchanged between an agent and the server. Frames are
+// encoded as JSON, and separated by newlines.
+type frame struct {
+	Type string `json:"type"`
+
+	// Handshake fields.
+	Nonce      string   `json:"nonce,omitempty"`
+	Proof      string   `json:"proof,omitempty"`
+	Agent      string   `json:"agent,omitempty"`
+	Boot       string   `json:"boot,omitempty"`       // Identifies the agent's process, to detect restarts.
+	Interfaces []string `json:"interfaces,omitempty"` // Interfaces of the agent's sources.
+	Interval   int64    `json:"interval,omitempty"`   // Agent's snapshot interval, in milliseconds.
+	Resume     bool     `json:"resume,omitempty"`     // Whether the server knows the agent's events up to Seq.
+
+	// Sequence number of an event, or of the last event acknowledged.
+	Seq uint64 `json:"seq,omitempty"`
+
+	Interface string            `json:"interface,omitempty"`
+	Event     string            `json:"event,omitempty"` // Raw event, see hostapd.Event.
+	Status    *hostapd.Status   `json:"status,omitempty"`
+	Stations  []hostapd.Station `json:"stations,omitempty"`
+	Unknown   bool              `json:"unknown,omitempty"` // The interface is unable to list its stations.
+}
+
+// Labels of the direction of frames, included in their authenticator.
+const (
+	labelAgent  = 'A'
+	labelServer = 'S'
+)
+
+// conn exchanges frames. Once the handshake is complete, each frame is
+// prefixed by its authenticator, which covers the frame's direction and
+// position in the stream, preventing frames from being forged, reordered,
+// replayed or reflected.
+type conn struct {
+	net.Conn
+	r                *bufio.Reader
+	label, peerLabel byte
+
+	// Set once the handshake is complete, before
+	// frames are read and written concurrently.
+	key []byte
+
+	writeMu sync.Mutex // Serializes writes, and protects sent.
+	sent    uint64
+	read    uint64
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newConn(c net.Conn, label, peerLabel byte) *conn {
+	return &conn{
+		Conn:      c,
+		r:         bufio.NewReader(c),
+		label:     label,
+		peerLabel: peerLabel,
+		closed:    make(chan struct{}),
+	}
+}
+
+// Close closes the connection.
+func (c *conn) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.Conn.Close()
+	})
+	return err
+}
+
+// writeFrame writes the frame.
+func (c *conn) writeFrame(f frame) error {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	auth := "-"
+	if c.key != nil {
+		auth = hex.EncodeToString(c.sum(c.label, c.sent, b))
+		c.sent++
+	}
+	c.SetWriteDeadline(time.Now().Add(ioTimeout))
+	_, err = fmt.Fprintf(c.Conn, "%s %s\n", auth, b)
+	return err
+}
+
+// readFrame reads the next frame. It must not be called concurrently.
+func (c *conn) readFrame() (frame, error) {
+	var line []byte
+	for {
+		b, err := c.r.ReadSlice('\n')
+		line = append(line, b...)
+		if len(line) > maxFrameLen {
+			return frame{}, fmt.Errorf("frame exceeds %d bytes", maxFrameLen)
+		}
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return frame{}, err
+		}
+	}
+
+	auth, b, ok := bytes.Cut(bytes.TrimSuffix(line, []byte("\n")), []byte(" "))
+	if !ok {
+		return frame{}, errors.New("invalid frame")
+	}
+	if c.key != nil {
+		want := hex.EncodeToString(c.sum(c.peerLabel, c.read, b))
+		if !hmac.Equal(auth, []byte(want)) {
+			return frame{}, ErrAuth
+		}
+		c.read++
+	}
+
+	var f frame
+	if err := json.Unmarshal(b, &f); err != nil {
+		return frame{}, fmt.Errorf("invalid frame: %w", err)
+	}
+	return f, nil
+}
+
+// sum returns the authenticator of the nth frame sent in the direction
+// of the label.
+func (c *conn) sum(label byte, n uint64, b []byte) []byte {
+	var hdr [9]byte
+	hdr[0] = label
+	binary.BigEndian.PutUint64(hdr[1:], n)
+
+	h := hmac.New(sha256.New, c.key)
+	h.Write(hdr[:])
+	h.Write(b)
+	return h.Sum(nil)
+}
+
+// proof returns the proof of knowing the secret, bound to the values of the
+// handshake.
+func proof(secret []byte, values ...string) string {
+	h := hmac.New(sha256.New, secret)
+	for _, v := range values {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// validProof returns whether p is the proof of the values.
+func validProof(p string, secret []byte, values ...string) bool {
+	return hmac.Equal([]byte(p), []byte(proof(secret, values...)))
+}
+
+// sessionKey returns the key used to authenticate the frames of a connection.
+func sessionKey(secret []byte, serverNonce, agentNonce string) []byte {
+	key, _ := hex.DecodeString(proof(secret, "session", serverNonce, agentNonce))
+	return key
+}
+
+// newNonce returns a random value, used once.
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
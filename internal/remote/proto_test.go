@@ -0,0 +1,53 @@
+package remote
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestConn_authentication(t *testing.T) {
+	agentEnd, serverEnd := net.Pipe()
+	c := newConn(serverEnd, labelServer, labelAgent)
+	defer c.Close()
+	defer agentEnd.Close()
+	c.key = sessionKey([]byte("s3cr3t"), "server-nonce", "agent-nonce")
+
+	const payload = `{"type":"event","seq":1,"interface":"wlan0","event":"AP-STA-CONNECTED 04:ab:00:12:34:56"}`
+	// send writes a frame authenticated with the given direction and position.
+	send := func(label byte, n uint64, payload string) {
+		line := fmt.Sprintf("%s %s\n", hex.EncodeToString(c.sum(label, n, []byte(payload))), payload)
+		go agentEnd.Write([]byte(line))
+	}
+
+	send(labelAgent, 0, payload)
+	got, err := c.readFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Seq != 1 || got.Event != "AP-STA-CONNECTED 04:ab:00:12:34:56" {
+		t.Fatalf("got frame %+v", got)
+	}
+
+	testCases := map[string]func(){
+		"replayed":  func() { send(labelAgent, 0, payload) },
+		"reflected": func() { send(labelServer, 1, payload) },
+		"tampered": func() {
+			line := fmt.Sprintf("%s %s\n", hex.EncodeToString(c.sum(labelAgent, 1, []byte(payload))), payload[:len(payload)-1]+" }")
+			go agentEnd.Write([]byte(line))
+		},
+	}
+	for name, send := range testCases {
+		send()
+		if _, err = c.readFrame(); !errors.Is(err, ErrAuth) {
+			t.Fatalf("%s: got readFrame() err %v; want %v", name, err, ErrAuth)
+		}
+	}
+
+	send(labelAgent, 1, payload)
+	if _, err = c.readFrame(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,274 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/awilliams/wifi-presence/internal/hostapd"
+	"github.com/awilliams/wifi-presence/internal/presence"
+)
+
+// fakeSource is a presence.Source whose stations and events are
+// controlled by the test.
+type fakeSource struct {
+	ifname   string
+	stations chan []hostapd.Station
+	events   chan hostapd.Event
+}
+
+func newFakeSource(ifname string, stations ...hostapd.Station) *fakeSource {
+	f := fakeSource{
+		ifname:   ifname,
+		stations: make(chan []hostapd.Station, 1),
+		events:   make(chan hostapd.Event),
+	}
+	f.stations <- stations
+	return &f
+}
+
+func (f *fakeSource) Interface() string {
+	return f.ifname
+}
+
+func (f *fakeSource) Status(context.Context) (hostapd.Status, error) {
+	return hostapd.Status{
+		State: "ENABLED",
+		BSS: []hostapd.BSS{
+			{Interface: f.ifname, SSID: "test", BSSID: "aa:bb:cc:dd:ee:ff"},
+		},
+	}, nil
+}
+
+func (f *fakeSource) Stations(context.Context) ([]hostapd.Station, error) {
+	stations := <-f.stations
+	f.stations <- stations
+	return stations, nil
+}
+
+func (f *fakeSource) setStations(stations ...hostapd.Station) {
+	<-f.stations
+	f.stations <- stations
+}
+
+func (f *fakeSource) Attach(ctx context.Context, events func(hostapd.Event) error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e := <-f.events:
+			if err := events(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// watch calls Watch in a goroutine, returning a channel of each set of sources.
+func watch(t *testing.T, s *Server) <-chan []presence.Source {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	sources := make(chan []presence.Source, 8)
+	go s.Watch(ctx, func(srcs []presence.Source) error {
+		sources <- srcs
+		return nil
+	})
+	return sources
+}
+
+// waitSources waits for a set of sources with the given names.
+func waitSources(t *testing.T, sources <-chan []presence.Source, want ...string) []presence.Source {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case srcs := <-sources:
+			var names []string
+			for _, src := range srcs {
+				names = append(names, src.(*Source).String())
+			}
+			if reflect.DeepEqual(names, want) {
+				return srcs
+			}
+		case <-timeout:
+			t.Fatalf("timeout waiting for sources %v", want)
+		}
+	}
+}
+
+// runAgent runs an agent in a goroutine, returning a function that stops it.
+func runAgent(t *testing.T, addr net.Addr, secret string, sources ...presence.Source) func() {
+	t.Helper()
+	// Snapshots are only sent when connecting.
+	a, err := NewAgent("ap1", addr.String(), []byte(secret), sources, WithSnapshotInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := a.Run(ctx); err != nil {
+			t.Errorf("Agent.Run() err: %v", err)
+		}
+	}()
+	stop := func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+func parseEvent(t *testing.T, name, mac string) hostapd.Event {
+	t.Helper()
+	e, err := hostapd.ParseEvent(fmt.Sprintf("%s %s", name, mac))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestAgentServer(t *testing.T) {
+	const (
+		secret = "s3cr3t"
+		mac1   = "04:ab:00:12:34:01"
+		mac2   = "04:ab:00:12:34:02"
+	)
+
+	srv, err := Listen("127.0.0.1:0", []byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	sources := watch(t, srv)
+	waitSources(t, sources)
+
+	local := newFakeSource("wlan0", hostapd.Station{MAC: mac1, Associated: true})
+	runAgent(t, srv.Addr(), secret, local)
+
+	// The interface is added once its first snapshot is received.
+	src := waitSources(t, sources, "ap1/wlan0")[0]
+	if got := src.Interface(); got != "wlan0" {
+		t.Fatalf("got Interface() %q; want %q", got, "wlan0")
+	}
+	status, err := src.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bss, _ := status.BSSByInterface("wlan0"); bss.BSSID != "aa:bb:cc:dd:ee:ff" {
+		t.Fatalf("got BSSID %q; want %q", bss.BSSID, "aa:bb:cc:dd:ee:ff")
+	}
+	stations, err := src.Stations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stations) != 1 || stations[0].MAC != mac1 {
+		t.Fatalf("got Stations() %+v; want %s", stations, mac1)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan hostapd.Event, 8)
+	go src.Attach(ctx, func(e hostapd.Event) error {
+		events <- e
+		return nil
+	})
+	expectEvent := func(want string) {
+		t.Helper()
+		select {
+		case got := <-events:
+			if got.Raw() != want {
+				t.Fatalf("got event %q; want %q", got.Raw(), want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timeout waiting for event %q", want)
+		}
+	}
+	sendEvent := func(e hostapd.Event) {
+		t.Helper()
+		select {
+		case local.events <- e:
+		case <-time.After(time.Second):
+			t.Fatalf("timeout sending %q", e.Raw())
+		}
+	}
+
+	// Events are forwarded.
+	local.setStations(hostapd.Station{MAC: mac1, Associated: true}, hostapd.Station{MAC: mac2, Associated: true})
+	sendEvent(parseEvent(t, "AP-STA-CONNECTED", mac2))
+	expectEvent("AP-STA-CONNECTED " + mac2)
+
+	disconnect := func() {
+		srv.mu.Lock()
+		srv.agents["ap1"].conn.Close()
+		srv.mu.Unlock()
+	}
+
+	// The connection is lost; events are replayed once reconnected,
+	// followed by a snapshot.
+	disconnect()
+	local.setStations(hostapd.Station{MAC: mac2, Associated: true})
+	sendEvent(parseEvent(t, "AP-STA-DISCONNECTED", mac1))
+	expectEvent("AP-STA-DISCONNECTED " + mac1)
+
+	// Events that are missed are corrected by the snapshot.
+	local.setStations()
+	disconnect()
+	expectEvent("AP-STA-DISCONNECTED " + mac2)
+
+	// The source remains after reconnecting.
+	select {
+	case srcs := <-sources:
+		t.Fatalf("got unexpected sources %v", srcs)
+	default:
+	}
+}
+
+func TestServer_gracePeriod(t *testing.T) {
+	const secret = "s3cr3t"
+
+	srv, err := Listen("127.0.0.1:0", []byte(secret), WithGracePeriod(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	sources := watch(t, srv)
+
+	stop := runAgent(t, srv.Addr(), secret, newFakeSource("wlan0"), newFakeSource("wlan1"))
+	waitSources(t, sources, "ap1/wlan0", "ap1/wlan1")
+
+	// The agent's sources are removed once it does not reconnect.
+	stop()
+	waitSources(t, sources)
+}
+
+func TestServer_invalidSecret(t *testing.T) {
+	srv, err := Listen("127.0.0.1:0", []byte("s3cr3t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	a, err := NewAgent("ap1", srv.Addr().String(), []byte("wrong"), []presence.Source{newFakeSource("wlan0")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = a.handshake(newConn(c, labelAgent, labelServer)); !errors.Is(err, ErrAuth) {
+		t.Fatalf("got handshake() err %v; want %v", err, ErrAuth)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.agents) != 0 {
+		t.Fatalf("got %d agents; want 0", len(srv.agents))
+	}
+}
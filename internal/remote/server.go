@@ -0,0 +1,617 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/awilliams/wifi-presence/internal/hostapd"
+	"github.com/awilliams/wifi-presence/internal/presence"
+)
+
+// DefaultGracePeriod is the default time the sources of an agent are kept
+// after its connection is lost, waiting for it to reconnect.
+const DefaultGracePeriod = time.Minute
+
+// queueLen is the number of updates buffered by each Source.
+const queueLen = 64
+
+// ServerOpt is a configuration option for a Server.
+type ServerOpt func(*Server)
+
+// WithGracePeriod sets the time the sources of an agent are kept after its
+// connection is lost. Once the grace period expires, its sources are removed,
+// and their stations are considered disconnected. The default is
+// DefaultGracePeriod.
+func WithGracePeriod(d time.Duration) ServerOpt {
+	return func(s *Server) {
+		s.grace = d
+	}
+}
+
+// WithServerLogger sets the logger of the Server.
+func WithServerLogger(l *log.Logger) ServerOpt {
+	return func(s *Server) {
+		s.logger = l
+	}
+}
+
+// Listen returns a Server accepting agents on the TCP address, e.g.
+// DefaultAddr. Agents are authenticated using the shared secret.
+func Listen(addr string, secret []byte, opts ...ServerOpt) (*Server, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("shared secret cannot be empty")
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := Server{
+		ln:      ln,
+		secret:  secret,
+		agents:  make(map[string]*agent),
+		changed: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	if s.grace <= 0 {
+		s.grace = DefaultGracePeriod
+	}
+	if s.logger == nil {
+		s.logger = log.New(io.Discard, "", 0)
+	}
+
+	go s.serve()
+	return &s, nil
+}
+
+// Server accepts connections from agents, and reports the interfaces of each
+// agent as a Source.
+//
+// Server implements presence.SourceWatcher.
+type Server struct {
+	ln     net.Listener
+	secret []byte
+	grace  time.Duration
+	logger *log.Logger
+
+	mu      sync.Mutex        // Protects following.
+	agents  map[string]*agent // By name.
+	changed chan struct{}     // Closed, and replaced, when the set of sources changes.
+	closed  bool
+}
+
+// agent is the server's record of an agent.
+type agent struct {
+	name    string
+	boot    string
+	seq     uint64             // Sequence number of the last event received.
+	sources map[string]*Source // By interface.
+	conn    *conn              // Current connection, if any.
+	expire  *time.Timer        // Removes the agent once the grace period expires.
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// Close stops accepting agents, and closes the connection of each agent.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for _, ag := range s.agents {
+		if ag.conn != nil {
+			ag.conn.Close()
+		}
+		if ag.expire != nil {
+			ag.expire.Stop()
+		}
+	}
+	s.mu.Unlock()
+	return s.ln.Close()
+}
+
+// Watch calls sources with the sources of all agents each time they change,
+// until the context is done. The source of an interface is included once its
+// status and stations have been received.
+func (s *Server) Watch(ctx context.Context, sources func([]presence.Source) error) error {
+	for {
+		s.mu.Lock()
+		var current []presence.Source
+		for _, ag := range s.agents {
+			for _, src := range ag.sources {
+				if src.isReady() {
+					current = append(current, src)
+				}
+			}
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		sort.Slice(current, func(i, j int) bool {
+			return current[i].(*Source).String() < current[j].(*Source).String()
+		})
+		if err := sources(current); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+		}
+	}
+}
+
+// notifyChanged notifies Watch that the set of sources has changed.
+// s.mu must be held.
+func (s *Server) notifyChanged() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) serve() {
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if !closed {
+				s.logger.Printf("unable to accept agents: %v", err)
+			}
+			return
+		}
+		go s.handle(newConn(nc, labelServer, labelAgent))
+	}
+}
+
+// handle authenticates the agent and receives its frames until the
+// connection is lost.
+func (s *Server) handle(c *conn) {
+	defer c.Close()
+
+	hello, nonce, err := s.handshake(c)
+	if err != nil {
+		s.logger.Printf("agent %s: %v", c.RemoteAddr(), err)
+		return
+	}
+
+	ag, resume, seq := s.attach(hello, c)
+	defer s.detach(ag, c)
+
+	err = c.writeFrame(frame{
+		Type:   frameWelcome,
+		Proof:  proof(s.secret, frameWelcome, hello.Nonce, nonce),
+		Resume: resume,
+		Seq:    seq,
+	})
+	if err != nil {
+		s.logger.Printf("agent %q: %v", ag.name, err)
+		return
+	}
+	c.key = sessionKey(s.secret, nonce, hello.Nonce)
+	c.SetDeadline(time.Time{})
+	s.logger.Printf("agent %q connected from %s (resumed: %t)", ag.name, c.RemoteAddr(), resume)
+
+	interval := time.Duration(hello.Interval) * time.Millisecond
+	if interval <= 0 {
+		interval = DefaultSnapshotInterval
+	}
+	for {
+		c.SetReadDeadline(time.Now().Add(missedSnapshots * interval))
+		f, err := c.readFrame()
+		if err != nil {
+			s.logger.Printf("agent %q: %v", ag.name, err)
+			return
+		}
+		if err = s.receive(ag, c, f); err != nil {
+			s.logger.Printf("agent %q: %v", ag.name, err)
+			return
+		}
+	}
+}
+
+// handshake authenticates the agent, returning its hello frame along with
+// the server's nonce.
+func (s *Server) handshake(c *conn) (frame, string, error) {
+	c.SetDeadline(time.Now().Add(ioTimeout))
+
+	nonce, err := newNonce()
+	if err != nil {
+		return frame{}, "", err
+	}
+	if err = c.writeFrame(frame{Type: frameChallenge, Nonce: nonce}); err != nil {
+		return frame{}, "", err
+	}
+
+	hello, err := c.readFrame()
+	if err != nil {
+		return frame{}, "", err
+	}
+	if hello.Type != frameHello {
+		return frame{}, "", fmt.Errorf("unexpected %q frame; want %q", hello.Type, frameHello)
+	}
+	if !validProof(hello.Proof, s.secret, frameHello, nonce, hello.Nonce, hello.Agent, hello.Boot) {
+		return frame{}, "", ErrAuth
+	}
+	if hello.Agent == "" {
+		return frame{}, "", errors.New("agent name cannot be empty")
+	}
+	return hello, nonce, nil
+}
+
+// attach records the agent's connection, replacing any previous connection.
+// It returns whether the agent's events up to and including the returned
+// sequence number have been received from the same process of the agent.
+func (s *Server) attach(hello frame, c *conn) (*agent, bool, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ag, ok := s.agents[hello.Agent]
+	if !ok {
+		ag = &agent{
+			name:    hello.Agent,
+			sources: make(map[string]*Source),
+		}
+		s.agents[ag.name] = ag
+	}
+	if ag.conn != nil {
+		// The previous connection has not yet timed out.
+		ag.conn.Close()
+	}
+	ag.conn = c
+	if ag.expire != nil {
+		ag.expire.Stop()
+		ag.expire = nil
+	}
+
+	resume := ok && ag.boot == hello.Boot
+	if !resume {
+		ag.boot = hello.Boot
+		ag.seq = 0
+	}
+
+	// Remove the sources of interfaces the agent no longer has.
+	current := make(map[string]bool, len(hello.Interfaces))
+	for _, ifname := range hello.Interfaces {
+		current[ifname] = true
+	}
+	var removed bool
+	for ifname, src := range ag.sources {
+		if !current[ifname] {
+			src.remove()
+			delete(ag.sources, ifname)
+			removed = true
+		}
+	}
+	if removed {
+		s.notifyChanged()
+	}
+
+	return ag, resume, ag.seq
+}
+
+// detach removes the agent's connection, removing the agent if it does
+// not reconnect within the grace period.
+func (s *Server) detach(ag *agent, c *conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ag.conn != c {
+		// Replaced by a newer connection.
+		return
+	}
+	ag.conn = nil
+	if s.closed {
+		return
+	}
+
+	s.logger.Printf("agent %q disconnected; removing in %s unless it reconnects", ag.name, s.grace)
+	ag.expire = time.AfterFunc(s.grace, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if ag.conn != nil || s.agents[ag.name] != ag {
+			return
+		}
+		s.logger.Printf("agent %q did not reconnect; removing", ag.name)
+		delete(s.agents, ag.name)
+		for _, src := range ag.sources {
+			src.remove()
+		}
+		s.notifyChanged()
+	})
+}
+
+// receive handles a frame received from the agent.
+func (s *Server) receive(ag *agent, c *conn, f frame) error {
+	switch f.Type {
+	case frameStatus:
+		if f.Status == nil {
+			return errors.New("status frame without status")
+		}
+		s.mu.Lock()
+		src, ok := ag.sources[f.Interface]
+		if !ok {
+			src = newSource(ag.name, f.Interface)
+			ag.sources[f.Interface] = src
+		}
+		s.mu.Unlock()
+		src.setStatus(*f.Status)
+		return nil
+
+	case frameStations:
+		s.mu.Lock()
+		src := ag.sources[f.Interface]
+		seq := ag.seq
+		s.mu.Unlock()
+		if src == nil {
+			return fmt.Errorf("%s: stations received before status", f.Interface)
+		}
+		u := update{snapshot: true, stations: f.Stations, unknown: f.Unknown}
+		if src.init(u) {
+			// The source's first snapshot.
+			s.mu.Lock()
+			s.notifyChanged()
+			s.mu.Unlock()
+		} else if !src.enqueue(u, c.closed) {
+			return net.ErrClosed
+		}
+		// Acknowledge snapshots, so that the agent's
+		// connection is known to be alive.
+		return c.writeFrame(frame{Type: frameAck, Seq: seq})
+
+	case frameEvent:
+		s.mu.Lock()
+		src := ag.sources[f.Interface]
+		replayed := f.Seq <= ag.seq
+		s.mu.Unlock()
+		// Events replayed after reconnecting may have already been received.
+		// Events of a source that has yet to be snapshotted are covered by
+		// its first snapshot.
+		if !replayed && src != nil && src.isReady() {
+			if !src.enqueue(update{event: f.Event}, c.closed) {
+				return net.ErrClosed
+			}
+		}
+		s.mu.Lock()
+		if f.Seq > ag.seq {
+			ag.seq = f.Seq
+		}
+		s.mu.Unlock()
+		return c.writeFrame(frame{Type: frameAck, Seq: f.Seq})
+
+	default:
+		return fmt.Errorf("unexpected %q frame", f.Type)
+	}
+}
+
+// update is a change of a Source's stations, received from its agent.
+type update struct {
+	event    string            // Raw event, if not a snapshot.
+	snapshot bool              // Whether stations is a snapshot of the stations.
+	stations []hostapd.Station // Stations of the snapshot.
+	unknown  bool              // The agent is unable to list the stations.
+}
+
+func newSource(agentName, ifname string) *Source {
+	return &Source{
+		agent:    agentName,
+		ifname:   ifname,
+		queue:    make(chan update, queueLen),
+		removed:  make(chan struct{}),
+		stations: make(map[string]hostapd.Station),
+	}
+}
+
+// Source is an interface of an agent. Its connected stations are those of
+// the agent's most recent snapshot, updated by the events received since.
+// When a snapshot differs from the stations connected according to events,
+// for example because events were lost while hostapd restarted, the
+// differences are reported as station connect and disconnect events.
+//
+// Source implements presence.Source.
+type Source struct {
+	agent   string
+	ifname  string
+	queue   chan update
+	removed chan struct{} // Closed when removed from the server.
+
+	mu       sync.Mutex // Protects following.
+	status   hostapd.Status
+	ready    bool // Whether the first snapshot has been received.
+	unknown  bool
+	stations map[string]hostapd.Station // Connected stations, by lower case ID (see hostapd.Station.ID).
+}
+
+// String returns the name of the agent and interface, e.g. "ap1/wlan0".
+func (s *Source) String() string {
+	return s.agent + "/" + s.ifname
+}
+
+// Interface returns the name of the agent's network interface.
+func (s *Source) Interface() string {
+	return s.ifname
+}
+
+// Status returns the most recent status received from the agent.
+func (s *Source) Status(ctx context.Context) (hostapd.Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status, nil
+}
+
+// Stations returns the connected stations. hostapd.ErrUnknownCmd is returned
+// if the agent is unable to list the interface's stations.
+func (s *Source) Stations(ctx context.Context) ([]hostapd.Station, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.unknown {
+		return nil, fmt.Errorf("%s: %w", s, hostapd.ErrUnknownCmd("STA-FIRST"))
+	}
+	stations := make([]hostapd.Station, 0, len(s.stations))
+	for _, sta := range s.stations {
+		stations = append(stations, sta)
+	}
+	sort.Slice(stations, func(i, j int) bool { return stations[i].MAC < stations[j].MAC })
+	return stations, nil
+}
+
+// Attach calls events with the events received from the agent, along with the
+// station connect and disconnect events of differences between snapshots and
+// the preceding events. Events that cannot be parsed, and redundant station
+// events, are ignored. It blocks
+// until the context is done, or the source is removed.
+func (s *Source) Attach(ctx context.Context, events func(hostapd.Event) error) error {
+	for {
+		var u update
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.removed:
+			return nil
+		case u = <-s.queue:
+		}
+
+		var evts []hostapd.Event
+		if u.snapshot {
+			evts = s.applySnapshot(u)
+		} else if e, err := hostapd.ParseEvent(u.event); err == nil && s.applyEvent(e) {
+			evts = append(evts, e)
+		}
+		for _, e := range evts {
+			if err := events(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Source) isReady() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ready
+}
+
+func (s *Source) setStatus(status hostapd.Status) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+// init applies the first snapshot, returning false if the source
+// already had one.
+func (s *Source) init(u update) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ready {
+		return false
+	}
+	s.ready = true
+	s.setStations(u)
+	return true
+}
+
+// enqueue queues the update for Attach, returning false if the source
+// was removed or the connection closed while waiting.
+func (s *Source) enqueue(u update, closed <-chan struct{}) bool {
+	select {
+	case s.queue <- u:
+		return true
+	case <-s.removed:
+		return false
+	case <-closed:
+		return false
+	}
+}
+
+func (s *Source) remove() {
+	close(s.removed)
+}
+
+// setStations replaces the connected stations with those of the
+// snapshot. s.mu must be held.
+func (s *Source) setStations(u update) {
+	s.unknown = u.unknown
+	if u.unknown {
+		return
+	}
+	s.stations = make(map[string]hostapd.Station, len(u.stations))
+	for _, sta := range u.stations {
+		if sta.Associated {
+			s.stations[strings.ToLower(sta.ID())] = sta
+		}
+	}
+}
+
+// applySnapshot applies the snapshot, returning events for the stations
+// that connected or disconnected without an event.
+func (s *Source) applySnapshot(u update) []hostapd.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev := s.stations
+	s.setStations(u)
+	if u.unknown {
+		return nil
+	}
+
+	var evts []hostapd.Event
+	add := func(name, mac string) {
+		if e, err := hostapd.ParseEvent(fmt.Sprintf("%s %s", name, mac)); err == nil {
+			evts = append(evts, e)
+		}
+	}
+	for _, mac := range sortedMACs(s.stations) {
+		if _, ok := prev[mac]; !ok {
+			add("AP-STA-CONNECTED", mac)
+		}
+	}
+	for _, mac := range sortedMACs(prev) {
+		if _, ok := s.stations[mac]; !ok {
+			add("AP-STA-DISCONNECTED", mac)
+		}
+	}
+	return evts
+}
+
+// applyEvent updates the connected stations using the event, returning false
+// if the event is redundant, e.g. the disconnect event of a station whose
+// departure was already reported because of a snapshot.
+func (s *Source) applyEvent(e hostapd.Event) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch e := e.(type) {
+	case hostapd.EventStationConnect:
+		mac := strings.ToLower(e.ID())
+		if _, ok := s.stations[mac]; ok {
+			return false
+		}
+		s.stations[mac] = hostapd.Station{MAC: mac, Associated: true}
+	case hostapd.EventStationDisconnect:
+		mac := strings.ToLower(e.ID())
+		if _, ok := s.stations[mac]; !ok {
+			return false
+		}
+		delete(s.stations, mac)
+	}
+	return true
+}
+
+func sortedMACs(stations map[string]hostapd.Station) []string {
+	macs := make([]string, 0, len(stations))
+	for mac := range stations {
+		macs = append(macs, mac)
+	}
+	sort.Strings(macs)
+	return macs
+}
@@ -0,0 +1,5 @@
+// Package syslog reads hostapd's log messages, received from a syslog client
+// or read from a stream such as the output of OpenWrt's `logread -f`, and uses
+// them as a source of stations. It is intended for when wifi-presence is unable
+// to reach hostapd's control interface, for example when it runs on another host.
+package syslog
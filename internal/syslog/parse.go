@@ -0,0 +1,215 @@
+package syslog
+
+import (
+	"strings"
+	"time"
+
+	"github.com/awilliams/wifi-presence/internal/hostapd"
+)
+
+// Timestamp formats of the supported log formats.
+const (
+	// RFC 3164 (BSD syslog), e.g. "Oct  6 12:00:00".
+	bsdTimestamp = time.Stamp
+	// OpenWrt's logread, e.g. "Thu Oct  6 12:00:00 2022".
+	logreadTimestamp = time.ANSIC
+)
+
+// message is a log message.
+type message struct {
+	hostname string // May be blank.
+	app      string // Program that logged the message, e.g. "hostapd". May be blank.
+	text     string
+}
+
+// parseLine parses a log line in one of the following formats, returning the
+// message it contains:
+//
+//	<30>1 2022-10-06T12:00:00.000Z ap1 hostapd - - - wlan0: AP-STA-CONNECTED ... (RFC 5424)
+//	<30>Oct  6 12:00:00 ap1 hostapd: wlan0: AP-STA-CONNECTED ...                (RFC 3164)
+//	Thu Oct  6 12:00:00 2022 daemon.notice hostapd: wlan0: AP-STA-CONNECTED ... (logread)
+//
+// Lines in any other format are returned as the message's text.
+func parseLine(line string) message {
+	line = strings.TrimRight(line, "\r\n")
+
+	if rest, ok := cutPriority(line); ok {
+		if strings.HasPrefix(rest, "1 ") {
+			if m, ok := parseRFC5424(rest[2:]); ok {
+				return m
+			}
+		}
+		return parseRFC3164(rest)
+	}
+
+	if len(line) > len(logreadTimestamp) {
+		if _, err := time.Parse(logreadTimestamp, line[:len(logreadTimestamp)]); err == nil {
+			// The timestamp is followed by the facility and level.
+			rest := strings.TrimPrefix(line[len(logreadTimestamp):], " ")
+			if _, rest, ok := strings.Cut(rest, " "); ok {
+				return parseTag(rest)
+			}
+		}
+	}
+
+	return message{text: line}
+}
+
+// cutPriority removes the priority, e.g. "<30>", that prefixes syslog messages.
+func cutPriority(line string) (string, bool) {
+	if !strings.HasPrefix(line, "<") {
+		return "", false
+	}
+	end := strings.IndexByte(line, '>')
+	if end < 2 || end > 4 {
+		return "", false
+	}
+	for _, c := range line[1:end] {
+		if c < '0' || c > '9' {
+			return "", false
+		}
+	}
+	return line[end+1:], true
+}
+
+// parseRFC5424 parses the message following the priority and version, i.e.
+// "TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]".
+func parseRFC5424(s string) (message, bool) {
+	fields := strings.SplitN(s, " ", 6)
+	if len(fields) < 6 {
+		return message{}, false
+	}
+	m := message{
+		hostname: nilValue(fields[1]),
+		app:      nilValue(fields[2]),
+	}
+
+	rest := fields[5]
+	switch {
+	case strings.HasPrefix(rest, "-"):
+		rest = rest[1:]
+	case strings.HasPrefix(rest, "["):
+		end := structuredDataEnd(rest)
+		if end < 0 {
+			return message{}, false
+		}
+		rest = rest[end:]
+	default:
+		return message{}, false
+	}
+	// The message may be prefixed by a UTF-8 byte order mark.
+	m.text = strings.TrimPrefix(strings.TrimPrefix(rest, " "), "\ufeff")
+	return m, true
+}
+
+// structuredDataEnd returns the index following the structured data elements
+// that s begins with, e.g. `[exampleSDID@32473 iut="3"]`, or -1 if they are
+// not terminated. Within parameter values, '"', '\' and ']' are escaped by '\'.
+func structuredDataEnd(s string) int {
+	var inValue, escaped bool
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case escaped:
+			escaped = false
+		case inValue && c == '\\':
+			escaped = true
+		case c == '"':
+			inValue = !inValue
+		case !inValue && c == ']':
+			if i+1 == len(s) || s[i+1] != '[' {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// nilValue returns v, or "" if v is the NILVALUE "-".
+func nilValue(v string) string {
+	if v == "-" {
+		return ""
+	}
+	return v
+}
+
+// parseRFC3164 parses the message following the priority, i.e. "TIMESTAMP
+// HOSTNAME TAG: MSG". The timestamp and hostname are omitted by some clients.
+func parseRFC3164(s string) message {
+	if len(s) > len(bsdTimestamp) {
+		if _, err := time.Parse(bsdTimestamp, s[:len(bsdTimestamp)]); err == nil {
+			s = strings.TrimPrefix(s[len(bsdTimestamp):], " ")
+		}
+	}
+
+	// The hostname, if present, is followed by the tag.
+	first, rest, _ := strings.Cut(s, " ")
+	if strings.HasSuffix(first, ":") || strings.Contains(first, "[") {
+		return parseTag(s)
+	}
+	m := parseTag(rest)
+	if m.app == "" {
+		// Not a hostname after all.
+		return message{text: s}
+	}
+	m.hostname = first
+	return m
+}
+
+// parseTag parses "TAG: MSG", where the tag is the name of the program
+// optionally followed by its PID, e.g. "hostapd[1234]".
+func parseTag(s string) message {
+	tag, text, ok := strings.Cut(s, ": ")
+	if !ok || tag == "" || strings.Contains(tag, " ") {
+		return message{text: s}
+	}
+	app, _, _ := strings.Cut(tag, "[")
+	return message{app: app, text: text}
+}
+
+// staMessages are the station messages that hostapd logs, of the form
+// "STA <MAC> <MODULE>: <MESSAGE>", mapped to the equivalent control interface
+// event. Association precedes authentication, so only the completion of the
+// WPA handshake indicates that a station is connected.
+var staMessages = map[string]string{
+	"IEEE 802.11: disassociated":            "AP-STA-DISCONNECTED",
+	"IEEE 802.11: deauthenticated":          "AP-STA-DISCONNECTED",
+	"WPA: pairwise key handshake completed": "AP-STA-CONNECTED",
+}
+
+// parseMessage parses a message logged by hostapd, which are prefixed by the
+// interface, e.g. "wlan0: AP-STA-CONNECTED 04:ab:00:12:34:56", returning the
+// interface and the equivalent control interface event. Messages that are not
+// events, or that are unrecognized, are ignored by returning a nil event.
+func parseMessage(text string) (string, hostapd.Event) {
+	ifname, msg, ok := strings.Cut(text, ": ")
+	if !ok || ifname == "" || strings.Contains(ifname, " ") {
+		return "", nil
+	}
+
+	if strings.HasPrefix(msg, "STA ") {
+		// E.g. "STA 04:ab:00:12:34:56 IEEE 802.11: disassociated".
+		fields := strings.SplitN(msg, " ", 3)
+		if len(fields) < 3 {
+			return "", nil
+		}
+		for prefix, name := range staMessages {
+			if strings.HasPrefix(fields[2], prefix) {
+				e, err := hostapd.ParseEvent(name + " " + fields[1])
+				if err != nil {
+					return "", nil
+				}
+				return ifname, e
+			}
+		}
+		return "", nil
+	}
+
+	e, err := hostapd.ParseEvent(msg)
+	if err != nil {
+		return "", nil
+	}
+	if _, ok := e.(hostapd.EventUnrecognized); ok {
+		return "", nil
+	}
+	return ifname, e
+}
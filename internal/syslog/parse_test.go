@@ -0,0 +1,83 @@
+package syslog
+
+import "testing"
+
+func TestParseLine(t *testing.T) {
+	testCases := []struct {
+		line string
+		want message
+	}{
+		{
+			line: "<30>1 2022-10-06T12:00:00.000Z ap1 hostapd 1234 - - wlan0: AP-STA-CONNECTED 04:ab:00:12:34:56",
+			want: message{hostname: "ap1", app: "hostapd", text: "wlan0: AP-STA-CONNECTED 04:ab:00:12:34:56"},
+		},
+		{
+			line: `<30>1 2022-10-06T12:00:00.000Z ap1 hostapd - - [meta sequenceId="1" note="a \"quoted\] value"][origin ip="10.0.0.2"] ` + "\ufeff" + "wlan0: AP-ENABLED",
+			want: message{hostname: "ap1", app: "hostapd", text: "wlan0: AP-ENABLED"},
+		},
+		{
+			line: "<30>1 - - - - - -",
+			want: message{},
+		},
+		{
+			line: "<30>Oct  6 12:00:00 ap1 hostapd[1234]: wlan0: AP-STA-CONNECTED 04:ab:00:12:34:56\n",
+			want: message{hostname: "ap1", app: "hostapd", text: "wlan0: AP-STA-CONNECTED 04:ab:00:12:34:56"},
+		},
+		{
+			line: "<30>Oct  6 12:00:00 hostapd: wlan0: AP-ENABLED",
+			want: message{app: "hostapd", text: "wlan0: AP-ENABLED"},
+		},
+		{
+			line: "<30>hostapd: wlan0: AP-ENABLED",
+			want: message{app: "hostapd", text: "wlan0: AP-ENABLED"},
+		},
+		{
+			line: "<30>something else entirely",
+			want: message{text: "something else entirely"},
+		},
+		{
+			line: "Thu Oct  6 12:00:00 2022 daemon.notice hostapd: wlan0: STA 04:ab:00:12:34:56 IEEE 802.11: disassociated",
+			want: message{app: "hostapd", text: "wlan0: STA 04:ab:00:12:34:56 IEEE 802.11: disassociated"},
+		},
+		{
+			line: "wlan0: AP-STA-DISCONNECTED 04:ab:00:12:34:56",
+			want: message{text: "wlan0: AP-STA-DISCONNECTED 04:ab:00:12:34:56"},
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := parseLine(tc.line); got != tc.want {
+			t.Errorf("got parseLine(%q) %+v; want %+v", tc.line, got, tc.want)
+		}
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	// Messages mapped to the raw string of their event, if any.
+	testCases := map[string]string{
+		"wlan0: AP-STA-CONNECTED 04:ab:00:12:34:56 keyid=guest":                       "AP-STA-CONNECTED 04:ab:00:12:34:56 keyid=guest",
+		"wlan0: AP-STA-DISCONNECTED 04:ab:00:12:34:56":                                "AP-STA-DISCONNECTED 04:ab:00:12:34:56",
+		"wlan0: STA 04:ab:00:12:34:56 WPA: pairwise key handshake completed (RSN)":    "AP-STA-CONNECTED 04:ab:00:12:34:56",
+		"wlan0: STA 04:ab:00:12:34:56 IEEE 802.11: disassociated":                     "AP-STA-DISCONNECTED 04:ab:00:12:34:56",
+		"wlan0: STA 04:ab:00:12:34:56 IEEE 802.11: deauthenticated due to inactivity": "AP-STA-DISCONNECTED 04:ab:00:12:34:56",
+		"wlan0: STA 04:ab:00:12:34:56 IEEE 802.11: associated (aid 1)":                "",
+		"wlan0: interface state UNINITIALIZED->ENABLED":                               "",
+		"wlan0: AP-STA-CONNECTED invalid":                                             "",
+		"AP-STA-CONNECTED 04:ab:00:12:34:56":                                          "",
+		"":                                                                            "",
+	}
+
+	for text, want := range testCases {
+		var got string
+		ifname, e := parseMessage(text)
+		if e != nil {
+			got = e.Raw()
+			if ifname != "wlan0" {
+				t.Errorf("got parseMessage(%q) interface %q; want %q", text, ifname, "wlan0")
+			}
+		}
+		if got != want {
+			t.Errorf("got parseMessage(%q) %q; want %q", text, got, want)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/awilliams/wifi-presence/internal/hostapd"
+)
+
+// sourceName is the name used as the interface and SSID of Source.
+const sourceName = "syslog"
+
+// maxMessageLen is the maximum length of a message. Longer messages
+// are discarded.
+const maxMessageLen = 64 * 1024
+
+// errEndOfInput is returned by Attach once all lines of a Source
+// created using NewReader have been read.
+var errEndOfInput = errors.New("end of input")
+
+// Open returns a Source reading hostapd's log messages from addr, which is
+// either a syslog address of the form udp://host:port or tcp://host:port, "-"
+// to read lines from stdin, or the path of a FIFO to read lines from, e.g. one
+// that the output of `logread -f` is written to.
+func Open(addr string) (*Source, error) {
+	switch {
+	case addr == "-":
+		return NewReader(os.Stdin), nil
+	case strings.HasPrefix(addr, "udp://"):
+		return Listen("udp", strings.TrimPrefix(addr, "udp://"))
+	case strings.HasPrefix(addr, "tcp://"):
+		return Listen("tcp", strings.TrimPrefix(addr, "tcp://"))
+	default:
+		return OpenFIFO(addr)
+	}
+}
+
+// Listen returns a Source receiving syslog messages on the address of the
+// network, which is "udp" or "tcp". Messages received over TCP are either
+// separated by newlines, or prefixed by their length (octet counting, as
+// described by RFC 6587).
+func Listen(network, addr string) (*Source, error) {
+	switch network {
+	case "udp", "udp4", "udp6":
+		conn, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		s := newSource(network+"://"+conn.LocalAddr().String(), conn.LocalAddr(), conn.Close)
+		go s.readPackets(conn)
+		return s, nil
+
+	case "tcp", "tcp4", "tcp6":
+		ln, err := net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		s := newSource(network+"://"+ln.Addr().String(), ln.Addr(), ln.Close)
+		go s.serve(ln)
+		return s, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported network %q", network)
+	}
+}
+
+// NewReader returns a Source reading lines from r, e.g. stdin. Attach returns
+// an error once the end of r is reached.
+func NewReader(r io.Reader) *Source {
+	s := newSource("-", nil, func() error { return nil })
+	go func() {
+		if err := s.readLines(r); err != nil {
+			s.stop(err)
+			return
+		}
+		s.stop(errEndOfInput)
+	}()
+	return s
+}
+
+// OpenFIFO returns a Source reading lines from the FIFO (named pipe) at path.
+// Unlike NewReader, the FIFO may be written to by successive writers, e.g.
+// when `logread -f` is restarted.
+func OpenFIFO(path string) (*Source, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Mode()&os.ModeNamedPipe == 0 {
+		return nil, fmt.Errorf("%s is not a FIFO", path)
+	}
+
+	// Opening the FIFO for writing as well as reading prevents the
+	// end of file being read when the last writer closes it.
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	s := newSource(path, nil, f.Close)
+	go func() {
+		if err := s.readLines(f); err != nil {
+			s.stop(err)
+		}
+	}()
+	return s, nil
+}
+
+// newSource returns a Source whose pseudo BSSID is derived from the name
+// of what it reads from.
+func newSource(name string, addr net.Addr, closer func() error) *Source {
+	return &Source{
+		bssid:    hostapd.PseudoBSSID(sourceName + "/" + name),
+		addr:     addr,
+		closer:   closer,
+		lines:    make(chan string),
+		closed:   make(chan struct{}),
+		done:     make(chan struct{}),
+		stations: make(map[string]station),
+	}
+}
+
+// Source reports the stations of hostapd's log messages. The control interface
+// events that hostapd logs (e.g. "wlan0: AP-STA-CONNECTED 04:ab:00:12:34:56")
+// are used, along with its station messages (e.g. "wlan0: STA 04:ab:00:12:34:56
+// IEEE 802.11: disassociated"). Messages are expected in the RFC 5424 or RFC
+// 3164 syslog formats, the format of OpenWrt's logread, or without any header.
+//
+// Since stations are only known from their messages, stations that connected
+// before the Source was created are unknown until they reconnect.
+//
+// All access points and interfaces whose messages are received are reported
+// as a single BSS. A station is attributed to the host and interface of its
+// most recent connect message, and disconnect messages from others, e.g. those
+// logged late by the access point a station roamed from, are ignored.
+//
+// Source implements presence.Source.
+type Source struct {
+	bssid  string   // Pseudo BSSID, derived from what is read from.
+	addr   net.Addr // Address listening on, if any.
+	closer func() error
+
+	lines  chan string
+	closed chan struct{} // Closed by Close.
+
+	stopOnce sync.Once
+	done     chan struct{} // Closed when no more lines will be read.
+	err      error         // Reason no more lines will be read, set before done is closed.
+
+	closeOnce sync.Once
+
+	mu       sync.Mutex         // Protects following.
+	stations map[string]station // Stations seen, by MAC.
+}
+
+// station is the state of a station, according to its messages.
+type station struct {
+	connected bool
+	host      string // Hostname of the most recent connect message, which may be blank.
+	ifname    string // Interface of the most recent connect message.
+}
+
+// Addr returns the address the Source is listening on, or nil if it
+// is not listening.
+func (s *Source) Addr() net.Addr {
+	return s.addr
+}
+
+// Close stops receiving or reading lines.
+func (s *Source) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		err = s.closer()
+	})
+	return err
+}
+
+// Interface returns "syslog".
+func (s *Source) Interface() string {
+	return sourceName
+}
+
+// Status returns a status with a single BSS named "syslog". Stations are not
+// attributed to the interface of their messages. The BSSID is a pseudo BSSID
+// derived from the address, FIFO or reader that messages are read from.
+func (s *Source) Status(ctx context.Context) (hostapd.Status, error) {
+	return hostapd.Status{
+		State: "ENABLED",
+		SSID:  sourceName,
+		BSSID: s.bssid,
+		BSS: []hostapd.BSS{
+			{Interface: sourceName, SSID: sourceName, BSSID: s.bssid},
+		},
+	}, nil
+}
+
+// Stations returns the stations that are connected according to their
+// most recent messages.
+func (s *Source) Stations(ctx context.Context) ([]hostapd.Station, error) {
+	s.mu.Lock()
+	macs := make([]string, 0, len(s.stations))
+	for mac, sta := range s.stations {
+		if sta.connected {
+			macs = append(macs, mac)
+		}
+	}
+	s.mu.Unlock()
+	sort.Strings(macs)
+
+	stations := make([]hostapd.Station, 0, len(macs))
+	for _, mac := range macs {
+		stations = append(stations, hostapd.Station{
+			MAC:        mac,
+			Associated: true,
+		})
+	}
+	return stations, nil
+}
+
+// Attach calls events with the event of each of hostapd's messages that are
+// received. Since hostapd logs several messages when a station connects or
+// disconnects, repeated station connect and disconnect events are omitted, as
+// are disconnect events from other than the host and interface the station
+// last connected to. It blocks until the context is done, or an error occurs.
+func (s *Source) Attach(ctx context.Context, events func(hostapd.Event) error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.done:
+			return fmt.Errorf("%s: %w", sourceName, s.err)
+		case line := <-s.lines:
+			msg := parseLine(line)
+			ifname, e := parseMessage(msg.text)
+			if e == nil || !s.update(msg.hostname, ifname, e) {
+				continue
+			}
+			if err := events(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// update updates the station of a station connect or disconnect event logged
+// by the host's interface, returning false if the station's state is unchanged,
+// or if it is a disconnect from other than the host and interface the station
+// last connected to.
+func (s *Source) update(host, ifname string, e hostapd.Event) bool {
+	var (
+		mac       string
+		connected bool
+	)
+	switch e := e.(type) {
+	case hostapd.EventStationConnect:
+		mac, connected = e.MAC, true
+	case hostapd.EventStationDisconnect:
+		mac = e.MAC
+	default:
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, ok := s.stations[mac]
+	if connected {
+		s.stations[mac] = station{connected: true, host: host, ifname: ifname}
+		return !prev.connected
+	}
+	if ok && (!prev.connected || prev.host != host || prev.ifname != ifname) {
+		return false
+	}
+	s.stations[mac] = station{}
+	return true
+}
+
+// send sends the line to Attach, returning false if the Source is closed.
+func (s *Source) send(line string) bool {
+	if line = strings.TrimRight(line, "\r\n"); line == "" {
+		return true
+	}
+	select {
+	case s.lines <- line:
+		return true
+	case <-s.closed:
+		return false
+	}
+}
+
+// stop stops Attach with the error.
+func (s *Source) stop(err error) {
+	s.stopOnce.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+// isClosed returns whether Close was called.
+func (s *Source) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// readLines reads and sends each line of r until the end of r is reached,
+// or the Source is closed.
+func (s *Source) readLines(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), maxMessageLen)
+	for scanner.Scan() {
+		if !s.send(scanner.Text()) {
+			return nil
+		}
+	}
+	if s.isClosed() {
+		return nil
+	}
+	return scanner.Err()
+}
+
+// readPackets receives syslog messages, one or more lines per packet.
+func (s *Source) readPackets(conn net.PacketConn) {
+	buf := make([]byte, maxMessageLen)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if !s.isClosed() {
+				s.stop(err)
+			}
+			return
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			if !s.send(line) {
+				return
+			}
+		}
+	}
+}
+
+// serve accepts syslog clients, receiving their messages.
+func (s *Source) serve(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if !s.isClosed() {
+				s.stop(err)
+			}
+			return
+		}
+
+		done := make(chan struct{})
+		go func() {
+			// Disconnect clients when the Source is closed.
+			select {
+			case <-s.closed:
+				conn.Close()
+			case <-done:
+			}
+		}()
+		go func() {
+			defer close(done)
+			defer conn.Close()
+			_ = s.readFrames(bufio.NewReader(conn))
+		}()
+	}
+}
+
+// readFrames reads messages that are either terminated by a newline, or
+// prefixed by their length followed by a space, until an error occurs.
+func (s *Source) readFrames(r *bufio.Reader) error {
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return err
+		}
+
+		var frame string
+		if b[0] >= '0' && b[0] <= '9' {
+			// Octet counting, e.g. "31 <30>hostapd: wlan0: AP-ENABLED".
+			prefix, err := r.ReadString(' ')
+			if err != nil {
+				return err
+			}
+			n, err := strconv.Atoi(strings.TrimSuffix(prefix, " "))
+			if err != nil || n > maxMessageLen {
+				return fmt.Errorf("invalid message length %q", prefix)
+			}
+			buf := make([]byte, n)
+			if _, err = io.ReadFull(r, buf); err != nil {
+				return err
+			}
+			frame = string(buf)
+		} else {
+			if frame, err = r.ReadString('\n'); err != nil {
+				s.send(frame)
+				return err
+			}
+		}
+		if !s.send(frame) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package syslog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/awilliams/wifi-presence/internal/hostapd"
+)
+
+// attach calls Attach in a goroutine, returning channels of
+// its events and of its error.
+func attach(t *testing.T, s *Source) (<-chan hostapd.Event, <-chan error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	events := make(chan hostapd.Event, 8)
+	attachErr := make(chan error, 1)
+	go func() {
+		attachErr <- s.Attach(ctx, func(e hostapd.Event) error {
+			events <- e
+			return nil
+		})
+	}()
+	return events, attachErr
+}
+
+// expectEvents waits for events with the given raw strings.
+func expectEvents(t *testing.T, events <-chan hostapd.Event, attachErr <-chan error, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case got := <-events:
+			if got.Raw() != w {
+				t.Fatalf("got event %q; want %q", got.Raw(), w)
+			}
+		case err := <-attachErr:
+			t.Fatalf("Attach() err: %v", err)
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for event %q", w)
+		}
+	}
+}
+
+func TestSource_reader(t *testing.T) {
+	lines := []string{
+		"Thu Oct  6 12:00:00 2022 daemon.notice hostapd: wlan0: STA 04:ab:00:12:34:56 IEEE 802.11: associated (aid 1)",
+		"Thu Oct  6 12:00:00 2022 daemon.notice hostapd: wlan0: AP-STA-CONNECTED 04:ab:00:12:34:56",
+		"Thu Oct  6 12:00:00 2022 daemon.info hostapd: wlan0: STA 04:ab:00:12:34:56 WPA: pairwise key handshake completed (RSN)",
+		"Thu Oct  6 12:00:01 2022 daemon.notice hostapd: wlan1: AP-STA-CONNECTED 04:ab:00:12:34:57",
+		"Thu Oct  6 12:00:01 2022 daemon.info dnsmasq-dhcp[1234]: DHCPACK(br-lan) 192.168.1.10 04:ab:00:12:34:56 phone",
+		"Thu Oct  6 12:01:00 2022 daemon.info hostapd: wlan0: STA 04:ab:00:12:34:56 IEEE 802.11: disassociated",
+		"Thu Oct  6 12:01:00 2022 daemon.notice hostapd: wlan0: AP-STA-DISCONNECTED 04:ab:00:12:34:56",
+	}
+	s := NewReader(strings.NewReader(strings.Join(lines, "\n")))
+	defer s.Close()
+
+	events, attachErr := attach(t, s)
+	// Repeated connect and disconnect events are omitted.
+	expectEvents(t, events, attachErr,
+		"AP-STA-CONNECTED 04:ab:00:12:34:56",
+		"AP-STA-CONNECTED 04:ab:00:12:34:57",
+		"AP-STA-DISCONNECTED 04:ab:00:12:34:56",
+	)
+
+	select {
+	case err := <-attachErr:
+		if !errors.Is(err, errEndOfInput) {
+			t.Fatalf("got Attach() err %v; want %v", err, errEndOfInput)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for Attach to return")
+	}
+	select {
+	case e := <-events:
+		t.Fatalf("got unexpected event %q", e.Raw())
+	default:
+	}
+
+	stations, err := s.Stations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []hostapd.Station{{MAC: "04:ab:00:12:34:57", Associated: true}}
+	if !reflect.DeepEqual(stations, want) {
+		t.Fatalf("got Stations() %+v; want %+v", stations, want)
+	}
+}
+
+func TestSource_roaming(t *testing.T) {
+	lines := []string{
+		"<30>Oct  6 12:00:00 ap1 hostapd: wlan0: AP-STA-CONNECTED 04:ab:00:12:34:56",
+		"<30>Oct  6 12:01:00 ap2 hostapd: wlan0: AP-STA-CONNECTED 04:ab:00:12:34:56",
+		// The disconnect from the previous access point is logged late.
+		"<30>Oct  6 12:01:01 ap1 hostapd: wlan0: AP-STA-DISCONNECTED 04:ab:00:12:34:56",
+		"<30>Oct  6 12:02:00 ap2 hostapd: wlan1: AP-STA-DISCONNECTED 04:ab:00:12:34:56",
+		"<30>Oct  6 12:03:00 ap2 hostapd: wlan0: AP-STA-DISCONNECTED 04:ab:00:12:34:56",
+	}
+	s := NewReader(strings.NewReader(strings.Join(lines, "\n")))
+	defer s.Close()
+
+	events, attachErr := attach(t, s)
+	select {
+	case err := <-attachErr:
+		if !errors.Is(err, errEndOfInput) {
+			t.Fatalf("got Attach() err %v; want %v", err, errEndOfInput)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for Attach to return")
+	}
+
+	// Events are sent before Attach returns.
+	var got []string
+	for len(events) > 0 {
+		got = append(got, (<-events).Raw())
+	}
+	want := []string{
+		"AP-STA-CONNECTED 04:ab:00:12:34:56",
+		"AP-STA-DISCONNECTED 04:ab:00:12:34:56",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got events %q; want %q", got, want)
+	}
+}
+
+func TestSource_bssid(t *testing.T) {
+	bssid := func(s *Source) string {
+		t.Helper()
+		status, err := s.Status(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		bss, ok := status.BSSByInterface(s.Interface())
+		if !ok {
+			t.Fatalf("no BSS for interface %q", s.Interface())
+		}
+		if _, err = net.ParseMAC(bss.BSSID); err != nil {
+			t.Fatalf("got invalid BSSID %q: %v", bss.BSSID, err)
+		}
+		return bss.BSSID
+	}
+
+	// Sources running at once have different BSSIDs.
+	s1, err := Open("udp://127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s1.Close()
+	s2, err := Open("tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Close()
+	if b1, b2 := bssid(s1), bssid(s2); b1 == b2 {
+		t.Fatalf("got BSSID %q of both sources", b1)
+	}
+
+	// The BSSID is derived from the address.
+	addr := s1.Addr().String()
+	s1.Close()
+	s3, err := Open("udp://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s3.Close()
+	if b1, b3 := bssid(s1), bssid(s3); b1 != b3 {
+		t.Fatalf("got BSSID %q; want %q", b3, b1)
+	}
+}
+
+func TestSource_udp(t *testing.T) {
+	s, err := Open("udp://127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	events, attachErr := attach(t, s)
+
+	conn, err := net.Dial("udp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	fmt.Fprint(conn, "<30>1 2022-10-06T12:00:00.000Z ap1 hostapd - - - wlan0: AP-STA-CONNECTED 04:ab:00:12:34:56")
+	expectEvents(t, events, attachErr, "AP-STA-CONNECTED 04:ab:00:12:34:56")
+	fmt.Fprint(conn, "<30>Oct  6 12:00:00 ap1 hostapd: wlan0: AP-STA-DISCONNECTED 04:ab:00:12:34:56\n")
+	expectEvents(t, events, attachErr, "AP-STA-DISCONNECTED 04:ab:00:12:34:56")
+}
+
+func TestSource_tcp(t *testing.T) {
+	s, err := Open("tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	events, attachErr := attach(t, s)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Newline separated, and octet counted messages.
+	fmt.Fprint(conn, "<30>Oct  6 12:00:00 ap1 hostapd: wlan0: AP-STA-CONNECTED 04:ab:00:12:34:56\n")
+	msg := "<30>1 2022-10-06T12:00:00.000Z ap1 hostapd - - - wlan0: AP-STA-DISCONNECTED 04:ab:00:12:34:56"
+	fmt.Fprintf(conn, "%d %s", len(msg), msg)
+	expectEvents(t, events, attachErr,
+		"AP-STA-CONNECTED 04:ab:00:12:34:56",
+		"AP-STA-DISCONNECTED 04:ab:00:12:34:56",
+	)
+
+	// Clients are disconnected once closed.
+	s.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("got Read() err %v; want connection closed", err)
+	}
+}
+
+func TestSource_fifo(t *testing.T) {
+	fifo := path.Join(t.TempDir(), "hostapd.log")
+	if err := syscall.Mkfifo(fifo, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Open(fifo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	events, attachErr := attach(t, s)
+
+	// Lines are read from successive writers.
+	for _, line := range []string{
+		"wlan0: AP-STA-CONNECTED 04:ab:00:12:34:56",
+		"wlan0: AP-STA-DISCONNECTED 04:ab:00:12:34:56",
+	} {
+		w, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintln(w, line)
+		w.Close()
+		expectEvents(t, events, attachErr, strings.TrimPrefix(line, "wlan0: "))
+	}
+
+	if _, err = Open(path.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("expected error opening missing FIFO")
+	}
+}
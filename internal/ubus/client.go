@@ -0,0 +1,398 @@
+package ubus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultSocket is the location of ubusd's socket on OpenWrt.
+const DefaultSocket = "/var/run/ubus/ubus.sock"
+
+// ErrUnsubscribed is returned by Subscribe when the object being
+// subscribed to is removed, for example because its process exited.
+var ErrUnsubscribed = errors.New("object was removed")
+
+// ErrClosed is returned when the connection to ubusd is closed.
+var ErrClosed = errors.New("ubus connection closed")
+
+// StatusError is a non-zero status returned by ubusd, or by the
+// object a method was invoked on.
+type StatusError int32
+
+// Status codes, from libubus' ubusmsg.h.
+const (
+	StatusInvalidCommand   StatusError = 1
+	StatusInvalidArgument  StatusError = 2
+	StatusMethodNotFound   StatusError = 3
+	StatusNotFound         StatusError = 4
+	StatusNoData           StatusError = 5
+	StatusPermissionDenied StatusError = 6
+	StatusTimeout          StatusError = 7
+	StatusNotSupported     StatusError = 8
+	StatusUnknownError     StatusError = 9
+	StatusConnectionFailed StatusError = 10
+)
+
+func (e StatusError) Error() string {
+	names := map[StatusError]string{
+		StatusInvalidCommand:   "invalid command",
+		StatusInvalidArgument:  "invalid argument",
+		StatusMethodNotFound:   "method not found",
+		StatusNotFound:         "not found",
+		StatusNoData:           "no response",
+		StatusPermissionDenied: "permission denied",
+		StatusTimeout:          "request timed out",
+		StatusNotSupported:     "operation not supported",
+		StatusUnknownError:     "unknown error",
+		StatusConnectionFailed: "connection failed",
+	}
+	if name, ok := names[e]; ok {
+		return fmt.Sprintf("ubus: %s", name)
+	}
+	return fmt.Sprintf("ubus: status %d", int32(e))
+}
+
+// Dial connects to ubusd's Unix socket at sock, typically DefaultSocket.
+func Dial(sock string) (*Client, error) {
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, err
+	}
+
+	// ubusd greets each client with a hello message.
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	hello, err := ReadMessage(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to read hello message: %w", err)
+	}
+	if hello.Type != MsgHello {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected message type %d; want hello", hello.Type)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	c := Client{
+		conn:    conn,
+		pending: make(map[uint16]*request),
+		subs:    make(map[uint32]*subscriber),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+
+	return &c, nil
+}
+
+// Client is a ubus client. Methods may be called concurrently.
+type Client struct {
+	conn net.Conn
+
+	writeMu sync.Mutex // Serializes writes to conn.
+
+	mu      sync.Mutex // Protects following.
+	seq     uint16
+	pending map[uint16]*request
+	subs    map[uint32]*subscriber // By subscriber object ID.
+	err     error                  // Reason the connection was closed.
+
+	done chan struct{} // Closed when readLoop returns.
+}
+
+// request is a pending request, waiting for its status.
+type request struct {
+	msgs chan Message
+	done chan struct{} // Closed when no longer waiting.
+}
+
+// subscriber receives notifications for a subscription.
+type subscriber struct {
+	notifications chan Message
+	removed       chan struct{} // Closed when the target object is removed.
+	stopped       chan struct{} // Closed when Subscribe returns.
+}
+
+// Close closes the connection to ubusd.
+func (c *Client) Close() error {
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
+
+// Lookup returns the ID of the object at path, e.g. "hostapd.wlan0".
+// StatusNotFound is returned if there is no such object.
+func (c *Client) Lookup(ctx context.Context, path string) (uint32, error) {
+	objs, err := c.lookup(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	id, ok := objs[path]
+	if !ok {
+		return 0, StatusNotFound
+	}
+	return id, nil
+}
+
+// List returns the paths of all objects matching the pattern, which may end
+// with a '*' wildcard, e.g. "hostapd.*". The paths are mapped to their IDs.
+func (c *Client) List(ctx context.Context, pattern string) (map[string]uint32, error) {
+	objs, err := c.lookup(ctx, pattern)
+	if errors.Is(err, StatusNotFound) {
+		return objs, nil
+	}
+	return objs, err
+}
+
+func (c *Client) lookup(ctx context.Context, path string) (map[string]uint32, error) {
+	var m Message
+	m.SetString(AttrObjPath, path)
+
+	objs := make(map[string]uint32)
+	err := c.request(ctx, MsgLookup, 0, m, func(data Message) error {
+		path, _ := data.String(AttrObjPath)
+		if id, ok := data.Uint32(AttrObjID); ok {
+			objs[path] = id
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to look up %q: %w", path, err)
+	}
+	return objs, nil
+}
+
+// Call invokes the method of the object with the given ID, returning the
+// method's response.
+func (c *Client) Call(ctx context.Context, id uint32, method string, args map[string]interface{}) (map[string]interface{}, error) {
+	var m Message
+	m.SetUint32(AttrObjID, id)
+	m.SetString(AttrMethod, method)
+	if err := m.SetTable(AttrData, args); err != nil {
+		return nil, err
+	}
+
+	resp := make(map[string]interface{})
+	err := c.request(ctx, MsgInvoke, id, m, func(data Message) error {
+		t, err := data.Table(AttrData)
+		if err != nil {
+			return err
+		}
+		for k, v := range t {
+			resp[k] = v
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to call %q: %w", method, err)
+	}
+	return resp, nil
+}
+
+// Subscribe subscribes to the notifications of the object with the given ID,
+// calling the callback with the type and data of each one. It blocks until the
+// context is done, or an error occurs. ErrUnsubscribed is returned if the
+// object is removed. An error returned by the callback stops Subscribe.
+func (c *Client) Subscribe(ctx context.Context, id uint32, notifications func(typ string, data map[string]interface{}) error) error {
+	// Notifications are delivered to a subscriber object
+	// owned by this client.
+	var subID uint32
+	err := c.request(ctx, MsgAddObject, 0, Message{}, func(data Message) error {
+		subID, _ = data.Uint32(AttrObjID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to add subscriber: %w", err)
+	}
+	defer func() {
+		var m Message
+		m.SetUint32(AttrObjID, subID)
+		removeCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = c.request(removeCtx, MsgRemoveObject, 0, m, nil)
+	}()
+
+	sub := subscriber{
+		notifications: make(chan Message, 16),
+		removed:       make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	c.mu.Lock()
+	c.subs[subID] = &sub
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.subs, subID)
+		c.mu.Unlock()
+		close(sub.stopped)
+	}()
+
+	var m Message
+	m.SetUint32(AttrObjID, subID)
+	m.SetUint32(AttrTarget, id)
+	if err = c.request(ctx, MsgSubscribe, 0, m, nil); err != nil {
+		return fmt.Errorf("unable to subscribe: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-c.done:
+			return c.closeErr()
+		case <-sub.removed:
+			return ErrUnsubscribed
+		case n := <-sub.notifications:
+			typ, _ := n.String(AttrMethod)
+			data, err := n.Table(AttrData)
+			if err != nil {
+				return fmt.Errorf("invalid %q notification: %w", typ, err)
+			}
+			if err = notifications(typ, data); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// request sends a message and waits for its status. The callback, if any,
+// is called with each data message received in response.
+func (c *Client) request(ctx context.Context, typ MsgType, peer uint32, m Message, data func(Message) error) error {
+	req := request{
+		msgs: make(chan Message, 1),
+		done: make(chan struct{}),
+	}
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	// Find an unused sequence number.
+	for {
+		c.seq++
+		if _, ok := c.pending[c.seq]; !ok {
+			break
+		}
+	}
+	seq := c.seq
+	c.pending[seq] = &req
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, seq)
+		c.mu.Unlock()
+		close(req.done)
+	}()
+
+	m.Type, m.Seq, m.Peer = typ, seq, peer
+	if err := c.write(m); err != nil {
+		return err
+	}
+
+	var dataErr error
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.done:
+			return c.closeErr()
+		case resp := <-req.msgs:
+			if resp.Type == MsgData {
+				if data != nil && dataErr == nil {
+					dataErr = data(resp)
+				}
+				continue
+			}
+			if status, _ := resp.Uint32(AttrStatus); status != 0 {
+				return StatusError(status)
+			}
+			return dataErr
+		}
+	}
+}
+
+func (c *Client) write(m Message) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return WriteMessage(c.conn, m)
+}
+
+func (c *Client) closeErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// readLoop reads messages until the connection is closed, delivering
+// responses to pending requests and notifications to subscribers.
+func (c *Client) readLoop() {
+	var err error
+	defer func() {
+		c.mu.Lock()
+		c.err = fmt.Errorf("%w: %v", ErrClosed, err)
+		c.mu.Unlock()
+		close(c.done)
+	}()
+
+	for {
+		var m Message
+		if m, err = ReadMessage(c.conn); err != nil {
+			return
+		}
+
+		switch m.Type {
+		case MsgData, MsgStatus:
+			c.mu.Lock()
+			req, ok := c.pending[m.Seq]
+			c.mu.Unlock()
+			if ok {
+				select {
+				case req.msgs <- m:
+				case <-req.done:
+				}
+			}
+
+		case MsgInvoke:
+			// Notifications are delivered as invocations
+			// of the subscriber object.
+			if !m.Bool(AttrNoReply) {
+				id, _ := m.Uint32(AttrObjID)
+				var status Message
+				status.Type, status.Seq, status.Peer = MsgStatus, m.Seq, m.Peer
+				status.SetUint32(AttrStatus, 0)
+				status.SetUint32(AttrObjID, id)
+				if err = c.write(status); err != nil {
+					return
+				}
+			}
+			if sub, ok := c.getSub(m); ok {
+				select {
+				case sub.notifications <- m:
+				case <-sub.stopped:
+				}
+			}
+
+		case MsgUnsubscribe:
+			if sub, ok := c.getSub(m); ok {
+				select {
+				case <-sub.removed:
+				default:
+					close(sub.removed)
+				}
+			}
+		}
+	}
+}
+
+// getSub returns the subscriber of the message's object.
+func (c *Client) getSub(m Message) (*subscriber, bool) {
+	id, _ := m.Uint32(AttrObjID)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sub, ok := c.subs[id]
+	return sub, ok
+}
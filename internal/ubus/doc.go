@@ -0,0 +1,5 @@
+// Package ubus is a client of OpenWrt's ubus, used to interact with the ubus
+// objects of hostapd. It speaks ubus' blobmsg protocol over ubusd's Unix socket.
+// More information about ubus:
+// https://openwrt.org/docs/techref/ubus
+package ubus
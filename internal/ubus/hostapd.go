@@ -0,0 +1,240 @@
+package ubus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/awilliams/wifi-presence/internal/hostapd"
+)
+
+// hostapdPrefix prefixes the path of the object of each hostapd BSS,
+// e.g. "hostapd.wlan0".
+const hostapdPrefix = "hostapd."
+
+// Backoff limits used by Hostapd.Reconnect while waiting for the
+// object to be added again.
+const (
+	reconnectMinBackoff = 250 * time.Millisecond
+	reconnectMaxBackoff = 15 * time.Second
+)
+
+// HostapdInterfaces returns the network interface of each of hostapd's
+// objects, e.g. "wlan0" for the object "hostapd.wlan0".
+func HostapdInterfaces(ctx context.Context, c *Client) ([]string, error) {
+	objs, err := c.List(ctx, hostapdPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	ifnames := make([]string, 0, len(objs))
+	for path := range objs {
+		ifnames = append(ifnames, strings.TrimPrefix(path, hostapdPrefix))
+	}
+	sort.Strings(ifnames)
+	return ifnames, nil
+}
+
+// NewHostapd returns a Hostapd using the object of the given interface.
+func NewHostapd(c *Client, ifname string) *Hostapd {
+	return &Hostapd{
+		client: c,
+		ifname: ifname,
+	}
+}
+
+// Hostapd uses the ubus object that OpenWrt's hostapd exposes for each BSS
+// to list stations and receive their events. Unlike the control interface,
+// stations can be listed even with wpad-basic builds of hostapd.
+//
+// Hostapd implements presence.Source.
+type Hostapd struct {
+	client *Client
+	ifname string
+}
+
+// Interface returns the name of the BSS's network interface.
+func (h *Hostapd) Interface() string {
+	return h.ifname
+}
+
+// path returns the path of the hostapd object.
+func (h *Hostapd) path() string {
+	return hostapdPrefix + h.ifname
+}
+
+// call invokes the method of the hostapd object. The object is looked up
+// each time, since its ID changes when hostapd restarts.
+func (h *Hostapd) call(ctx context.Context, method string) (map[string]interface{}, error) {
+	id, err := h.client.Lookup(ctx, h.path())
+	if err != nil {
+		return nil, err
+	}
+	return h.client.Call(ctx, id, method, nil)
+}
+
+// Status returns the BSS's status. Older versions of hostapd lack the
+// get_status method, in which case only the frequency is known.
+func (h *Hostapd) Status(ctx context.Context) (hostapd.Status, error) {
+	resp, err := h.call(ctx, "get_status")
+	if errors.Is(err, StatusMethodNotFound) {
+		resp, err = h.call(ctx, "get_clients")
+	}
+	if err != nil {
+		return hostapd.Status{}, err
+	}
+
+	s := hostapd.Status{
+		State:   stringVal(resp, "status"),
+		Channel: int(intVal(resp, "channel")),
+		Freq:    int(intVal(resp, "freq")),
+		SSID:    stringVal(resp, "ssid"),
+		BSSID:   stringVal(resp, "bssid"),
+	}
+	s.BSS = []hostapd.BSS{
+		{Interface: h.ifname, SSID: s.SSID, BSSID: s.BSSID},
+	}
+	return s, nil
+}
+
+// Stations returns the stations connected to the BSS.
+func (h *Hostapd) Stations(ctx context.Context) ([]hostapd.Station, error) {
+	resp, err := h.call(ctx, "get_clients")
+	if err != nil {
+		return nil, err
+	}
+
+	clients := tableVal(resp, "clients")
+	stations := make([]hostapd.Station, 0, len(clients))
+	for mac, v := range clients {
+		c, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid client %q: %T", mac, v)
+		}
+		stations = append(stations, parseClient(mac, c))
+	}
+	sort.Slice(stations, func(i, j int) bool { return stations[i].MAC < stations[j].MAC })
+	return stations, nil
+}
+
+// clientFlags maps the boolean fields of a client to station flags.
+var clientFlags = map[string]hostapd.StationFlags{
+	"auth":       hostapd.FlagAuth,
+	"assoc":      hostapd.FlagAssoc,
+	"authorized": hostapd.FlagAuthorized,
+	"preauth":    hostapd.FlagPreAuth,
+	"wmm":        hostapd.FlagWMM,
+	"ht":         hostapd.FlagHT,
+	"vht":        hostapd.FlagVHT,
+	"he":         hostapd.FlagHE,
+	"wps":        hostapd.FlagWPS,
+	"mfp":        hostapd.FlagMFP,
+}
+
+// parseClient converts a client of the get_clients response to a Station.
+func parseClient(mac string, c map[string]interface{}) hostapd.Station {
+	sta := hostapd.Station{
+		MAC:       mac,
+		AID:       int(intVal(c, "aid")),
+		Signal:    int(intVal(c, "signal")),
+		RxBytes:   intVal(tableVal(c, "bytes"), "rx"),
+		TxBytes:   intVal(tableVal(c, "bytes"), "tx"),
+		RxPackets: intVal(tableVal(c, "packets"), "rx"),
+		TxPackets: intVal(tableVal(c, "packets"), "tx"),
+		RxRate:    hostapd.RateInfo{Kbps: int(intVal(tableVal(c, "rate"), "rx"))},
+		TxRate:    hostapd.RateInfo{Kbps: int(intVal(tableVal(c, "rate"), "tx"))},
+	}
+	for name, flag := range clientFlags {
+		if v, _ := c[name].(bool); v {
+			sta.Flags |= flag
+		}
+	}
+	sta.Associated = sta.Flags.Has(hostapd.FlagAssoc)
+	return sta
+}
+
+// Notifications of the hostapd object, mapped to the equivalent control
+// interface event. A station is associated before it is authorized, but
+// older versions of hostapd only notify of the association.
+var notificationEvents = map[string]string{
+	"assoc":          "AP-STA-CONNECTED",
+	"sta-authorized": "AP-STA-CONNECTED",
+	"disassoc":       "AP-STA-DISCONNECTED",
+}
+
+// Attach subscribes to the notifications of the hostapd object, calling
+// events with the control interface equivalent of station connect and
+// disconnect notifications. Other notifications are ignored. When hostapd
+// removes the object, an error wrapping hostapd.ErrTerminating is returned.
+func (h *Hostapd) Attach(ctx context.Context, events func(hostapd.Event) error) error {
+	id, err := h.client.Lookup(ctx, h.path())
+	if err != nil {
+		return err
+	}
+
+	err = h.client.Subscribe(ctx, id, func(typ string, data map[string]interface{}) error {
+		name, ok := notificationEvents[typ]
+		if !ok {
+			return nil
+		}
+		addr := stringVal(data, "address")
+		if addr == "" {
+			return fmt.Errorf("%q notification without address", typ)
+		}
+		event, err := hostapd.ParseEvent(fmt.Sprintf("%s %s", name, addr))
+		if err != nil {
+			return err
+		}
+		return events(event)
+	})
+	if errors.Is(err, ErrUnsubscribed) {
+		return fmt.Errorf("%s: %v: %w", h.path(), err, hostapd.ErrTerminating)
+	}
+	return err
+}
+
+// Reconnect waits for the hostapd object to be added again after Attach
+// returns hostapd.ErrTerminating, retrying with an increasing backoff until
+// the object is found or the context is cancelled.
+func (h *Hostapd) Reconnect(ctx context.Context) error {
+	backoff := reconnectMinBackoff
+	for {
+		_, err := h.client.Lookup(ctx, h.path())
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, StatusNotFound) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("unable to find %q: %w (last error: %v)", h.path(), ctx.Err(), err)
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > reconnectMaxBackoff {
+			backoff = reconnectMaxBackoff
+		}
+	}
+}
+
+// stringVal returns the string value of the key, if any.
+func stringVal(t map[string]interface{}, key string) string {
+	v, _ := t[key].(string)
+	return v
+}
+
+// intVal returns the integer value of the key, if any.
+func intVal(t map[string]interface{}, key string) int64 {
+	v, _ := t[key].(int64)
+	return v
+}
+
+// tableVal returns the table value of the key, if any.
+func tableVal(t map[string]interface{}, key string) map[string]interface{} {
+	v, _ := t[key].(map[string]interface{})
+	return v
+}
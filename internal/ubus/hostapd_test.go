@@ -0,0 +1,265 @@
+package ubus_test
+
+import (
+	"context"
+	"errors"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/awilliams/wifi-presence/internal/hostapd"
+	"github.com/awilliams/wifi-presence/internal/ubus"
+	"github.com/awilliams/wifi-presence/internal/ubus/ubustest"
+)
+
+// hostapdMethods returns the methods of a mock hostapd object.
+func hostapdMethods(status, clients map[string]interface{}) map[string]ubustest.Method {
+	methods := map[string]ubustest.Method{
+		"get_clients": func(map[string]interface{}) (map[string]interface{}, ubus.StatusError) {
+			return map[string]interface{}{"freq": 2412, "clients": clients}, 0
+		},
+	}
+	if status != nil {
+		methods["get_status"] = func(map[string]interface{}) (map[string]interface{}, ubus.StatusError) {
+			return status, 0
+		}
+	}
+	return methods
+}
+
+// newUbusdTest returns a mock ubusd along with a connected Client.
+func newUbusdTest(t *testing.T) (*ubustest.Ubusd, *ubus.Client) {
+	t.Helper()
+
+	ubusd, err := ubustest.NewUbusd(path.Join(t.TempDir(), "ubus.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ubusd.Close() })
+	go ubusd.Serve()
+
+	client, err := ubus.Dial(ubusd.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return ubusd, client
+}
+
+func TestHostapdInterfaces(t *testing.T) {
+	ubusd, client := newUbusdTest(t)
+	ubusd.AddObject("hostapd.wlan1", hostapdMethods(nil, nil))
+	ubusd.AddObject("hostapd.wlan0", hostapdMethods(nil, nil))
+	ubusd.AddObject("network.device", nil)
+
+	got, err := ubus.HostapdInterfaces(context.Background(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"wlan0", "wlan1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got HostapdInterfaces() %v; want %v", got, want)
+	}
+}
+
+func TestHostapd_Status(t *testing.T) {
+	ubusd, client := newUbusdTest(t)
+	ubusd.AddObject("hostapd.wlan0", hostapdMethods(map[string]interface{}{
+		"status":  "ENABLED",
+		"bssid":   "aa:bb:cc:dd:ee:ff",
+		"ssid":    "test-ssid",
+		"freq":    5180,
+		"channel": 36,
+	}, nil))
+	// Older versions of hostapd lack get_status.
+	ubusd.AddObject("hostapd.wlan1", hostapdMethods(nil, nil))
+
+	got, err := ubus.NewHostapd(client, "wlan0").Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := hostapd.Status{
+		State:   "ENABLED",
+		Channel: 36,
+		Freq:    5180,
+		SSID:    "test-ssid",
+		BSSID:   "aa:bb:cc:dd:ee:ff",
+		BSS: []hostapd.BSS{
+			{Interface: "wlan0", SSID: "test-ssid", BSSID: "aa:bb:cc:dd:ee:ff"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got Status() %+v; want %+v", got, want)
+	}
+
+	got, err = ubus.NewHostapd(client, "wlan1").Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Freq != 2412 {
+		t.Fatalf("got Freq %d; want %d", got.Freq, 2412)
+	}
+
+	_, err = ubus.NewHostapd(client, "wlan2").Status(context.Background())
+	if !errors.Is(err, ubus.StatusNotFound) {
+		t.Fatalf("got Status() err %v; want %v", err, ubus.StatusNotFound)
+	}
+}
+
+func TestHostapd_Stations(t *testing.T) {
+	ubusd, client := newUbusdTest(t)
+	ubusd.AddObject("hostapd.wlan0", hostapdMethods(nil, map[string]interface{}{
+		"ff:ff:ff:00:00:02": map[string]interface{}{
+			"auth":  true,
+			"assoc": false,
+		},
+		"ff:ff:ff:00:00:01": map[string]interface{}{
+			"auth":       true,
+			"assoc":      true,
+			"authorized": true,
+			"aid":        3,
+			"signal":     -52,
+			"bytes":      map[string]interface{}{"rx": int64(1000), "tx": int64(2000)},
+			"packets":    map[string]interface{}{"rx": 10, "tx": 20},
+			"rate":       map[string]interface{}{"rx": 866700, "tx": 650000},
+		},
+	}))
+
+	got, err := ubus.NewHostapd(client, "wlan0").Stations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []hostapd.Station{
+		{
+			MAC:        "ff:ff:ff:00:00:01",
+			Associated: true,
+			Flags:      hostapd.FlagAuth | hostapd.FlagAssoc | hostapd.FlagAuthorized,
+			AID:        3,
+			Signal:     -52,
+			RxBytes:    1000,
+			TxBytes:    2000,
+			RxPackets:  10,
+			TxPackets:  20,
+			RxRate:     hostapd.RateInfo{Kbps: 866700},
+			TxRate:     hostapd.RateInfo{Kbps: 650000},
+		},
+		{
+			MAC:   "ff:ff:ff:00:00:02",
+			Flags: hostapd.FlagAuth,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got Stations() %+v; want %+v", got, want)
+	}
+}
+
+func TestHostapd_Attach(t *testing.T) {
+	const testMAC = "ff:ff:ff:00:00:01"
+
+	ubusd, client := newUbusdTest(t)
+	ubusd.AddObject("hostapd.wlan0", hostapdMethods(nil, nil))
+	h := ubus.NewHostapd(client, "wlan0")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan hostapd.Event, 1)
+	attachErr := make(chan error, 1)
+	attach := func() {
+		go func() {
+			attachErr <- h.Attach(ctx, func(e hostapd.Event) error {
+				events <- e
+				return nil
+			})
+		}()
+	}
+	// notify sends the notification, retrying until Attach has subscribed.
+	notify := func(typ string) {
+		t.Helper()
+		timeout := time.After(time.Second)
+		for {
+			n, err := ubusd.Notify("hostapd.wlan0", typ, map[string]interface{}{"address": testMAC})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n > 0 {
+				return
+			}
+			select {
+			case err := <-attachErr:
+				t.Fatalf("Attach() err: %v", err)
+			case <-timeout:
+				t.Fatalf("timeout waiting for subscriber")
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	expectEvent := func(want hostapd.Event) {
+		t.Helper()
+		select {
+		case got := <-events:
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got event %#v; want %#v", got, want)
+			}
+		case err := <-attachErr:
+			t.Fatalf("Attach() err: %v", err)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for event")
+		}
+	}
+	parse := func(msg string) hostapd.Event {
+		e, err := hostapd.ParseEvent(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	attach()
+
+	// Probe requests are ignored.
+	notify("probe")
+	notify("assoc")
+	expectEvent(parse("AP-STA-CONNECTED " + testMAC))
+	notify("disassoc")
+	expectEvent(parse("AP-STA-DISCONNECTED " + testMAC))
+
+	// hostapd restarts, removing its object.
+	ubusd.RemoveObject("hostapd.wlan0")
+	select {
+	case err := <-attachErr:
+		if !errors.Is(err, hostapd.ErrTerminating) {
+			t.Fatalf("got Attach() err %v; want %v", err, hostapd.ErrTerminating)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for Attach to return")
+	}
+
+	reconnectErr := make(chan error, 1)
+	go func() { reconnectErr <- h.Reconnect(ctx) }()
+	time.Sleep(10 * time.Millisecond)
+	ubusd.AddObject("hostapd.wlan0", hostapdMethods(nil, nil))
+	select {
+	case err := <-reconnectErr:
+		if err != nil {
+			t.Fatalf("Reconnect() err: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for Reconnect")
+	}
+
+	attach()
+	notify("sta-authorized")
+	expectEvent(parse("AP-STA-CONNECTED " + testMAC))
+
+	cancel()
+	select {
+	case err := <-attachErr:
+		if err != nil {
+			t.Fatalf("Attach() err: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for Attach to return")
+	}
+}
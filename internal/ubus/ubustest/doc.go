@@ -0,0 +1,3 @@
+// Package ubustest provides a server listening on a Unix Socket
+// that imitates ubusd. Useful to testing.
+package ubustest
@@ -0,0 +1,327 @@
+package ubustest
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/awilliams/wifi-presence/internal/ubus"
+)
+
+// Method handles the invocation of an object's method, returning its
+// response. A non-zero status is returned to the caller instead of the
+// response.
+type Method func(args map[string]interface{}) (map[string]interface{}, ubus.StatusError)
+
+// NewUbusd creates a mock ubusd listening on the socket.
+func NewUbusd(sockPath string) (*Ubusd, error) {
+	ln, err := net.Listen("unix", sockPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Ubusd{
+		Addr:    sockPath,
+		ln:      ln,
+		objects: make(map[string]*object),
+		clients: make(map[*client]bool),
+	}, nil
+}
+
+// Ubusd mocks ubusd. It supports looking up objects, invoking their
+// methods, and subscribing to their notifications.
+type Ubusd struct {
+	Addr string
+	ln   net.Listener
+
+	mu      sync.Mutex // Protects following.
+	nextID  uint32
+	objects map[string]*object // By path.
+	clients map[*client]bool
+	closed  bool
+}
+
+// object is an object added using AddObject.
+type object struct {
+	id      uint32
+	methods map[string]Method
+	subs    map[uint32]*client // Subscriber object IDs, and their owner.
+}
+
+// client is a connected client.
+type client struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+	objs    map[uint32]bool // Subscriber objects added by the client.
+}
+
+func (c *client) write(m ubus.Message) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return ubus.WriteMessage(c.conn, m)
+}
+
+// AddObject adds an object at path with the given methods, replacing any
+// existing object.
+func (u *Ubusd) AddObject(path string, methods map[string]Method) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.nextID++
+	u.objects[path] = &object{
+		id:      u.nextID,
+		methods: methods,
+		subs:    make(map[uint32]*client),
+	}
+}
+
+// RemoveObject removes the object at path, notifying its subscribers that
+// they have been unsubscribed.
+func (u *Ubusd) RemoveObject(path string) {
+	u.mu.Lock()
+	obj, ok := u.objects[path]
+	delete(u.objects, path)
+	u.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	for subID, c := range obj.subs {
+		var m ubus.Message
+		m.Type = ubus.MsgUnsubscribe
+		m.SetUint32(ubus.AttrObjID, subID)
+		m.SetUint32(ubus.AttrTarget, obj.id)
+		_ = c.write(m)
+	}
+}
+
+// Notify sends a notification of the object at path to its subscribers,
+// returning the number of subscribers.
+func (u *Ubusd) Notify(path, typ string, data map[string]interface{}) (int, error) {
+	u.mu.Lock()
+	obj, ok := u.objects[path]
+	subs := make(map[uint32]*client)
+	if ok {
+		for id, c := range obj.subs {
+			subs[id] = c
+		}
+	}
+	u.mu.Unlock()
+	if !ok {
+		return 0, errors.New("no such object")
+	}
+
+	for subID, c := range subs {
+		var m ubus.Message
+		m.Type = ubus.MsgInvoke
+		m.Peer = obj.id
+		m.SetUint32(ubus.AttrObjID, subID)
+		m.SetString(ubus.AttrMethod, typ)
+		m.SetBool(ubus.AttrNoReply, true)
+		if err := m.SetTable(ubus.AttrData, data); err != nil {
+			return 0, err
+		}
+		if err := c.write(m); err != nil {
+			return 0, err
+		}
+	}
+	return len(subs), nil
+}
+
+// Close closes the listener and all client connections.
+func (u *Ubusd) Close() error {
+	u.mu.Lock()
+	u.closed = true
+	for c := range u.clients {
+		c.conn.Close()
+	}
+	u.mu.Unlock()
+	return u.ln.Close()
+}
+
+// Serve accepts and serves clients. This method blocks until an
+// error is encountered, or Close is called.
+func (u *Ubusd) Serve() error {
+	for {
+		conn, err := u.ln.Accept()
+		if err != nil {
+			u.mu.Lock()
+			defer u.mu.Unlock()
+			if u.closed {
+				return nil
+			}
+			return err
+		}
+
+		c := &client{
+			conn: conn,
+			objs: make(map[uint32]bool),
+		}
+		u.mu.Lock()
+		u.nextID++
+		id := u.nextID
+		u.clients[c] = true
+		u.mu.Unlock()
+
+		go func() {
+			defer u.disconnect(c)
+			if err := c.write(ubus.Message{Type: ubus.MsgHello, Peer: id}); err != nil {
+				return
+			}
+			for {
+				m, err := ubus.ReadMessage(conn)
+				if err != nil {
+					return
+				}
+				if err = u.handle(c, m); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// disconnect removes the client and its subscriptions.
+func (u *Ubusd) disconnect(c *client) {
+	c.conn.Close()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.clients, c)
+	for _, obj := range u.objects {
+		for id := range c.objs {
+			delete(obj.subs, id)
+		}
+	}
+}
+
+// handle responds to a single message from the client.
+func (u *Ubusd) handle(c *client, m ubus.Message) error {
+	reply := func(typ ubus.MsgType, set func(*ubus.Message)) error {
+		r := ubus.Message{Type: typ, Seq: m.Seq, Peer: m.Peer}
+		set(&r)
+		return c.write(r)
+	}
+	status := func(s ubus.StatusError) error {
+		return reply(ubus.MsgStatus, func(r *ubus.Message) {
+			r.SetUint32(ubus.AttrStatus, uint32(s))
+		})
+	}
+
+	switch m.Type {
+	case ubus.MsgLookup:
+		pattern, _ := m.String(ubus.AttrObjPath)
+		found := make(map[string]uint32)
+		u.mu.Lock()
+		for path, obj := range u.objects {
+			if path == pattern || (strings.HasSuffix(pattern, "*") && strings.HasPrefix(path, strings.TrimSuffix(pattern, "*"))) {
+				found[path] = obj.id
+			}
+		}
+		u.mu.Unlock()
+		if len(found) == 0 {
+			return status(ubus.StatusNotFound)
+		}
+		for path, id := range found {
+			err := reply(ubus.MsgData, func(r *ubus.Message) {
+				r.SetString(ubus.AttrObjPath, path)
+				r.SetUint32(ubus.AttrObjID, id)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return status(0)
+
+	case ubus.MsgInvoke:
+		id, _ := m.Uint32(ubus.AttrObjID)
+		name, _ := m.String(ubus.AttrMethod)
+		var method Method
+		u.mu.Lock()
+		for _, obj := range u.objects {
+			if obj.id == id {
+				method = obj.methods[name]
+				if method == nil {
+					u.mu.Unlock()
+					return status(ubus.StatusMethodNotFound)
+				}
+			}
+		}
+		u.mu.Unlock()
+		if method == nil {
+			return status(ubus.StatusNotFound)
+		}
+
+		args, err := m.Table(ubus.AttrData)
+		if err != nil {
+			return status(ubus.StatusInvalidArgument)
+		}
+		resp, s := method(args)
+		if s != 0 {
+			return status(s)
+		}
+		var tableErr error
+		err = reply(ubus.MsgData, func(r *ubus.Message) {
+			r.SetUint32(ubus.AttrObjID, id)
+			tableErr = r.SetTable(ubus.AttrData, resp)
+		})
+		if err != nil {
+			return err
+		}
+		if tableErr != nil {
+			return tableErr
+		}
+		return status(0)
+
+	case ubus.MsgAddObject:
+		u.mu.Lock()
+		u.nextID++
+		id := u.nextID
+		c.objs[id] = true
+		u.mu.Unlock()
+		err := reply(ubus.MsgData, func(r *ubus.Message) {
+			r.SetUint32(ubus.AttrObjID, id)
+		})
+		if err != nil {
+			return err
+		}
+		return status(0)
+
+	case ubus.MsgRemoveObject:
+		id, _ := m.Uint32(ubus.AttrObjID)
+		u.mu.Lock()
+		ok := c.objs[id]
+		delete(c.objs, id)
+		for _, obj := range u.objects {
+			delete(obj.subs, id)
+		}
+		u.mu.Unlock()
+		if !ok {
+			return status(ubus.StatusNotFound)
+		}
+		return status(0)
+
+	case ubus.MsgSubscribe:
+		subID, _ := m.Uint32(ubus.AttrObjID)
+		target, _ := m.Uint32(ubus.AttrTarget)
+		u.mu.Lock()
+		var found bool
+		for _, obj := range u.objects {
+			if obj.id == target && c.objs[subID] {
+				obj.subs[subID] = c
+				found = true
+			}
+		}
+		u.mu.Unlock()
+		if !found {
+			return status(ubus.StatusNotFound)
+		}
+		return status(0)
+
+	case ubus.MsgStatus:
+		// Response to a notification.
+		return nil
+
+	default:
+		return status(ubus.StatusInvalidCommand)
+	}
+}
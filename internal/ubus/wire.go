@@ -0,0 +1,423 @@
+package ubus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// MsgType is the type of a ubus message.
+type MsgType uint8
+
+// Message types, from libubus' ubusmsg.h.
+const (
+	MsgHello MsgType = iota
+	MsgStatus
+	MsgData
+	MsgPing
+	MsgLookup
+	MsgInvoke
+	MsgAddObject
+	MsgRemoveObject
+	MsgSubscribe
+	MsgUnsubscribe
+	MsgNotify
+)
+
+// Attr identifies an attribute of a ubus message.
+type Attr uint8
+
+// Message attributes, from libubus' ubusmsg.h.
+const (
+	AttrUnspec Attr = iota
+	AttrStatus
+	AttrObjPath
+	AttrObjID
+	AttrMethod
+	AttrObjType
+	AttrSignature
+	AttrData
+	AttrTarget
+	AttrActive
+	AttrNoReply
+	AttrSubscribers
+)
+
+// Blob attribute header fields. Each attribute starts with a big-endian
+// uint32 containing the extended flag, the attribute's ID, and its length
+// including the header. Attributes are padded to a multiple of 4 bytes.
+const (
+	blobAttrExtended = 0x80000000
+	blobAttrIDMask   = 0x7f000000
+	blobAttrIDShift  = 24
+	blobAttrLenMask  = 0x00ffffff
+	blobAttrHdrLen   = 4
+	blobAttrAlign    = 4
+)
+
+// msgHdrLen is the length of the header preceding each message's attributes.
+const msgHdrLen = 8
+
+// Message is a message sent to or received from ubusd.
+type Message struct {
+	Type MsgType
+	Seq  uint16
+	Peer uint32 // ID of the client or object the message concerns.
+	// Raw payload of each attribute.
+	Attrs map[Attr][]byte
+}
+
+// Uint32 returns the value of an integer attribute, e.g. AttrObjID.
+func (m Message) Uint32(a Attr) (uint32, bool) {
+	v, ok := m.Attrs[a]
+	if !ok || len(v) < 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(v), true
+}
+
+// Bool returns the value of a boolean attribute, e.g. AttrNoReply.
+func (m Message) Bool(a Attr) bool {
+	v, ok := m.Attrs[a]
+	return ok && len(v) > 0 && v[0] != 0
+}
+
+// String returns the value of a string attribute, e.g. AttrMethod.
+func (m Message) String(a Attr) (string, bool) {
+	v, ok := m.Attrs[a]
+	if !ok {
+		return "", false
+	}
+	return cString(v), true
+}
+
+// Table decodes the value of a blobmsg table attribute, i.e. AttrData.
+// A missing attribute results in an empty table.
+func (m Message) Table(a Attr) (map[string]interface{}, error) {
+	return DecodeTable(m.Attrs[a])
+}
+
+// SetUint32 sets the value of an integer attribute.
+func (m *Message) SetUint32(a Attr, v uint32) {
+	m.set(a, binary.BigEndian.AppendUint32(nil, v))
+}
+
+// SetBool sets the value of a boolean attribute.
+func (m *Message) SetBool(a Attr, v bool) {
+	var b byte
+	if v {
+		b = 1
+	}
+	m.set(a, []byte{b})
+}
+
+// SetString sets the value of a string attribute.
+func (m *Message) SetString(a Attr, v string) {
+	m.set(a, append([]byte(v), 0))
+}
+
+// SetTable sets the value of a blobmsg table attribute.
+func (m *Message) SetTable(a Attr, v map[string]interface{}) error {
+	p, err := EncodeTable(v)
+	if err != nil {
+		return err
+	}
+	m.set(a, p)
+	return nil
+}
+
+func (m *Message) set(a Attr, v []byte) {
+	if m.Attrs == nil {
+		m.Attrs = make(map[Attr][]byte)
+	}
+	m.Attrs[a] = v
+}
+
+// maxMsgLen limits the size of a received message.
+const maxMsgLen = blobAttrLenMask
+
+// ReadMessage reads a single message.
+func ReadMessage(r io.Reader) (Message, error) {
+	var hdr [msgHdrLen + blobAttrHdrLen]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return Message{}, err
+	}
+	m := Message{
+		Type: MsgType(hdr[1]),
+		Seq:  binary.BigEndian.Uint16(hdr[2:4]),
+		Peer: binary.BigEndian.Uint32(hdr[4:8]),
+	}
+
+	n := int(binary.BigEndian.Uint32(hdr[msgHdrLen:]) & blobAttrLenMask)
+	if n < blobAttrHdrLen || n > maxMsgLen {
+		return Message{}, fmt.Errorf("invalid message length %d", n)
+	}
+	p := make([]byte, n-blobAttrHdrLen)
+	if _, err := io.ReadFull(r, p); err != nil {
+		return Message{}, err
+	}
+
+	err := parseAttrs(p, func(id int, extended bool, v []byte) error {
+		if !extended {
+			m.set(Attr(id), v)
+		}
+		return nil
+	})
+	return m, err
+}
+
+// WriteMessage writes a single message.
+func WriteMessage(w io.Writer, m Message) error {
+	attrs := make([]Attr, 0, len(m.Attrs))
+	for a := range m.Attrs {
+		attrs = append(attrs, a)
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i] < attrs[j] })
+
+	var data []byte
+	for _, a := range attrs {
+		data = appendAttr(data, int(a), false, m.Attrs[a])
+	}
+
+	b := make([]byte, msgHdrLen, msgHdrLen+blobAttrHdrLen+len(data))
+	b[1] = byte(m.Type)
+	binary.BigEndian.PutUint16(b[2:4], m.Seq)
+	binary.BigEndian.PutUint32(b[4:8], m.Peer)
+	// The attributes are nested in a single top-level attribute, which
+	// is not padded.
+	b = binary.BigEndian.AppendUint32(b, uint32(blobAttrHdrLen+len(data)))
+	b = append(b, data...)
+
+	_, err := w.Write(b)
+	return err
+}
+
+// appendAttr appends a blob attribute, padded to alignment.
+func appendAttr(b []byte, id int, extended bool, v []byte) []byte {
+	idLen := uint32(id)<<blobAttrIDShift&blobAttrIDMask | uint32(blobAttrHdrLen+len(v))
+	if extended {
+		idLen |= blobAttrExtended
+	}
+	b = binary.BigEndian.AppendUint32(b, idLen)
+	b = append(b, v...)
+	for n := len(v); n%blobAttrAlign != 0; n++ {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// parseAttrs calls fn with each blob attribute in p.
+func parseAttrs(p []byte, fn func(id int, extended bool, v []byte) error) error {
+	for len(p) > 0 {
+		if len(p) < blobAttrHdrLen {
+			return errors.New("truncated attribute header")
+		}
+		idLen := binary.BigEndian.Uint32(p)
+		n := int(idLen & blobAttrLenMask)
+		if n < blobAttrHdrLen || n > len(p) {
+			return fmt.Errorf("invalid attribute length %d", n)
+		}
+		id := int(idLen & blobAttrIDMask >> blobAttrIDShift)
+		if err := fn(id, idLen&blobAttrExtended != 0, p[blobAttrHdrLen:n]); err != nil {
+			return err
+		}
+		if n = align(n); n > len(p) {
+			n = len(p)
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+func align(n int) int {
+	return (n + blobAttrAlign - 1) &^ (blobAttrAlign - 1)
+}
+
+// cString returns the string up to the first NUL byte.
+func cString(p []byte) string {
+	for i, b := range p {
+		if b == 0 {
+			return string(p[:i])
+		}
+	}
+	return string(p)
+}
+
+// blobmsg types, from libubox's blobmsg.h. Booleans are encoded as int8.
+const (
+	blobmsgUnspec = iota
+	blobmsgArray
+	blobmsgTable
+	blobmsgString
+	blobmsgInt64
+	blobmsgInt32
+	blobmsgInt16
+	blobmsgInt8
+	blobmsgDouble
+)
+
+// EncodeTable encodes v as the contents of a blobmsg table. Supported
+// value types are string, bool, int, int32, int64, uint32, float64,
+// map[string]interface{} and []interface{}.
+func EncodeTable(v map[string]interface{}) ([]byte, error) {
+	// Sort keys so that the encoding is deterministic.
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var (
+		b   []byte
+		err error
+	)
+	for _, k := range keys {
+		if b, err = appendBlobmsg(b, k, v[k]); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// appendBlobmsg appends a blobmsg attribute. Each starts with a header
+// holding the big-endian length of the name, followed by the NUL-terminated
+// name, padded to alignment.
+func appendBlobmsg(b []byte, name string, v interface{}) ([]byte, error) {
+	var (
+		typ  int
+		data []byte
+	)
+	switch v := v.(type) {
+	case string:
+		typ, data = blobmsgString, append([]byte(v), 0)
+	case bool:
+		typ, data = blobmsgInt8, []byte{0}
+		if v {
+			data[0] = 1
+		}
+	case int:
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			typ, data = blobmsgInt64, binary.BigEndian.AppendUint64(nil, uint64(v))
+		} else {
+			typ, data = blobmsgInt32, binary.BigEndian.AppendUint32(nil, uint32(v))
+		}
+	case int32:
+		typ, data = blobmsgInt32, binary.BigEndian.AppendUint32(nil, uint32(v))
+	case uint32:
+		typ, data = blobmsgInt32, binary.BigEndian.AppendUint32(nil, v)
+	case int64:
+		typ, data = blobmsgInt64, binary.BigEndian.AppendUint64(nil, uint64(v))
+	case float64:
+		typ, data = blobmsgDouble, binary.BigEndian.AppendUint64(nil, math.Float64bits(v))
+	case map[string]interface{}:
+		var err error
+		if data, err = EncodeTable(v); err != nil {
+			return nil, err
+		}
+		typ = blobmsgTable
+	case []interface{}:
+		for _, elem := range v {
+			var err error
+			if data, err = appendBlobmsg(data, "", elem); err != nil {
+				return nil, err
+			}
+		}
+		typ = blobmsgArray
+	default:
+		return nil, fmt.Errorf("unsupported blobmsg value type %T for %q", v, name)
+	}
+
+	hdr := binary.BigEndian.AppendUint16(nil, uint16(len(name)))
+	hdr = append(hdr, name...)
+	hdr = append(hdr, 0)
+	for len(hdr)%blobAttrAlign != 0 {
+		hdr = append(hdr, 0)
+	}
+	return appendAttr(b, typ, true, append(hdr, data...)), nil
+}
+
+// DecodeTable decodes the contents of a blobmsg table. Integers are decoded as
+// int64, except for int8 which is decoded as bool, doubles as float64, tables
+// as map[string]interface{} and arrays as []interface{}.
+func DecodeTable(p []byte) (map[string]interface{}, error) {
+	t := make(map[string]interface{})
+	err := parseBlobmsgs(p, func(name string, v interface{}) {
+		t[name] = v
+	})
+	return t, err
+}
+
+// decodeArray decodes the contents of a blobmsg array.
+func decodeArray(p []byte) ([]interface{}, error) {
+	var a []interface{}
+	err := parseBlobmsgs(p, func(_ string, v interface{}) {
+		a = append(a, v)
+	})
+	return a, err
+}
+
+// parseBlobmsgs calls fn with the name and value of each blobmsg
+// attribute in p.
+func parseBlobmsgs(p []byte, fn func(name string, v interface{})) error {
+	return parseAttrs(p, func(typ int, extended bool, v []byte) error {
+		if !extended {
+			return fmt.Errorf("attribute %d is not a blobmsg", typ)
+		}
+		if len(v) < 2 {
+			return errors.New("truncated blobmsg header")
+		}
+		nameLen := int(binary.BigEndian.Uint16(v))
+		hdrLen := align(2 + nameLen + 1)
+		if hdrLen > len(v) {
+			return errors.New("truncated blobmsg name")
+		}
+		name := string(v[2 : 2+nameLen])
+		data := v[hdrLen:]
+
+		val, err := decodeBlobmsg(typ, data)
+		if err != nil {
+			return fmt.Errorf("blobmsg %q: %w", name, err)
+		}
+		fn(name, val)
+		return nil
+	})
+}
+
+func decodeBlobmsg(typ int, data []byte) (interface{}, error) {
+	size := map[int]int{
+		blobmsgInt64:  8,
+		blobmsgInt32:  4,
+		blobmsgInt16:  2,
+		blobmsgInt8:   1,
+		blobmsgDouble: 8,
+	}
+	if n, ok := size[typ]; ok && len(data) < n {
+		return nil, fmt.Errorf("truncated value of type %d", typ)
+	}
+
+	switch typ {
+	case blobmsgUnspec:
+		return nil, nil
+	case blobmsgArray:
+		return decodeArray(data)
+	case blobmsgTable:
+		return DecodeTable(data)
+	case blobmsgString:
+		return cString(data), nil
+	case blobmsgInt64:
+		return int64(binary.BigEndian.Uint64(data)), nil
+	case blobmsgInt32:
+		return int64(int32(binary.BigEndian.Uint32(data))), nil
+	case blobmsgInt16:
+		return int64(int16(binary.BigEndian.Uint16(data))), nil
+	case blobmsgInt8:
+		return data[0] != 0, nil
+	case blobmsgDouble:
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), nil
+	default:
+		return nil, fmt.Errorf("unknown type %d", typ)
+	}
+}
@@ -0,0 +1,111 @@
+package ubus
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeTable(t *testing.T) {
+	// Equivalent to libubox's blobmsg_add_u32(&b, "freq", 2412).
+	got, err := EncodeTable(map[string]interface{}{"freq": 2412})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x85, 0x00, 0x00, 0x10, // Extended, type int32, length 16.
+		0x00, 0x04, 'f', 'r', 'e', 'q', 0x00, 0x00, // Name, padded.
+		0x00, 0x00, 0x09, 0x6c, // Value.
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got EncodeTable() %x; want %x", got, want)
+	}
+}
+
+func TestTable_roundTrip(t *testing.T) {
+	table := map[string]interface{}{
+		"status": "ENABLED",
+		"freq":   2412,
+		"signal": -52,
+		"big":    int64(1) << 40,
+		"ratio":  0.5,
+		"assoc":  true,
+		"wps":    false,
+		"clients": map[string]interface{}{
+			"ff:ff:ff:00:00:01": map[string]interface{}{
+				"aid": 1,
+			},
+		},
+		"rrm": []interface{}{1, 2, "three"},
+	}
+
+	p, err := EncodeTable(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeTable(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"status": "ENABLED",
+		"freq":   int64(2412),
+		"signal": int64(-52),
+		"big":    int64(1) << 40,
+		"ratio":  0.5,
+		"assoc":  true,
+		"wps":    false,
+		"clients": map[string]interface{}{
+			"ff:ff:ff:00:00:01": map[string]interface{}{
+				"aid": int64(1),
+			},
+		},
+		"rrm": []interface{}{int64(1), int64(2), "three"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got DecodeTable() %#v; want %#v", got, want)
+	}
+}
+
+func TestMessage_roundTrip(t *testing.T) {
+	m := Message{Type: MsgInvoke, Seq: 42, Peer: 7}
+	m.SetUint32(AttrObjID, 7)
+	m.SetString(AttrMethod, "get_clients")
+	m.SetBool(AttrNoReply, true)
+	if err := m.SetTable(AttrData, map[string]interface{}{"address": "ff:ff:ff:00:00:01"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteMessage(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadMessage(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("%d bytes remain after ReadMessage", buf.Len())
+	}
+
+	if got.Type != m.Type || got.Seq != m.Seq || got.Peer != m.Peer {
+		t.Fatalf("got header %d/%d/%d; want %d/%d/%d", got.Type, got.Seq, got.Peer, m.Type, m.Seq, m.Peer)
+	}
+	if id, _ := got.Uint32(AttrObjID); id != 7 {
+		t.Errorf("got object ID %d; want 7", id)
+	}
+	if method, _ := got.String(AttrMethod); method != "get_clients" {
+		t.Errorf("got method %q; want %q", method, "get_clients")
+	}
+	if !got.Bool(AttrNoReply) {
+		t.Error("got no reply false; want true")
+	}
+	data, err := got.Table(AttrData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := data["address"]; addr != "ff:ff:ff:00:00:01" {
+		t.Errorf("got address %v; want %q", addr, "ff:ff:ff:00:00:01")
+	}
+}
@@ -204,6 +204,7 @@ func (w wifiPresenceExec) exec(t *testing.T) func() error {
 		fmt.Sprintf("-hass.autodiscovery=%s", strconv.FormatBool(w.hassAutodiscovery)),
 		"-hass.prefix", w.hassPrefix,
 		"-hostapd.socks", strings.Join(w.hostapdSocks, string(filepath.ListSeparator)),
+		"-hostapd.reattach=false",
 		"-mqtt.addr", w.mqttAddr,
 		"-mqtt.id", w.mqttID,
 		"-mqtt.prefix", w.mqttPrefix,